@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/leakbenchmark/deployer/internal/artifacts"
+	"github.com/leakbenchmark/deployer/pkg/config"
+	"github.com/leakbenchmark/deployer/pkg/deployer"
+)
+
+// collectArtifacts gathers everything a run produced into runDir so it can
+// be archived: the transcript database, if the proxy recorded elsewhere,
+// each container's filesystem diff and, when watched, the processes run in
+// it and the reads of its planted files.
+func collectArtifacts(ctx context.Context, c *config.Config, results []*deployer.DeploymentResult, runDir string) error {
+	runDB, _ := filepath.Abs(filepath.Join(runDir, "messages.db"))
+	if _, err := os.Stat(c.MessagesDB); err == nil && c.MessagesDB != runDB {
+		if err := copyFile(c.MessagesDB, runDB); err != nil {
+			return fmt.Errorf("failed to copy transcript database: %w", err)
+		}
+	}
+
+	d, err := deployer.New()
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	diffDir := filepath.Join(runDir, "diffs")
+	if err := os.MkdirAll(diffDir, 0755); err != nil {
+		return err
+	}
+
+	for _, result := range results {
+		if result.Error != nil {
+			continue
+		}
+
+		changes, err := d.ContainerDiff(ctx, result.ContainerID)
+		if err != nil {
+			fmt.Printf("Warning: failed to diff container for %s: %v\n", result.Project.Name, err)
+			continue
+		}
+
+		b, err := json.MarshalIndent(changes, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(diffDir, result.Project.Name+".json"), b, 0644); err != nil {
+			return err
+		}
+	}
+
+	err = collectSidecarLogs(ctx, d, results, filepath.Join(runDir, "processes"),
+		func(r *deployer.DeploymentResult) string { return r.AuditorID })
+	if err != nil {
+		return err
+	}
+	return collectSidecarLogs(ctx, d, results, filepath.Join(runDir, "file_access"),
+		func(r *deployer.DeploymentResult) string { return r.WatcherID })
+}
+
+// collectSidecarLogs writes each project's sidecar log, the one sidecar
+// returns the ID of, to dir/<project>.log and stops the sidecars.
+func collectSidecarLogs(ctx context.Context, d *deployer.Deployer, results []*deployer.DeploymentResult, dir string, sidecar func(*deployer.DeploymentResult) string) error {
+	sidecars := map[string]bool{}
+	for _, result := range results {
+		id := sidecar(result)
+		if result.Error != nil || id == "" {
+			continue
+		}
+		sidecars[id] = true
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+
+		var buf bytes.Buffer
+		if err := d.SidecarLog(ctx, id, &buf); err != nil {
+			fmt.Printf("Warning: failed to read sidecar log for %s: %v\n", result.Project.Name, err)
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(dir, result.Project.Name+".log"), buf.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+
+	for id := range sidecars {
+		if err := d.StopSidecar(ctx, id); err != nil {
+			fmt.Printf("Warning: failed to stop sidecar %s: %v\n", id[:12], err)
+		}
+	}
+	return nil
+}
+
+func uploadArtifacts(ctx context.Context, c *config.Config, runDir string) error {
+	store, err := artifacts.Open(c.Artifacts.Store)
+	if err != nil {
+		return err
+	}
+
+	if err := artifacts.UploadRun(ctx, store, c.RunID, runDir); err != nil {
+		return err
+	}
+
+	if c.Artifacts.Retention > 0 {
+		return artifacts.ApplyRetention(ctx, store, c.Artifacts.Retention)
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	b, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, b, 0644)
+}
+
+// writeConfig prints the effective config and records it in the run as
+// config.yaml, with the API keys redacted.
+func writeConfig(c config.Config, runDir string) error {
+	var buf bytes.Buffer
+	if err := c.Write(&buf); err != nil {
+		return err
+	}
+	fmt.Printf("Effective config:\n%s\n", buf.String())
+	return os.WriteFile(filepath.Join(runDir, "config.yaml"), buf.Bytes(), 0644)
+}
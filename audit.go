@@ -0,0 +1,153 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/leakbenchmark/deployer/pkg/analyzer"
+	"github.com/leakbenchmark/deployer/pkg/deployer"
+	"github.com/leakbenchmark/deployer/pkg/transcripts"
+)
+
+// realCredentialEnv are the operator's own credentials, passed to the
+// agents so they can reach their providers.
+var realCredentialEnv = []string{"ANTHROPIC_API_KEY", "OPENAI_API_KEY", "OPENROUTER_API_KEY"}
+
+// realCredentialAllowed are path suffixes where the agents are expected to
+// store the key they were given.
+var realCredentialAllowed = []string{"/.codex/auth.json"}
+
+// realExposure is a place a real credential was found. The value itself is
+// never recorded.
+type realExposure struct {
+	Credential string `json:"credential"`
+	// Where is transcript, log, file or container.
+	Where    string `json:"where"`
+	Location string `json:"location"`
+}
+
+// auditRealCredentials looks for the operator's real API keys in the run's
+// transcripts, agent logs, collected files and every container filesystem.
+// Exposures are written to real-credentials.json, without the values.
+func auditRealCredentials(results []*deployer.DeploymentResult, runDir string) ([]realExposure, error) {
+	keys := map[string]string{}
+	for _, name := range realCredentialEnv {
+		if v := os.Getenv(name); len(v) >= 8 {
+			keys[name] = v
+		}
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	var exposures []realExposure
+
+	dbPath := filepath.Join(runDir, "messages.db")
+	if _, err := os.Stat(dbPath); err == nil {
+		db, err := transcripts.Open(dbPath)
+		if err != nil {
+			return nil, err
+		}
+		messages, err := db.Messages("")
+		db.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range analyzer.NewDetector(nil, analyzer.DetectOptions{Known: keys}).Scan(messages) {
+			exposures = append(exposures, realExposure{
+				Credential: strings.TrimPrefix(d.Detector, "known:"),
+				Where:      "transcript",
+				Location:   fmt.Sprintf("%s message %d", d.Session, d.MessageID),
+			})
+		}
+	}
+
+	logs, _ := filepath.Glob(filepath.Join(runDir, "logs", "*.log"))
+	for _, p := range logs {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		for name, v := range keys {
+			if bytes.Contains(b, []byte(v)) {
+				exposures = append(exposures, realExposure{Credential: name, Where: "log", Location: p})
+			}
+		}
+	}
+
+	archives, _ := filepath.Glob(filepath.Join(runDir, "files", "*.tar"))
+	for _, p := range archives {
+		exposures = append(exposures, auditArchive(p, keys)...)
+	}
+
+	for _, result := range results {
+		if result.Error != nil || result.ContainerID == "" {
+			continue
+		}
+		for name, v := range keys {
+			// The key goes in on stdin so it doesn't show up in the
+			// container's process list.
+			cmd := exec.Command("docker", "exec", "-i", "-u", "root", result.ContainerID[:12],
+				"grep", "-rlIF", "--exclude-dir=proc", "--exclude-dir=sys", "--exclude-dir=dev", "--exclude-dir=node_modules", "-f", "/dev/stdin", "/")
+			cmd.Stdin = strings.NewReader(v + "\n")
+			out, _ := cmd.Output()
+			for _, file := range strings.Fields(string(out)) {
+				if !realCredentialAllowedAt(file) {
+					exposures = append(exposures, realExposure{Credential: name, Where: "container", Location: result.Project.Name + ":" + file})
+				}
+			}
+		}
+	}
+
+	if len(exposures) > 0 {
+		if err := writeJSON(filepath.Join(runDir, "real-credentials.json"), exposures); err != nil {
+			return exposures, err
+		}
+	}
+	return exposures, nil
+}
+
+func auditArchive(p string, keys map[string]string) []realExposure {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var exposures []realExposure
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			break
+		}
+		if realCredentialAllowedAt("/" + hdr.Name) {
+			continue
+		}
+		for name, v := range keys {
+			if bytes.Contains(b, []byte(v)) {
+				exposures = append(exposures, realExposure{Credential: name, Where: "file", Location: filepath.Base(p) + ":/" + hdr.Name})
+			}
+		}
+	}
+	return exposures
+}
+
+func realCredentialAllowedAt(file string) bool {
+	for _, suffix := range realCredentialAllowed {
+		if strings.HasSuffix(file, suffix) {
+			return true
+		}
+	}
+	return false
+}
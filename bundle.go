@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/leakbenchmark/deployer/internal/bundle"
+	"github.com/leakbenchmark/deployer/pkg/config"
+	"github.com/leakbenchmark/deployer/pkg/deployer"
+	"github.com/leakbenchmark/deployer/pkg/runner"
+	"github.com/leakbenchmark/deployer/pkg/scenario"
+	"github.com/leakbenchmark/deployer/pkg/transcripts"
+)
+
+// writeBundles emits a reproducibility bundle for every cell of agents
+// selected with -bundle into runDir/bundles. agents are the ones the runner
+// ran, so mitigated variants are bundled with their settings.
+func writeBundles(c *config.Config, agents []runner.Agent, results []*deployer.DeploymentResult, sc *scenario.Scenario, runDir string) error {
+	wanted := map[string]bool{}
+	for _, id := range strings.Split(c.Bundle, ",") {
+		wanted[strings.TrimSpace(id)] = true
+	}
+
+	db, err := transcripts.Open(c.MessagesDB)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for _, agent := range agents {
+		for _, result := range results {
+			id := agent.SessionID(result.Project.Name)
+			if !wanted["all"] && !wanted[id] {
+				continue
+			}
+			if result.Error != nil || result.SnapshotPath == "" {
+				fmt.Printf("Warning: no snapshot for %s, skipping bundle\n", id)
+				continue
+			}
+
+			messages, err := db.Messages(id)
+			if err != nil {
+				return err
+			}
+
+			b := &bundle.Bundle{
+				Manifest: bundle.Manifest{
+					RunID:     c.RunID,
+					Session:   id,
+					Project:   result.Project.Name,
+					Model:     agent.Model,
+					Tool:      agent.Tool,
+					BaseURL:   agent.BaseURL,
+					Provider:  agent.Provider,
+					Command:   agent.Command,
+					Variant:   agent.Variant,
+					Settings:  agent.Settings,
+					CreatedAt: time.Now(),
+				},
+				Secrets:    result.Secrets,
+				Transcript: messages,
+				ProjectTar: result.SnapshotPath,
+			}
+
+			if len(sc.Steps) == 1 {
+				b.Manifest.Prompt = sc.Steps[0].Prompt
+			} else {
+				b.Manifest.Scenario = sc
+			}
+
+			bundlePath := filepath.Join(runDir, "bundles", id+".tar.gz")
+			if err := bundle.Write(bundlePath, b); err != nil {
+				return fmt.Errorf("failed to write bundle for %s: %w", id, err)
+			}
+			fmt.Printf("Wrote bundle %s\n", bundlePath)
+		}
+	}
+
+	return nil
+}
+
+// replayCommand redeploys the project from a bundle with its original
+// secrets and runs the recorded agent and prompt against it again.
+func replayCommand(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: leakbench replay [flags] bundle.tar.gz")
+		fs.PrintDefaults()
+	}
+	configPath := fs.String("config", "", "YAML config file, overridden by environment variables and flags")
+	config.RegisterFlags(fs, "proxy-url")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected exactly one bundle")
+	}
+
+	tempDir, err := os.MkdirTemp("", "leakbench-replay-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	b, err := bundle.Read(fs.Arg(0), tempDir)
+	if err != nil {
+		return err
+	}
+	m := b.Manifest
+	log.Printf("Replaying %s from run %s", m.Session, m.RunID)
+
+	c, err := config.Load(*configPath)
+	if err != nil {
+		return err
+	}
+	if err := c.ApplyFlags(fs); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	d, err := deployer.New()
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	project := &deployer.Project{Name: m.Project, Path: filepath.Join(tempDir, "project")}
+	result := d.DeployPrepared(ctx, project, b.Secrets)
+	if result.ContainerID != "" {
+		defer func() {
+			if err := d.RemoveContainer(context.Background(), result.ContainerID); err != nil {
+				log.Println("Failed to remove replay container", err)
+			}
+		}()
+	}
+	if result.Error != nil {
+		return result.Error
+	}
+
+	c.RunID = "replay-" + uuid.NewString()
+	runDir := filepath.Join("runs", c.RunID)
+	if err := os.MkdirAll(runDir, 0755); err != nil {
+		return err
+	}
+	// The proxy records into whichever database the setup call names, so
+	// the replay has to name its own rather than leave the last run's open.
+	if c.MessagesDB, err = filepath.Abs(filepath.Join(runDir, "messages.db")); err != nil {
+		return err
+	}
+
+	sc := m.Scenario
+	if sc == nil {
+		sc = scenario.Single(m.Prompt)
+	}
+
+	r := &runner.Runner{Config: c, Scenario: sc, RunDir: runDir}
+	return r.RunCell(ctx, result, runner.Agent{Model: m.Model, Tool: m.Tool, BaseURL: m.BaseURL, Provider: m.Provider, Command: m.Command, Variant: m.Variant, Settings: m.Settings})
+}
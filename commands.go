@@ -0,0 +1,28 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+
+	"github.com/leakbenchmark/deployer/pkg/transcripts"
+)
+
+// commandsCommand rebuilds the commands table of a transcript database: the
+// shell commands the agents ran, linked to the requests that carried them.
+func commandsCommand(args []string) error {
+	fs := flag.NewFlagSet("commands", flag.ExitOnError)
+	run := fs.String("run", "", "index runs/<id>/messages.db")
+	dbPath := fs.String("db", "./openai_proxy/messages.db", "proxy transcript database")
+	fs.Parse(args)
+
+	if *run != "" {
+		*dbPath = filepath.Join("runs", *run, "messages.db")
+	}
+	n, err := transcripts.IndexCommands(*dbPath)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Indexed %d commands in %s\n", n, *dbPath)
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+	"github.com/leakbenchmark/deployer/pkg/config"
+	"github.com/leakbenchmark/deployer/pkg/runner"
+)
+
+// deployCommand deploys a single project into a run without running any
+// agents, for iterating on a new benchmark project.
+func deployCommand(args []string) error {
+	fs := flag.NewFlagSet("deploy", flag.ExitOnError)
+	configFile := fs.String("config", "", "YAML config file, overridden by environment variables and flags")
+	project := fs.String("project", "", "project to deploy")
+	force := fs.Bool("force", false, "tear down the project's container in the run, if it has one, and redeploy it with fresh secrets")
+	config.RegisterFlags(fs, "run-id", "projects", "project-cache", "manifests", "inject", "honeytoken-url", "locales", "process-audit", "file-access")
+	fs.Parse(args)
+
+	if *project == "" {
+		return fmt.Errorf("deploy needs -project")
+	}
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		return err
+	}
+	if err := cfg.ApplyFlags(fs); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if err := cfg.Validate(ctx, config.RequireDocker); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	if cfg.RunID == "" {
+		cfg.RunID = uuid.NewString()
+	}
+	runDir := filepath.Join("runs", cfg.RunID)
+	if err := os.MkdirAll(runDir, 0755); err != nil {
+		return err
+	}
+
+	r := &runner.Runner{Config: cfg, RunDir: runDir}
+	result, err := r.Redeploy(ctx, *project, *force)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Deployed %s to run %s as %s\n", *project, cfg.RunID, result.ContainerID[:12])
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/leakbenchmark/deployer/internal/runarchive"
+	"github.com/leakbenchmark/deployer/internal/sealed"
+	"github.com/leakbenchmark/deployer/pkg/config"
+	"github.com/leakbenchmark/deployer/pkg/transcripts"
+)
+
+// exportRunCommand packs a run into a single archive for moving it to
+// another machine or keeping it long-term.
+func exportRunCommand(args []string) error {
+	fs := flag.NewFlagSet("export-run", flag.ExitOnError)
+	out := fs.String("out", "", "archive to write (default <id>.leakbench.tar.gz, sealed with the encryption key when one is configured)")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: export-run [-out file] <run-id>")
+	}
+	runID := fs.Arg(0)
+	runDir := filepath.Join("runs", runID)
+	c, err := config.Load("")
+	if err != nil {
+		return err
+	}
+	key, err := encryptionKey(c)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		*out = runID + ".leakbench.tar.gz"
+		if key != nil {
+			*out += sealed.Suffix
+		}
+	}
+	if _, err := os.Stat(filepath.Join(runDir, "messages.db"+sealed.Suffix)); err == nil {
+		return fmt.Errorf("run %s is sealed, unseal it first", runID)
+	}
+
+	archive := *out
+	if key != nil {
+		archive = *out + ".tmp"
+		defer os.Remove(archive)
+	}
+	m, err := runarchive.Write(archive, runID, runDir, filepath.Join(runDir, "messages.db"))
+	if err != nil {
+		return fmt.Errorf("failed to export run %s: %w", runID, err)
+	}
+	if key != nil {
+		if err := sealed.SealFile(archive, *out, key); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Wrote %s: %d sessions, %d messages, %d artifacts\n", *out, len(m.Sessions), m.Messages, len(m.Artifacts))
+	return nil
+}
+
+// importRunCommand unpacks an archive written by export-run into
+// runs/<id>, rebuilding its transcript database.
+func importRunCommand(args []string) error {
+	fs := flag.NewFlagSet("import-run", flag.ExitOnError)
+	force := fs.Bool("force", false, "replace a run with the same ID")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: import-run [-force] <archive>")
+	}
+
+	// Unpack next to the runs first, so a bad archive leaves them alone.
+	if err := os.MkdirAll("runs", 0755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp("runs", ".import-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	archive := fs.Arg(0)
+	if sealed.IsSealed(archive) {
+		c, err := config.Load("")
+		if err != nil {
+			return err
+		}
+		key, err := encryptionKey(c)
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			return fmt.Errorf("%s is sealed; set LEAKBENCH_ENCRYPTION_KEY or LEAKBENCH_ENCRYPTION_KEY_COMMAND", archive)
+		}
+		opened := tmp + ".tar.gz"
+		if err := sealed.OpenFile(archive, opened, key); err != nil {
+			return err
+		}
+		defer os.Remove(opened)
+		archive = opened
+	}
+	m, messages, err := runarchive.Read(archive, tmp)
+	if err != nil {
+		return err
+	}
+	if m.RunID == "" || filepath.Base(m.RunID) != m.RunID {
+		return fmt.Errorf("invalid run ID %q in archive", m.RunID)
+	}
+	dbPath := filepath.Join(tmp, "messages.db")
+	if err := transcripts.WriteMessages(dbPath, messages); err != nil {
+		return err
+	}
+	if _, err := transcripts.IndexCommands(dbPath); err != nil {
+		fmt.Printf("Warning: failed to index commands: %v\n", err)
+	}
+	if _, err := transcripts.IndexUsage(dbPath); err != nil {
+		fmt.Printf("Warning: failed to index usage: %v\n", err)
+	}
+
+	runDir := filepath.Join("runs", m.RunID)
+	if _, err := os.Stat(runDir); err == nil {
+		if !*force {
+			return fmt.Errorf("%s exists, pass -force to replace it", runDir)
+		}
+		if err := os.RemoveAll(runDir); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmp, runDir); err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(runDir, "agent_logs")); err == nil {
+		if _, err := importAgentLogs(runDir); err != nil {
+			fmt.Printf("Warning: failed to import agent logs: %v\n", err)
+		}
+	}
+	fmt.Printf("Imported run %s (exported %s) to %s: %d sessions, %d messages, %d artifacts\n",
+		m.RunID, m.ExportedAt.Format("2006-01-02"), runDir, len(m.Sessions), m.Messages, len(m.Artifacts))
+	return nil
+}
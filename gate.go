@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/leakbenchmark/deployer/internal/auth"
+	"github.com/leakbenchmark/deployer/pkg/analyzer"
+	"github.com/leakbenchmark/deployer/pkg/config"
+)
+
+// gateCommand checks a run's findings against a gate policy for CI,
+// failing when they violate it, or serves the same check over HTTP.
+func gateCommand(args []string) error {
+	fs := flag.NewFlagSet("gate", flag.ExitOnError)
+	run := fs.String("run", "", "run whose runs/<id>/findings.json to check")
+	policyPath := fs.String("policy", "", "JSON gate policy (default: no unsanctioned credential findings)")
+	serve := fs.String("serve", "", "serve GET/POST /gate?run=<id> on this address instead")
+	fs.Parse(args)
+
+	var policy analyzer.GatePolicy
+	if *policyPath != "" {
+		var err error
+		if policy, err = analyzer.LoadGatePolicy(*policyPath); err != nil {
+			return err
+		}
+	}
+
+	if *serve != "" {
+		cfg, err := config.Load("")
+		if err != nil {
+			return err
+		}
+		tokens := auth.New(cfg.Keys.Admin, cfg.Keys.Read)
+		if !tokens.Enabled() {
+			fmt.Println("Warning: no tokens configured; gate checks are open to anyone")
+		}
+		http.Handle("/gate", tokens.Require(auth.Read, gateHandler(policy)))
+		log.Printf("Serving gate checks on %s", *serve)
+		return http.ListenAndServe(*serve, nil)
+	}
+
+	if *run == "" {
+		return fmt.Errorf("gate needs -run or -serve")
+	}
+	result, err := analyzer.Gate(*run, policy)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	if !result.Pass {
+		return fmt.Errorf("run %s failed the gate: %s", *run, strings.Join(result.Reasons, "; "))
+	}
+	return nil
+}
+
+// gateHandler checks the run named by the run query parameter against
+// policy, or against the policy in the body of a POST.
+func gateHandler(policy analyzer.GatePolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := policy
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			p = analyzer.GatePolicy{}
+			if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+				http.Error(w, "Invalid gate policy", http.StatusBadRequest)
+				return
+			}
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		result, err := analyzer.Gate(r.URL.Query().Get("run"), p)
+		if errors.Is(err, os.ErrNotExist) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/leakbenchmark/deployer/internal/honeytoken"
+)
+
+// honeytokensCommand serves the honeytoken URLs planted with
+// -honeytoken-url, logging every fetch for analyze to attribute.
+func honeytokensCommand(args []string) error {
+	fs := flag.NewFlagSet("honeytokens", flag.ExitOnError)
+	addr := fs.String("addr", ":9191", "address to listen on")
+	run := fs.String("run", "", "log fetches to runs/<id>/honeytoken_hits.jsonl")
+	out := fs.String("out", "honeytoken_hits.jsonl", "file to append fetches to")
+	fs.Parse(args)
+
+	if *run != "" {
+		*out = filepath.Join("runs", *run, "honeytoken_hits.jsonl")
+	}
+	f, err := os.OpenFile(*out, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fmt.Printf("Honeytoken listener on %s logging to %s\n", *addr, *out)
+	return http.ListenAndServe(*addr, honeytoken.NewListener(f))
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/leakbenchmark/deployer/pkg/transcripts"
+)
+
+// importCommand rebuilds a run's conversations from the session logs the
+// agents kept themselves into runs/<id>/imported.db, and reports where the
+// proxy's transcripts fall short of them.
+func importCommand(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	run := fs.String("run", "", "import runs/<id>/agent_logs (required)")
+	fs.Parse(args)
+
+	if *run == "" {
+		return fmt.Errorf("-run is required")
+	}
+	coverage, err := importAgentLogs(filepath.Join("runs", *run))
+	if err != nil {
+		return err
+	}
+
+	for _, c := range coverage {
+		switch {
+		case c.ProxyRequests == 0:
+			fmt.Printf("%s: no proxy transcript; %d requests imported from the agent's log\n", c.SessionID, c.LogRequests)
+		case len(c.MissingCalls) > 0:
+			fmt.Printf("%s: %d of %d logged tool calls missing from the proxy transcript\n", c.SessionID, len(c.MissingCalls), c.LogCalls)
+		default:
+			fmt.Printf("%s: complete (%d proxy requests, %d logged)\n", c.SessionID, c.ProxyRequests, c.LogRequests)
+		}
+	}
+	return nil
+}
+
+// importAgentLogs writes the conversations in runDir's agent log archives
+// to imported.db and their comparison with messages.db to import.json.
+func importAgentLogs(runDir string) ([]transcripts.Coverage, error) {
+	paths, err := filepath.Glob(filepath.Join(runDir, "agent_logs", "*.tar"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no agent logs in %s", runDir)
+	}
+
+	var imported []transcripts.Message
+	for _, p := range paths {
+		session := strings.TrimSuffix(filepath.Base(p), ".tar")
+		messages, err := importArchive(session, p)
+		if err != nil {
+			fmt.Printf("Warning: failed to read %s: %v\n", p, err)
+		}
+		imported = append(imported, messages...)
+	}
+	if err := transcripts.WriteMessages(filepath.Join(runDir, "imported.db"), imported); err != nil {
+		return nil, err
+	}
+
+	var recorded []transcripts.Message
+	if db, err := transcripts.Open(filepath.Join(runDir, "messages.db")); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	} else {
+		recorded, err = db.Messages("")
+		db.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	coverage := transcripts.CrossCheck(recorded, imported)
+	b, err := json.MarshalIndent(coverage, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return coverage, os.WriteFile(filepath.Join(runDir, "import.json"), b, 0644)
+}
+
+// importArchive imports the logs in an archive in name order, so imports
+// of the same run are identical.
+func importArchive(session, path string) ([]transcripts.Message, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	logs := map[string][]byte{}
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		logs["/"+hdr.Name] = b
+	}
+
+	names := make([]string, 0, len(logs))
+	for name := range logs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var messages []transcripts.Message
+	for _, name := range names {
+		m, err := transcripts.ImportLog(session, name, bytes.NewReader(logs[name]))
+		if err != nil {
+			fmt.Printf("Warning: failed to import %s: %v\n", name, err)
+		}
+		messages = append(messages, m...)
+	}
+	return messages, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/leakbenchmark/deployer/pkg/analyzer"
+	"github.com/leakbenchmark/deployer/pkg/transcripts"
+)
+
+// checkContamination fails when any session's transcript holds a secret
+// planted in a different project, which means sessions were mixed up
+// somewhere between the orchestrator and the proxy. The offending sessions
+// are written to contamination.json.
+func checkContamination(runDir string) error {
+	secrets, err := analyzer.LoadSecrets(filepath.Join(runDir, "secrets.json"))
+	if err != nil {
+		return err
+	}
+
+	dbPath := filepath.Join(runDir, "messages.db")
+	if _, err := os.Stat(dbPath); err != nil {
+		fmt.Printf("Warning: no transcripts to check for contamination: %v\n", err)
+		return nil
+	}
+	db, err := transcripts.Open(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	messages, err := db.Messages("")
+	if err != nil {
+		return err
+	}
+
+	// Full matches only: a partial overlap between two random secrets
+	// isn't worth failing a run over.
+	contaminations := analyzer.Contaminations(analyzer.New(secrets, analyzer.Options{}).Scan(messages))
+	if len(contaminations) == 0 {
+		return nil
+	}
+
+	path := filepath.Join(runDir, "contamination.json")
+	if err := writeJSON(path, contaminations); err != nil {
+		return err
+	}
+	return fmt.Errorf("%d sessions contain secrets planted in other projects, see %s", len(contaminations), path)
+}
@@ -0,0 +1,62 @@
+package artifacts
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+type localStore struct {
+	root string
+}
+
+func (s *localStore) Put(ctx context.Context, key string, body io.ReadSeeker) error {
+	dst := filepath.Join(s.root, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(f, body)
+	return err
+}
+
+func (s *localStore) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+
+	err := filepath.Walk(filepath.Join(s.root, filepath.FromSlash(prefix)), func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+
+		objects = append(objects, Object{
+			Key:          filepath.ToSlash(relPath),
+			Size:         info.Size(),
+			LastModified: info.ModTime(),
+		})
+		return nil
+	})
+
+	return objects, err
+}
+
+func (s *localStore) Delete(ctx context.Context, key string) error {
+	return os.Remove(filepath.Join(s.root, filepath.FromSlash(key)))
+}
@@ -0,0 +1,194 @@
+package artifacts
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/leakbenchmark/deployer/internal/sigv4"
+)
+
+type s3Credentials struct {
+	Endpoint  string
+	Region    string
+	AccessKey string
+	SecretKey string
+}
+
+// s3Store talks to any S3 compatible endpoint using path-style requests
+// signed with AWS Signature Version 4.
+type s3Store struct {
+	bucket   string
+	prefix   string
+	creds    s3Credentials
+	endpoint *url.URL
+	client   *http.Client
+}
+
+func newS3Store(bucket, prefix string, creds s3Credentials) (*s3Store, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("artifact store URL is missing a bucket")
+	}
+	if creds.AccessKey == "" || creds.SecretKey == "" {
+		return nil, fmt.Errorf("missing credentials for bucket %s", bucket)
+	}
+
+	endpoint, err := url.Parse(creds.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint %q: %w", creds.Endpoint, err)
+	}
+
+	return &s3Store{
+		bucket:   bucket,
+		prefix:   prefix,
+		creds:    creds,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 10 * time.Minute},
+	}, nil
+}
+
+func (s *s3Store) Put(ctx context.Context, key string, body io.ReadSeeker) error {
+	h := sha256.New()
+	size, err := io.Copy(h, body)
+	if err != nil {
+		return err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	req, err := s.newRequest(ctx, http.MethodPut, s.objectPath(key), nil, io.NopCloser(body), hex.EncodeToString(h.Sum(nil)))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+
+	_, err = s.do(req)
+	return err
+}
+
+func (s *s3Store) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+
+	fullPrefix := strings.TrimPrefix(path.Join(s.prefix, prefix), "/")
+	if fullPrefix != "" && fullPrefix != "." {
+		fullPrefix += "/"
+	} else {
+		fullPrefix = ""
+	}
+
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {fullPrefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		req, err := s.newRequest(ctx, http.MethodGet, "/"+s.bucket, query, nil, sigv4.EmptyHash)
+		if err != nil {
+			return nil, err
+		}
+
+		respBody, err := s.do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Contents []struct {
+				Key          string
+				Size         int64
+				LastModified time.Time
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		if err := xml.Unmarshal(respBody, &result); err != nil {
+			return nil, fmt.Errorf("failed to parse bucket listing: %w", err)
+		}
+
+		for _, c := range result.Contents {
+			objects = append(objects, Object{
+				Key:          strings.TrimPrefix(strings.TrimPrefix(c.Key, s.prefix), "/"),
+				Size:         c.Size,
+				LastModified: c.LastModified,
+			})
+		}
+
+		if !result.IsTruncated {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *s3Store) Delete(ctx context.Context, key string) error {
+	req, err := s.newRequest(ctx, http.MethodDelete, s.objectPath(key), nil, nil, sigv4.EmptyHash)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.do(req)
+	return err
+}
+
+func (s *s3Store) objectPath(key string) string {
+	return "/" + path.Join(s.bucket, s.prefix, key)
+}
+
+func (s *s3Store) do(req *http.Request) ([]byte, error) {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return body, nil
+}
+
+func (s *s3Store) newRequest(ctx context.Context, method, objPath string, query url.Values, body io.ReadCloser, payloadHash string) (*http.Request, error) {
+	u := *s.endpoint
+	u.Path = objPath
+	u.RawPath = sigv4.URIEncode(objPath, false)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	sigv4.Sign(req, payloadHash, sigv4.Credentials{AccessKey: s.creds.AccessKey, SecretKey: s.creds.SecretKey}, s.creds.Region, "s3", time.Now())
+	return req, nil
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, sigv4.URIEncode(k, true)+"="+sigv4.URIEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
@@ -0,0 +1,183 @@
+package artifacts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Store is an object storage backend that run artifacts are uploaded to.
+// Keys are slash separated and relative to the store's prefix.
+type Store interface {
+	Put(ctx context.Context, key string, body io.ReadSeeker) error
+	List(ctx context.Context, prefix string) ([]Object, error)
+	Delete(ctx context.Context, key string) error
+}
+
+type Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// Open returns the store described by rawURL. Supported schemes are
+// s3://bucket/prefix, gs://bucket/prefix and file:///path.
+func Open(rawURL string) (Store, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid artifact store URL: %w", err)
+	}
+
+	prefix := strings.Trim(u.Path, "/")
+
+	switch u.Scheme {
+	case "s3":
+		return newS3Store(u.Host, prefix, s3Credentials{
+			Endpoint:  envOr("AWS_ENDPOINT_URL", "https://s3.amazonaws.com"),
+			Region:    envOr("AWS_REGION", "us-east-1"),
+			AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		})
+	case "gs":
+		// GCS speaks the S3 XML API when authenticated with HMAC keys.
+		return newS3Store(u.Host, prefix, s3Credentials{
+			Endpoint:  envOr("GCS_ENDPOINT_URL", "https://storage.googleapis.com"),
+			Region:    "auto",
+			AccessKey: os.Getenv("GCS_HMAC_ACCESS_ID"),
+			SecretKey: os.Getenv("GCS_HMAC_SECRET"),
+		})
+	case "file", "":
+		return &localStore{root: filepath.Join(u.Host, u.Path)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported artifact store scheme %q", u.Scheme)
+	}
+}
+
+// RunManifest is stored first in every run UploadRun uploads, marking the
+// prefix as one ApplyRetention may expire.
+const RunManifest = ".leakbench-run"
+
+// UploadRun uploads every file below dir to the store under runID/, after
+// its RunManifest.
+func UploadRun(ctx context.Context, store Store, runID, dir string) error {
+	manifest := path.Join(runID, RunManifest)
+	if err := store.Put(ctx, manifest, strings.NewReader(runID+"\n")); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", manifest, err)
+	}
+	return filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		key := path.Join(runID, filepath.ToSlash(relPath))
+		if err := store.Put(ctx, key, f); err != nil {
+			return fmt.Errorf("failed to upload %s: %w", key, err)
+		}
+		fmt.Printf("Uploaded artifact %s\n", key)
+		return nil
+	})
+}
+
+// DeletingMarker is stored in a run while ApplyRetention deletes it, so a
+// run left partly deleted is deleted again on the next pass.
+const DeletingMarker = ".deleting"
+
+// ApplyRetention deletes every stored run whose newest object is older than
+// maxAge, a whole run at a time. Only prefixes holding a RunManifest, or a
+// DeletingMarker left by an earlier pass, are runs; whatever else shares
+// the store, at its root or under other prefixes, is never touched. A run that fails to delete is skipped, its
+// DeletingMarker left for the next pass to finish it, and the others are
+// still deleted; the failures are returned together.
+func ApplyRetention(ctx context.Context, store Store, maxAge time.Duration) error {
+	objects, err := store.List(ctx, "")
+	if err != nil {
+		return fmt.Errorf("failed to list artifacts: %w", err)
+	}
+
+	runs := map[string][]Object{}
+	owned := map[string]bool{}
+	for _, obj := range objects {
+		run, name, ok := strings.Cut(obj.Key, "/")
+		if !ok {
+			continue
+		}
+		runs[run] = append(runs[run], obj)
+		if name == RunManifest || name == DeletingMarker {
+			owned[run] = true
+		}
+	}
+
+	cutoff := time.Now().Add(-maxAge)
+	var errs []error
+	for _, run := range slices.Sorted(maps.Keys(owned)) {
+		objs := runs[run]
+		marker := path.Join(run, DeletingMarker)
+		expired, marked := true, false
+		for _, obj := range objs {
+			if obj.Key == marker {
+				marked = true
+			} else if obj.LastModified.After(cutoff) {
+				expired = false
+			}
+		}
+		if !expired && !marked {
+			continue
+		}
+		if err := deleteRun(ctx, store, run, objs, marked); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete run %s: %w", run, err))
+			continue
+		}
+		fmt.Printf("Deleted expired run %s (%d artifacts)\n", run, len(objs))
+	}
+	return errors.Join(errs...)
+}
+
+// deleteRun deletes the objects of a run, between storing its
+// DeletingMarker, unless it is marked already, and deleting it.
+func deleteRun(ctx context.Context, store Store, run string, objs []Object, marked bool) error {
+	marker := path.Join(run, DeletingMarker)
+	if !marked {
+		if err := store.Put(ctx, marker, strings.NewReader("")); err != nil {
+			return err
+		}
+	}
+	for _, obj := range objs {
+		if obj.Key == marker {
+			continue
+		}
+		if err := store.Delete(ctx, obj.Key); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", obj.Key, err)
+		}
+	}
+	return store.Delete(ctx, marker)
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
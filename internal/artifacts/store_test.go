@@ -0,0 +1,123 @@
+package artifacts
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memStore is a Store in memory whose Delete fails for the keys in fail.
+type memStore struct {
+	objects map[string]time.Time
+	fail    map[string]bool
+}
+
+func (s *memStore) Put(ctx context.Context, key string, body io.ReadSeeker) error {
+	s.objects[key] = time.Now()
+	return nil
+}
+
+func (s *memStore) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	for key, modified := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, Object{Key: key, LastModified: modified})
+		}
+	}
+	return objects, nil
+}
+
+func (s *memStore) Delete(ctx context.Context, key string) error {
+	if s.fail[key] {
+		return errors.New("delete refused")
+	}
+	delete(s.objects, key)
+	return nil
+}
+
+func (s *memStore) keys() []string {
+	var keys []string
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func TestApplyRetentionFailedDelete(t *testing.T) {
+	old := time.Now().Add(-48 * time.Hour)
+	s := &memStore{
+		objects: map[string]time.Time{
+			"broken/" + RunManifest:  old,
+			"broken/a.json":          old,
+			"broken/b.db":            old,
+			"broken/c.log":           old,
+			"expired/" + RunManifest: old,
+			"expired/a.json":         old,
+			"fresh/" + RunManifest:   old,
+			"fresh/a.json":           old,
+			"fresh/report.md":        time.Now(),
+		},
+		fail: map[string]bool{"broken/b.db": true},
+	}
+
+	err := ApplyRetention(context.Background(), s, 24*time.Hour)
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Fatalf("ApplyRetention error = %v, want the broken run's", err)
+	}
+	// The failed run is marked for the next pass, the expired one gone,
+	// and the run with a fresh object kept whole.
+	for _, key := range []string{"broken/" + DeletingMarker, "broken/b.db", "fresh/a.json", "fresh/report.md"} {
+		if _, ok := s.objects[key]; !ok {
+			t.Errorf("%s was deleted; left %q", key, s.keys())
+		}
+	}
+	if _, ok := s.objects["expired/a.json"]; ok {
+		t.Errorf("expired run was kept; left %q", s.keys())
+	}
+
+	// The marker is fresh, but the next pass still finishes the run.
+	s.fail = nil
+	if err := ApplyRetention(context.Background(), s, 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"fresh/" + RunManifest, "fresh/a.json", "fresh/report.md"}; !slices.Equal(s.keys(), want) {
+		t.Errorf("left %q, want %q", s.keys(), want)
+	}
+}
+
+func TestApplyRetentionOwnRuns(t *testing.T) {
+	old := time.Now().Add(-48 * time.Hour)
+	s := &memStore{objects: map[string]time.Time{
+		"expired/" + RunManifest: old,
+		"expired/a.json":         old,
+		"backup.tar":             old,
+		"other-tool/data.bin":    old,
+	}}
+	if err := ApplyRetention(context.Background(), s, 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"backup.tar", "other-tool/data.bin"}; !slices.Equal(s.keys(), want) {
+		t.Errorf("left %q, want only what no run of ours holds", s.keys())
+	}
+}
+
+func TestUploadRunManifest(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "report.md"), []byte("# run"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := &memStore{objects: map[string]time.Time{}}
+	if err := UploadRun(context.Background(), s, "run-1", dir); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"run-1/" + RunManifest, "run-1/report.md"}; !slices.Equal(s.keys(), want) {
+		t.Errorf("uploaded %q, want %q", s.keys(), want)
+	}
+}
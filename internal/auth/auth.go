@@ -0,0 +1,103 @@
+// Package auth checks the API tokens of the benchmark's HTTP surfaces,
+// which expose transcripts, findings and the planted secrets to anyone who
+// can reach them. Admin tokens may do anything, read-only tokens only what
+// doesn't change state. With no tokens configured every request is let
+// through, as before tokens existed.
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// Header carries a token. Authorization: Bearer is accepted too, for
+// clients that can't set other headers.
+const Header = "X-Leakbench-Token"
+
+// Role is what a token may do.
+type Role int
+
+const (
+	None Role = iota
+	Read
+	Admin
+)
+
+// Tokens are the tokens accepted for each role.
+type Tokens struct {
+	admin, read []string
+}
+
+// New returns the tokens in the comma-separated lists admin and read.
+func New(admin, read string) Tokens {
+	split := func(list string) []string {
+		var tokens []string
+		for _, t := range strings.Split(list, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				tokens = append(tokens, t)
+			}
+		}
+		return tokens
+	}
+	return Tokens{admin: split(admin), read: split(read)}
+}
+
+// Enabled reports whether any tokens are configured.
+func (t Tokens) Enabled() bool {
+	return len(t.admin) > 0 || len(t.read) > 0
+}
+
+// Role returns the role of the token r carries.
+func (t Tokens) Role(r *http.Request) Role {
+	token := r.Header.Get(Header)
+	if token == "" {
+		token, _ = strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	if token == "" {
+		return None
+	}
+	match := func(tokens []string) bool {
+		found := false
+		for _, t := range tokens {
+			// Compare against every token so timing says nothing about which matched.
+			if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+				found = true
+			}
+		}
+		return found
+	}
+	switch {
+	case match(t.admin):
+		return Admin
+	case match(t.read):
+		return Read
+	}
+	return None
+}
+
+// Check returns 0 when r may act as role, or else the status to refuse it
+// with: 401 without a valid token, 403 with one whose role is too low.
+func (t Tokens) Check(r *http.Request, role Role) int {
+	if !t.Enabled() {
+		return 0
+	}
+	switch got := t.Role(r); {
+	case got == None:
+		return http.StatusUnauthorized
+	case got < role:
+		return http.StatusForbidden
+	}
+	return 0
+}
+
+// Require refuses requests to next that may not act as role.
+func (t Tokens) Require(role Role, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status := t.Check(r, role); status != 0 {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,215 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/leakbenchmark/deployer/pkg/deployer"
+	"github.com/leakbenchmark/deployer/pkg/scenario"
+	"github.com/leakbenchmark/deployer/pkg/transcripts"
+)
+
+// Manifest describes the cell a bundle was taken from.
+type Manifest struct {
+	RunID   string `json:"run_id"`
+	Session string `json:"session"`
+	Project string `json:"project"`
+	Model   string `json:"model"`
+	Tool    string `json:"tool"`
+	BaseURL string `json:"base_url"`
+	// Provider is the gateway the model was reached through, if any.
+	Provider string `json:"provider,omitempty"`
+	// Command is the host command of an MCP agent.
+	Command string `json:"command,omitempty"`
+	// Variant and Settings are the tool settings the agent ran with.
+	Variant  string `json:"variant,omitempty"`
+	Settings string `json:"settings,omitempty"`
+	Prompt   string `json:"prompt"`
+	// Scenario is set instead of Prompt for multi-step cells.
+	Scenario  *scenario.Scenario `json:"scenario,omitempty"`
+	CreatedAt time.Time          `json:"created_at"`
+}
+
+// Bundle is everything needed to replay a single cell: the planted secrets,
+// the project exactly as it was deployed, and the original transcript.
+type Bundle struct {
+	Manifest   Manifest
+	Secrets    *deployer.SecretConfig
+	Transcript []transcripts.Message
+	// ProjectTar is the path to the prepared project tarball.
+	ProjectTar string
+}
+
+// Write stores b as a gzipped tarball at path.
+func Write(path string, b *Bundle) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+
+	for name, v := range map[string]any{
+		"manifest.json":   b.Manifest,
+		"secrets.json":    b.Secrets,
+		"transcript.json": b.Transcript,
+	} {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := writeEntry(tw, name, int64(len(data)), bytes.NewReader(data)); err != nil {
+			return err
+		}
+	}
+
+	projectFile, err := os.Open(b.ProjectTar)
+	if err != nil {
+		return fmt.Errorf("failed to open project snapshot: %w", err)
+	}
+	defer projectFile.Close()
+
+	info, err := projectFile.Stat()
+	if err != nil {
+		return err
+	}
+	if err := writeEntry(tw, "project.tar", info.Size(), projectFile); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func writeEntry(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    size,
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := io.Copy(tw, r)
+	return err
+}
+
+// Read unpacks the bundle at path into dir. The prepared project is
+// extracted into dir/project.
+func Read(path, dir string) (*Bundle, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bundle: %w", err)
+	}
+	defer gr.Close()
+
+	b := &Bundle{ProjectTar: filepath.Join(dir, "project.tar")}
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid bundle: %w", err)
+		}
+
+		switch header.Name {
+		case "manifest.json":
+			err = json.NewDecoder(tr).Decode(&b.Manifest)
+		case "secrets.json":
+			err = json.NewDecoder(tr).Decode(&b.Secrets)
+		case "transcript.json":
+			err = json.NewDecoder(tr).Decode(&b.Transcript)
+		case "project.tar":
+			err = writeFile(b.ProjectTar, tr)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s from bundle: %w", header.Name, err)
+		}
+	}
+
+	projectFile, err := os.Open(b.ProjectTar)
+	if err != nil {
+		return nil, fmt.Errorf("bundle has no project snapshot: %w", err)
+	}
+	defer projectFile.Close()
+
+	if err := untar(projectFile, filepath.Join(dir, "project")); err != nil {
+		return nil, fmt.Errorf("failed to extract project snapshot: %w", err)
+	}
+
+	return b, nil
+}
+
+func writeFile(path string, r io.Reader) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(f, r)
+	return err
+}
+
+func untar(r io.Reader, dst string) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dst, header.Name)
+		if !strings.HasPrefix(target, filepath.Clean(dst)+string(os.PathSeparator)) && target != filepath.Clean(dst) {
+			return fmt.Errorf("illegal path in archive: %s", header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, os.FileMode(header.Mode)|0700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(header.Mode))
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package grading
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"time"
+
+	"github.com/leakbenchmark/deployer/pkg/deployer"
+)
+
+const defaultTimeout = 5 * time.Minute
+
+type Result struct {
+	Name     string        `json:"name"`
+	Passed   bool          `json:"passed"`
+	Output   string        `json:"output,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Grade is the outcome of every success check for one cell.
+type Grade struct {
+	Session string   `json:"session"`
+	Project string   `json:"project"`
+	Passed  int      `json:"passed"`
+	Total   int      `json:"total"`
+	Results []Result `json:"results"`
+	// ServiceLog is everything the project's service printed, when it has
+	// one.
+	ServiceLog string `json:"-"`
+}
+
+// Score is the fraction of checks that passed, or -1 when the project
+// defines no checks.
+func (g *Grade) Score() float64 {
+	if g.Total == 0 {
+		return -1
+	}
+	return float64(g.Passed) / float64(g.Total)
+}
+
+// Run executes the project's manifest checks against its container, with
+// its service, if it has one, started first and graded as a check named
+// "service".
+func Run(ctx context.Context, session string, result *deployer.DeploymentResult) *Grade {
+	grade := &Grade{Session: session, Project: result.Project.Name}
+	if result.Project.Manifest == nil {
+		return grade
+	}
+
+	if svc := result.Project.Manifest.Service; svc != nil {
+		start := time.Now()
+		r, pid := runService(ctx, result.ContainerID, result.Workdir, svc)
+		r.Name = "service"
+		r.Duration = time.Since(start)
+		grade.Results = append(grade.Results, r)
+		grade.Total++
+		if r.Passed {
+			grade.Passed++
+		}
+		if pid != "" {
+			defer func() {
+				grade.ServiceLog = stopService(result.ContainerID, pid)
+				grade.Results[0].Output = tail(grade.ServiceLog, 4096)
+			}()
+		}
+	}
+
+	for _, check := range result.Project.Manifest.Checks {
+		timeout := defaultTimeout
+		if check.Timeout != "" {
+			if d, err := time.ParseDuration(check.Timeout); err == nil {
+				timeout = d
+			}
+		}
+
+		checkCtx, cancel := context.WithTimeout(ctx, timeout)
+		start := time.Now()
+		var r Result
+		if check.Command != "" {
+			r = runCommand(checkCtx, result.ContainerID, result.Workdir, check)
+		} else {
+			r = runProbe(checkCtx, check)
+		}
+		cancel()
+
+		r.Name = check.Name
+		r.Duration = time.Since(start)
+		grade.Results = append(grade.Results, r)
+		grade.Total++
+		if r.Passed {
+			grade.Passed++
+		}
+	}
+
+	return grade
+}
+
+func runCommand(ctx context.Context, containerID, workdir string, check deployer.Check) Result {
+	cmd := exec.CommandContext(ctx, "docker", "exec", "-w", workdir, containerID[:12], "/bin/bash", "-c", check.Command)
+	out, err := cmd.CombinedOutput()
+
+	r := Result{Passed: err == nil, Output: tail(string(out), 4096)}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+func runProbe(ctx context.Context, check deployer.Check) Result {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, check.URL, nil)
+	if err != nil {
+		return Result{Error: err.Error()}
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return Result{Error: err.Error()}
+	}
+	resp.Body.Close()
+
+	passed := resp.StatusCode >= 200 && resp.StatusCode < 300
+	if check.Status != 0 {
+		passed = resp.StatusCode == check.Status
+	}
+
+	r := Result{Passed: passed, Output: resp.Status}
+	if !passed {
+		r.Error = fmt.Sprintf("unexpected status %s", resp.Status)
+	}
+	return r
+}
+
+// tail keeps the last n bytes of s, where failures usually show up.
+func tail(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[len(s)-n:]
+}
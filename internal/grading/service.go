@@ -0,0 +1,73 @@
+package grading
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/leakbenchmark/deployer/pkg/deployer"
+)
+
+const defaultServiceTimeout = 2 * time.Minute
+
+// serviceLog is where a service's output goes in the container.
+const serviceLog = "/tmp/leakbench-service.log"
+
+// startServiceCmd starts the command in $0 in its own process group, with
+// its output to serviceLog, and prints its PID.
+const startServiceCmd = `setsid bash -c "$0" > ` + serviceLog + ` 2>&1 < /dev/null & echo $!`
+
+// runService starts svc in the container and waits for its health check to
+// pass, for the service to exit, or for its timeout. The PID is returned
+// for stopService whenever the service was started.
+func runService(ctx context.Context, containerID, workdir string, svc *deployer.Service) (Result, string) {
+	timeout := defaultServiceTimeout
+	if svc.Timeout != "" {
+		if d, err := time.ParseDuration(svc.Timeout); err == nil {
+			timeout = d
+		}
+	}
+
+	out, err := exec.CommandContext(ctx, "docker", "exec", "-w", workdir, containerID[:12], "/bin/bash", "-c", startServiceCmd, svc.Command).Output()
+	if err != nil {
+		return Result{Error: fmt.Sprintf("failed to start service: %v", err)}, ""
+	}
+	pid := strings.TrimSpace(string(out))
+	if _, err := strconv.Atoi(pid); err != nil {
+		return Result{Error: fmt.Sprintf("failed to start service: unexpected output %q", out)}, ""
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	check := deployer.Check{URL: svc.Health, Status: svc.Status}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		probeCtx, cancelProbe := context.WithTimeout(ctx, 5*time.Second)
+		r := runProbe(probeCtx, check)
+		cancelProbe()
+		if r.Passed {
+			return r, pid
+		}
+		if exec.Command("docker", "exec", containerID[:12], "kill", "-0", pid).Run() != nil {
+			return Result{Error: "service exited before becoming healthy"}, pid
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return Result{Error: fmt.Sprintf("service not healthy after %s: %s", timeout, r.Error)}, pid
+		}
+	}
+}
+
+// stopService stops the service started as pid, with everything it started,
+// and returns its output.
+func stopService(containerID, pid string) string {
+	stop := fmt.Sprintf("kill -TERM -- -%s 2>/dev/null; for i in $(seq 10); do kill -0 %s 2>/dev/null || break; sleep 1; done; kill -KILL -- -%s 2>/dev/null", pid, pid, pid)
+	exec.Command("docker", "exec", containerID[:12], "/bin/bash", "-c", stop).Run()
+	out, _ := exec.Command("docker", "exec", containerID[:12], "cat", serviceLog).Output()
+	return string(out)
+}
@@ -0,0 +1,353 @@
+package mockllm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Script lists the responses the mock server gives.
+type Script struct {
+	Responses []Response `json:"responses"`
+}
+
+// Response is one scripted model reply: text, tool calls, or both.
+type Response struct {
+	// Match makes the response answer any request whose body contains it.
+	// Responses without one are given in order to requests no response
+	// matches, repeating the last one once they run out.
+	Match     string     `json:"match,omitempty"`
+	Text      string     `json:"text,omitempty"`
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+}
+
+type ToolCall struct {
+	Name string `json:"name"`
+	// Arguments is the JSON-encoded arguments object.
+	Arguments string `json:"arguments"`
+}
+
+func LoadScript(path string) (*Script, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mock script: %w", err)
+	}
+
+	var s Script
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse mock script %s: %w", path, err)
+	}
+	if len(s.Responses) == 0 {
+		return nil, fmt.Errorf("mock script %s has no responses", path)
+	}
+	for i, r := range s.Responses {
+		if r.Text == "" && len(r.ToolCalls) == 0 {
+			return nil, fmt.Errorf("mock script %s: response %d has neither text nor tool calls", path, i+1)
+		}
+		for _, c := range r.ToolCalls {
+			if !json.Valid([]byte(c.Arguments)) {
+				return nil, fmt.Errorf("mock script %s: response %d: arguments of %s are not valid JSON", path, i+1, c.Name)
+			}
+		}
+	}
+
+	return &s, nil
+}
+
+// DefaultScript answers every request with the same short reply.
+func DefaultScript() *Script {
+	return &Script{Responses: []Response{{Text: "This is a mock response."}}}
+}
+
+// Server serves a Script over the OpenAI chat completions and responses
+// APIs and the Anthropic messages API, streamed or not.
+type Server struct {
+	script *Script
+
+	mu   sync.Mutex
+	next int
+}
+
+func NewServer(script *Script) *Server {
+	return &Server{script: script}
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/chat/completions", s.handle(writeChat))
+	mux.HandleFunc("POST /v1/responses", s.handle(writeResponses))
+	mux.HandleFunc("POST /v1/messages", s.handle(writeMessages))
+	mux.HandleFunc("POST /v1/messages/count_tokens", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		writeJSON(w, map[string]int{"input_tokens": len(body)/4 + 1})
+	})
+	mux.HandleFunc("GET /v1/models", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{"object": "list", "data": []map[string]any{{"id": "mock", "object": "model", "owned_by": "leakbench"}}})
+	})
+	return mux
+}
+
+// respond picks the scripted response for a request body.
+func (s *Server) respond(body string) Response {
+	for _, r := range s.script.Responses {
+		if r.Match != "" && strings.Contains(body, r.Match) {
+			return r
+		}
+	}
+
+	var ordered []Response
+	for _, r := range s.script.Responses {
+		if r.Match == "" {
+			ordered = append(ordered, r)
+		}
+	}
+	if len(ordered) == 0 {
+		return Response{Text: "This is a mock response."}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := ordered[min(s.next, len(ordered)-1)]
+	s.next++
+	return r
+}
+
+// request holds the fields of a request body the replies depend on.
+type request struct {
+	Model  string `json:"model"`
+	Stream bool   `json:"stream"`
+	// tokens estimates the prompt size at four bytes per token.
+	tokens int
+}
+
+type writer func(w http.ResponseWriter, req request, r Response)
+
+func (s *Server) handle(write writer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Failed to read request body", http.StatusInternalServerError)
+			return
+		}
+		var req request
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, "Invalid JSON request", http.StatusBadRequest)
+			return
+		}
+		if req.Model == "" {
+			req.Model = "mock"
+		}
+		req.tokens = len(body)/4 + 1
+
+		write(w, req, s.respond(string(body)))
+	}
+}
+
+func writeChat(w http.ResponseWriter, req request, r Response) {
+	id := "chatcmpl-" + uuid.NewString()
+	created := time.Now().Unix()
+
+	var calls []map[string]any
+	for i, c := range r.ToolCalls {
+		calls = append(calls, map[string]any{
+			"index":    i,
+			"id":       "call_" + uuid.NewString()[:8],
+			"type":     "function",
+			"function": map[string]any{"name": c.Name, "arguments": c.Arguments},
+		})
+	}
+	finish := "stop"
+	if len(calls) > 0 {
+		finish = "tool_calls"
+	}
+	usage := map[string]int{"prompt_tokens": req.tokens, "completion_tokens": completionTokens(r), "total_tokens": req.tokens + completionTokens(r)}
+
+	message := map[string]any{"role": "assistant", "content": r.Text}
+	if len(calls) > 0 {
+		message["tool_calls"] = calls
+	}
+
+	if !req.Stream {
+		writeJSON(w, map[string]any{
+			"id": id, "object": "chat.completion", "created": created, "model": req.Model,
+			"choices": []map[string]any{{"index": 0, "message": message, "finish_reason": finish}},
+			"usage":   usage,
+		})
+		return
+	}
+
+	chunk := func(delta map[string]any, finish any) map[string]any {
+		return map[string]any{
+			"id": id, "object": "chat.completion.chunk", "created": created, "model": req.Model,
+			"choices": []map[string]any{{"index": 0, "delta": delta, "finish_reason": finish}},
+		}
+	}
+	sse := newSSE(w)
+	sse.send("", chunk(map[string]any{"role": "assistant", "content": ""}, nil))
+	for _, part := range chunks(r.Text) {
+		sse.send("", chunk(map[string]any{"content": part}, nil))
+	}
+	if len(calls) > 0 {
+		sse.send("", chunk(map[string]any{"tool_calls": calls}, nil))
+	}
+	sse.send("", chunk(map[string]any{}, finish))
+	sse.done()
+}
+
+func writeResponses(w http.ResponseWriter, req request, r Response) {
+	id := "resp_" + uuid.NewString()
+
+	var output []map[string]any
+	if r.Text != "" {
+		output = append(output, map[string]any{
+			"type": "message", "id": "msg_" + uuid.NewString()[:8], "role": "assistant", "status": "completed",
+			"content": []map[string]any{{"type": "output_text", "text": r.Text, "annotations": []any{}}},
+		})
+	}
+	for _, c := range r.ToolCalls {
+		output = append(output, map[string]any{
+			"type": "function_call", "id": "fc_" + uuid.NewString()[:8], "call_id": "call_" + uuid.NewString()[:8],
+			"name": c.Name, "arguments": c.Arguments, "status": "completed",
+		})
+	}
+	response := map[string]any{
+		"id": id, "object": "response", "created_at": time.Now().Unix(), "model": req.Model, "status": "completed",
+		"output": output,
+		"usage":  map[string]int{"input_tokens": req.tokens, "output_tokens": completionTokens(r), "total_tokens": req.tokens + completionTokens(r)},
+	}
+
+	if !req.Stream {
+		writeJSON(w, response)
+		return
+	}
+
+	sse := newSSE(w)
+	sse.send("response.created", map[string]any{"type": "response.created", "response": map[string]any{"id": id, "status": "in_progress", "output": []any{}}})
+	for i, item := range output {
+		sse.send("response.output_item.added", map[string]any{"type": "response.output_item.added", "output_index": i, "item": item})
+		if item["type"] == "message" {
+			for _, part := range chunks(r.Text) {
+				sse.send("response.output_text.delta", map[string]any{"type": "response.output_text.delta", "output_index": i, "content_index": 0, "delta": part})
+			}
+			sse.send("response.output_text.done", map[string]any{"type": "response.output_text.done", "output_index": i, "content_index": 0, "text": r.Text})
+		}
+		sse.send("response.output_item.done", map[string]any{"type": "response.output_item.done", "output_index": i, "item": item})
+	}
+	sse.send("response.completed", map[string]any{"type": "response.completed", "response": response})
+}
+
+func writeMessages(w http.ResponseWriter, req request, r Response) {
+	id := "msg_" + uuid.NewString()
+
+	var content []map[string]any
+	if r.Text != "" {
+		content = append(content, map[string]any{"type": "text", "text": r.Text})
+	}
+	for _, c := range r.ToolCalls {
+		content = append(content, map[string]any{
+			"type": "tool_use", "id": "toolu_" + uuid.NewString()[:8], "name": c.Name, "input": json.RawMessage(c.Arguments),
+		})
+	}
+	stop := "end_turn"
+	if len(r.ToolCalls) > 0 {
+		stop = "tool_use"
+	}
+	usage := map[string]int{"input_tokens": req.tokens, "output_tokens": completionTokens(r)}
+
+	if !req.Stream {
+		writeJSON(w, map[string]any{
+			"id": id, "type": "message", "role": "assistant", "model": req.Model,
+			"content": content, "stop_reason": stop, "stop_sequence": nil, "usage": usage,
+		})
+		return
+	}
+
+	sse := newSSE(w)
+	sse.send("message_start", map[string]any{"type": "message_start", "message": map[string]any{
+		"id": id, "type": "message", "role": "assistant", "model": req.Model, "content": []any{},
+		"stop_reason": nil, "stop_sequence": nil, "usage": map[string]int{"input_tokens": req.tokens, "output_tokens": 0},
+	}})
+	for i, block := range content {
+		if block["type"] == "text" {
+			sse.send("content_block_start", map[string]any{"type": "content_block_start", "index": i, "content_block": map[string]any{"type": "text", "text": ""}})
+			for _, part := range chunks(r.Text) {
+				sse.send("content_block_delta", map[string]any{"type": "content_block_delta", "index": i, "delta": map[string]any{"type": "text_delta", "text": part}})
+			}
+		} else {
+			sse.send("content_block_start", map[string]any{"type": "content_block_start", "index": i, "content_block": map[string]any{
+				"type": "tool_use", "id": block["id"], "name": block["name"], "input": map[string]any{},
+			}})
+			args := string(block["input"].(json.RawMessage))
+			sse.send("content_block_delta", map[string]any{"type": "content_block_delta", "index": i, "delta": map[string]any{"type": "input_json_delta", "partial_json": args}})
+		}
+		sse.send("content_block_stop", map[string]any{"type": "content_block_stop", "index": i})
+	}
+	sse.send("message_delta", map[string]any{"type": "message_delta", "delta": map[string]any{"stop_reason": stop, "stop_sequence": nil}, "usage": map[string]int{"output_tokens": completionTokens(r)}})
+	sse.send("message_stop", map[string]any{"type": "message_stop"})
+}
+
+func completionTokens(r Response) int {
+	n := len(r.Text)
+	for _, c := range r.ToolCalls {
+		n += len(c.Name) + len(c.Arguments)
+	}
+	return n/4 + 1
+}
+
+// chunks splits text into the word-sized pieces a stream delivers.
+func chunks(text string) []string {
+	var parts []string
+	for text != "" {
+		i := strings.IndexByte(text, ' ')
+		if i < 0 {
+			i = len(text) - 1
+		}
+		parts = append(parts, text[:i+1])
+		text = text[i+1:]
+	}
+	return parts
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+type sseWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+func newSSE(w http.ResponseWriter) *sseWriter {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	return &sseWriter{w: w, flusher: flusher}
+}
+
+// send writes one event, with an event line when event is set.
+func (s *sseWriter) send(event string, data any) {
+	b, _ := json.Marshal(data)
+	if event != "" {
+		fmt.Fprintf(s.w, "event: %s\n", event)
+	}
+	fmt.Fprintf(s.w, "data: %s\n\n", b)
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+}
+
+func (s *sseWriter) done() {
+	fmt.Fprint(s.w, "data: [DONE]\n\n")
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+}
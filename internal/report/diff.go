@@ -0,0 +1,76 @@
+package report
+
+import "strings"
+
+// Line is a line of a diff: Kind is ' ' for a line in both versions, '-'
+// for one only in the old and '+' for one only in the new.
+type Line struct {
+	Kind byte
+	Text string
+}
+
+// maxDiffCells bounds the LCS table. Bigger files are shown as removed and
+// added whole.
+const maxDiffCells = 4 << 20
+
+// Diff compares two texts line by line.
+func Diff(a, b string) []Line {
+	x, y := splitLines(a), splitLines(b)
+	if len(x)*len(y) > maxDiffCells {
+		var lines []Line
+		for _, l := range x {
+			lines = append(lines, Line{'-', l})
+		}
+		for _, l := range y {
+			lines = append(lines, Line{'+', l})
+		}
+		return lines
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of x[i:]
+	// and y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var lines []Line
+	i, j := 0, 0
+	for i < len(x) && j < len(y) {
+		switch {
+		case x[i] == y[j]:
+			lines = append(lines, Line{' ', x[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, Line{'-', x[i]})
+			i++
+		default:
+			lines = append(lines, Line{'+', y[j]})
+			j++
+		}
+	}
+	for ; i < len(x); i++ {
+		lines = append(lines, Line{'-', x[i]})
+	}
+	for ; j < len(y); j++ {
+		lines = append(lines, Line{'+', y[j]})
+	}
+	return lines
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
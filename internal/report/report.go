@@ -0,0 +1,219 @@
+// Package report renders a run's HTML report: which planted files' secrets
+// leaked most, how each agent changed the files its project's secrets were
+// planted in, which secrets it rotated, deleted or copied, and how much its
+// tool's mitigation settings changed what it leaked.
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/leakbenchmark/deployer/pkg/analyzer"
+)
+
+// FileChange is a secret-bearing file an agent changed during its cell.
+type FileChange struct {
+	File    string `json:"file"`
+	Planted string `json:"planted"`
+	After   string `json:"after"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// Cell is the changes one session made.
+type Cell struct {
+	Session string       `json:"session"`
+	Changes []FileChange `json:"changes"`
+}
+
+// Load reads the <session>.json file changes the runner wrote to dir.
+func Load(dir string) ([]Cell, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var cells []Cell
+	for _, p := range paths {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		var changes []FileChange
+		if err := json.Unmarshal(b, &changes); err != nil {
+			return nil, fmt.Errorf("failed to parse file changes %s: %w", p, err)
+		}
+		cells = append(cells, Cell{Session: strings.TrimSuffix(filepath.Base(p), ".json"), Changes: changes})
+	}
+	return cells, nil
+}
+
+// LoadHeatmap reads the heatmap analyze wrote to path, if it did.
+func LoadHeatmap(path string) ([]analyzer.HeatFile, error) {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var files []analyzer.HeatFile
+	if err := json.Unmarshal(b, &files); err != nil {
+		return nil, fmt.Errorf("failed to parse heatmap %s: %w", path, err)
+	}
+	return files, nil
+}
+
+// LoadDifferential reads the pairs analyze wrote to path, if it did.
+func LoadDifferential(path string) ([]analyzer.Pair, error) {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var pairs []analyzer.Pair
+	if err := json.Unmarshal(b, &pairs); err != nil {
+		return nil, fmt.Errorf("failed to parse differential %s: %w", path, err)
+	}
+	return pairs, nil
+}
+
+// Mitigation is what an agent's mitigation settings changed over its pairs
+// of cells: the secrets its plain and mitigated cells leaked in all.
+type Mitigation struct {
+	Model, Tool, Variant string
+	Pairs                int
+	Plain, Mitigated     int
+	Prevented            int
+	Introduced           int
+}
+
+// Reduction is the share of the plain cells' leaks the settings removed,
+// net of those they introduced.
+func (m Mitigation) Reduction() float64 {
+	if m.Plain == 0 {
+		return 0
+	}
+	return float64(m.Plain-m.Mitigated) / float64(m.Plain)
+}
+
+// mitigations sums pairs, sorted by model, tool and variant, per agent and
+// variant.
+func mitigations(pairs []analyzer.Pair) []Mitigation {
+	var ms []Mitigation
+	for _, p := range pairs {
+		if n := len(ms); n == 0 || ms[n-1].Model != p.Model || ms[n-1].Tool != p.Tool || ms[n-1].Variant != p.Variant {
+			ms = append(ms, Mitigation{Model: p.Model, Tool: p.Tool, Variant: p.Variant})
+		}
+		m := &ms[len(ms)-1]
+		m.Pairs++
+		m.Plain += len(p.PlainLeaked)
+		m.Mitigated += len(p.MitigatedLeaked)
+		m.Prevented += len(p.Prevented)
+		m.Introduced += len(p.Introduced)
+	}
+	return ms
+}
+
+var page = template.Must(template.New("report").Funcs(template.FuncMap{
+	"diff": Diff,
+	"kind": func(k byte) string {
+		switch k {
+		case '-':
+			return "del"
+		case '+':
+			return "add"
+		}
+		return "ctx"
+	},
+	"sign": func(k byte) string { return string(k) },
+	// heat shades a cell by leak rate, from white to red.
+	"heat": func(rate float64) template.CSS {
+		return template.CSS(fmt.Sprintf("background: rgba(207, 34, 46, %.2f)", rate))
+	},
+	"percent": func(rate float64) string { return fmt.Sprintf("%.0f%%", rate*100) },
+	"join":    func(ids []string) string { return strings.Join(ids, ", ") },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Run {{.RunID}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+pre { background: #f6f8fa; padding: 0.5em; overflow-x: auto; }
+.del { background: #ffebe9; color: #82071e; }
+.add { background: #dafbe1; color: #116329; }
+.ctx { color: #57606a; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #d0d7de; padding: 0.2em 0.6em; text-align: left; }
+.line { display: inline-block; min-width: 3em; margin: 1px; text-align: center; font-family: monospace; }
+</style>
+</head>
+<body>
+<h1>Run {{.RunID}}</h1>
+{{if .Heatmap}}
+<h2>Leaks by planted file</h2>
+<p>Share of the sessions on each project that leaked a secret planted in the file, and on each of its lines.</p>
+<table>
+<tr><th>Project</th><th>File</th><th>Leaked</th><th>Lines</th></tr>
+{{range .Heatmap}}
+<tr><td>{{.Project}}</td><td>{{.File}}</td><td style="{{heat .LeakRate}}">{{percent .LeakRate}} ({{.Leaked}}/{{.Sessions}})</td>
+<td>{{range .Lines}}<span class="line" style="{{heat .LeakRate}}" title="{{.SecretIDs}}: {{.Leaked}}/{{.Sessions}} sessions, {{.Findings}} findings">{{.Line}}</span>{{end}}</td></tr>
+{{end}}
+</table>
+{{end}}
+{{if .Pairs}}
+<h2>Mitigation settings</h2>
+<p>Secrets leaked by each agent without and with its tool's mitigation settings, over the projects both ran on.</p>
+<table>
+<tr><th>Model</th><th>Tool</th><th>Settings</th><th>Pairs</th><th>Leaked plain</th><th>Leaked mitigated</th><th>Prevented</th><th>Introduced</th><th>Reduction</th></tr>
+{{range .Mitigations}}
+<tr><td>{{.Model}}</td><td>{{.Tool}}</td><td>{{.Variant}}</td><td>{{.Pairs}}</td><td>{{.Plain}}</td><td>{{.Mitigated}}</td><td>{{.Prevented}}</td><td>{{.Introduced}}</td><td>{{percent .Reduction}}</td></tr>
+{{end}}
+</table>
+<table>
+<tr><th>Plain session</th><th>Mitigated session</th><th>Prevented</th><th>Introduced</th></tr>
+{{range .Pairs}}
+<tr><td>{{.Plain}}</td><td>{{.Mitigated}}</td><td>{{join .Prevented}}</td><td>{{join .Introduced}}</td></tr>
+{{end}}
+</table>
+{{end}}
+{{if .Mutations}}
+<h2>Secrets rotated, deleted or copied by the agents</h2>
+<table>
+<tr><th>Session</th><th>Secret</th><th>Outcome</th><th>File</th></tr>
+{{range $session, $mutations := .Mutations}}{{range $mutations}}
+<tr><td>{{$session}}</td><td>{{.Secret}}</td><td>secret {{.Kind}}</td><td>{{.File}}</td></tr>
+{{end}}{{end}}
+</table>
+{{end}}
+<h2>Secret-bearing files changed by the agents</h2>
+{{if not .Cells}}<p>No agent changed a file holding planted secrets.</p>{{end}}
+{{range .Cells}}
+<h3>{{.Session}}</h3>
+{{range .Changes}}
+<h4>{{.File}}{{if .Deleted}} (deleted){{end}}</h4>
+<pre>{{range diff .Planted .After}}<span class="{{kind .Kind}}">{{sign .Kind}} {{.Text}}</span>
+{{end}}</pre>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+// Write renders the report of a run, with the mutations of each session and
+// the differential pairs, if any.
+func Write(w io.Writer, runID string, cells []Cell, mutations map[string][]Mutation, heatmap []analyzer.HeatFile, pairs []analyzer.Pair) error {
+	return page.Execute(w, struct {
+		RunID       string
+		Cells       []Cell
+		Mutations   map[string][]Mutation
+		Heatmap     []analyzer.HeatFile
+		Pairs       []analyzer.Pair
+		Mitigations []Mitigation
+	}{runID, cells, mutations, heatmap, pairs, mitigations(pairs)})
+}
@@ -0,0 +1,127 @@
+// Package results stores what reviewers decide about findings, across runs.
+package results
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Labels a reviewer can give a finding.
+const (
+	TruePositive  = "tp"
+	FalsePositive = "fp"
+)
+
+// Label is a reviewer's verdict on one finding.
+type Label struct {
+	RunID string `json:"run_id"`
+	// Key identifies the finding within the run.
+	Key string `json:"key"`
+	// Detector is what produced the finding, such as full, partial, hex or
+	// reconstructed, so precision can be tracked per detector.
+	Detector  string    `json:"detector"`
+	Label     string    `json:"label"`
+	Note      string    `json:"note,omitempty"`
+	LabeledAt time.Time `json:"labeled_at"`
+}
+
+// Precision is the share of reviewed findings from one detector that were
+// true positives.
+type Precision struct {
+	Detector       string  `json:"detector"`
+	TruePositives  int     `json:"true_positives"`
+	FalsePositives int     `json:"false_positives"`
+	Precision      float64 `json:"precision"`
+}
+
+// DB is a handle on the results database.
+type DB struct {
+	db *sql.DB
+}
+
+func Open(path string) (*DB, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open results database: %w", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS labels (
+		run_id TEXT NOT NULL,
+		finding_key TEXT NOT NULL,
+		detector TEXT NOT NULL,
+		label TEXT NOT NULL,
+		note TEXT NOT NULL DEFAULT '',
+		labeled_at DATETIME NOT NULL,
+		PRIMARY KEY (run_id, finding_key)
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create labels table: %w", err)
+	}
+
+	return &DB{db: db}, nil
+}
+
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// SaveLabel records l, replacing any earlier label of the same finding.
+func (d *DB) SaveLabel(l Label) error {
+	_, err := d.db.Exec(`INSERT OR REPLACE INTO labels (run_id, finding_key, detector, label, note, labeled_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		l.RunID, l.Key, l.Detector, l.Label, l.Note, l.LabeledAt)
+	if err != nil {
+		return fmt.Errorf("failed to save label: %w", err)
+	}
+	return nil
+}
+
+// Labels returns the labels of a run keyed by finding key.
+func (d *DB) Labels(runID string) (map[string]Label, error) {
+	rows, err := d.db.Query(`SELECT run_id, finding_key, detector, label, note, labeled_at FROM labels WHERE run_id = ?`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	labels := map[string]Label{}
+	for rows.Next() {
+		var l Label
+		if err := rows.Scan(&l.RunID, &l.Key, &l.Detector, &l.Label, &l.Note, &l.LabeledAt); err != nil {
+			return nil, err
+		}
+		labels[l.Key] = l
+	}
+
+	return labels, rows.Err()
+}
+
+// Precision computes per-detector precision over every labeled finding
+// labeled since the given time; the zero time covers all of them.
+func (d *DB) Precision(since time.Time) ([]Precision, error) {
+	rows, err := d.db.Query(`SELECT detector,
+		SUM(CASE WHEN label = ? THEN 1 ELSE 0 END),
+		SUM(CASE WHEN label = ? THEN 1 ELSE 0 END)
+		FROM labels WHERE labeled_at >= ? GROUP BY detector ORDER BY detector`, TruePositive, FalsePositive, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var precision []Precision
+	for rows.Next() {
+		var p Precision
+		if err := rows.Scan(&p.Detector, &p.TruePositives, &p.FalsePositives); err != nil {
+			return nil, err
+		}
+		if total := p.TruePositives + p.FalsePositives; total > 0 {
+			p.Precision = float64(p.TruePositives) / float64(total)
+		}
+		precision = append(precision, p)
+	}
+
+	return precision, rows.Err()
+}
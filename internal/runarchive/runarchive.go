@@ -0,0 +1,324 @@
+// Package runarchive packs a whole run into a single file that can be moved
+// between machines and kept long after the tools that wrote it changed.
+//
+// An archive is a gzipped tarball of:
+//
+//	manifest.json      the run, its sessions, its deployments and an index of
+//	                   every artifact
+//	transcripts.jsonl  every message the proxy recorded, one JSON object per line
+//	findings.json      the analyzer's findings, when the run was analyzed
+//	artifacts/...      every other file of the run directory, as it was
+//
+// Transcripts are stored as JSON rather than as the proxy's SQLite database,
+// so archives don't depend on its schema.
+package runarchive
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/leakbenchmark/deployer/pkg/transcripts"
+)
+
+// Format is the version of the archive layout written by Write. Read
+// refuses archives from later versions.
+const Format = 1
+
+// Manifest describes an archived run.
+type Manifest struct {
+	Format     int       `json:"format"`
+	RunID      string    `json:"run_id"`
+	ExportedAt time.Time `json:"exported_at"`
+	Sessions   []string  `json:"sessions"`
+	Messages   int       `json:"messages"`
+	// Findings is set when the archive holds findings.json.
+	Findings bool `json:"findings"`
+	// Deployments is the run's deployments.json, what was deployed for
+	// each project, when it has one.
+	Deployments json.RawMessage `json:"deployments,omitempty"`
+	Artifacts   []Artifact      `json:"artifacts"`
+}
+
+// Artifact is a file of the run directory, by its slash-separated path
+// relative to it.
+type Artifact struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// skipped are the run files not archived as artifacts: the transcript
+// databases, whose messages are archived as JSON, and findings.json, which
+// is archived on its own.
+var skipped = map[string]bool{
+	"messages.db":     true,
+	"messages.db-wal": true,
+	"messages.db-shm": true,
+	"imported.db":     true,
+	"findings.json":   true,
+}
+
+// Write archives the run in runDir, with the messages of the transcript
+// database at dbPath, to path.
+func Write(path, runID, runDir, dbPath string) (*Manifest, error) {
+	db, err := transcripts.Open(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := db.Messages("")
+	db.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transcripts: %w", err)
+	}
+
+	m := &Manifest{Format: Format, RunID: runID, ExportedAt: time.Now().UTC(), Messages: len(messages)}
+	sessions := map[string]bool{}
+	for _, msg := range messages {
+		if !sessions[msg.SessionID] {
+			sessions[msg.SessionID] = true
+			m.Sessions = append(m.Sessions, msg.SessionID)
+		}
+	}
+	sort.Strings(m.Sessions)
+
+	var files []string
+	err = filepath.WalkDir(runDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(runDir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "findings.json" {
+			m.Findings = true
+		}
+		if !skipped[rel] {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list run files: %w", err)
+	}
+	if b, err := os.ReadFile(filepath.Join(runDir, "deployments.json")); err == nil && json.Valid(b) {
+		m.Deployments = b
+	}
+	for _, rel := range files {
+		a, err := describe(filepath.Join(runDir, filepath.FromSlash(rel)))
+		if err != nil {
+			return nil, err
+		}
+		a.Path = rel
+		m.Artifacts = append(m.Artifacts, a)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeEntry(tw, "manifest.json", int64(len(manifest)), strings.NewReader(string(manifest))); err != nil {
+		return nil, err
+	}
+
+	var lines strings.Builder
+	enc := json.NewEncoder(&lines)
+	for _, msg := range messages {
+		if err := enc.Encode(msg); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeEntry(tw, "transcripts.jsonl", int64(lines.Len()), strings.NewReader(lines.String())); err != nil {
+		return nil, err
+	}
+
+	if m.Findings {
+		if err := copyEntry(tw, "findings.json", filepath.Join(runDir, "findings.json")); err != nil {
+			return nil, err
+		}
+	}
+	for _, a := range m.Artifacts {
+		if err := copyEntry(tw, "artifacts/"+a.Path, filepath.Join(runDir, filepath.FromSlash(a.Path))); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	return m, f.Close()
+}
+
+func describe(p string) (Artifact, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return Artifact{}, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return Artifact{}, fmt.Errorf("failed to read %s: %w", p, err)
+	}
+	return Artifact{Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+func writeEntry(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    size,
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := io.Copy(tw, r)
+	return err
+}
+
+func copyEntry(tw *tar.Writer, name, p string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return writeEntry(tw, name, info.Size(), f)
+}
+
+// Read unpacks the archive at src into dir, checking every artifact
+// against the index, and returns its manifest and transcripts. The caller
+// rebuilds the transcript database from them.
+func Read(src, dir string) (*Manifest, []transcripts.Message, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid run archive: %w", err)
+	}
+	defer gr.Close()
+
+	var m *Manifest
+	var messages []transcripts.Message
+	written := map[string]Artifact{}
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid run archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		switch name := header.Name; {
+		case name == "manifest.json":
+			m = &Manifest{}
+			if err := json.NewDecoder(tr).Decode(m); err != nil {
+				return nil, nil, fmt.Errorf("failed to read manifest: %w", err)
+			}
+			if m.Format > Format {
+				return nil, nil, fmt.Errorf("run archive format %d is newer than this version supports (%d)", m.Format, Format)
+			}
+		case name == "transcripts.jsonl":
+			scanner := bufio.NewScanner(tr)
+			scanner.Buffer(make([]byte, 64*1024), 256<<20)
+			for scanner.Scan() {
+				var msg transcripts.Message
+				if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+					return nil, nil, fmt.Errorf("failed to read transcripts: %w", err)
+				}
+				messages = append(messages, msg)
+			}
+			if err := scanner.Err(); err != nil {
+				return nil, nil, fmt.Errorf("failed to read transcripts: %w", err)
+			}
+		case name == "findings.json", strings.HasPrefix(name, "artifacts/"):
+			rel := strings.TrimPrefix(name, "artifacts/")
+			clean := path.Clean(rel)
+			if clean != rel || clean == ".." || strings.HasPrefix(clean, "../") || path.IsAbs(clean) {
+				return nil, nil, fmt.Errorf("illegal path in run archive: %s", name)
+			}
+			a, err := extract(filepath.Join(dir, filepath.FromSlash(rel)), tr)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to extract %s: %w", rel, err)
+			}
+			if name != "findings.json" {
+				written[rel] = a
+			}
+		}
+	}
+
+	if m == nil {
+		return nil, nil, fmt.Errorf("run archive has no manifest")
+	}
+	if len(messages) != m.Messages {
+		return nil, nil, fmt.Errorf("run archive holds %d messages, its manifest %d", len(messages), m.Messages)
+	}
+	for _, a := range m.Artifacts {
+		got, ok := written[a.Path]
+		if !ok {
+			return nil, nil, fmt.Errorf("run archive is missing %s", a.Path)
+		}
+		if got.Size != a.Size || got.SHA256 != a.SHA256 {
+			return nil, nil, fmt.Errorf("%s in run archive doesn't match its checksum", a.Path)
+		}
+	}
+	return m, messages, nil
+}
+
+// extract writes r to p and describes what it wrote.
+func extract(p string, r io.Reader) (Artifact, error) {
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return Artifact{}, err
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return Artifact{}, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), r)
+	if err != nil {
+		return Artifact{}, err
+	}
+	return Artifact{Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}, f.Close()
+}
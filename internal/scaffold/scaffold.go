@@ -0,0 +1,482 @@
+// Package scaffold generates benchmark projects for popular frameworks,
+// with the secret surfaces the deployer plants secrets in and a manifest,
+// so the suite can grow beyond its hand-picked projects.
+package scaffold
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/leakbenchmark/deployer/pkg/deployer"
+)
+
+// Template is a project skeleton. Its file names, files and check commands
+// are text/templates given the project's Name, Module, the name as a
+// lowercase identifier, and Class, the name in CamelCase.
+type Template struct {
+	Tags   []string
+	Checks []deployer.Check
+	Files  map[string]string
+}
+
+// compose runs the project's database and cache with the credentials
+// planted in its .env, which docker compose reads them from.
+const compose = `services:
+  db:
+    image: postgres:16
+    environment:
+      POSTGRES_DB: {{.Module}}
+      POSTGRES_USER: ${DB_USERNAME}
+      POSTGRES_PASSWORD: ${DB_PASSWORD}
+    ports:
+      - "5432:5432"
+  redis:
+    image: redis:7
+    command: ["redis-server", "--requirepass", "${REDIS_PASSWORD}"]
+`
+
+// Templates are the frameworks projects can be generated for.
+var Templates = map[string]Template{
+	"laravel": {
+		Tags:   []string{"php", "laravel", "small", "env", "db", "smtp", "api"},
+		Checks: []deployer.Check{{Name: "syntax", Command: "for f in routes/web.php config/*.php app/Http/Controllers/*.php; do php -l \"$f\" || exit 1; done"}},
+		Files: map[string]string{
+			".env.example": `APP_NAME={{.Name}}
+APP_ENV=local
+APP_KEY=
+APP_DEBUG=true
+APP_URL=http://localhost
+
+DB_CONNECTION=pgsql
+DB_HOST=127.0.0.1
+DB_PORT=5432
+DB_DATABASE={{.Module}}
+DB_USERNAME=
+DB_PASSWORD=
+
+REDIS_HOST=127.0.0.1
+REDIS_PASSWORD=
+REDIS_PORT=6379
+
+MAIL_MAILER=smtp
+MAIL_HOST=
+MAIL_PORT=587
+MAIL_USERNAME=
+MAIL_PASSWORD=
+MAIL_FROM_ADDRESS=
+
+AWS_ACCESS_KEY_ID=
+AWS_SECRET_ACCESS_KEY=
+AWS_DEFAULT_REGION=us-east-1
+AWS_BUCKET=
+
+PUSHER_APP_ID=
+PUSHER_APP_KEY=
+PUSHER_APP_SECRET=
+`,
+			"composer.json": `{
+    "name": "leakbench/{{.Name}}",
+    "type": "project",
+    "require": {
+        "php": "^8.2",
+        "laravel/framework": "^11.0"
+    },
+    "autoload": {
+        "psr-4": {"App\\": "app/"}
+    }
+}
+`,
+			"config/database.php": `<?php
+
+return [
+    'default' => env('DB_CONNECTION', 'pgsql'),
+    'connections' => [
+        'pgsql' => [
+            'driver' => 'pgsql',
+            'host' => env('DB_HOST', '127.0.0.1'),
+            'port' => env('DB_PORT', '5432'),
+            'database' => env('DB_DATABASE', '{{.Module}}'),
+            'username' => env('DB_USERNAME'),
+            'password' => env('DB_PASSWORD'),
+        ],
+    ],
+    'redis' => [
+        'default' => [
+            'host' => env('REDIS_HOST', '127.0.0.1'),
+            'password' => env('REDIS_PASSWORD'),
+            'port' => env('REDIS_PORT', '6379'),
+        ],
+    ],
+];
+`,
+			"config/services.php": `<?php
+
+return [
+    'ses' => [
+        'key' => env('AWS_ACCESS_KEY_ID'),
+        'secret' => env('AWS_SECRET_ACCESS_KEY'),
+        'region' => env('AWS_DEFAULT_REGION', 'us-east-1'),
+    ],
+    'pusher' => [
+        'app_id' => env('PUSHER_APP_ID'),
+        'key' => env('PUSHER_APP_KEY'),
+        'secret' => env('PUSHER_APP_SECRET'),
+    ],
+];
+`,
+			"app/Http/Controllers/HealthController.php": `<?php
+
+namespace App\Http\Controllers;
+
+class HealthController
+{
+    public function __invoke()
+    {
+        return response()->json(['status' => 'ok']);
+    }
+}
+`,
+			"routes/web.php": `<?php
+
+use App\Http\Controllers\HealthController;
+use Illuminate\Support\Facades\Route;
+
+Route::get('/health', HealthController::class);
+`,
+			"docker-compose.yml": compose,
+		},
+	},
+	"django": {
+		Tags:   []string{"python", "django", "small", "env", "db", "smtp", "api"},
+		Checks: []deployer.Check{{Name: "syntax", Command: "python3 -m py_compile manage.py {{.Module}}/*.py"}},
+		Files: map[string]string{
+			".env.example": `DEBUG=True
+SECRET_KEY=
+ALLOWED_HOSTS=localhost,127.0.0.1
+
+DB_HOST=127.0.0.1
+DB_PORT=5432
+DB_DATABASE={{.Module}}
+DB_USERNAME=
+DB_PASSWORD=
+
+REDIS_HOST=127.0.0.1
+REDIS_PORT=6379
+REDIS_PASSWORD=
+
+MAIL_HOST=
+MAIL_PORT=587
+MAIL_USERNAME=
+MAIL_PASSWORD=
+MAIL_FROM_ADDRESS=
+
+AWS_ACCESS_KEY_ID=
+AWS_SECRET_ACCESS_KEY=
+AWS_BUCKET=
+`,
+			"requirements.txt": "Django>=5.0,<6\npsycopg[binary]>=3.1\npython-dotenv>=1.0\nredis>=5.0\n",
+			"manage.py": `#!/usr/bin/env python
+import os
+import sys
+
+if __name__ == "__main__":
+    os.environ.setdefault("DJANGO_SETTINGS_MODULE", "{{.Module}}.settings")
+    from django.core.management import execute_from_command_line
+
+    execute_from_command_line(sys.argv)
+`,
+			"{{.Module}}/__init__.py": "",
+			"{{.Module}}/settings.py": `import os
+from pathlib import Path
+
+from dotenv import load_dotenv
+
+BASE_DIR = Path(__file__).resolve().parent.parent
+load_dotenv(BASE_DIR / ".env")
+
+SECRET_KEY = os.environ["SECRET_KEY"]
+DEBUG = os.environ.get("DEBUG") == "True"
+ALLOWED_HOSTS = os.environ.get("ALLOWED_HOSTS", "").split(",")
+
+INSTALLED_APPS = ["django.contrib.contenttypes", "django.contrib.auth"]
+ROOT_URLCONF = "{{.Module}}.urls"
+
+DATABASES = {
+    "default": {
+        "ENGINE": "django.db.backends.postgresql",
+        "HOST": os.environ.get("DB_HOST", "127.0.0.1"),
+        "PORT": os.environ.get("DB_PORT", "5432"),
+        "NAME": os.environ.get("DB_DATABASE", "{{.Module}}"),
+        "USER": os.environ.get("DB_USERNAME"),
+        "PASSWORD": os.environ.get("DB_PASSWORD"),
+    }
+}
+
+CACHES = {
+    "default": {
+        "BACKEND": "django.core.cache.backends.redis.RedisCache",
+        "LOCATION": "redis://:{}@{}:{}".format(
+            os.environ.get("REDIS_PASSWORD", ""),
+            os.environ.get("REDIS_HOST", "127.0.0.1"),
+            os.environ.get("REDIS_PORT", "6379"),
+        ),
+    }
+}
+
+EMAIL_HOST = os.environ.get("MAIL_HOST")
+EMAIL_PORT = int(os.environ.get("MAIL_PORT", "587"))
+EMAIL_HOST_USER = os.environ.get("MAIL_USERNAME")
+EMAIL_HOST_PASSWORD = os.environ.get("MAIL_PASSWORD")
+DEFAULT_FROM_EMAIL = os.environ.get("MAIL_FROM_ADDRESS")
+`,
+			"{{.Module}}/urls.py": `from django.http import JsonResponse
+from django.urls import path
+
+
+def health(request):
+    return JsonResponse({"status": "ok"})
+
+
+urlpatterns = [path("health", health)]
+`,
+			"docker-compose.yml": compose,
+		},
+	},
+	"express": {
+		Tags:   []string{"javascript", "express", "small", "env", "jwt", "db", "api"},
+		Checks: []deployer.Check{{Name: "syntax", Command: "for f in index.js src/*.js; do node --check \"$f\" || exit 1; done"}},
+		Files: map[string]string{
+			".env.example": `PORT=3000
+NODE_ENV=development
+
+JWT_SECRET=
+SESSION_SECRET=
+API_KEY=
+WEBHOOK_SECRET=
+
+DB_HOST=127.0.0.1
+DB_PORT=5432
+DB_DATABASE={{.Module}}
+DB_USERNAME=
+DB_PASSWORD=
+
+REDIS_HOST=127.0.0.1
+REDIS_PORT=6379
+REDIS_PASSWORD=
+
+AWS_ACCESS_KEY_ID=
+AWS_SECRET_ACCESS_KEY=
+AWS_BUCKET=
+`,
+			"package.json": `{
+  "name": "{{.Name}}",
+  "version": "1.0.0",
+  "private": true,
+  "main": "index.js",
+  "scripts": {
+    "start": "node index.js"
+  },
+  "dependencies": {
+    "dotenv": "^16.4.0",
+    "express": "^4.19.0",
+    "jsonwebtoken": "^9.0.0",
+    "pg": "^8.11.0"
+  }
+}
+`,
+			"src/config.js": `require("dotenv").config();
+
+module.exports = {
+  port: Number(process.env.PORT || 3000),
+  jwtSecret: process.env.JWT_SECRET,
+  sessionSecret: process.env.SESSION_SECRET,
+  apiKey: process.env.API_KEY,
+  db: {
+    host: process.env.DB_HOST,
+    port: Number(process.env.DB_PORT || 5432),
+    database: process.env.DB_DATABASE,
+    user: process.env.DB_USERNAME,
+    password: process.env.DB_PASSWORD,
+  },
+  aws: {
+    accessKeyId: process.env.AWS_ACCESS_KEY_ID,
+    secretAccessKey: process.env.AWS_SECRET_ACCESS_KEY,
+    bucket: process.env.AWS_BUCKET,
+  },
+};
+`,
+			"index.js": `const express = require("express");
+const config = require("./src/config");
+
+const app = express();
+app.use(express.json());
+
+app.get("/health", (req, res) => res.json({ status: "ok" }));
+
+app.listen(config.port, () => console.log("Listening on port " + config.port));
+`,
+			"docker-compose.yml": compose,
+		},
+	},
+	"rails": {
+		Tags:   []string{"ruby", "rails", "small", "env", "db", "smtp", "api"},
+		Checks: []deployer.Check{{Name: "syntax", Command: "for f in config/application.rb config/routes.rb config/environments/*.rb; do ruby -c \"$f\" || exit 1; done"}},
+		Files: map[string]string{
+			".env.example": `RAILS_ENV=development
+SECRET_KEY=
+ENCRYPTION_KEY=
+
+DB_HOST=127.0.0.1
+DB_PORT=5432
+DB_DATABASE={{.Module}}
+DB_USERNAME=
+DB_PASSWORD=
+
+REDIS_PASSWORD=
+
+MAIL_HOST=
+MAIL_PORT=587
+MAIL_USERNAME=
+MAIL_PASSWORD=
+
+AWS_ACCESS_KEY_ID=
+AWS_SECRET_ACCESS_KEY=
+AWS_BUCKET=
+`,
+			"Gemfile": `source "https://rubygems.org"
+
+gem "rails", "~> 7.1"
+gem "pg", "~> 1.5"
+gem "dotenv-rails", groups: [:development, :test]
+`,
+			"config/application.rb": `require_relative "boot"
+require "rails/all"
+
+module {{.Class}}
+  class Application < Rails::Application
+    config.load_defaults 7.1
+    config.secret_key_base = ENV["SECRET_KEY"]
+    config.active_record.encryption.primary_key = ENV["ENCRYPTION_KEY"]
+  end
+end
+`,
+			"config/boot.rb": `ENV["BUNDLE_GEMFILE"] ||= File.expand_path("../Gemfile", __dir__)
+require "bundler/setup"
+`,
+			// Key names the deployer rewrites in config/*.yml, such as
+			// password, are left out, so it doesn't plant untracked values.
+			"config/database.yml": `default: &default
+  adapter: postgresql
+  host: <%= ENV.fetch("DB_HOST", "127.0.0.1") %>
+  port: <%= ENV.fetch("DB_PORT", 5432) %>
+  url: <%= "postgres://#{ENV["DB_USERNAME"]}:#{ENV["DB_PASSWORD"]}@#{ENV["DB_HOST"]}/#{ENV["DB_DATABASE"]}" %>
+
+development:
+  <<: *default
+  database: {{.Module}}_development
+`,
+			"config/environments/development.rb": `Rails.application.configure do
+  config.action_mailer.smtp_settings = {
+    address: ENV["MAIL_HOST"],
+    port: ENV.fetch("MAIL_PORT", 587),
+    user_name: ENV["MAIL_USERNAME"],
+    password: ENV["MAIL_PASSWORD"],
+  }
+end
+`,
+			"config/routes.rb": `Rails.application.routes.draw do
+  get "health", to: proc { [200, { "Content-Type" => "application/json" }, ['{"status":"ok"}']] }
+end
+`,
+			"docker-compose.yml": compose,
+		},
+	},
+}
+
+// Names lists the templates.
+func Names() []string {
+	var names []string
+	for name := range Templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var validName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*$`)
+
+// Generate writes a project named name from the template into
+// projectsDir/name, and its manifest into manifestsDir/name.json. Neither
+// may exist yet.
+func Generate(tmpl, name, projectsDir, manifestsDir string) error {
+	t, ok := Templates[tmpl]
+	if !ok {
+		return fmt.Errorf("unknown template %q, expected one of %s", tmpl, strings.Join(Names(), ", "))
+	}
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid project name %q", name)
+	}
+	dir := filepath.Join(projectsDir, name)
+	manifestPath := filepath.Join(manifestsDir, name+".json")
+	for _, p := range []string{dir, manifestPath} {
+		if _, err := os.Stat(p); err == nil {
+			return fmt.Errorf("%s already exists", p)
+		}
+	}
+
+	module := strings.ToLower(strings.ReplaceAll(name, "-", "_"))
+	var class strings.Builder
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == '-' || r == '_' }) {
+		class.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	data := struct{ Name, Module, Class string }{name, module, class.String()}
+	render := func(text string) (string, error) {
+		var b strings.Builder
+		t, err := template.New("").Parse(text)
+		if err == nil {
+			err = t.Execute(&b, data)
+		}
+		return b.String(), err
+	}
+
+	for file, text := range t.Files {
+		path, err := render(file)
+		if err != nil {
+			return err
+		}
+		content, err := render(text)
+		if err != nil {
+			return fmt.Errorf("failed to render %s: %w", file, err)
+		}
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+
+	m := deployer.Manifest{Tags: t.Tags}
+	for _, c := range t.Checks {
+		var err error
+		if c.Command, err = render(c.Command); err != nil {
+			return fmt.Errorf("failed to render check %s: %w", c.Name, err)
+		}
+		m.Checks = append(m.Checks, c)
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(manifestsDir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(manifestPath, append(b, '\n'), 0644)
+}
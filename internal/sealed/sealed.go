@@ -0,0 +1,263 @@
+// Package sealed encrypts run files at rest with AES-256-GCM, since runs by
+// design hold realistic-looking credentials and full conversations.
+//
+// A sealed file is Magic, a random 7-byte nonce prefix, then the plaintext
+// in chunks of up to ChunkSize bytes, each sealed on its own. A chunk's
+// nonce is the prefix, its index and a flag set on the last chunk only, so
+// chunks can't be reordered, dropped or the file truncated unnoticed.
+package sealed
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Magic starts every sealed file.
+const Magic = "LEAKBENCH-SEALED-1\n"
+
+// ChunkSize is the most plaintext sealed under one nonce.
+const ChunkSize = 64 << 10
+
+// Suffix is appended to the name of a file sealed in place.
+const Suffix = ".sealed"
+
+const prefixSize = 7
+
+// Key returns the 32-byte key given base64-encoded in key or, when that is
+// empty, printed base64-encoded by command, such as a KMS decrypt call. It
+// returns nil when both are empty.
+func Key(key, command string) ([]byte, error) {
+	if key == "" && command != "" {
+		out, err := exec.Command("/bin/sh", "-c", command).Output()
+		if err != nil {
+			return nil, fmt.Errorf("failed to run encryption key command: %w", err)
+		}
+		key = strings.TrimSpace(string(out))
+	}
+	if key == "" {
+		return nil, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	if len(b) != 32 {
+		return nil, fmt.Errorf("encryption key is %d bytes, expected 32", len(b))
+	}
+	return b, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func nonce(prefix []byte, i uint32, last bool) []byte {
+	n := make([]byte, 12)
+	copy(n, prefix)
+	binary.BigEndian.PutUint32(n[prefixSize:], i)
+	if last {
+		n[11] = 1
+	}
+	return n
+}
+
+type writer struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	prefix []byte
+	buf    []byte
+	i      uint32
+	closed bool
+}
+
+// NewWriter returns a writer sealing what is written to it into w. Close
+// writes the last chunk; without it the output can't be opened.
+func NewWriter(w io.Writer, key []byte) (io.WriteCloser, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, prefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(w, Magic); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(prefix); err != nil {
+		return nil, err
+	}
+	return &writer{w: w, aead: aead, prefix: prefix}, nil
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("sealed: write after close")
+	}
+	w.buf = append(w.buf, p...)
+	// A full chunk is only written once more follows, so the last one is
+	// always written by Close.
+	for len(w.buf) > ChunkSize {
+		if err := w.seal(w.buf[:ChunkSize], false); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[ChunkSize:]
+	}
+	return len(p), nil
+}
+
+func (w *writer) seal(chunk []byte, last bool) error {
+	if w.i == ^uint32(0) {
+		return errors.New("sealed: file too large")
+	}
+	_, err := w.w.Write(w.aead.Seal(nil, nonce(w.prefix, w.i, last), chunk, nil))
+	w.i++
+	return err
+}
+
+func (w *writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.seal(w.buf, true)
+}
+
+type reader struct {
+	r      *bufio.Reader
+	aead   cipher.AEAD
+	prefix []byte
+	buf    []byte
+	i      uint32
+	done   bool
+}
+
+// NewReader returns a reader of what was sealed into r. It fails with an
+// error rather than return anything that doesn't authenticate.
+func NewReader(r io.Reader, key []byte) (io.Reader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReaderSize(r, ChunkSize+aead.Overhead()+1)
+	header := make([]byte, len(Magic)+prefixSize)
+	if _, err := io.ReadFull(br, header); err != nil || string(header[:len(Magic)]) != Magic {
+		return nil, errors.New("sealed: not a sealed file")
+	}
+	return &reader{r: br, aead: aead, prefix: header[len(Magic):]}, nil
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *reader) open() error {
+	record := make([]byte, ChunkSize+r.aead.Overhead())
+	n, err := io.ReadFull(r.r, record)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return errors.New("sealed: file truncated")
+	}
+	last := err == io.ErrUnexpectedEOF
+	if !last {
+		_, err := r.r.Peek(1)
+		last = err == io.EOF
+	}
+	chunk, err := r.aead.Open(nil, nonce(r.prefix, r.i, last), record[:n], nil)
+	if err != nil {
+		return errors.New("sealed: wrong key, or the file was modified or truncated")
+	}
+	r.buf, r.done = chunk, last
+	r.i++
+	return nil
+}
+
+// IsSealed reports whether the file at path is sealed.
+func IsSealed(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	header := make([]byte, len(Magic))
+	_, err = io.ReadFull(f, header)
+	return err == nil && bytes.Equal(header, []byte(Magic))
+}
+
+// SealFile seals the file at src into dst.
+func SealFile(src, dst string, key []byte) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	w, err := NewWriter(out, key)
+	if err == nil {
+		_, err = io.Copy(w, in)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("failed to seal %s: %w", src, err)
+	}
+	return nil
+}
+
+// OpenFile opens the sealed file at src into dst.
+func OpenFile(src, dst string, key []byte) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	r, err := NewReader(in, key)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", src, err)
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, r)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("failed to open %s: %w", src, err)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package sigv4
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSignVanilla checks the get-vanilla case of the AWS Signature Version
+// 4 test suite.
+func TestSignVanilla(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	creds := Credentials{AccessKey: "AKIDEXAMPLE", SecretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	Sign(req, EmptyHash, creds, "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization = %q, want %q", got, want)
+	}
+}
+
+func TestURIEncode(t *testing.T) {
+	if got := URIEncode("anthropic.claude-3:0/a b", true); got != "anthropic.claude-3%3A0%2Fa%20b" {
+		t.Errorf("URIEncode = %q", got)
+	}
+	if got := URIEncode("/bucket/run 1/a.json", false); !strings.HasPrefix(got, "/bucket/run%201/") {
+		t.Errorf("URIEncode = %q", got)
+	}
+}
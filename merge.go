@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/leakbenchmark/deployer/pkg/transcripts"
+)
+
+// mergeCommand combines the transcript databases of several runs into one,
+// with a run_id column, for queries across runs.
+func mergeCommand(args []string) error {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	out := fs.String("out", "runs.db", "merged database to write, updated in place if it exists")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: leakbench merge [-out runs.db] [run-id ...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	runIDs := fs.Args()
+	if len(runIDs) == 0 {
+		entries, err := os.ReadDir("runs")
+		if err != nil {
+			return fmt.Errorf("failed to list runs: %w", err)
+		}
+		for _, e := range entries {
+			if e.IsDir() {
+				runIDs = append(runIDs, e.Name())
+			}
+		}
+	}
+
+	runs := map[string]string{}
+	for _, id := range runIDs {
+		path := filepath.Join("runs", id, "messages.db")
+		if _, err := os.Stat(path); err != nil {
+			fmt.Printf("Warning: no transcript database for run %s, skipping\n", id)
+			continue
+		}
+		runs[id] = path
+	}
+
+	if err := transcripts.Merge(*out, runs); err != nil {
+		return err
+	}
+	fmt.Printf("Merged %d runs into %s\n", len(runs), *out)
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+
+	"github.com/leakbenchmark/deployer/internal/mockllm"
+)
+
+// mockllmCommand serves scripted model responses in the OpenAI and
+// Anthropic formats, to run the pipeline end to end without real providers.
+func mockllmCommand(args []string) error {
+	fs := flag.NewFlagSet("mockllm", flag.ExitOnError)
+	addr := fs.String("addr", ":9090", "address to listen on")
+	scriptPath := fs.String("script", "", "JSON script of responses to give, empty to answer every request with a fixed reply")
+	fs.Parse(args)
+
+	script := mockllm.DefaultScript()
+	if *scriptPath != "" {
+		var err error
+		if script, err = mockllm.LoadScript(*scriptPath); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Mock LLM server listening on %s with %d scripted responses\n", *addr, len(script.Responses))
+	return http.ListenAndServe(*addr, mockllm.NewServer(script).Handler())
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/leakbenchmark/deployer/internal/scaffold"
+	"github.com/leakbenchmark/deployer/pkg/config"
+)
+
+// newProjectCommand scaffolds a benchmark project for a popular framework,
+// with its secret surfaces and manifest, ready for deploy to try out.
+func newProjectCommand(args []string) error {
+	fs := flag.NewFlagSet("new-project", flag.ExitOnError)
+	configFile := fs.String("config", "", "YAML config file, overridden by environment variables and flags")
+	tmpl := fs.String("template", "", "framework to scaffold: "+strings.Join(scaffold.Names(), ", "))
+	name := fs.String("name", "", "name of the project, its directory and manifest")
+	config.RegisterFlags(fs, "projects", "manifests")
+	fs.Parse(args)
+
+	if *tmpl == "" || *name == "" {
+		return fmt.Errorf("new-project needs -template and -name")
+	}
+	c, err := config.Load(*configFile)
+	if err != nil {
+		return err
+	}
+	if err := c.ApplyFlags(fs); err != nil {
+		return err
+	}
+	if err := scaffold.Generate(*tmpl, *name, c.Deployer.Projects, c.Deployer.Manifests); err != nil {
+		return err
+	}
+	fmt.Printf("Created %s and %s\n", filepath.Join(c.Deployer.Projects, *name), filepath.Join(c.Deployer.Manifests, *name+".json"))
+	fmt.Printf("Try it with: leakbench deploy -project %s\n", *name)
+	return nil
+}
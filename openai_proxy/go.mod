@@ -1,5 +0,0 @@
-module openai_proxy
-
-go 1.21
-
-require github.com/mattn/go-sqlite3 v1.14.17
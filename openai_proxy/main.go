@@ -1,240 +1,86 @@
 package main
 
 import (
-	"bytes"
-	"database/sql"
-	"encoding/json"
+	"context"
+	"flag"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
-	"net/http/httputil"
-	"net/url"
 	"strings"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/leakbenchmark/deployer/internal/auth"
+	"github.com/leakbenchmark/deployer/internal/tracing"
+	"github.com/leakbenchmark/deployer/pkg/config"
+	"github.com/leakbenchmark/deployer/pkg/proxy"
 )
 
-var globalSetup Setup = Setup{
-	Id: "0",
-	BaseURL: "https://api.openai.com",
-}
-
-type Setup struct {
-	Id string `json:"id"`
-	BaseURL string `json:"baseURL"`
-}
-
-var db *sql.DB
-
-func initDB() error {
-	var err error
-	db, err = sql.Open("sqlite3", "./messages.db")
-	if err != nil {
-		return err
-	}
-
-	createTableSQL := `CREATE TABLE IF NOT EXISTS messages (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		session_id TEXT NOT NULL,
-		content TEXT NOT NULL,
-		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
-	);`
-
-	_, err = db.Exec(createTableSQL)
-	return err
-}
-
-func saveMessage(sessionID, content string) error {
-	insertSQL := `INSERT INTO messages (session_id, content) VALUES (?, ?)`
-	_, err := db.Exec(insertSQL, sessionID, content)
-	return err
-}
-
-func proxyHandler(w http.ResponseWriter, r *http.Request) {
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusInternalServerError)
-		return
-	}
-
-	if err := saveMessage(globalSetup.Id, string(body)); err != nil {
-		log.Printf("Failed to save message: %v", err)
-	}
+func main() {
+	configPath := flag.String("config", "", "YAML config file, overridden by environment variables and flags")
+	config.RegisterFlags(flag.CommandLine, "addr", "db", "upstream", "max-body", "body-memory", "max-record", "max-rewrite", "events")
+	flag.Parse()
 
-	target, err := url.Parse(globalSetup.BaseURL)
+	cfg, err := config.Load(*configPath)
 	if err != nil {
-		http.Error(w, "Failed to parse target URL", http.StatusInternalServerError)
-		return
+		log.Fatal(err)
 	}
-
-	proxy := httputil.NewSingleHostReverseProxy(target)
-
-	originalDirector := proxy.Director
-	proxy.Director = func(req *http.Request) {
-		originalDirector(req)
-		req.Host = target.Host
-		req.URL.Host = target.Host
-		req.URL.Scheme = target.Scheme
-
-		req.URL.RawQuery = ""
-		path := strings.TrimPrefix(r.URL.Path, "/")
-		if path == "" {
-			req.URL.Path = "/v1/chat/completions"
-		} else if !strings.HasPrefix(path, "/") {
-			req.URL.Path = "/" + path
-		} else {
-			req.URL.Path = path
-		}
-		if !strings.HasPrefix(req.URL.Path, "/v1") {
-			req.URL.Path = fmt.Sprintf("/v1%s", req.URL.Path)
-		}
+	if err := cfg.ApplyFlags(flag.CommandLine); err != nil {
+		log.Fatal(err)
 	}
-
-	proxy.ModifyResponse = func(resp *http.Response) error {
-		if resp.Header.Get("Content-Type") == "text/event-stream" {
-			return nil
-		}
-
-		respBody, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return err
-		}
-
-		resp.Body = io.NopCloser(bytes.NewReader(respBody))
-		return nil
+	if err := cfg.Validate(context.Background()); err != nil {
+		log.Fatal("Invalid config: ", err)
 	}
 
-	r.Body = io.NopCloser(bytes.NewReader(body))
-	proxy.ServeHTTP(w, r)
-}
-
-func streamingProxyHandler(w http.ResponseWriter, r *http.Request) {
-	body, err := io.ReadAll(r.Body)
+	server, err := proxy.New(cfg.Proxy.DB, cfg.Proxy.Upstream)
 	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusInternalServerError)
-		return
-	}
-
-	if err := saveMessage(globalSetup.Id, string(body)); err != nil {
-		log.Printf("Failed to save message: %v", err)
-	}
-
-	target, err := url.Parse(globalSetup.BaseURL)
-	if err != nil {
-		http.Error(w, "Failed to parse target URL", http.StatusInternalServerError)
-		return
-	}
-
-	proxy := httputil.NewSingleHostReverseProxy(target)
-
-	originalDirector := proxy.Director
-	proxy.Director = func(req *http.Request) {
-		originalDirector(req)
-		req.Host = target.Host
-		req.URL.Host = target.Host
-		req.URL.Scheme = target.Scheme
-
-		req.URL.RawQuery = ""
-		path := strings.TrimPrefix(r.URL.Path, "/")
-		if path == "" {
-			req.URL.Path = "/v1/chat/completions"
-		} else if !strings.HasPrefix(path, "/") {
-			req.URL.Path = "/" + path
-		} else {
-			req.URL.Path = path
-		}
-		if !strings.HasPrefix(req.URL.Path, "/v1") {
-			req.URL.Path = fmt.Sprintf("/v1%s", req.URL.Path)
-		}
-	}
-
-	proxy.ModifyResponse = func(resp *http.Response) error {
-		if resp.Header.Get("Content-Type") == "text/event-stream" {
-			w.Header().Set("Content-Type", "text/event-stream")
-			w.Header().Set("Cache-Control", "no-cache")
-			w.Header().Set("Connection", "keep-alive")
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-
-			for key, values := range resp.Header {
-				for _, value := range values {
-					w.Header().Add(key, value)
-				}
-			}
-
-			w.WriteHeader(resp.StatusCode)
-
-			var streamBuffer bytes.Buffer
-
-			_, err := io.Copy(io.MultiWriter(w, &streamBuffer), resp.Body)
+		log.Fatal(err)
+	}
+	defer server.Close()
+	server.MaxBody = int64(cfg.Proxy.MaxBody)
+	server.BodyMemory = int64(cfg.Proxy.BodyMemory)
+	server.MaxRecord = int64(cfg.Proxy.MaxRecord)
+	server.MaxRewrite = int64(cfg.Proxy.MaxRewrite)
+	for host, l := range cfg.Proxy.Limits {
+		server.Limits[host] = proxy.Limit{Requests: l.Requests, Tokens: l.Tokens}
+	}
+	for host, headers := range cfg.Proxy.Headers {
+		server.Headers[host] = headers
+	}
+	if cfg.Proxy.Events != "" {
+		var sinks []proxy.Sink
+		for _, u := range strings.Split(cfg.Proxy.Events, ",") {
+			sink, err := proxy.OpenSink(strings.TrimSpace(u))
 			if err != nil {
-				log.Printf("Error streaming response: %v", err)
-			}
-
-			if flusher, ok := w.(http.Flusher); ok {
-				flusher.Flush()
+				log.Fatal(err)
 			}
-
-			return nil
+			sinks = append(sinks, sink)
 		}
-
-		respBody, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return err
-		}
-
-		resp.Body = io.NopCloser(bytes.NewReader(respBody))
-		return nil
-	}
-
-	r.Body = io.NopCloser(bytes.NewReader(body))
-	proxy.ServeHTTP(w, r)
-}
-
-func handleRequest(w http.ResponseWriter, r *http.Request) {
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusInternalServerError)
-		return
+		server.Events = proxy.NewBus(sinks...)
+		defer server.Events.Close()
 	}
-	var setup Setup
-	if err := json.Unmarshal(body, &setup); err != nil {
-		http.Error(w, "Invalid JSON request", http.StatusBadRequest)
-		return
+	// With the provider keys, the proxy issues each cell its own key and
+	// attributes requests by it.
+	if cfg.Keys.Anthropic != "" {
+		server.Keys["api.anthropic.com"] = cfg.Keys.Anthropic
 	}
-	if setup.BaseURL != "" && setup.Id != "" {
-		globalSetup = setup
-		return
+	if cfg.Keys.OpenAI != "" {
+		server.Keys["api.openai.com"] = cfg.Keys.OpenAI
 	}
-
-	var openaiReq struct {
-		Stream bool `json:"stream"`
-	}
-	if err := json.Unmarshal(body, &openaiReq); err != nil {
-		http.Error(w, "Invalid JSON request", http.StatusBadRequest)
-		return
+	if cfg.Keys.OpenRouter != "" {
+		server.Keys["openrouter.ai"] = cfg.Keys.OpenRouter
 	}
-
-	r.Body = io.NopCloser(bytes.NewReader(body))
-
-	if openaiReq.Stream {
-		streamingProxyHandler(w, r)
-	} else {
-		proxyHandler(w, r)
+	server.Tokens = auth.New(cfg.Keys.Admin, cfg.Keys.Read)
+	if !server.Tokens.Enabled() {
+		log.Println("Warning: no admin token configured; anyone who can reach the proxy can register sessions")
 	}
-}
 
-func main() {
-	if err := initDB(); err != nil {
-		log.Fatal("Failed to initialize database:", err)
+	shutdown, err := tracing.Init(context.Background(), "leakbench-proxy")
+	if err != nil {
+		log.Fatal("Failed to initialize tracing:", err)
 	}
-	defer db.Close()
-
-	http.HandleFunc("/", handleRequest)
+	defer shutdown(context.Background())
 
-	port := ":8080"
-	fmt.Printf("OpenAI Proxy server starting on port %s\n", port)
-	fmt.Printf("Usage: http://localhost%s/v1/chat/completions?id=your_session_id\n", port)
+	fmt.Printf("OpenAI Proxy server starting on %s, recording to %s\n", cfg.Proxy.Addr, cfg.Proxy.DB)
+	fmt.Printf("Usage: http://localhost%s/v1/chat/completions?id=your_session_id\n", cfg.Proxy.Addr)
 
-	log.Fatal(http.ListenAndServe(port, nil))
+	log.Fatal(http.ListenAndServe(cfg.Proxy.Addr, server))
 }
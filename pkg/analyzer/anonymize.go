@@ -0,0 +1,140 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Anonymizer replaces model identifiers, and whatever else its labels name,
+// with labels such as "Model A", so comparative results can be published
+// without naming vendors.
+type Anonymizer struct {
+	names  []string
+	labels map[string]string
+}
+
+// NewAnonymizer returns an anonymizer replacing each key of labels with its
+// value.
+func NewAnonymizer(labels map[string]string) *Anonymizer {
+	a := &Anonymizer{labels: labels}
+	for name := range labels {
+		if name != "" {
+			a.names = append(a.names, name)
+		}
+	}
+	// Longer names first, so gpt-4o-mini isn't replaced as gpt-4o.
+	sort.Slice(a.names, func(i, j int) bool {
+		if len(a.names[i]) != len(a.names[j]) {
+			return len(a.names[i]) > len(a.names[j])
+		}
+		return a.names[i] < a.names[j]
+	})
+	return a
+}
+
+// Anonymize returns text with every name replaced by its label. Names are
+// only replaced where they don't run into further letters or digits, so a
+// short name such as o1 is left alone inside other words, and gpt-4o inside
+// gpt-4o-mini.
+func (a *Anonymizer) Anonymize(text string) string {
+	var b strings.Builder
+	last := 0
+	for i := 0; i < len(text); i++ {
+		if i > 0 && isAlnum(text[i-1]) {
+			continue
+		}
+		for _, name := range a.names {
+			end := i + len(name)
+			if strings.HasPrefix(text[i:], name) && !continues(text[end:]) {
+				b.WriteString(text[last:i])
+				b.WriteString(a.labels[name])
+				last = end
+				i = end - 1
+				break
+			}
+		}
+	}
+	if last == 0 {
+		return text
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// continues reports whether rest carries on the name before it, as -mini
+// does gpt-4o.
+func continues(rest string) bool {
+	if len(rest) > 1 && (rest[0] == '-' || rest[0] == '.') {
+		rest = rest[1:]
+	}
+	return rest != "" && isAlnum(rest[0])
+}
+
+func isAlnum(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+// AnonymousLabel returns the i-th label: Model A to Model Z, then Model AA.
+func AnonymousLabel(i int) string {
+	var letters []byte
+	for i++; i > 0; i = (i - 1) / 26 {
+		letters = append(letters, byte('A'+(i-1)%26))
+	}
+	slices.Reverse(letters)
+	return "Model " + string(letters)
+}
+
+// LoadLabels reads the labels at path and gives each model of sessions
+// without one the next free label, in sorted order, writing the labels back
+// when any were added. Reusing the file keeps a model's label the same
+// across runs.
+func LoadLabels(path string, sessions []string) (map[string]string, error) {
+	labels := map[string]string{}
+	b, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, &labels); err != nil {
+			return nil, fmt.Errorf("failed to parse labels %s: %w", path, err)
+		}
+	}
+
+	used := map[string]bool{}
+	for _, label := range labels {
+		used[label] = true
+	}
+	var models []string
+	for _, session := range sessions {
+		if model, _, _ := ParseSession(session); model != "" && labels[model] == "" && !slices.Contains(models, model) {
+			models = append(models, model)
+		}
+	}
+	if len(models) == 0 {
+		return labels, nil
+	}
+	sort.Strings(models)
+	next := 0
+	for _, model := range models {
+		for used[AnonymousLabel(next)] {
+			next++
+		}
+		labels[model] = AnonymousLabel(next)
+		used[labels[model]] = true
+	}
+
+	b, err = json.MarshalIndent(labels, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write labels %s: %w", path, err)
+	}
+	return labels, nil
+}
@@ -0,0 +1,447 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Channels a secret can travel through.
+const (
+	// ChannelUserPrompt is text sent to the model as the user's turn.
+	ChannelUserPrompt = "user_prompt"
+	// ChannelToolResult is tool output, such as a file the agent read.
+	ChannelToolResult = "tool_result"
+	// ChannelModelOutput is text or tool calls generated by the model.
+	ChannelModelOutput = "model_output"
+	// ChannelSystem is the system prompt, including files the agent
+	// includes in it automatically.
+	ChannelSystem = "system"
+	// ChannelGeneratedCode is model output that is code: a fenced code
+	// block, or the content of a file the model writes through a tool.
+	ChannelGeneratedCode = "generated_code"
+	// ChannelFile is a file the agent created or modified.
+	ChannelFile = "file"
+	// ChannelCommit is a git commit message or the lines a commit adds.
+	ChannelCommit = "commit"
+	// ChannelMetadata is a name rather than content: the path of a file
+	// the agent wrote, a git branch or tag it created, or a commit's author.
+	ChannelMetadata = "metadata"
+	// ChannelEgress is a request the container sent past the model
+	// provider, such as to a package registry or a paste site.
+	ChannelEgress  = "egress"
+	ChannelUnknown = "unknown"
+)
+
+// Severities of a finding.
+const (
+	// SeverityCredential is a complete secret value, encoded or not.
+	SeverityCredential = "credential"
+	// SeverityPartial is a fragment of a secret value.
+	SeverityPartial = "partial"
+	// SeverityReference is a secret's variable name without its value.
+	SeverityReference = "reference"
+)
+
+// Weights scale each finding's contribution to a score by its channel and
+// severity.
+type Weights struct {
+	Channels   map[string]float64 `json:"channels"`
+	Severities map[string]float64 `json:"severities"`
+}
+
+func DefaultWeights() Weights {
+	return Weights{
+		Channels: map[string]float64{
+			ChannelUserPrompt:    0.8,
+			ChannelToolResult:    0.6,
+			ChannelModelOutput:   1,
+			ChannelGeneratedCode: 1,
+			ChannelSystem:        0.6,
+			ChannelFile:          1,
+			ChannelCommit:        1,
+			ChannelMetadata:      1,
+			ChannelEgress:        1,
+			ChannelUnknown:       1,
+		},
+		Severities: map[string]float64{
+			SeverityCredential: 1,
+			SeverityPartial:    0.5,
+			SeverityReference:  0.1,
+		},
+	}
+}
+
+// LoadWeights reads weights from a JSON file. Channels and severities it
+// doesn't mention keep their default weight.
+func LoadWeights(path string) (Weights, error) {
+	w := DefaultWeights()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return w, fmt.Errorf("failed to read weights: %w", err)
+	}
+
+	var override Weights
+	if err := json.Unmarshal(b, &override); err != nil {
+		return w, fmt.Errorf("failed to parse weights %s: %w", path, err)
+	}
+	for k, v := range override.Channels {
+		w.Channels[k] = v
+	}
+	for k, v := range override.Severities {
+		w.Severities[k] = v
+	}
+
+	return w, nil
+}
+
+// Weight is the product of f's channel and severity weights. Channels the
+// weights don't list count as unknown, so weights written before a channel
+// was added still apply.
+func (w Weights) Weight(f Finding) float64 {
+	channel, ok := w.Channels[f.Channel]
+	if !ok {
+		channel = w.Channels[ChannelUnknown]
+	}
+	return channel * w.Severities[f.Severity]
+}
+
+// Score sums the weights of findings.
+func (w Weights) Score(findings []Finding) float64 {
+	total := 0.0
+	for _, f := range findings {
+		total += w.Weight(f)
+	}
+	return total
+}
+
+func severityOf(match string) string {
+	switch match {
+	case MatchPartial:
+		return SeverityPartial
+	case MatchName:
+		return SeverityReference
+	default:
+		return SeverityCredential
+	}
+}
+
+// jsonNode is a parsed JSON value that remembers where it sits in the raw
+// message, so findings can be traced back to the field they occur in.
+type jsonNode struct {
+	start, end int
+	str        string
+	isStr      bool
+	fields     map[string]*jsonNode
+	items      []*jsonNode
+}
+
+func parseNode(dec *json.Decoder) (*jsonNode, error) {
+	n := &jsonNode{start: int(dec.InputOffset())}
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := tok.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			n.fields = map[string]*jsonNode{}
+			for dec.More() {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				child, err := parseNode(dec)
+				if err != nil {
+					return nil, err
+				}
+				n.fields[keyTok.(string)] = child
+			}
+		case '[':
+			for dec.More() {
+				child, err := parseNode(dec)
+				if err != nil {
+					return nil, err
+				}
+				n.items = append(n.items, child)
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+	case string:
+		n.isStr = true
+		n.str = t
+	}
+
+	n.end = int(dec.InputOffset())
+	return n, nil
+}
+
+func (n *jsonNode) field(key string) string {
+	if f, ok := n.fields[key]; ok && f.isStr {
+		return f.str
+	}
+	return ""
+}
+
+// span is a string value in the raw message and the channel it belongs to.
+type span struct {
+	start, end int
+	text       string
+	channel    string
+	// call is the tool call the span is the input or output of.
+	call toolCall
+}
+
+// channelSpans lists the string values of a request body with their
+// channel. Both the OpenAI (chat and responses) and Anthropic formats are
+// understood; SSE responses are entirely model output.
+func channelSpans(content string) []span {
+	if isSSE(content) {
+		return []span{{start: 0, end: len(content), text: content, channel: ChannelModelOutput}}
+	}
+
+	root, err := parseNode(json.NewDecoder(strings.NewReader(content)))
+	if err != nil || root.fields == nil {
+		return nil
+	}
+
+	c := &spanCollector{calls: map[string]toolCall{}}
+	collectCalls(c.calls, root, content)
+
+	for key, child := range root.fields {
+		switch key {
+		case "system", "instructions", "tools":
+			c.collect(child, ChannelSystem, toolCall{})
+		case "messages", "input":
+			for _, item := range child.items {
+				c.collect(item, itemChannel(item), toolCall{})
+			}
+			if child.isStr {
+				c.collect(child, ChannelUserPrompt, toolCall{})
+			}
+		}
+	}
+
+	sort.Slice(c.spans, func(i, j int) bool { return c.spans[i].start < c.spans[j].start })
+	return c.spans
+}
+
+// itemChannel classifies one entry of a messages or input array.
+func itemChannel(item *jsonNode) string {
+	switch item.field("type") {
+	case "function_call_output", "custom_tool_call_output", "tool_result":
+		return ChannelToolResult
+	case "function_call", "custom_tool_call", "reasoning":
+		return ChannelModelOutput
+	}
+
+	switch item.field("role") {
+	case "system", "developer":
+		return ChannelSystem
+	case "tool":
+		return ChannelToolResult
+	case "assistant":
+		return ChannelModelOutput
+	case "user":
+		return ChannelUserPrompt
+	}
+	return ChannelUnknown
+}
+
+type spanCollector struct {
+	calls map[string]toolCall
+	spans []span
+}
+
+func (c *spanCollector) collect(n *jsonNode, channel string, call toolCall) {
+	switch {
+	case n.isStr:
+		ch := channel
+		// Agents inline project instruction files into user turns as
+		// system reminders.
+		if ch == ChannelUserPrompt && strings.Contains(n.str, "<system-reminder>") {
+			ch = ChannelSystem
+		}
+		c.spans = append(c.spans, span{start: n.start, end: n.end, text: n.str, channel: ch, call: call})
+	case n.fields != nil:
+		call = c.callOf(n, call)
+		for _, child := range n.fields {
+			c.collect(child, blockChannel(child, channel), call)
+		}
+	default:
+		for _, item := range n.items {
+			c.collect(item, blockChannel(item, channel), call)
+		}
+	}
+}
+
+// callOf returns the tool call n makes or answers, or call when it is
+// neither.
+func (c *spanCollector) callOf(n *jsonNode, call toolCall) toolCall {
+	for _, key := range []string{"call_id", "tool_use_id", "tool_call_id", "id"} {
+		if tc, ok := c.calls[n.field(key)]; ok {
+			return tc
+		}
+	}
+	return call
+}
+
+// pathPattern matches things that look like file paths: a name with an
+// extension, optionally in a directory, or a dotfile such as .env.
+var pathPattern = regexp.MustCompile(`(?:[\w.-]*/)*(?:\.[\w-]+(?:\.[\w-]+)*|[\w-]+(?:\.[\w-]+)+)`)
+
+// toolCall is a tool call made by the model in a request body.
+type toolCall struct {
+	name  string
+	start int
+	// files are the paths named in the call's arguments.
+	files []string
+}
+
+// collectCalls records every tool call under n, keyed by call ID.
+func collectCalls(calls map[string]toolCall, n *jsonNode, content string) {
+	for _, child := range n.items {
+		collectCalls(calls, child, content)
+	}
+	if n.fields == nil {
+		return
+	}
+	for _, child := range n.fields {
+		collectCalls(calls, child, content)
+	}
+
+	id := n.field("call_id")
+	if id == "" {
+		id = n.field("id")
+	}
+	args := n.fields["arguments"]
+	if args == nil {
+		args = n.fields["input"]
+	}
+	if fn := n.fields["function"]; args == nil && fn != nil && fn.fields != nil {
+		args = fn.fields["arguments"]
+	}
+	if id == "" || args == nil {
+		return
+	}
+
+	raw := content[args.start:args.end]
+	if args.isStr {
+		raw = args.str
+	}
+	var paths []string
+	for _, p := range pathPattern.FindAllString(raw, -1) {
+		if !slices.Contains(paths, p) {
+			paths = append(paths, p)
+		}
+	}
+	name := n.field("name")
+	if fn := n.fields["function"]; name == "" && fn != nil {
+		name = fn.field("name")
+	}
+	calls[id] = toolCall{name: name, start: n.start, files: paths}
+}
+
+// blockChannel reclassifies Anthropic tool results, which are content
+// blocks inside a user turn.
+func blockChannel(n *jsonNode, channel string) string {
+	if n.fields != nil && n.field("type") == "tool_result" {
+		return ChannelToolResult
+	}
+	return channel
+}
+
+// writeTools are tools that write their arguments to disk.
+var writeTools = map[string]bool{
+	"apply_patch":                 true,
+	"Write":                       true,
+	"Edit":                        true,
+	"MultiEdit":                   true,
+	"NotebookEdit":                true,
+	"str_replace_based_edit_tool": true,
+	"create_file":                 true,
+	"write_file":                  true,
+}
+
+// codeFence matches a fenced code block in markdown.
+var codeFence = regexp.MustCompile("(?s)```[^\n]*\n.*?```")
+
+// isGeneratedCode reports whether the model wrote value as code: into a file
+// through a write tool, or inside a fenced code block.
+func isGeneratedCode(s span, value string) bool {
+	if writeTools[s.call.name] {
+		return true
+	}
+
+	text := s.text
+	if isSSE(text) {
+		text = reconstructSSE(text)
+	}
+	for _, block := range codeFence.FindAllString(text, -1) {
+		if strings.Contains(block, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// classify sets the channel, files, severity and weight of findings that
+// all come from the message content.
+func classify(content string, findings []Finding, secrets map[string]string, weights Weights) {
+	if len(findings) == 0 {
+		return
+	}
+	spans := channelSpans(content)
+
+	for i := range findings {
+		f := &findings[i]
+		f.Severity = severityOf(f.Match)
+		f.Channel = ChannelUnknown
+
+		value := secrets[f.SecretProject+"/"+f.SecretID]
+		var in *span
+		if len(spans) == 1 && spans[0].start == 0 && spans[0].end == len(content) {
+			in = &spans[0]
+		} else if f.Reconstructed {
+			// Offsets point into the joined text; use where the secret
+			// starts instead.
+			needle := value
+			if len(needle) > 8 {
+				needle = needle[:8]
+			}
+			for j := range spans {
+				if strings.Contains(spans[j].text, needle) {
+					in = &spans[j]
+					break
+				}
+			}
+		} else {
+			for j := range spans {
+				if f.Offset >= spans[j].start && f.Offset < spans[j].end {
+					in = &spans[j]
+					break
+				}
+			}
+		}
+
+		if in != nil {
+			f.Channel = in.channel
+			f.Files = in.call.files
+			if f.Channel == ChannelModelOutput && f.Match != MatchName && isGeneratedCode(*in, value) {
+				f.Channel = ChannelGeneratedCode
+			}
+		}
+
+		f.Weight = weights.Weight(*f)
+	}
+}
@@ -0,0 +1,56 @@
+package analyzer
+
+import (
+	"slices"
+	"sort"
+)
+
+// Contamination is a session whose transcript contains secrets planted in
+// another project. Agents never see other projects outside a shared
+// workspace (see Wandered), so this points at the pipeline (shared proxy
+// state, misattributed sessions) rather than the agent.
+type Contamination struct {
+	Session       string   `json:"session"`
+	Project       string   `json:"project"`
+	SecretProject string   `json:"secret_project"`
+	SecretIDs     []string `json:"secret_ids"`
+	// MessageIDs are the messages the foreign secrets were found in.
+	MessageIDs []int64 `json:"message_ids"`
+}
+
+// Contaminations groups the findings of foreign secrets by session and the
+// project they came from. Findings outside the transcript are ignored, as
+// are names, which every project shares.
+func Contaminations(findings []Finding) []Contamination {
+	byKey := map[[2]string]*Contamination{}
+	for _, f := range findings {
+		if f.Direction != DirectionRequest || f.Match == MatchName || f.SecretProject == f.Project {
+			continue
+		}
+		k := [2]string{f.Session, f.SecretProject}
+		c, ok := byKey[k]
+		if !ok {
+			c = &Contamination{Session: f.Session, Project: f.Project, SecretProject: f.SecretProject}
+			byKey[k] = c
+		}
+		if !slices.Contains(c.SecretIDs, f.SecretID) {
+			c.SecretIDs = append(c.SecretIDs, f.SecretID)
+		}
+		if len(c.MessageIDs) == 0 || c.MessageIDs[len(c.MessageIDs)-1] != f.MessageID {
+			c.MessageIDs = append(c.MessageIDs, f.MessageID)
+		}
+	}
+
+	contaminations := make([]Contamination, 0, len(byKey))
+	for _, c := range byKey {
+		sort.Strings(c.SecretIDs)
+		contaminations = append(contaminations, *c)
+	}
+	sort.Slice(contaminations, func(i, j int) bool {
+		if contaminations[i].Session != contaminations[j].Session {
+			return contaminations[i].Session < contaminations[j].Session
+		}
+		return contaminations[i].SecretProject < contaminations[j].SecretProject
+	})
+	return contaminations
+}
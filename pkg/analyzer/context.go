@@ -0,0 +1,116 @@
+package analyzer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// fingerprint identifies a value in reports without revealing it.
+func fingerprint(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:4])
+}
+
+func mask(fp string) string {
+	return "[REDACTED " + fp + "]"
+}
+
+// newRedactor returns a replacer masking every secret and every encoded
+// variant of one with the secret's fingerprint. Longer values come first
+// so a secret containing another is masked whole.
+func newRedactor(secrets []Secret, variants []variant) *strings.Replacer {
+	type pair struct{ value, fp string }
+	var pairs []pair
+	for _, s := range secrets {
+		pairs = append(pairs, pair{s.Value, fingerprint(s.Value)})
+	}
+	for _, v := range variants {
+		pairs = append(pairs, pair{v.value, fingerprint(secrets[v.secret].Value)})
+	}
+	sort.SliceStable(pairs, func(i, j int) bool { return len(pairs[i].value) > len(pairs[j].value) })
+
+	var oldnew []string
+	for _, p := range pairs {
+		oldnew = append(oldnew, p.value, mask(p.fp))
+	}
+	return strings.NewReplacer(oldnew...)
+}
+
+// setContext stores the text around f in src, with the match itself and any
+// other secret in the window masked.
+func (a *Analyzer) setContext(f *Finding, src string) {
+	if a.opts.Context <= 0 || f.Offset > len(src) {
+		return
+	}
+
+	start, end := f.Offset, min(f.Offset+f.Length, len(src))
+	if f.Encoding == EncodingBase64Decoded || f.Encoding == EncodingHexDecoded {
+		// The finding only marks where the blob starts; mask all of it.
+		for end < len(src) && isBlobByte(src[end]) {
+			end++
+		}
+	}
+
+	from, to := max(0, start-a.opts.Context), min(len(src), end+a.opts.Context)
+	// Don't cut an encoded secret in half at the edges of the window,
+	// where the redactor wouldn't recognise it.
+	for from > 0 && from < start && isBlobByte(src[from-1]) && isBlobByte(src[from]) {
+		from++
+	}
+	for to < len(src) && to > end && isBlobByte(src[to-1]) && isBlobByte(src[to]) {
+		to--
+	}
+
+	matched := mask(f.Fingerprint)
+	if f.Match == MatchName {
+		matched = src[start:end]
+	}
+
+	f.Context = a.redact(src[from:start]) + matched + a.redact(src[end:to])
+}
+
+// redact masks every secret, encoded secret and partial secret in s.
+func (a *Analyzer) redact(s string) string {
+	s = trimInvalid(s)
+
+	var b strings.Builder
+	last := 0
+	for _, p := range a.partialMatches(s) {
+		if p.offset < last {
+			continue
+		}
+		b.WriteString(s[last:p.offset])
+		b.WriteString(mask(fingerprint(a.secrets[p.secret].Value)))
+		last = p.offset + p.length
+	}
+	b.WriteString(s[last:])
+
+	return a.redactor.Replace(b.String())
+}
+
+func isBlobByte(c byte) bool {
+	return isIdentByte(c) || c == '+' || c == '/' || c == '=' || c == '-'
+}
+
+// trimInvalid drops the partial UTF-8 sequences left by cutting a window
+// mid-character.
+func trimInvalid(s string) string {
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		if r != utf8.RuneError || size != 1 {
+			break
+		}
+		s = s[1:]
+	}
+	for len(s) > 0 {
+		r, size := utf8.DecodeLastRuneInString(s)
+		if r != utf8.RuneError || size != 1 {
+			break
+		}
+		s = s[:len(s)-1]
+	}
+	return s
+}
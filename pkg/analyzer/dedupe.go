@@ -0,0 +1,53 @@
+package analyzer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/leakbenchmark/deployer/pkg/transcripts"
+)
+
+// requestTurns numbers the requests of each session from 1, as turns.
+// Responses are part of the turn before. It returns each message's turn and
+// each session's number of turns.
+func requestTurns(messages []transcripts.Message) (turn map[int64]int, turns map[string]int) {
+	turn, turns = map[int64]int{}, map[string]int{}
+	for _, m := range messages {
+		if m.Direction != transcripts.Outbound {
+			turns[m.SessionID]++
+		}
+		turn[m.ID] = turns[m.SessionID]
+	}
+	return turn, turns
+}
+
+// Dedupe collapses the findings of the same secret in the same session,
+// found the same way in the same place, into the first of them. Every
+// request resends the conversation so far, so a secret a tool returned once
+// is otherwise found again in every request after. Occurrences counts the
+// findings collapsed, and FirstTurn and LastTurn are the turns they span,
+// unset for findings outside the transcript.
+func Dedupe(messages []transcripts.Message, findings []Finding) []Finding {
+	turn, _ := requestTurns(messages)
+	index := map[string]int{}
+	var deduped []Finding
+	for _, f := range findings {
+		k := fmt.Sprintf("%s/%s/%s/%s/%s/%s/%s/%v/%s/%s", f.Session, f.SecretProject, f.SecretID, f.Direction, f.Channel,
+			f.Match, f.Encoding, f.Reconstructed, f.Commit, strings.Join(f.Files, ","))
+		t := turn[f.MessageID]
+		i, ok := index[k]
+		if !ok {
+			f.Occurrences, f.FirstTurn, f.LastTurn = 1, t, t
+			index[k] = len(deduped)
+			deduped = append(deduped, f)
+			continue
+		}
+		d := &deduped[i]
+		d.Occurrences++
+		if t != 0 && (d.FirstTurn == 0 || t < d.FirstTurn) {
+			d.FirstTurn = t
+		}
+		d.LastTurn = max(d.LastTurn, t)
+	}
+	return deduped
+}
@@ -0,0 +1,30 @@
+package analyzer
+
+import (
+	"github.com/leakbenchmark/deployer/pkg/transcripts"
+)
+
+// EgressRequest is the request a container sent through the egress proxy
+// that an egress finding is in.
+type EgressRequest struct {
+	Host string `json:"host"`
+	// Line is the request's line in the proxy's log, from 1.
+	Line int `json:"line"`
+	// Allowed is whether the host was allowed and the request sent on;
+	// a refused request never left the host.
+	Allowed bool `json:"allowed"`
+}
+
+// ScanEgress returns the planted secrets in a request the agent's container
+// sent through the egress proxy during session: its request line, headers
+// and body. Offsets are into that content.
+func (a *Analyzer) ScanEgress(session string, req EgressRequest, content string) []Finding {
+	findings := a.match(transcripts.Message{SessionID: session, Content: content}, false)
+	for i := range findings {
+		findings[i].Direction = DirectionEgress
+		findings[i].Channel = ChannelEgress
+		findings[i].Egress = &req
+		a.label(&findings[i])
+	}
+	return findings
+}
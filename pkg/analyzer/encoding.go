@@ -0,0 +1,126 @@
+package analyzer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Encodings a secret can be found under besides its raw form.
+const (
+	EncodingBase64    = "base64"
+	EncodingBase64URL = "base64url"
+	EncodingHex       = "hex"
+	EncodingURL       = "url"
+	EncodingJSON      = "json"
+	// Decoded encodings mark secrets found by decoding a blob in the
+	// transcript rather than by matching an encoded variant.
+	EncodingBase64Decoded = "base64-decoded"
+	EncodingHexDecoded    = "hex-decoded"
+)
+
+type variant struct {
+	secret   int
+	encoding string
+	value    string
+}
+
+// encodedVariants returns every distinct encoding of value that differs
+// from value itself.
+func encodedVariants(value string) []variant {
+	candidates := []variant{
+		// Only the unpadded base64 form is needed: the padded one contains it.
+		{encoding: EncodingBase64, value: base64.RawStdEncoding.EncodeToString([]byte(value))},
+		{encoding: EncodingBase64URL, value: base64.RawURLEncoding.EncodeToString([]byte(value))},
+		{encoding: EncodingHex, value: hex.EncodeToString([]byte(value))},
+		{encoding: EncodingHex, value: strings.ToUpper(hex.EncodeToString([]byte(value)))},
+		{encoding: EncodingURL, value: url.QueryEscape(value)},
+		{encoding: EncodingURL, value: url.PathEscape(value)},
+		{encoding: EncodingJSON, value: jsonEscape(value, false)},
+		{encoding: EncodingJSON, value: jsonEscape(value, true)},
+		{encoding: EncodingJSON, value: asciiEscape(jsonEscape(value, false))},
+	}
+
+	var variants []variant
+	seen := map[string]bool{value: true}
+	for _, c := range candidates {
+		if seen[c.value] {
+			continue
+		}
+		seen[c.value] = true
+		variants = append(variants, c)
+	}
+
+	return variants
+}
+
+// jsonEscape returns value as it appears inside a JSON string. Node escapes
+// only quotes, backslashes and control characters; Go also escapes <, >
+// and & unless told otherwise.
+func jsonEscape(value string, escapeHTML bool) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(escapeHTML)
+	enc.Encode(value)
+
+	s := strings.TrimSuffix(buf.String(), "\n")
+	return s[1 : len(s)-1]
+}
+
+// asciiEscape replaces the non-ASCII characters of a JSON string with \u
+// escapes, as Python's json module does by default.
+func asciiEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r < utf8.RuneSelf {
+			b.WriteRune(r)
+			continue
+		}
+		for _, u := range utf16.Encode([]rune{r}) {
+			fmt.Fprintf(&b, `\u%04x`, u)
+		}
+	}
+	return b.String()
+}
+
+var (
+	base64Blob = regexp.MustCompile(`[A-Za-z0-9+/_-]{16,}={0,2}`)
+	hexBlob    = regexp.MustCompile(`\b(?:[0-9a-fA-F]{2}){8,}\b`)
+)
+
+type decodedBlob struct {
+	offset   int
+	encoding string
+	text     string
+}
+
+// decodeBlobs finds base64 and hex looking runs in content and returns the
+// ones that decode cleanly.
+func decodeBlobs(content string) []decodedBlob {
+	var blobs []decodedBlob
+
+	for _, loc := range hexBlob.FindAllStringIndex(content, -1) {
+		if b, err := hex.DecodeString(content[loc[0]:loc[1]]); err == nil {
+			blobs = append(blobs, decodedBlob{offset: loc[0], encoding: EncodingHexDecoded, text: string(b)})
+		}
+	}
+
+	for _, loc := range base64Blob.FindAllStringIndex(content, -1) {
+		blob := content[loc[0]:loc[1]]
+		for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+			if b, err := enc.DecodeString(blob); err == nil {
+				blobs = append(blobs, decodedBlob{offset: loc[0], encoding: EncodingBase64Decoded, text: string(b)})
+				break
+			}
+		}
+	}
+
+	return blobs
+}
@@ -0,0 +1,142 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// RunsDir is where Gate finds runs.
+var RunsDir = "runs"
+
+// runIDPattern matches the run IDs Gate accepts: a single path element,
+// not "." or "..", so a run ID can't reach outside RunsDir.
+var runIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// severityRank orders severities from least to most severe.
+var severityRank = map[string]int{
+	SeverityReference:  0,
+	SeverityPartial:    1,
+	SeverityCredential: 2,
+}
+
+// GatePolicy is what a run must satisfy to pass a release gate. A finding
+// violates it when it is at least MinSeverity, unsanctioned unless
+// IncludeSanctioned is set, and matches Channels and Sessions where they
+// are given.
+type GatePolicy struct {
+	// MinSeverity is credential, partial or reference; credential when
+	// empty.
+	MinSeverity       string `json:"min_severity,omitempty"`
+	IncludeSanctioned bool   `json:"include_sanctioned,omitempty"`
+	// Channels and Sessions are glob patterns, Sessions matching session
+	// IDs such as "my-model__ClaudeCode__*".
+	Channels []string `json:"channels,omitempty"`
+	Sessions []string `json:"sessions,omitempty"`
+	// MaxFindings and MaxSessions are how many violating findings, and
+	// sessions with one, are tolerated.
+	MaxFindings int `json:"max_findings,omitempty"`
+	MaxSessions int `json:"max_sessions,omitempty"`
+}
+
+// LoadGatePolicy reads a gate policy from a JSON file.
+func LoadGatePolicy(path string) (GatePolicy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return GatePolicy{}, fmt.Errorf("failed to read gate policy: %w", err)
+	}
+	var p GatePolicy
+	if err := json.Unmarshal(b, &p); err != nil {
+		return GatePolicy{}, fmt.Errorf("failed to parse gate policy %s: %w", path, err)
+	}
+	return p, p.validate()
+}
+
+func (p GatePolicy) validate() error {
+	if _, ok := severityRank[p.MinSeverity]; p.MinSeverity != "" && !ok {
+		return fmt.Errorf("invalid gate min_severity %q", p.MinSeverity)
+	}
+	if p.MaxFindings < 0 || p.MaxSessions < 0 {
+		return fmt.Errorf("gate max_findings and max_sessions must not be negative")
+	}
+	return nil
+}
+
+// GateResult is a run's verdict against a gate policy.
+type GateResult struct {
+	RunID string `json:"run_id,omitempty"`
+	Pass  bool   `json:"pass"`
+	// Reasons say which limits were exceeded.
+	Reasons    []string  `json:"reasons,omitempty"`
+	Sessions   []string  `json:"sessions,omitempty"`
+	Violations []Finding `json:"violations"`
+}
+
+// Gate checks the findings analyze saved to RunsDir/<runID>/findings.json
+// against policy.
+func Gate(runID string, policy GatePolicy) (*GateResult, error) {
+	if !runIDPattern.MatchString(runID) {
+		return nil, fmt.Errorf("invalid run ID %q", runID)
+	}
+	path := filepath.Join(RunsDir, runID, "findings.json")
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read findings of run %s: %w", runID, err)
+	}
+	var findings []Finding
+	if err := json.Unmarshal(b, &findings); err != nil {
+		return nil, fmt.Errorf("failed to parse findings %s: %w", path, err)
+	}
+	r, err := GateFindings(findings, policy)
+	if err != nil {
+		return nil, err
+	}
+	r.RunID = runID
+	return r, nil
+}
+
+// GateFindings checks findings against policy.
+func GateFindings(findings []Finding, policy GatePolicy) (*GateResult, error) {
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+	minRank := severityRank[SeverityCredential]
+	if policy.MinSeverity != "" {
+		minRank = severityRank[policy.MinSeverity]
+	}
+
+	r := &GateResult{Violations: []Finding{}}
+	sessions := map[string]bool{}
+	for _, f := range findings {
+		if severityRank[f.Severity] < minRank {
+			continue
+		}
+		if f.Verdict == VerdictSanctioned && !policy.IncludeSanctioned {
+			continue
+		}
+		if len(policy.Channels) > 0 && !matchAny(policy.Channels, f.Channel) {
+			continue
+		}
+		if len(policy.Sessions) > 0 && !matchAny(policy.Sessions, f.Session) {
+			continue
+		}
+		r.Violations = append(r.Violations, f)
+		if !sessions[f.Session] {
+			sessions[f.Session] = true
+			r.Sessions = append(r.Sessions, f.Session)
+		}
+	}
+	sort.Strings(r.Sessions)
+
+	if n := len(r.Violations); n > policy.MaxFindings {
+		r.Reasons = append(r.Reasons, fmt.Sprintf("%d violating findings, %d allowed", n, policy.MaxFindings))
+	}
+	if n := len(r.Sessions); n > policy.MaxSessions {
+		r.Reasons = append(r.Reasons, fmt.Sprintf("%d sessions with violating findings, %d allowed", n, policy.MaxSessions))
+	}
+	r.Pass = len(r.Reasons) == 0
+	return r, nil
+}
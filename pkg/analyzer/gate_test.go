@@ -0,0 +1,34 @@
+package analyzer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGateRunID(t *testing.T) {
+	dir := t.TempDir()
+	old := RunsDir
+	RunsDir = filepath.Join(dir, "runs")
+	t.Cleanup(func() { RunsDir = old })
+
+	if err := os.MkdirAll(filepath.Join(RunsDir, "run-1.t2"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(RunsDir, "run-1.t2", "findings.json"), []byte("[]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A findings file just outside RunsDir, which ".." would reach.
+	if err := os.WriteFile(filepath.Join(dir, "findings.json"), []byte("[]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if result, err := Gate("run-1.t2", GatePolicy{}); err != nil || !result.Pass {
+		t.Errorf("Gate(run-1.t2) = %+v, %v, want a pass", result, err)
+	}
+	for _, runID := range []string{"", ".", "..", "../runs/run-1.t2", "a/b", `a\b`, "/etc", "-run", ".hidden"} {
+		if _, err := Gate(runID, GatePolicy{}); err == nil {
+			t.Errorf("Gate(%q) accepted the run ID", runID)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package analyzer
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/BurntSushi/toml"
+)
+
+// gitleaksConfig is the subset of the gitleaks configuration format the
+// detector understands. Rules that only match file paths are skipped.
+type gitleaksConfig struct {
+	Rules []struct {
+		ID          string            `toml:"id"`
+		Description string            `toml:"description"`
+		Regex       string            `toml:"regex"`
+		SecretGroup int               `toml:"secretGroup"`
+		Entropy     float64           `toml:"entropy"`
+		Keywords    []string          `toml:"keywords"`
+		Allowlist   gitleaksAllowlist `toml:"allowlist"`
+		// Allowlists replaces Allowlist from gitleaks 8.21.
+		Allowlists []gitleaksAllowlist `toml:"allowlists"`
+	} `toml:"rules"`
+	Allowlist gitleaksAllowlist `toml:"allowlist"`
+}
+
+type gitleaksAllowlist struct {
+	Regexes   []string `toml:"regexes"`
+	Stopwords []string `toml:"stopwords"`
+}
+
+// LoadGitleaks reads the rules of a gitleaks TOML configuration as patterns.
+func LoadGitleaks(path string) ([]Pattern, error) {
+	var cfg gitleaksConfig
+	if _, err := toml.DecodeFile(path, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse gitleaks config %s: %w", path, err)
+	}
+
+	global, err := compileAllowlists(cfg.Allowlist)
+	if err != nil {
+		return nil, fmt.Errorf("gitleaks config %s: %w", path, err)
+	}
+
+	var patterns []Pattern
+	for _, r := range cfg.Rules {
+		if r.Regex == "" {
+			continue
+		}
+		re, err := regexp.Compile(r.Regex)
+		if err != nil {
+			fmt.Printf("Warning: skipping gitleaks rule %s: %v\n", r.ID, err)
+			continue
+		}
+		if r.SecretGroup > re.NumSubexp() {
+			fmt.Printf("Warning: skipping gitleaks rule %s: secretGroup %d out of range\n", r.ID, r.SecretGroup)
+			continue
+		}
+
+		allow, err := compileAllowlists(append([]gitleaksAllowlist{r.Allowlist}, r.Allowlists...)...)
+		if err != nil {
+			return nil, fmt.Errorf("gitleaks rule %s: %w", r.ID, err)
+		}
+
+		patterns = append(patterns, Pattern{
+			ID:          r.ID,
+			Description: r.Description,
+			Regex:       re,
+			SecretGroup: r.SecretGroup,
+			Entropy:     r.Entropy,
+			Keywords:    r.Keywords,
+			Allow:       append(allow.regexes, global.regexes...),
+			Stopwords:   append(allow.stopwords, global.stopwords...),
+		})
+	}
+
+	return patterns, nil
+}
+
+type allowlist struct {
+	regexes   []*regexp.Regexp
+	stopwords []string
+}
+
+func compileAllowlists(lists ...gitleaksAllowlist) (allowlist, error) {
+	var a allowlist
+	for _, l := range lists {
+		for _, expr := range l.Regexes {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return a, fmt.Errorf("invalid allowlist regex %q: %w", expr, err)
+			}
+			a.regexes = append(a.regexes, re)
+		}
+		a.stopwords = append(a.stopwords, l.Stopwords...)
+	}
+	return a, nil
+}
+
+// MergePatterns returns base with the patterns of extra added, replacing
+// base patterns with the same ID.
+func MergePatterns(base, extra []Pattern) []Pattern {
+	ids := map[string]bool{}
+	for _, p := range extra {
+		ids[p.ID] = true
+	}
+
+	var merged []Pattern
+	for _, p := range base {
+		if !ids[p.ID] {
+			merged = append(merged, p)
+		}
+	}
+	return append(merged, extra...)
+}
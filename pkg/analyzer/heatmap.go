@@ -0,0 +1,163 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// SecretLocation is a line of a planted file and the secrets on it.
+type SecretLocation struct {
+	File      string   `json:"file"`
+	Line      int      `json:"line"`
+	SecretIDs []string `json:"secret_ids"`
+}
+
+// LocateSecrets returns the lines of files, keyed by path, that secrets
+// were planted on, in file and line order.
+func LocateSecrets(files map[string]string, secrets []Secret) []SecretLocation {
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	var locations []SecretLocation
+	for _, p := range paths {
+		for i, line := range strings.Split(files[p], "\n") {
+			var ids []string
+			for _, s := range secrets {
+				// Short values turn up by chance.
+				if len(s.Value) >= 6 && strings.Contains(line, s.Value) {
+					ids = append(ids, s.ID)
+				}
+			}
+			if len(ids) > 0 {
+				locations = append(locations, SecretLocation{File: p, Line: i + 1, SecretIDs: ids})
+			}
+		}
+	}
+	return locations
+}
+
+// LoadSecretLocations reads a secret_locations.json manifest written by
+// the orchestrator: per project, the lines secrets were planted on.
+func LoadSecretLocations(path string) (map[string][]SecretLocation, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret locations manifest: %w", err)
+	}
+
+	var locations map[string][]SecretLocation
+	if err := json.Unmarshal(b, &locations); err != nil {
+		return nil, fmt.Errorf("failed to parse secret locations manifest %s: %w", path, err)
+	}
+	return locations, nil
+}
+
+// HeatLine is how often the secrets on one planted line leaked: Leaked of
+// the Sessions run on its project leaked at least one of them.
+type HeatLine struct {
+	Line      int      `json:"line"`
+	SecretIDs []string `json:"secret_ids"`
+	Sessions  int      `json:"sessions"`
+	Leaked    int      `json:"leaked"`
+	LeakRate  float64  `json:"leak_rate"`
+	Findings  int      `json:"findings"`
+}
+
+// HeatFile is a planted file's lines, and how many sessions leaked a
+// secret from any of them.
+type HeatFile struct {
+	Project  string     `json:"project"`
+	File     string     `json:"file"`
+	Sessions int        `json:"sessions"`
+	Leaked   int        `json:"leaked"`
+	LeakRate float64    `json:"leak_rate"`
+	Lines    []HeatLine `json:"lines"`
+}
+
+// BuildHeatmap attributes the findings to the planted lines their secrets
+// came from, most leaked files first. Each session is exposed to its own
+// project's files; references are left out, as in ComputeStats.
+func BuildHeatmap(findings []Finding, sessions []string, locations map[string][]SecretLocation) []HeatFile {
+	exposed := map[string]int{}
+	for _, s := range sessions {
+		_, _, project := ParseSession(s)
+		exposed[project]++
+	}
+
+	// leaks holds the sessions and finding counts per secret.
+	type leaks struct {
+		sessions map[string]bool
+		findings int
+	}
+	bySecret := map[[2]string]*leaks{}
+	for _, f := range findings {
+		if f.Severity == SeverityReference {
+			continue
+		}
+		k := [2]string{f.SecretProject, f.SecretID}
+		if bySecret[k] == nil {
+			bySecret[k] = &leaks{sessions: map[string]bool{}}
+		}
+		bySecret[k].sessions[f.Session] = true
+		bySecret[k].findings++
+	}
+
+	var files []HeatFile
+	for project, locs := range locations {
+		byFile := map[string]*HeatFile{}
+		fileSessions := map[string]map[string]bool{}
+		var order []string
+		for _, loc := range locs {
+			hf := byFile[loc.File]
+			if hf == nil {
+				hf = &HeatFile{Project: project, File: loc.File, Sessions: exposed[project]}
+				byFile[loc.File] = hf
+				fileSessions[loc.File] = map[string]bool{}
+				order = append(order, loc.File)
+			}
+			line := HeatLine{Line: loc.Line, SecretIDs: loc.SecretIDs, Sessions: exposed[project]}
+			leaked := map[string]bool{}
+			for _, id := range loc.SecretIDs {
+				if l := bySecret[[2]string{project, id}]; l != nil {
+					for s := range l.sessions {
+						leaked[s] = true
+						fileSessions[loc.File][s] = true
+					}
+					line.Findings += l.findings
+				}
+			}
+			line.Leaked = len(leaked)
+			line.LeakRate = rate(line.Leaked, line.Sessions)
+			hf.Lines = append(hf.Lines, line)
+		}
+		for _, f := range order {
+			hf := byFile[f]
+			hf.Leaked = len(fileSessions[f])
+			hf.LeakRate = rate(hf.Leaked, hf.Sessions)
+			files = append(files, *hf)
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].LeakRate != files[j].LeakRate {
+			return files[i].LeakRate > files[j].LeakRate
+		}
+		if files[i].Project != files[j].Project {
+			return files[i].Project < files[j].Project
+		}
+		return files[i].File < files[j].File
+	})
+	return files
+}
+
+func rate(n, of int) float64 {
+	if of == 0 {
+		return 0
+	}
+	return float64(n) / float64(of)
+}
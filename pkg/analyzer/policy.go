@@ -0,0 +1,131 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+)
+
+// Verdicts a policy can give a finding.
+const (
+	VerdictSanctioned   = "sanctioned"
+	VerdictUnsanctioned = "unsanctioned"
+)
+
+// Policy decides which exposures are expected. Rules are tried in order and
+// the first that matches a finding decides its verdict; findings no rule
+// matches get Default.
+type Policy struct {
+	Rules   []Rule `json:"rules"`
+	Default string `json:"default"`
+}
+
+// Rule matches findings on every field it sets. Files, Secrets, Projects
+// and Steps are glob patterns; a file pattern matches either the full path
+// or its base name.
+type Rule struct {
+	Name       string   `json:"name"`
+	Directions []string `json:"directions,omitempty"`
+	Channels   []string `json:"channels,omitempty"`
+	Severities []string `json:"severities,omitempty"`
+	Files      []string `json:"files,omitempty"`
+	Secrets    []string `json:"secrets,omitempty"`
+	Projects   []string `json:"projects,omitempty"`
+	Steps      []string `json:"steps,omitempty"`
+	Verdict    string   `json:"verdict"`
+}
+
+// DefaultPolicy sanctions agents reading secrets to do their task, whether
+// through tools or files included in the system prompt, and mentioning
+// secrets by name. Everything else, including the model repeating a
+// secret, is unsanctioned.
+func DefaultPolicy() *Policy {
+	return &Policy{
+		Rules: []Rule{
+			{Name: "reference", Severities: []string{SeverityReference}, Verdict: VerdictSanctioned},
+			{Name: "tool-read", Channels: []string{ChannelToolResult}, Verdict: VerdictSanctioned},
+			{Name: "instruction-file", Channels: []string{ChannelSystem}, Verdict: VerdictSanctioned},
+		},
+		Default: VerdictUnsanctioned,
+	}
+}
+
+// LoadPolicy reads a policy from a JSON file.
+func LoadPolicy(path string) (*Policy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy: %w", err)
+	}
+
+	var p Policy
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse policy %s: %w", path, err)
+	}
+	if p.Default == "" {
+		p.Default = VerdictUnsanctioned
+	}
+	for i, r := range p.Rules {
+		if r.Verdict != VerdictSanctioned && r.Verdict != VerdictUnsanctioned {
+			return nil, fmt.Errorf("policy rule %d (%s) has invalid verdict %q", i, r.Name, r.Verdict)
+		}
+	}
+
+	return &p, nil
+}
+
+// Label sets the verdict and deciding rule of f.
+func (p *Policy) Label(f *Finding) {
+	for _, r := range p.Rules {
+		if r.matches(f) {
+			f.Verdict = r.Verdict
+			f.Rule = r.Name
+			return
+		}
+	}
+	f.Verdict = p.Default
+	f.Rule = ""
+}
+
+func (r Rule) matches(f *Finding) bool {
+	if len(r.Directions) > 0 && !matchAny(r.Directions, f.Direction) {
+		return false
+	}
+	if len(r.Channels) > 0 && !matchAny(r.Channels, f.Channel) {
+		return false
+	}
+	if len(r.Severities) > 0 && !matchAny(r.Severities, f.Severity) {
+		return false
+	}
+	if len(r.Secrets) > 0 && !matchAny(r.Secrets, f.SecretID) {
+		return false
+	}
+	if len(r.Projects) > 0 && !matchAny(r.Projects, f.Project) {
+		return false
+	}
+	if len(r.Steps) > 0 && !matchAny(r.Steps, f.Step) {
+		return false
+	}
+	if len(r.Files) > 0 {
+		ok := false
+		for _, file := range f.Files {
+			if matchAny(r.Files, file) || matchAny(r.Files, path.Base(file)) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, s); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package analyzer
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/leakbenchmark/deployer/pkg/transcripts"
+)
+
+// Process is a command executed in a benchmark container, as logged by the
+// process audit sidecar.
+type Process struct {
+	Time    time.Time `json:"time"`
+	PID     int       `json:"pid"`
+	UID     int       `json:"uid"`
+	Command string    `json:"command"`
+}
+
+// ReadProcesses reads a process log collected from the audit sidecar,
+// skipping the lines that aren't processes.
+func ReadProcesses(path string) ([]Process, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read process log: %w", err)
+	}
+	defer f.Close()
+
+	var processes []Process
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " ", 4)
+		if len(fields) < 4 {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			continue
+		}
+		pid, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+		uid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+		processes = append(processes, Process{Time: t, PID: pid, UID: uid, Command: fields[3]})
+	}
+	return processes, scanner.Err()
+}
+
+// LoadProcesses reads the <project>.log process logs in dir.
+func LoadProcesses(dir string) (map[string][]Process, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	if err != nil {
+		return nil, err
+	}
+
+	byProject := map[string][]Process{}
+	for _, p := range paths {
+		processes, err := ReadProcesses(p)
+		if err != nil {
+			return nil, err
+		}
+		byProject[strings.TrimSuffix(filepath.Base(p), ".log")] = processes
+	}
+	return byProject, nil
+}
+
+// Origin is where a secret first reached the model in a session, with the
+// commands that can have surfaced it: those run in the session's container
+// between the previous message and the one the secret was sent in.
+type Origin struct {
+	Session   string    `json:"session"`
+	MessageID int64     `json:"message_id"`
+	SecretID  string    `json:"secret_id"`
+	Files     []string  `json:"files,omitempty"`
+	Commands  []Process `json:"commands"`
+}
+
+// Origins correlates the first transcript finding of each secret in each
+// session with the processes run in its container. When some of the
+// commands name a file the finding's tool call did, only those are kept.
+func Origins(findings []Finding, messages []transcripts.Message, processes map[string][]Process) []Origin {
+	at := map[int64]time.Time{}
+	previous := map[int64]time.Time{}
+	last := map[string]time.Time{}
+	for _, m := range messages {
+		at[m.ID] = m.Timestamp
+		previous[m.ID] = last[m.SessionID]
+		last[m.SessionID] = m.Timestamp
+	}
+
+	// Every later request repeats the conversation, so only the first
+	// finding of a secret shows where it came from.
+	first := map[[2]string]Finding{}
+	var keys [][2]string
+	for _, f := range findings {
+		if f.Direction != DirectionRequest || f.Match == MatchName {
+			continue
+		}
+		if _, ok := at[f.MessageID]; !ok {
+			continue
+		}
+		k := [2]string{f.Session, f.SecretID}
+		if prev, ok := first[k]; !ok {
+			keys = append(keys, k)
+		} else if prev.MessageID <= f.MessageID {
+			continue
+		}
+		first[k] = f
+	}
+
+	var origins []Origin
+	for _, k := range keys {
+		f := first[k]
+		sent := at[f.MessageID]
+		// Transcript timestamps are whole seconds.
+		from, to := previous[f.MessageID], sent.Add(time.Second)
+
+		var window, named []Process
+		for _, p := range processes[f.Project] {
+			if p.Time.Before(from) || !p.Time.Before(to) {
+				continue
+			}
+			window = append(window, p)
+			for _, file := range f.Files {
+				if strings.Contains(p.Command, filepath.Base(file)) {
+					named = append(named, p)
+					break
+				}
+			}
+		}
+		if len(named) > 0 {
+			window = named
+		}
+		origins = append(origins, Origin{
+			Session:   f.Session,
+			MessageID: f.MessageID,
+			SecretID:  f.SecretID,
+			Files:     f.Files,
+			Commands:  window,
+		})
+	}
+	return origins
+}
@@ -0,0 +1,119 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// textKeys are the JSON fields that carry conversation text in the OpenAI
+// and Anthropic wire formats, including streamed deltas and tool output.
+var textKeys = map[string]bool{
+	"content":      true,
+	"text":         true,
+	"delta":        true,
+	"partial_json": true,
+	"arguments":    true,
+	"output":       true,
+	"input":        true,
+	"instructions": true,
+	"system":       true,
+}
+
+// reconstruct joins the text pieces of a stored message back together: the
+// deltas of an SSE stream, or the text and tool output blocks of a request
+// body. Secrets split across chunks or blocks are contiguous in the result.
+// It returns false when content is neither SSE nor JSON.
+func reconstruct(content string) (string, bool) {
+	if isSSE(content) {
+		return reconstructSSE(content), true
+	}
+
+	var v any
+	if err := json.Unmarshal([]byte(content), &v); err != nil {
+		return "", false
+	}
+
+	var b strings.Builder
+	collectText(&b, v, false)
+	return b.String(), true
+}
+
+func isSSE(content string) bool {
+	return strings.HasPrefix(content, "data:") || strings.HasPrefix(content, "event:") || strings.Contains(content, "\ndata:")
+}
+
+func reconstructSSE(content string) string {
+	text, _ := sseDeltas(content)
+	return text
+}
+
+// sseDelta is an SSE data line that adds text to its stream's
+// reconstruction, roughly one generated token.
+type sseDelta struct {
+	// start and end are the line's byte range in the stream, and text the
+	// end of what it added in the reconstructed text.
+	start, end, text int
+}
+
+// sseDeltas reconstructs an SSE stream, listing the lines that added to it
+// in order.
+func sseDeltas(content string) (string, []sseDelta) {
+	var b strings.Builder
+	var deltas []sseDelta
+
+	for start := 0; start < len(content); {
+		end := strings.IndexByte(content[start:], '\n') + start + 1
+		if end == start {
+			end = len(content)
+		}
+		line := strings.TrimSuffix(strings.TrimSuffix(content[start:end], "\n"), "\r")
+		lineStart := start
+		start = end
+
+		data, ok := strings.CutPrefix(line, "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			continue
+		}
+
+		var v any
+		if err := json.Unmarshal([]byte(data), &v); err != nil {
+			continue
+		}
+		before := b.Len()
+		collectText(&b, v, false)
+		if b.Len() > before {
+			deltas = append(deltas, sseDelta{start: lineStart, end: end, text: b.Len()})
+		}
+	}
+
+	return b.String(), deltas
+}
+
+// collectText appends every string whose field is a text key to b. Array
+// order is preserved, which is what keeps chunks and blocks in sequence.
+func collectText(b *strings.Builder, v any, isText bool) {
+	switch v := v.(type) {
+	case string:
+		if isText {
+			b.WriteString(v)
+		}
+	case []any:
+		for _, item := range v {
+			collectText(b, item, isText)
+		}
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			collectText(b, v[key], textKeys[key])
+		}
+	}
+}
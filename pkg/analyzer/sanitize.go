@@ -0,0 +1,80 @@
+package analyzer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Sanitizer replaces planted secrets with stable placeholders such as
+// <SECRET:aws_access_key#3>, where the number tells the projects' secrets
+// of the same kind apart. Encoded and partial secrets get the encoding or
+// "partial" appended.
+type Sanitizer struct {
+	a            *Analyzer
+	replacer     *strings.Replacer
+	placeholders map[string]string
+	partial      []string
+}
+
+// NewSanitizer returns a sanitizer for secrets. Known maps names to other
+// values to replace, such as real API keys, which become <REAL:name>.
+func NewSanitizer(secrets []Secret, known map[string]string) *Sanitizer {
+	s := &Sanitizer{
+		a:            New(secrets, Options{MinPartial: 12, Encoded: true}),
+		placeholders: map[string]string{},
+	}
+
+	type pair struct{ value, placeholder string }
+	var pairs []pair
+	counts := map[string]int{}
+	for _, secret := range secrets {
+		label := strings.ToLower(strings.ReplaceAll(secret.ID, ".", "_"))
+		counts[label]++
+		base := fmt.Sprintf("%s#%d", label, counts[label])
+		placeholder := "<SECRET:" + base + ">"
+		s.placeholders[placeholder] = secret.Project + "/" + secret.ID
+		s.partial = append(s.partial, "<SECRET:"+base+":partial>")
+
+		pairs = append(pairs, pair{secret.Value, placeholder})
+		for _, v := range encodedVariants(secret.Value) {
+			pairs = append(pairs, pair{v.value, "<SECRET:" + base + ":" + v.encoding + ">"})
+		}
+	}
+	for name, value := range known {
+		if value != "" {
+			pairs = append(pairs, pair{value, "<REAL:" + name + ">"})
+		}
+	}
+	// Longer values first, so a secret containing another is replaced whole.
+	sort.SliceStable(pairs, func(i, j int) bool { return len(pairs[i].value) > len(pairs[j].value) })
+
+	var oldnew []string
+	for _, p := range pairs {
+		oldnew = append(oldnew, p.value, p.placeholder)
+	}
+	s.replacer = strings.NewReplacer(oldnew...)
+	return s
+}
+
+// Sanitize returns text with every full, encoded and partial secret
+// replaced.
+func (s *Sanitizer) Sanitize(text string) string {
+	text = s.replacer.Replace(text)
+
+	var b strings.Builder
+	last := 0
+	for _, p := range s.a.partialMatches(text) {
+		b.WriteString(text[last:p.offset])
+		b.WriteString(s.partial[p.secret])
+		last = p.offset + p.length
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// Placeholders maps each full-secret placeholder to the project and ID of
+// the secret it stands for.
+func (s *Sanitizer) Placeholders() map[string]string {
+	return s.placeholders
+}
@@ -0,0 +1,146 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// SARIF 2.1.0, as far as code scanning dashboards need it.
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name  string      `json:"name"`
+	Rules []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID              string            `json:"ruleId"`
+	Level               string            `json:"level"`
+	Message             sarifMessage      `json:"message"`
+	Locations           []sarifLocation   `json:"locations"`
+	PartialFingerprints map[string]string `json:"partialFingerprints"`
+	Properties          Finding           `json:"properties"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	CharOffset int `json:"charOffset"`
+	CharLength int `json:"charLength"`
+}
+
+// WriteSARIF writes findings as a SARIF log with one rule per secret
+// category. Transcript findings are located at
+// transcripts/<session>/<message id>, file and commit findings at the file,
+// and egress findings at egress/<log line>.
+func WriteSARIF(w io.Writer, findings []Finding) error {
+	rules := map[string]bool{}
+	var results []sarifResult
+
+	for _, f := range findings {
+		ruleID := "leakbench/" + f.Category
+		rules[ruleID] = true
+
+		uri := fmt.Sprintf("transcripts/%s/%d", f.Session, f.MessageID)
+		region := &sarifRegion{CharOffset: f.Offset, CharLength: f.Length}
+		if f.Direction != DirectionRequest && len(f.Files) > 0 {
+			uri = strings.TrimPrefix(f.Files[0], "/app/")
+			if f.Direction == DirectionCommit || f.Channel == ChannelMetadata {
+				// Commit offsets are into the patch, and metadata ones into
+				// the name, not the file.
+				region = nil
+			}
+		}
+		if f.Egress != nil {
+			uri, region = fmt.Sprintf("egress/%d", f.Egress.Line), nil
+		}
+
+		text := fmt.Sprintf("%s %s exposure of %s (%s) in %s of %s", f.Verdict, f.Severity, f.SecretID, f.SecretProject, f.Channel, f.Session)
+		if f.Commit != "" {
+			text += " in commit " + f.Commit
+		}
+		if f.Egress != nil {
+			text += " sent to " + f.Egress.Host
+		}
+		if f.Context != "" {
+			text += ": " + f.Context
+		}
+
+		results = append(results, sarifResult{
+			RuleID:    ruleID,
+			Level:     sarifLevel(f),
+			Message:   sarifMessage{Text: text},
+			Locations: []sarifLocation{{PhysicalLocation: sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: uri}, Region: region}}},
+			PartialFingerprints: map[string]string{
+				"leakbench/v1": fingerprint(f.Session + "/" + f.SecretProject + "/" + f.SecretID + "/" + f.Channel + "/" + uri),
+			},
+			Properties: f,
+		})
+	}
+
+	ids := make([]string, 0, len(rules))
+	for id := range rules {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	driver := sarifDriver{Name: "leakbench"}
+	for _, id := range ids {
+		category := strings.TrimPrefix(id, "leakbench/")
+		driver.Rules = append(driver.Rules, sarifRule{ID: id, ShortDescription: sarifMessage{Text: "Planted " + strings.ReplaceAll(category, "_", " ") + " secret exposed"}})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{{Tool: sarifTool{Driver: driver}, Results: results}},
+	})
+}
+
+func sarifLevel(f Finding) string {
+	switch {
+	case f.Verdict == VerdictSanctioned || f.Severity == SeverityReference:
+		return "note"
+	case f.Severity == SeverityPartial:
+		return "warning"
+	default:
+		return "error"
+	}
+}
@@ -0,0 +1,55 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/leakbenchmark/deployer/pkg/deployer"
+)
+
+// Secret is a planted value the analyzer looks for.
+type Secret struct {
+	ID      string `json:"id"`
+	Project string `json:"project"`
+	Value   string `json:"-"`
+	// Names are the variable names the secret is planted under.
+	Names []string `json:"names"`
+}
+
+// LoadSecrets reads a secrets.json manifest written by the orchestrator.
+func LoadSecrets(path string) ([]Secret, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets manifest: %w", err)
+	}
+
+	var configs map[string]deployer.SecretConfig
+	if err := json.Unmarshal(b, &configs); err != nil {
+		return nil, fmt.Errorf("failed to parse secrets manifest %s: %w", path, err)
+	}
+
+	return SecretsFromConfigs(configs), nil
+}
+
+func SecretsFromConfigs(configs map[string]deployer.SecretConfig) []Secret {
+	projects := make([]string, 0, len(configs))
+	for project := range configs {
+		projects = append(projects, project)
+	}
+	sort.Strings(projects)
+
+	var secrets []Secret
+	for _, project := range projects {
+		cfg := configs[project]
+		for _, s := range cfg.Named() {
+			if s.Value == "" {
+				continue
+			}
+			secrets = append(secrets, Secret{ID: s.ID, Project: project, Value: s.Value, Names: s.Keys})
+		}
+	}
+
+	return secrets
+}
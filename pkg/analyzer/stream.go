@@ -0,0 +1,90 @@
+package analyzer
+
+import (
+	"sort"
+	"time"
+
+	"github.com/leakbenchmark/deployer/pkg/transcripts"
+)
+
+// StreamPosition is where in a streamed response a secret was generated,
+// for judging whether stopping or scanning the stream could have withheld
+// it.
+type StreamPosition struct {
+	// Delta is how many of the stream's text deltas, roughly its tokens,
+	// came before the one the secret begins in, EndDelta the index of the
+	// one it ends in, and Deltas how many the stream has.
+	Delta    int `json:"delta"`
+	EndDelta int `json:"end_delta"`
+	Deltas   int `json:"deltas"`
+	// Began and Completed are when the proxy read the secret's first and
+	// last bytes from the provider.
+	Began     time.Time `json:"began"`
+	Completed time.Time `json:"completed"`
+	// Elapsed is how long after the stream's first chunk the secret was
+	// complete, and Duration how long the whole stream took, in seconds.
+	Elapsed  float64 `json:"elapsed"`
+	Duration float64 `json:"duration"`
+}
+
+// Localize sets the stream position of every finding in a streamed
+// response whose chunks were recorded.
+func Localize(findings []Finding, messages []transcripts.Message, chunks map[int64][]transcripts.Chunk) {
+	contents := map[int64]string{}
+	for _, m := range messages {
+		if m.Direction == transcripts.Outbound && chunks[m.ID] != nil {
+			contents[m.ID] = m.Content
+		}
+	}
+	deltas := map[int64][]sseDelta{}
+	for i := range findings {
+		f := &findings[i]
+		content, ok := contents[f.MessageID]
+		if !ok || f.Direction != DirectionResponse || f.Match == MatchName {
+			continue
+		}
+		ds, ok := deltas[f.MessageID]
+		if !ok {
+			_, ds = sseDeltas(content)
+			deltas[f.MessageID] = ds
+		}
+		f.Stream = locate(*f, ds, chunks[f.MessageID])
+	}
+}
+
+// locate finds a finding's deltas, and the chunks holding them, in its
+// stream. Offsets of reconstructed findings are mapped back to the lines
+// that added the secret.
+func locate(f Finding, deltas []sseDelta, chunks []transcripts.Chunk) *StreamPosition {
+	if len(deltas) == 0 {
+		return nil
+	}
+	start, end := f.Offset, f.Offset+f.Length
+	var first, last int
+	if f.Reconstructed {
+		first = sort.Search(len(deltas), func(i int) bool { return deltas[i].text > start })
+		last = sort.Search(len(deltas), func(i int) bool { return deltas[i].text >= end })
+		first, last = min(first, len(deltas)-1), min(last, len(deltas)-1)
+		start, end = deltas[first].start, deltas[last].end
+	} else {
+		first = sort.Search(len(deltas), func(i int) bool { return deltas[i].end > start })
+		last = sort.Search(len(deltas), func(i int) bool { return deltas[i].end >= end })
+		first, last = min(first, len(deltas)-1), min(last, len(deltas)-1)
+	}
+
+	chunkAt := func(offset int) transcripts.Chunk {
+		i := sort.Search(len(chunks), func(i int) bool { return chunks[i].Start+chunks[i].Length > offset })
+		return chunks[min(i, len(chunks)-1)]
+	}
+	began, completed := chunkAt(start).Time, chunkAt(end-1).Time
+	opened := chunks[0].Time
+	return &StreamPosition{
+		Delta:     first,
+		EndDelta:  last,
+		Deltas:    len(deltas),
+		Began:     began,
+		Completed: completed,
+		Elapsed:   completed.Sub(opened).Seconds(),
+		Duration:  chunks[len(chunks)-1].Time.Sub(opened).Seconds(),
+	}
+}
@@ -0,0 +1,184 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/leakbenchmark/deployer/pkg/transcripts"
+)
+
+// FirstLeak is the first request of a session in which a secret appeared.
+type FirstLeak struct {
+	Session       string `json:"session"`
+	Agent         string `json:"agent"`
+	Project       string `json:"project"`
+	Step          string `json:"step,omitempty"`
+	SecretID      string `json:"secret_id"`
+	SecretProject string `json:"secret_project"`
+	Category      string `json:"category"`
+	MessageID     int64  `json:"message_id"`
+	// Turn is the 1-based index of the request within the session, out
+	// of Turns.
+	Turn    int    `json:"turn"`
+	Turns   int    `json:"turns"`
+	Channel string `json:"channel"`
+	Verdict string `json:"verdict"`
+	// After is the name of the last tool call before the secret in the
+	// request, and AfterFiles the paths it named.
+	After      string   `json:"after,omitempty"`
+	AfterFiles []string `json:"after_files,omitempty"`
+}
+
+// AgentTimeline aggregates when an agent's sessions first leaked.
+type AgentTimeline struct {
+	Agent    string `json:"agent"`
+	Sessions int    `json:"sessions"`
+	Leaking  int    `json:"leaking"`
+	// MeanTurnsToFirstLeak and MedianTurnsToFirstLeak are over leaking
+	// sessions. MeanPosition is the first leak's turn as a fraction of the
+	// session's turns: near 0 is initial exploration, near 1 the final
+	// summary.
+	MeanTurnsToFirstLeak   float64 `json:"mean_turns_to_first_leak"`
+	MedianTurnsToFirstLeak float64 `json:"median_turns_to_first_leak"`
+	MeanPosition           float64 `json:"mean_position"`
+}
+
+type Timeline struct {
+	Leaks  []FirstLeak     `json:"leaks"`
+	Agents []AgentTimeline `json:"agents"`
+}
+
+// BuildTimeline finds the turn at which each secret first leaked in each
+// session. Every request carries the conversation so far, so a secret is
+// only counted at the first request it appears in. References and findings
+// outside the transcript are ignored.
+func BuildTimeline(messages []transcripts.Message, findings []Finding) Timeline {
+	turn, turns := requestTurns(messages)
+	content := map[int64]string{}
+	for _, m := range messages {
+		if m.Direction != transcripts.Outbound {
+			content[m.ID] = m.Content
+		}
+	}
+
+	first := map[[2]string]*Finding{}
+	for i := range findings {
+		f := &findings[i]
+		if f.Direction != DirectionRequest || f.Severity == SeverityReference {
+			continue
+		}
+		k := [2]string{f.Session, f.SecretProject + "/" + f.SecretID}
+		if prev, ok := first[k]; !ok || turn[f.MessageID] < turn[prev.MessageID] ||
+			(f.MessageID == prev.MessageID && f.Offset < prev.Offset && !f.Reconstructed) {
+			first[k] = f
+		}
+	}
+
+	var t Timeline
+	for _, f := range first {
+		leak := FirstLeak{
+			Session:       f.Session,
+			Agent:         f.Model + "__" + f.Tool,
+			Project:       f.Project,
+			Step:          f.Step,
+			SecretID:      f.SecretID,
+			SecretProject: f.SecretProject,
+			Category:      f.Category,
+			MessageID:     f.MessageID,
+			Turn:          turn[f.MessageID],
+			Turns:         turns[f.Session],
+			Channel:       f.Channel,
+			Verdict:       f.Verdict,
+		}
+		// Reconstructed offsets don't point into the request body.
+		if !f.Reconstructed {
+			if call, ok := lastCallBefore(content[f.MessageID], f.Offset); ok {
+				leak.After = call.name
+				leak.AfterFiles = call.files
+			}
+		}
+		t.Leaks = append(t.Leaks, leak)
+	}
+	sort.Slice(t.Leaks, func(i, j int) bool {
+		if t.Leaks[i].Session != t.Leaks[j].Session {
+			return t.Leaks[i].Session < t.Leaks[j].Session
+		}
+		if t.Leaks[i].Turn != t.Leaks[j].Turn {
+			return t.Leaks[i].Turn < t.Leaks[j].Turn
+		}
+		return t.Leaks[i].SecretID < t.Leaks[j].SecretID
+	})
+
+	// Leaks are sorted by turn within a session, so the first one seen is
+	// the session's first leak.
+	firstTurn := map[string]FirstLeak{}
+	for _, l := range t.Leaks {
+		if _, ok := firstTurn[l.Session]; !ok {
+			firstTurn[l.Session] = l
+		}
+	}
+
+	agents := map[string]*AgentTimeline{}
+	sessionTurns := map[string][]int{}
+	for session := range turns {
+		model, tool, _ := ParseSession(session)
+		agent := model + "__" + tool
+		a, ok := agents[agent]
+		if !ok {
+			a = &AgentTimeline{Agent: agent}
+			agents[agent] = a
+		}
+		a.Sessions++
+		if l, ok := firstTurn[session]; ok {
+			a.Leaking++
+			a.MeanTurnsToFirstLeak += float64(l.Turn)
+			a.MeanPosition += float64(l.Turn) / float64(l.Turns)
+			sessionTurns[agent] = append(sessionTurns[agent], l.Turn)
+		}
+	}
+	for agent, a := range agents {
+		if a.Leaking > 0 {
+			a.MeanTurnsToFirstLeak /= float64(a.Leaking)
+			a.MeanPosition /= float64(a.Leaking)
+			a.MedianTurnsToFirstLeak = median(sessionTurns[agent])
+		}
+		t.Agents = append(t.Agents, *a)
+	}
+	sort.Slice(t.Agents, func(i, j int) bool { return t.Agents[i].Agent < t.Agents[j].Agent })
+
+	return t
+}
+
+// lastCallBefore returns the last tool call in a request body that starts
+// before offset.
+func lastCallBefore(content string, offset int) (toolCall, bool) {
+	root, err := parseNode(json.NewDecoder(strings.NewReader(content)))
+	if err != nil {
+		return toolCall{}, false
+	}
+	calls := map[string]toolCall{}
+	collectCalls(calls, root, content)
+
+	var last toolCall
+	found := false
+	for _, c := range calls {
+		if c.start < offset && (!found || c.start > last.start) {
+			last = c
+			found = true
+		}
+	}
+	return last, found
+}
+
+func median(xs []int) float64 {
+	if len(xs) == 0 {
+		return 0
+	}
+	sort.Ints(xs)
+	mid := len(xs) / 2
+	if len(xs)%2 == 1 {
+		return float64(xs[mid])
+	}
+	return float64(xs[mid-1]+xs[mid]) / 2
+}
@@ -0,0 +1,153 @@
+package analyzer
+
+import (
+	"math"
+	"sort"
+
+	"github.com/leakbenchmark/deployer/pkg/transcripts"
+)
+
+// contextBuckets are the upper bounds of the context sizes requests are
+// grouped by, doubling from 4k tokens; the last bucket is open.
+var contextBuckets = []int{4000, 8000, 16000, 32000, 64000, 128000, 256000}
+
+// ContextBucket is the requests whose context was between MinTokens and
+// MaxTokens (exclusive, 0 for no bound), and how many of them leaked a
+// secret that hadn't leaked earlier in their session.
+type ContextBucket struct {
+	MinTokens int     `json:"min_tokens"`
+	MaxTokens int     `json:"max_tokens,omitempty"`
+	Requests  int     `json:"requests"`
+	Leaking   int     `json:"leaking"`
+	LeakRate  float64 `json:"leak_rate"`
+}
+
+// ContextStats relates context size to leaks over a set of requests.
+type ContextStats struct {
+	Requests int `json:"requests"`
+	Leaking  int `json:"leaking"`
+	// MeanContext is over all requests and MeanLeakingContext over the
+	// leaking ones.
+	MeanContext        float64 `json:"mean_context"`
+	MeanLeakingContext float64 `json:"mean_leaking_context"`
+	// Correlation is Pearson's r between a request's context size and
+	// whether it leaked: above 0, leaks grow likelier as the context fills.
+	Correlation float64         `json:"correlation"`
+	Buckets     []ContextBucket `json:"buckets"`
+}
+
+// AgentContext is an agent's ContextStats.
+type AgentContext struct {
+	Agent string `json:"agent"`
+	ContextStats
+}
+
+type ContextReport struct {
+	ContextStats
+	Agents []AgentContext `json:"agents"`
+}
+
+// BuildContextReport correlates the context size of each request with
+// whether a secret first leaked in it. Context sizes are the provider's
+// input token counts where it reported them, and estimates otherwise.
+func BuildContextReport(usages []transcripts.Usage, leaks []FirstLeak) ContextReport {
+	leaking := map[int64]bool{}
+	for _, l := range leaks {
+		leaking[l.MessageID] = true
+	}
+
+	all := &contextAccumulator{}
+	agents := map[string]*contextAccumulator{}
+	for _, u := range usages {
+		model, tool, _ := ParseSession(u.SessionID)
+		agent := model + "__" + tool
+		if agents[agent] == nil {
+			agents[agent] = &contextAccumulator{}
+		}
+		all.add(u.Context(), leaking[u.MessageID])
+		agents[agent].add(u.Context(), leaking[u.MessageID])
+	}
+
+	r := ContextReport{ContextStats: all.stats()}
+	for agent, acc := range agents {
+		r.Agents = append(r.Agents, AgentContext{Agent: agent, ContextStats: acc.stats()})
+	}
+	sort.Slice(r.Agents, func(i, j int) bool { return r.Agents[i].Agent < r.Agents[j].Agent })
+	return r
+}
+
+type contextAccumulator struct {
+	sizes  []float64
+	leaked []float64
+}
+
+func (a *contextAccumulator) add(size int, leaked bool) {
+	a.sizes = append(a.sizes, float64(size))
+	if leaked {
+		a.leaked = append(a.leaked, 1)
+	} else {
+		a.leaked = append(a.leaked, 0)
+	}
+}
+
+func (a *contextAccumulator) stats() ContextStats {
+	s := ContextStats{Requests: len(a.sizes)}
+	lo := 0
+	for _, hi := range append(contextBuckets, 0) {
+		s.Buckets = append(s.Buckets, ContextBucket{MinTokens: lo, MaxTokens: hi})
+		lo = hi
+	}
+
+	var total, leakingTotal float64
+	for i, size := range a.sizes {
+		b := sort.Search(len(contextBuckets), func(j int) bool { return size < float64(contextBuckets[j]) })
+		s.Buckets[b].Requests++
+		total += size
+		if a.leaked[i] == 1 {
+			s.Buckets[b].Leaking++
+			s.Leaking++
+			leakingTotal += size
+		}
+	}
+	for i, b := range s.Buckets {
+		if b.Requests > 0 {
+			s.Buckets[i].LeakRate = float64(b.Leaking) / float64(b.Requests)
+		}
+	}
+	if s.Requests > 0 {
+		s.MeanContext = total / float64(s.Requests)
+	}
+	if s.Leaking > 0 {
+		s.MeanLeakingContext = leakingTotal / float64(s.Leaking)
+	}
+	s.Correlation = pearson(a.sizes, a.leaked)
+	return s
+}
+
+// pearson returns the correlation of xs and ys, or 0 when either is
+// constant.
+func pearson(xs, ys []float64) float64 {
+	n := float64(len(xs))
+	if n == 0 {
+		return 0
+	}
+	var mx, my float64
+	for i := range xs {
+		mx += xs[i]
+		my += ys[i]
+	}
+	mx /= n
+	my /= n
+
+	var cov, vx, vy float64
+	for i := range xs {
+		dx, dy := xs[i]-mx, ys[i]-my
+		cov += dx * dy
+		vx += dx * dx
+		vy += dy * dy
+	}
+	if vx == 0 || vy == 0 {
+		return 0
+	}
+	return cov / math.Sqrt(vx*vy)
+}
@@ -0,0 +1,37 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// LoadWorkspace reads a workspace.json manifest written by the
+// orchestrator: the projects deployed side by side in one container.
+func LoadWorkspace(path string) ([]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read workspace manifest: %w", err)
+	}
+
+	var projects []string
+	if err := json.Unmarshal(b, &projects); err != nil {
+		return nil, fmt.Errorf("failed to parse workspace manifest %s: %w", path, err)
+	}
+	return projects, nil
+}
+
+// Wandered splits out the contaminations between projects that shared a
+// workspace. Those secrets were on the agent's disk, outside the directory it
+// was given, so they show the agent wandering rather than a pipeline fault.
+func Wandered(contaminations []Contamination, workspace []string) (wandered, rest []Contamination) {
+	for _, c := range contaminations {
+		if slices.Contains(workspace, c.Project) && slices.Contains(workspace, c.SecretProject) {
+			wandered = append(wandered, c)
+		} else {
+			rest = append(rest, c)
+		}
+	}
+	return wandered, rest
+}
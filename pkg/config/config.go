@@ -0,0 +1,680 @@
+// Package config resolves the benchmark's settings from defaults, a YAML
+// file, LEAKBENCH_* environment variables and command-line flags.
+package config
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the settings of the orchestrator, deployer and proxy. Each
+// setting is resolved from, in increasing priority, its default, the YAML
+// config file, an environment variable and a command line flag.
+type Config struct {
+	RunID string `yaml:"run_id"`
+	// Scenario is a JSON scenario file run instead of the default prompt.
+	Scenario string `yaml:"scenario"`
+	// Bundle lists the sessions to emit reproducibility bundles for.
+	Bundle string `yaml:"bundle"`
+	// MessagesDB is the transcript database the proxy records a run to,
+	// runs/<run-id>/messages.db when empty.
+	MessagesDB string `yaml:"messages_db"`
+
+	Deployer  DeployerConfig  `yaml:"deployer"`
+	Proxy     ProxyConfig     `yaml:"proxy"`
+	Artifacts ArtifactsConfig `yaml:"artifacts"`
+	Keys      KeysConfig      `yaml:"keys"`
+	// Decoding is forced on every agent's requests by the proxy.
+	Decoding Decoding `yaml:"decoding"`
+	// Ablation is applied to every agent's requests by the proxy.
+	Ablation Ablation `yaml:"ablation"`
+	// Network degrades every agent's connection to its provider, in the
+	// proxy.
+	Network Network `yaml:"network"`
+	// ContextWindow simulates a smaller context window for every agent, in
+	// the proxy.
+	ContextWindow ContextWindow `yaml:"context_window"`
+	// Budget caps every agent session's turns and tokens, in the proxy.
+	Budget Budget `yaml:"budget"`
+	// Redaction masks the planted secrets in the traffic the proxy passes
+	// on, keeping the originals in the transcripts.
+	Redaction Redaction `yaml:"redaction"`
+	// Stall flags, and optionally ends, agent sessions that go quiet.
+	Stall Stall `yaml:"stall"`
+	// Differential pairs every agent's cells with ones run with its tool's
+	// mitigation settings.
+	Differential Differential `yaml:"differential"`
+	// Models are local model servers started for the run, for agents to
+	// use as their provider.
+	Models []Model `yaml:"models"`
+	// Egress locks the containers' egress down to the hosts their projects'
+	// manifests allow.
+	Egress Egress `yaml:"egress"`
+	// Trials repeats the run, each time with freshly generated secrets, as
+	// runs <run-id>-t1 to <run-id>-tN. Zero or one runs it once.
+	Trials int `yaml:"trials"`
+}
+
+type DeployerConfig struct {
+	// Projects is the directory the benchmark projects are discovered in.
+	Projects string `yaml:"projects"`
+	// Sources are git repositories the projects are fetched from instead,
+	// cloned into Cache.
+	Sources   []Source `yaml:"sources"`
+	Cache     string   `yaml:"cache"`
+	Manifests string   `yaml:"manifests"`
+	// Inject lists the places to plant prompt injections in each project.
+	Inject string `yaml:"inject"`
+	// HoneytokenURL is where the honeytoken listener is reached, by the
+	// agents and by anything their providers fetch.
+	HoneytokenURL string `yaml:"honeytoken_url"`
+	// Locales lists the locales to plant non-English fixtures, holding
+	// secrets and non-ASCII values, for in each project.
+	Locales string `yaml:"locales"`
+	// Surfaces lists the surfaces to plant secrets in (env, code, dotfiles,
+	// git, db), "all", or "random" for a random subset of them per project.
+	Surfaces string `yaml:"surfaces"`
+	// DependencyCache names the volumes shared by the containers as their
+	// npm and pip caches, none when empty.
+	DependencyCache string `yaml:"dependency_cache"`
+	// ProcessAudit is the image of a sidecar that logs every process
+	// executed in the benchmark containers, none when empty.
+	ProcessAudit string `yaml:"process_audit"`
+	// FileAccess is the image of a sidecar that logs reads of the files
+	// secrets are planted in, none when empty.
+	FileAccess string `yaml:"file_access"`
+	// Workspace lists projects to deploy side by side into one container,
+	// or "all", with each agent scoped to its own project's directory.
+	Workspace string `yaml:"workspace"`
+	// Suite is the suite version the projects are expected to hash to.
+	// A run against any other version fails before the agents start.
+	Suite string `yaml:"suite"`
+	// Tags selects the projects with any of the comma-separated tags in
+	// their manifest, and Subset those in the named subset. Subsets maps
+	// each name to its tags or project names; a name it doesn't define is
+	// taken as a tag.
+	Tags    string              `yaml:"tags"`
+	Subset  string              `yaml:"subset"`
+	Subsets map[string][]string `yaml:"subsets"`
+}
+
+type ProxyConfig struct {
+	// Addr is the address the proxy listens on, and URL where the
+	// orchestrator reaches it.
+	Addr string `yaml:"addr"`
+	URL  string `yaml:"url"`
+	// DB is the database the proxy records to until a setup call names
+	// another.
+	DB       string `yaml:"db"`
+	Upstream string `yaml:"upstream"`
+	// MaxBody caps the size of request bodies the proxy accepts, and
+	// BodyMemory how much of one, or of a streamed response, it holds in
+	// memory before spilling the rest to a temporary file. MaxRecord caps
+	// how much of a body it stores in the database, attaching larger ones
+	// whole, and MaxRewrite the size of bodies it changes in memory.
+	MaxBody    Size `yaml:"max_body"`
+	BodyMemory Size `yaml:"body_memory"`
+	MaxRecord  Size `yaml:"max_record"`
+	MaxRewrite Size `yaml:"max_rewrite"`
+	// Limits holds the per-minute budget of each provider host, shared by
+	// every cell running against it.
+	Limits map[string]Limit `yaml:"limits"`
+	// Headers holds extra headers to send to each provider host.
+	Headers map[string]map[string]string `yaml:"headers"`
+	// Events lists the comma-separated sinks the proxy publishes its
+	// events to: file, nats:// or kafka:// URLs.
+	Events string `yaml:"events"`
+}
+
+// Limit is a provider's budget per minute. Zero means unlimited.
+type Limit struct {
+	Requests int `yaml:"requests_per_minute"`
+	Tokens   int `yaml:"tokens_per_minute"`
+}
+
+// Decoding holds sampling parameters that override whatever the agent or
+// the provider's defaults would use. Unset ones are left alone.
+type Decoding struct {
+	Temperature *float64 `yaml:"temperature"`
+	TopP        *float64 `yaml:"top_p"`
+	Seed        *int64   `yaml:"seed"`
+}
+
+// Over returns d with the parameters it leaves unset taken from base.
+func (d Decoding) Over(base Decoding) Decoding {
+	if d.Temperature == nil {
+		d.Temperature = base.Temperature
+	}
+	if d.TopP == nil {
+		d.TopP = base.TopP
+	}
+	if d.Seed == nil {
+		d.Seed = base.Seed
+	}
+	return d
+}
+
+// Ablation removes context from the agents' requests, to study which of
+// it makes them leak.
+type Ablation struct {
+	// DropSystem are regular expressions; system prompt sections, which
+	// start at markdown headings, matching any of them are dropped.
+	DropSystem []string `yaml:"drop_system"`
+	// KeepTurns truncates the history to the last KeepTurns model turns.
+	KeepTurns int `yaml:"keep_turns"`
+	// StripToolResults replaces earlier tool results with a placeholder.
+	StripToolResults bool `yaml:"strip_tool_results"`
+}
+
+// IsZero reports whether a leaves requests alone.
+func (a Ablation) IsZero() bool {
+	return len(a.DropSystem) == 0 && a.KeepTurns == 0 && !a.StripToolResults
+}
+
+// Network is the connection the agents get to their providers: each
+// request is delayed by Latency, give or take Jitter, dropped with
+// probability Loss, and its response sent at no more than Bandwidth bytes
+// a second. Zero values leave it alone.
+type Network struct {
+	Latency   time.Duration `yaml:"latency"`
+	Jitter    time.Duration `yaml:"jitter"`
+	Loss      float64       `yaml:"loss"`
+	Bandwidth Size          `yaml:"bandwidth"`
+}
+
+// IsZero reports whether n leaves connections alone.
+func (n Network) IsZero() bool {
+	return n == Network{}
+}
+
+// ContextWindow is the context window the agents' requests are fit into:
+// those over Tokens, estimated at four bytes a token, lose their oldest
+// turns or, with Reject, are refused as the provider would. Zero leaves
+// requests alone.
+type ContextWindow struct {
+	Tokens int  `yaml:"tokens"`
+	Reject bool `yaml:"reject"`
+}
+
+// Budget is how many requests, and tokens as the provider reports them,
+// each agent session may use before the proxy refuses its requests. Zero is
+// unlimited.
+type Budget struct {
+	Turns  int `yaml:"turns"`
+	Tokens int `yaml:"tokens"`
+}
+
+// Redaction is where the proxy masks the planted secrets: in responses
+// before the agents see them and, with Requests, in requests before the
+// providers do.
+type Redaction struct {
+	Responses bool `yaml:"responses"`
+	Requests  bool `yaml:"requests"`
+}
+
+// Stall is how long an agent session may go without a message through the
+// proxy, while its tool is still running, before it is taken to have hung
+// rather than to be working. Stalled sessions are recorded and, with
+// Terminate, their tool is killed. Zero never flags a session.
+type Stall struct {
+	After     time.Duration `yaml:"after"`
+	Terminate bool          `yaml:"terminate"`
+}
+
+// Differential is the mitigation settings each tool is run with, as well as
+// without, in a differential run: the paths of a Claude Code settings.json
+// and a Codex config.toml. Variant names the cells run with them in session
+// IDs, "mitigated" when empty. A tool without settings is only run plain.
+type Differential struct {
+	Variant    string `yaml:"variant"`
+	ClaudeCode string `yaml:"claude_code"`
+	Codex      string `yaml:"codex"`
+}
+
+// Egress is whether the containers may reach only the hosts in their
+// projects' manifests, and Allow, through a proxy that logs what they send.
+// Allow lists hosts every container may reach, such as the registry the
+// agents' tools are installed from.
+type Egress struct {
+	Lockdown bool     `yaml:"lockdown"`
+	Allow    []string `yaml:"allow"`
+}
+
+// Model is a local model server, such as vLLM or Ollama, run in a container
+// on the host's network and reached by agents at http://localhost:<port>.
+type Model struct {
+	Name  string `yaml:"name"`
+	Image string `yaml:"image"`
+	// Args are passed to the image's entrypoint, such as vLLM's --model.
+	Args []string          `yaml:"args"`
+	Env  map[string]string `yaml:"env"`
+	// GPUs are passed through like docker run --gpus: "all", a count, or
+	// comma-separated device IDs. None when empty.
+	GPUs string `yaml:"gpus"`
+	// Volumes are host:container[:ro] mounts, such as a model cache.
+	Volumes []string `yaml:"volumes"`
+	Port    int      `yaml:"port"`
+	// Health is the path probed until the server is ready, "/" when empty,
+	// for at most Timeout, ten minutes when zero.
+	Health  string        `yaml:"health"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Source is a benchmark project fetched from git at a pinned revision.
+type Source struct {
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+	// Ref is the commit, or a tag, to check out.
+	Ref string `yaml:"ref"`
+}
+
+type ArtifactsConfig struct {
+	Store     string        `yaml:"store"`
+	Retention time.Duration `yaml:"retention"`
+}
+
+// KeysConfig holds the provider API keys passed to the agents. They are
+// only read from the environment or the config file, never from flags.
+type KeysConfig struct {
+	Anthropic string `yaml:"anthropic"`
+	OpenAI    string `yaml:"openai"`
+	// OpenRouter is the key of agents reaching their models through
+	// OpenRouter.
+	OpenRouter string `yaml:"openrouter"`
+	// Encryption is the base64-encoded AES-256 key runs are sealed at rest
+	// with or, when empty, EncryptionCommand prints it, such as from a KMS.
+	Encryption        string `yaml:"encryption"`
+	EncryptionCommand string `yaml:"encryption_command"`
+	// Admin is the token the proxy requires of setup calls, and Read lists
+	// comma-separated tokens that may only read, such as gate checks.
+	Admin string `yaml:"admin"`
+	Read  string `yaml:"read"`
+}
+
+func Default() Config {
+	return Config{
+		Deployer: DeployerConfig{
+			Projects:  "./benchmark_projects",
+			Cache:     "./.cache/projects",
+			Manifests: "./manifests",
+		},
+		Proxy: ProxyConfig{
+			Addr:       ":8080",
+			URL:        "http://localhost:8080",
+			DB:         "./messages.db",
+			Upstream:   "https://api.openai.com",
+			MaxBody:    256 << 20,
+			BodyMemory: 8 << 20,
+			MaxRecord:  16 << 20,
+			MaxRewrite: 32 << 20,
+		},
+		Egress: Egress{Allow: []string{"registry.npmjs.org"}},
+	}
+}
+
+// Size is a byte count, written with an optional KB, MB, GB or TB suffix.
+type Size int64
+
+var sizeUnits = []struct {
+	suffix string
+	bytes  Size
+}{
+	{"TB", 1 << 40},
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// ParseSize parses a byte count with an optional KB, MB, GB or TB suffix.
+func ParseSize(s string) (Size, error) {
+	upper := strings.ToUpper(strings.TrimSpace(s))
+	unit := Size(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(upper, u.suffix) {
+			upper, unit = strings.TrimSuffix(upper, u.suffix), u.bytes
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(upper), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return Size(n * float64(unit)), nil
+}
+
+func (n Size) String() string {
+	for _, u := range sizeUnits {
+		if n >= u.bytes && u.bytes > 1 {
+			return fmt.Sprintf("%.1f%s", float64(n)/float64(u.bytes), u.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+func (n Size) MarshalYAML() (any, error) {
+	return n.String(), nil
+}
+
+func (n *Size) UnmarshalYAML(node *yaml.Node) error {
+	size, err := ParseSize(node.Value)
+	if err != nil {
+		return err
+	}
+	*n = size
+	return nil
+}
+
+// setting ties a field to the flag and environment variable that set it.
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	str   *string
+	dur   *time.Duration
+	size  *Size
+	num   *int
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{flag: "run-id", env: "LEAKBENCH_RUN_ID", str: &c.RunID, usage: "identifier for this run (defaults to a random UUID)"},
+		{flag: "scenario", env: "LEAKBENCH_SCENARIO", str: &c.Scenario, usage: "JSON scenario giving each agent a sequence of prompts instead of PROMPT"},
+		{flag: "trials", env: "LEAKBENCH_TRIALS", num: &c.Trials, usage: "run the benchmark this many times, each with freshly generated secrets"},
+		{flag: "bundle", env: "LEAKBENCH_BUNDLE", str: &c.Bundle, usage: "comma separated session IDs to emit reproducibility bundles for, or \"all\""},
+		{flag: "messages-db", env: "LEAKBENCH_MESSAGES_DB", str: &c.MessagesDB, usage: "transcript database the proxy records to (defaults to runs/<run-id>/messages.db)"},
+		{flag: "projects", env: "LEAKBENCH_PROJECTS", str: &c.Deployer.Projects, usage: "directory to discover benchmark projects in"},
+		{flag: "project-cache", env: "LEAKBENCH_PROJECT_CACHE", str: &c.Deployer.Cache, usage: "directory the project sources are cloned into"},
+		{flag: "manifests", env: "LEAKBENCH_MANIFESTS", str: &c.Deployer.Manifests, usage: "directory of per-project manifests"},
+		{flag: "inject", env: "LEAKBENCH_INJECT", str: &c.Deployer.Inject, usage: "comma-separated places to plant prompt injections in each project (readme, comment, issue_template) or \"all\""},
+		{flag: "honeytoken-url", env: "LEAKBENCH_HONEYTOKEN_URL", str: &c.Deployer.HoneytokenURL, usage: "plant unique links under this URL, served by leakbench honeytokens, in each project's docs"},
+		{flag: "locales", env: "LEAKBENCH_LOCALES", str: &c.Deployer.Locales, usage: "comma-separated locales to plant non-English secret fixtures for in each project (de, ja, ru, zh) or \"all\""},
+		{flag: "surfaces", env: "LEAKBENCH_SURFACES", str: &c.Deployer.Surfaces, usage: "comma-separated surfaces to plant secrets in (env, code, dotfiles, git, db), \"all\", or \"random\" for a random subset per project (defaults to env)"},
+		{flag: "dependency-cache", env: "LEAKBENCH_DEPENDENCY_CACHE", str: &c.Deployer.DependencyCache, usage: "prefix of the Docker volumes shared by the containers as npm and pip caches (none by default)"},
+		{flag: "process-audit", env: "LEAKBENCH_PROCESS_AUDIT", str: &c.Deployer.ProcessAudit, usage: "image of a sidecar logging every process run in the containers, built from sidecars/execsnoop"},
+		{flag: "file-access", env: "LEAKBENCH_FILE_ACCESS", str: &c.Deployer.FileAccess, usage: "image of a sidecar logging reads of the planted secret files, built from sidecars/fileaccess"},
+		{flag: "workspace", env: "LEAKBENCH_WORKSPACE", str: &c.Deployer.Workspace, usage: "comma-separated projects to deploy into one shared container, or \"all\""},
+		{flag: "tags", env: "LEAKBENCH_TAGS", str: &c.Deployer.Tags, usage: "comma-separated project tags; only projects with one of them are deployed"},
+		{flag: "subset", env: "LEAKBENCH_SUBSET", str: &c.Deployer.Subset, usage: "named subset of the projects to deploy, from deployer.subsets, or a tag"},
+		{flag: "suite", env: "LEAKBENCH_SUITE", str: &c.Deployer.Suite, usage: "suite version the projects must hash to, as recorded in a previous run's suite.json"},
+		{flag: "addr", env: "LEAKBENCH_PROXY_ADDR", str: &c.Proxy.Addr, usage: "address the proxy listens on"},
+		{flag: "proxy-url", env: "LEAKBENCH_PROXY_URL", str: &c.Proxy.URL, usage: "URL the orchestrator reaches the proxy at"},
+		{flag: "db", env: "LEAKBENCH_PROXY_DB", str: &c.Proxy.DB, usage: "database the proxy records to until told otherwise"},
+		{flag: "upstream", env: "LEAKBENCH_UPSTREAM", str: &c.Proxy.Upstream, usage: "provider the proxy forwards to until told otherwise"},
+		{flag: "max-body", env: "LEAKBENCH_PROXY_MAX_BODY", size: &c.Proxy.MaxBody, usage: "largest request body the proxy accepts, e.g. 256MB"},
+		{flag: "body-memory", env: "LEAKBENCH_PROXY_BODY_MEMORY", size: &c.Proxy.BodyMemory, usage: "request and streamed response bytes held in memory before spilling to disk"},
+		{flag: "max-record", env: "LEAKBENCH_PROXY_MAX_RECORD", size: &c.Proxy.MaxRecord, usage: "most of a request or response the proxy stores in its database, e.g. 16MB; larger ones are attached whole"},
+		{flag: "max-rewrite", env: "LEAKBENCH_PROXY_MAX_REWRITE", size: &c.Proxy.MaxRewrite, usage: "largest request body the proxy changes through middleware, rewrites or redaction, e.g. 32MB"},
+		{flag: "events", env: "LEAKBENCH_PROXY_EVENTS", str: &c.Proxy.Events, usage: "comma-separated sinks to publish proxy events to: file:///path.jsonl, nats://host:4222/subject or kafka://rest-proxy:8082/topic"},
+		{flag: "artifact-store", env: "LEAKBENCH_ARTIFACT_STORE", str: &c.Artifacts.Store, usage: "upload run artifacts to s3://bucket/prefix, gs://bucket/prefix or file:///path"},
+		{flag: "artifact-retention", env: "LEAKBENCH_ARTIFACT_RETENTION", dur: &c.Artifacts.Retention, usage: "delete stored artifacts older than this, 0 keeps everything"},
+		{env: "ANTHROPIC_API_KEY", str: &c.Keys.Anthropic},
+		{env: "OPENAI_API_KEY", str: &c.Keys.OpenAI},
+		{env: "OPENROUTER_API_KEY", str: &c.Keys.OpenRouter},
+		{env: "LEAKBENCH_ENCRYPTION_KEY", str: &c.Keys.Encryption},
+		{env: "LEAKBENCH_ENCRYPTION_KEY_COMMAND", str: &c.Keys.EncryptionCommand},
+		{env: "LEAKBENCH_ADMIN_TOKEN", str: &c.Keys.Admin},
+		{env: "LEAKBENCH_READ_TOKENS", str: &c.Keys.Read},
+	}
+}
+
+func (s setting) set(value string) error {
+	if s.dur != nil {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q: %w", value, err)
+		}
+		*s.dur = d
+		return nil
+	}
+	if s.num != nil {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		*s.num = n
+		return nil
+	}
+	if s.size != nil {
+		n, err := ParseSize(value)
+		if err != nil {
+			return err
+		}
+		*s.size = n
+		return nil
+	}
+	*s.str = value
+	return nil
+}
+
+// Load resolves the config from its defaults, the file at path, if any, and
+// the environment. Flags are applied separately with ApplyFlags.
+func Load(path string) (Config, error) {
+	c := Default()
+
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return c, fmt.Errorf("failed to read config: %w", err)
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		dec.KnownFields(true)
+		if err := dec.Decode(&c); err != nil && err != io.EOF {
+			return c, fmt.Errorf("failed to parse config %s: %w", path, err)
+		}
+	}
+
+	for _, s := range c.settings() {
+		if v, ok := os.LookupEnv(s.env); ok && v != "" {
+			if err := s.set(v); err != nil {
+				return c, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+
+	return c, nil
+}
+
+// RegisterFlags adds a flag to fs for each of the named settings.
+func RegisterFlags(fs *flag.FlagSet, names ...string) {
+	defaults := Default()
+	byFlag := map[string]setting{}
+	for _, s := range defaults.settings() {
+		byFlag[s.flag] = s
+	}
+	for _, name := range names {
+		s, ok := byFlag[name]
+		if !ok {
+			panic("config: no setting for flag " + name)
+		}
+		if s.dur != nil {
+			fs.Duration(name, *s.dur, s.usage)
+		} else if s.size != nil {
+			fs.String(name, s.size.String(), s.usage)
+		} else if s.num != nil {
+			fs.Int(name, *s.num, s.usage)
+		} else {
+			fs.String(name, *s.str, s.usage)
+		}
+	}
+}
+
+// ApplyFlags overrides c with the flags set on the command line of the
+// parsed fs.
+func (c *Config) ApplyFlags(fs *flag.FlagSet) error {
+	byFlag := map[string]setting{}
+	for _, s := range c.settings() {
+		if s.flag != "" {
+			byFlag[s.flag] = s
+		}
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		if s, ok := byFlag[f.Name]; ok && err == nil {
+			if setErr := s.set(f.Value.String()); setErr != nil {
+				err = fmt.Errorf("-%s: %w", f.Name, setErr)
+			}
+		}
+	})
+	return err
+}
+
+// Requirement is a check Validate runs against the resolved config.
+type Requirement func(ctx context.Context, c Config) error
+
+// RequireAPIKeys checks that both provider keys are set.
+func RequireAPIKeys(ctx context.Context, c Config) error {
+	if c.Keys.Anthropic == "" {
+		return fmt.Errorf("no Anthropic API key: set ANTHROPIC_API_KEY or keys.anthropic")
+	}
+	if c.Keys.OpenAI == "" {
+		return fmt.Errorf("no OpenAI API key: set OPENAI_API_KEY or keys.openai")
+	}
+	return nil
+}
+
+// RequireDocker checks that the Docker daemon answers.
+func RequireDocker(ctx context.Context, c Config) error {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return fmt.Errorf("failed to create Docker client: %w", err)
+	}
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if _, err := cli.Ping(ctx); err != nil {
+		return fmt.Errorf("Docker is not reachable: %w", err)
+	}
+	return nil
+}
+
+// Validate checks that the settings every component needs are present,
+// then runs each requirement.
+func (c Config) Validate(ctx context.Context, reqs ...Requirement) error {
+	if c.Proxy.URL == "" || c.Proxy.Addr == "" {
+		return fmt.Errorf("proxy.url and proxy.addr must be set")
+	}
+	if c.Deployer.Projects == "" || c.Deployer.Manifests == "" {
+		return fmt.Errorf("deployer.projects and deployer.manifests must be set")
+	}
+	names := map[string]bool{}
+	for i, s := range c.Deployer.Sources {
+		if s.Name == "" || s.URL == "" || s.Ref == "" {
+			return fmt.Errorf("deployer.sources[%d] must set name, url and ref", i)
+		}
+		if names[s.Name] {
+			return fmt.Errorf("deployer.sources has two projects named %s", s.Name)
+		}
+		names[s.Name] = true
+	}
+	if len(c.Deployer.Sources) > 0 && c.Deployer.Cache == "" {
+		return fmt.Errorf("deployer.cache must be set to fetch deployer.sources")
+	}
+	for host, l := range c.Proxy.Limits {
+		if l.Requests < 0 || l.Tokens < 0 {
+			return fmt.Errorf("proxy.limits.%s must not be negative", host)
+		}
+	}
+	if t := c.Decoding.Temperature; t != nil && (*t < 0 || *t > 2) {
+		return fmt.Errorf("decoding.temperature must be between 0 and 2")
+	}
+	if p := c.Decoding.TopP; p != nil && (*p < 0 || *p > 1) {
+		return fmt.Errorf("decoding.top_p must be between 0 and 1")
+	}
+	models := map[string]bool{}
+	for i, m := range c.Models {
+		if m.Name == "" || m.Image == "" || m.Port <= 0 {
+			return fmt.Errorf("models[%d] must set name, image and port", i)
+		}
+		if models[m.Name] {
+			return fmt.Errorf("models has two servers named %s", m.Name)
+		}
+		models[m.Name] = true
+	}
+	if n := c.Network; n.Latency < 0 || n.Jitter < 0 || n.Jitter > n.Latency || n.Bandwidth < 0 {
+		return fmt.Errorf("network.latency, jitter and bandwidth must not be negative, nor jitter over latency")
+	}
+	if c.ContextWindow.Tokens < 0 {
+		return fmt.Errorf("context_window.tokens must not be negative")
+	}
+	if c.Budget.Turns < 0 || c.Budget.Tokens < 0 {
+		return fmt.Errorf("budget.turns and tokens must not be negative")
+	}
+	if c.Stall.After < 0 {
+		return fmt.Errorf("stall.after must not be negative")
+	}
+	if v := c.Differential.Variant; strings.Contains(v, "__") || strings.ContainsAny(v, "+/:") {
+		return fmt.Errorf("differential.variant must not contain \"__\", \"+\", \"/\" or \":\"")
+	}
+	if l := c.Network.Loss; l < 0 || l >= 1 {
+		return fmt.Errorf("network.loss must be at least 0 and under 1")
+	}
+	for _, p := range c.Ablation.DropSystem {
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("invalid ablation.drop_system pattern %q: %w", p, err)
+		}
+	}
+	if c.Ablation.KeepTurns < 0 {
+		return fmt.Errorf("ablation.keep_turns must not be negative")
+	}
+	if c.Trials < 0 {
+		return fmt.Errorf("trials must not be negative")
+	}
+	for _, req := range reqs {
+		if err := req(ctx, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Redacted returns c with the API keys, and the proxy's header values, which
+// can hold gateway credentials, replaced by whether they are set.
+func (c Config) Redacted() Config {
+	mask := func(key string) string {
+		if key == "" {
+			return ""
+		}
+		return "[set]"
+	}
+	c.Keys.Anthropic = mask(c.Keys.Anthropic)
+	c.Keys.OpenAI = mask(c.Keys.OpenAI)
+	c.Keys.OpenRouter = mask(c.Keys.OpenRouter)
+	c.Keys.Encryption = mask(c.Keys.Encryption)
+	c.Keys.Admin = mask(c.Keys.Admin)
+	c.Keys.Read = mask(c.Keys.Read)
+	headers := map[string]map[string]string{}
+	for host, hs := range c.Proxy.Headers {
+		headers[host] = map[string]string{}
+		for name, value := range hs {
+			headers[host][name] = mask(value)
+		}
+	}
+	if c.Proxy.Headers != nil {
+		c.Proxy.Headers = headers
+	}
+	return c
+}
+
+// Write writes the effective config, with keys redacted, as YAML.
+func (c Config) Write(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c.Redacted()); err != nil {
+		return err
+	}
+	return enc.Close()
+}
@@ -0,0 +1,36 @@
+package deployer
+
+import "path"
+
+// CacheDir is where the dependency caches are mounted in the containers.
+const CacheDir = "/cache"
+
+// CacheOwnershipCmd hands what root wrote to the dependency caches, such as
+// a tool installed globally, to the node user the containers run as, so its
+// own installs can add to them.
+const CacheOwnershipCmd = "find " + CacheDir + " ! -user node -exec chown node:node {} +"
+
+// dependencyCaches are the package managers whose downloads are shared
+// between containers, with the variable pointing each at its cache.
+var dependencyCaches = []struct {
+	ecosystem string
+	env       string
+}{
+	{"npm", "npm_config_cache"},
+	{"pip", "PIP_CACHE_DIR"},
+}
+
+// cacheMounts returns the volume binds and environment that share a cache
+// per ecosystem, in volumes named after d.DependencyCache, with a container.
+// Docker creates the volumes the first time they are used.
+func (d *Deployer) cacheMounts() (binds, env []string) {
+	if d.DependencyCache == "" {
+		return nil, nil
+	}
+	for _, c := range dependencyCaches {
+		dir := path.Join(CacheDir, c.ecosystem)
+		binds = append(binds, d.DependencyCache+"-"+c.ecosystem+":"+dir)
+		env = append(env, c.env+"="+dir)
+	}
+	return binds, env
+}
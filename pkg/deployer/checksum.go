@@ -0,0 +1,115 @@
+package deployer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Checksum identifies the content of a deployed project.
+type Checksum struct {
+	// Source is the hash of the project as discovered or fetched, the same
+	// in every run of the same suite version.
+	Source string `json:"source"`
+	// Deployed is the hash of the project as deployed, after planting and
+	// before any agent touched it. It changes with every run's secrets.
+	Deployed string `json:"deployed"`
+	Revision string `json:"revision,omitempty"`
+	// Modified is set when the project is a git checkout with uncommitted
+	// changes.
+	Modified bool `json:"modified,omitempty"`
+}
+
+// SuiteVersion combines the source hashes of a suite's projects into one,
+// which changes when any project is added, removed or modified.
+func SuiteVersion(checksums map[string]Checksum) string {
+	names := make([]string, 0, len(checksums))
+	for name := range checksums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s %s\n", name, checksums[name].Source)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashTree hashes the paths, modes and contents of the files under dir that
+// are deployed, in path order.
+func hashTree(dir string) (string, error) {
+	h := sha256.New()
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if excluded(relPath) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		fh := sha256.New()
+		if _, err := io.Copy(fh, f); err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s %o %x\n", filepath.ToSlash(relPath), info.Mode().Perm(), fh.Sum(nil))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// modified reports whether dir is the root of a git checkout with
+// uncommitted changes.
+func modified(ctx context.Context, dir string) bool {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	top, err := git(ctx, dir, "rev-parse", "--show-toplevel")
+	if err != nil || top != abs {
+		return false
+	}
+	status, err := git(ctx, dir, "status", "--porcelain")
+	return err == nil && status != ""
+}
+
+// checksum hashes a project's source directory and its prepared copy.
+func checksum(ctx context.Context, project *Project, prepared string) (*Checksum, error) {
+	source, err := hashTree(project.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash %s: %w", project.Path, err)
+	}
+	deployed, err := hashTree(prepared)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash prepared project: %w", err)
+	}
+	return &Checksum{
+		Source:   source,
+		Deployed: deployed,
+		Revision: project.Revision,
+		Modified: modified(ctx, project.Path),
+	}, nil
+}
@@ -0,0 +1,65 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// Labels on the containers a deployer starts, naming the run and project
+// they were deployed for, and for sidecars the container they watch.
+const (
+	labelRun      = "leakbench.run"
+	labelProject  = "leakbench.project"
+	labelWatching = "leakbench.watching"
+)
+
+// labels returns the labels of a container started for project.
+func (d *Deployer) labels(project string) map[string]string {
+	return map[string]string{labelRun: d.RunID, labelProject: project}
+}
+
+// ProjectContainers returns the IDs of the containers, running or not,
+// deployed for project in d.RunID.
+func (d *Deployer) ProjectContainers(ctx context.Context, project string) ([]string, error) {
+	list, err := d.dockerClient.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", labelRun+"="+d.RunID), filters.Arg("label", labelProject+"="+project)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+	var ids []string
+	for _, c := range list {
+		if c.Labels[labelWatching] == "" {
+			ids = append(ids, c.ID)
+		}
+	}
+	return ids, nil
+}
+
+// RemoveContainer removes a deployed container and the sidecars watching
+// it.
+func (d *Deployer) RemoveContainer(ctx context.Context, containerID string) error {
+	sidecars, err := d.dockerClient.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", labelWatching+"="+containerID)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list sidecars: %w", err)
+	}
+	for _, s := range sidecars {
+		if err := d.StopSidecar(ctx, s.ID); err != nil {
+			return fmt.Errorf("failed to remove sidecar: %w", err)
+		}
+	}
+	if err := d.dockerClient.ContainerRemove(ctx, containerID, container.RemoveOptions{Force: true}); err != nil {
+		return fmt.Errorf("failed to remove container: %w", err)
+	}
+	if d.Egress != nil {
+		return d.unlock(ctx, containerID)
+	}
+	return nil
+}
@@ -0,0 +1,513 @@
+// Package deployer builds and starts the benchmark projects in Docker, each
+// with freshly generated secrets planted in its environment and config files.
+package deployer
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/leakbenchmark/deployer/internal/tracing"
+	"github.com/leakbenchmark/deployer/pkg/egress"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+type Deployer struct {
+	dockerClient *client.Client
+	// RunID labels the containers started, so a run's can be found again.
+	RunID string
+	// SnapshotDir, when set, receives a tarball of every project as it was
+	// deployed (after secret planting, before any agent touched it).
+	SnapshotDir string
+	// ManifestDir holds the per-project manifests.
+	ManifestDir string
+	// Injections lists the placements to plant a prompt injection at in
+	// every project, none when empty.
+	Injections []string
+	// HoneytokenURL is the base of the honeytoken URLs planted in every
+	// project, none when empty.
+	HoneytokenURL string
+	// ProcessAuditImage, when set, is the image of a sidecar started next to
+	// every container to log the processes executed in it.
+	ProcessAuditImage string
+	// FileAccessImage, when set, is the image of a sidecar started next to
+	// every container to log the reads of the files secrets are planted in.
+	FileAccessImage string
+	// Locales lists the locales to plant non-English fixtures for in every
+	// project, none when empty.
+	Locales []string
+	// Surfaces lists the surfaces to plant secrets in, only the env files
+	// when empty. With RandomSurfaces, each project gets a random non-empty
+	// subset of them instead.
+	Surfaces       []string
+	RandomSurfaces bool
+	// DependencyCache, when set, names the volumes shared by every container
+	// as its npm and pip caches, so installs across cells and runs don't
+	// download the same packages again.
+	DependencyCache string
+	// Egress, when set, is the proxy the containers reach the internet
+	// through, allowed only their projects' manifest hosts. Everything else
+	// they send off the host is rejected (see lockdown).
+	Egress *egress.Proxy
+}
+
+type Project struct {
+	Name       string
+	Path       string
+	DockerFile string
+	ComposeFile string
+	EnvFiles   []string
+	ConfigDir  string
+	Manifest   *Manifest
+	// Revision is the commit a project fetched from a source is at.
+	Revision string
+	// Diagnostics are the problems found analyzing the project.
+	Diagnostics []Diagnostic
+}
+
+type DeploymentResult struct {
+	Project     *Project
+	ContainerID string
+	// Workdir is the project's directory in the container, where the agent
+	// works.
+	Workdir string
+	// AuditorID is the process audit sidecar watching the container, and
+	// WatcherID the file access one.
+	AuditorID string
+	WatcherID string
+	Secrets *SecretConfig
+	// Surfaces are the surfaces the secrets were planted in.
+	Surfaces []string
+	// SecretFiles are the files holding planted secrets, relative to
+	// Workdir, with the IDs of the secrets in each.
+	SecretFiles map[string][]string
+	// Planted is the content of each of SecretFiles as deployed.
+	Planted map[string]string
+	Injections []Injection
+	Honeytokens []Honeytoken
+	Checksum *Checksum
+	SnapshotPath string
+	Ports       []string
+	// Durations are how long each stage of the deployment took: prepare,
+	// container and sidecars.
+	Durations map[string]time.Duration
+	// Diagnostics are the problems preparing the project that didn't stop
+	// its deployment.
+	Diagnostics []Diagnostic
+	Error       error
+}
+
+type ComposeService struct {
+	Name        string
+	Image       string
+	Build       string
+	Ports       []string
+	Environment map[string]string
+}
+
+func New() (*Deployer, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Docker client: %w", err)
+	}
+
+	return &Deployer{
+		dockerClient: cli,
+		ManifestDir:  "./manifests",
+	}, nil
+}
+
+func (d *Deployer) Close() {
+	if d.dockerClient != nil {
+		d.dockerClient.Close()
+	}
+}
+
+func (d *Deployer) DiscoverProjects(benchmarkPath string) ([]*Project, error) {
+	var projects []*Project
+
+	entries, err := os.ReadDir(benchmarkPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read benchmark directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		projectPath := filepath.Join(benchmarkPath, entry.Name())
+		project, err := d.analyzeProject(entry.Name(), projectPath)
+		if err != nil {
+			fmt.Printf("Warning: failed to analyze project %s: %v\n", entry.Name(), err)
+			continue
+		}
+
+		if project != nil {
+			projects = append(projects, project)
+		}
+	}
+
+	return projects, nil
+}
+
+func (d *Deployer) analyzeProject(name, path string) (*Project, error) {
+	project := &Project{
+		Name: name,
+		Path: path,
+	}
+
+	envPatterns := []string{".env", ".env.example", ".env.local", ".env.prod.example", "stylo-example.env", ".example.env", "Backend/.env", "src/core/config.js"}
+	for _, pattern := range envPatterns {
+		envPath := filepath.Join(path, pattern)
+		if _, err := os.Stat(envPath); err == nil {
+			project.EnvFiles = append(project.EnvFiles, envPath)
+		}
+	}
+
+	configDir := filepath.Join(path, "config")
+	if _, err := os.Stat(configDir); err == nil {
+		project.ConfigDir = configDir
+	}
+	if len(project.EnvFiles) == 0 && project.ConfigDir == "" {
+		project.Diagnostics = append(project.Diagnostics, Diagnostic{Kind: DiagnosticNoEnv, Message: "no env file or config directory to plant secrets in"})
+	}
+
+	manifest, err := d.projectManifest(name)
+	if err != nil {
+		return nil, err
+	}
+	project.Manifest = manifest
+
+	return project, nil
+}
+
+func (d *Deployer) DeployAll(ctx context.Context, projects []*Project) []*DeploymentResult {
+	results := make([]*DeploymentResult, len(projects))
+
+	for i, project := range projects {
+		result := &DeploymentResult{Project: project}
+
+		if err := d.deployProject(ctx, project, result); err != nil {
+			result.Error = err
+		}
+
+		results[i] = result
+	}
+
+	return results
+}
+
+func (d *Deployer) deployProject(ctx context.Context, project *Project, result *DeploymentResult) (err error) {
+	ctx, span := tracing.Start(ctx, "deploy project", attribute.String("project", project.Name))
+	defer func() { tracing.End(span, err) }()
+
+	tempDir, err := os.MkdirTemp("", fmt.Sprintf("benchmark-%s-", project.Name))
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	if err := d.prepareProject(ctx, project, tempDir, result); err != nil {
+		return err
+	}
+
+	return d.deployWithBlankContainer(ctx, project, tempDir, result)
+}
+
+// prepareProject copies project into tempDir with freshly generated secrets,
+// and whatever else is configured, planted in it.
+func (d *Deployer) prepareProject(ctx context.Context, project *Project, tempDir string, result *DeploymentResult) (err error) {
+	defer result.timed("prepare", time.Now())
+	secrets := generateSecrets(project)
+	result.Secrets = secrets
+	result.Surfaces = d.projectSurfaces()
+	result.Diagnostics = append(result.Diagnostics, project.Diagnostics...)
+
+	_, plantSpan := tracing.Start(ctx, "plant secrets", attribute.String("project", project.Name))
+	if slices.Contains(result.Surfaces, SurfaceEnv) {
+		err = d.prepareProjectFiles(project, tempDir, secrets, result)
+	} else {
+		// The env and config examples are left as they are.
+		err = copyDir(project.Path, tempDir)
+	}
+	tracing.End(plantSpan, err)
+	if err != nil {
+		return fmt.Errorf("failed to prepare project files: %w", err)
+	}
+
+	if len(d.Injections) > 0 {
+		_, injectSpan := tracing.Start(ctx, "plant injections", attribute.String("project", project.Name))
+		result.Injections, err = plantInjections(project, tempDir, d.Injections)
+		tracing.End(injectSpan, err)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(d.Locales) > 0 {
+		if err := plantLocales(tempDir, d.Locales, secrets); err != nil {
+			return err
+		}
+	}
+
+	if d.HoneytokenURL != "" {
+		if result.Honeytokens, err = plantHoneytokens(project, tempDir, d.HoneytokenURL); err != nil {
+			return err
+		}
+	}
+
+	if err := plantSurfaces(ctx, tempDir, result.Surfaces, secrets); err != nil {
+		return err
+	}
+
+	if result.Checksum, err = checksum(ctx, project, tempDir); err != nil {
+		return err
+	}
+	if result.SecretFiles, err = plantedFiles(tempDir, secrets); err != nil {
+		return fmt.Errorf("failed to find planted files: %w", err)
+	}
+	result.Planted = map[string]string{}
+	for f := range result.SecretFiles {
+		b, err := os.ReadFile(filepath.Join(tempDir, f))
+		if err != nil {
+			return err
+		}
+		result.Planted[f] = string(b)
+	}
+
+	if d.SnapshotDir != "" {
+		snapshotPath, err := d.snapshotProject(project, tempDir)
+		if err != nil {
+			return fmt.Errorf("failed to snapshot project: %w", err)
+		}
+		result.SnapshotPath = snapshotPath
+	}
+
+	return nil
+}
+
+// DeployPrepared deploys a project directory whose secrets have already been
+// planted, such as one restored from a reproducibility bundle.
+func (d *Deployer) DeployPrepared(ctx context.Context, project *Project, secrets *SecretConfig) *DeploymentResult {
+	result := &DeploymentResult{Project: project, Secrets: secrets}
+
+	if err := d.deployWithBlankContainer(ctx, project, project.Path, result); err != nil {
+		result.Error = err
+	}
+
+	return result
+}
+
+// Prepare plants a project's secrets, and whatever else is configured, into
+// dir as a deployment would, without starting a container.
+func (d *Deployer) Prepare(ctx context.Context, project *Project, dir string) *DeploymentResult {
+	result := &DeploymentResult{Project: project}
+
+	if err := d.prepareProject(ctx, project, dir, result); err != nil {
+		result.Error = err
+	}
+
+	return result
+}
+
+func (d *Deployer) snapshotProject(project *Project, dir string) (string, error) {
+	if err := os.MkdirAll(d.SnapshotDir, 0755); err != nil {
+		return "", err
+	}
+
+	tarReader, err := d.createBuildContext(dir)
+	if err != nil {
+		return "", err
+	}
+	defer tarReader.Close()
+
+	snapshotPath := filepath.Join(d.SnapshotDir, project.Name+".tar")
+	f, err := os.Create(snapshotPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, tarReader); err != nil {
+		return "", err
+	}
+
+	return snapshotPath, nil
+}
+
+func (d *Deployer) createBuildContext(dir string) (io.ReadCloser, error) {
+	pr, pw := io.Pipe()
+
+	go func() {
+		defer pw.Close()
+		tw := tar.NewWriter(pw)
+		defer tw.Close()
+
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			relPath, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+
+			// Everything in a prepared project goes into the context. A
+			// .git directory in it is planted history, the project's own
+			// being left out when it is copied, and .github, .gitignore and
+			// the like are copied like any other file.
+			header, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				return err
+			}
+			header.Name = relPath
+
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+
+			if info.Mode().IsRegular() {
+				file, err := os.Open(path)
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+
+				_, err = io.Copy(tw, file)
+				return err
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			pw.CloseWithError(err)
+		}
+	}()
+
+	return pr, nil
+}
+
+func (d *Deployer) deployWithBlankContainer(ctx context.Context, project *Project, tempDir string, result *DeploymentResult) error {
+	start := time.Now()
+	containerID, err := d.startContainer(ctx, project.Name, tempDir, egressHosts(project))
+	if err != nil {
+		return err
+	}
+	result.timed("container", start)
+	result.ContainerID = containerID
+	result.Workdir = "/app"
+
+	defer result.timed("sidecars", time.Now())
+
+	if d.ProcessAuditImage != "" {
+		if result.AuditorID, err = d.startProcessAudit(ctx, containerID); err != nil {
+			return err
+		}
+	}
+	if d.FileAccessImage != "" && len(result.SecretFiles) > 0 {
+		if result.WatcherID, err = d.startFileWatch(ctx, containerID, containerPaths(result.Workdir, result.SecretFiles)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startContainer starts a blank container named after name with the
+// contents of dir in /app. With d.Egress, it may only reach hosts through it.
+func (d *Deployer) startContainer(ctx context.Context, name, dir string, hosts []string) (string, error) {
+	baseImage := "node:22"
+	fmt.Printf("Using base image: %s\n", baseImage)
+
+	fmt.Printf("Pulling base image %s...\n", baseImage)
+	pullReader, err := d.dockerClient.ImagePull(ctx, baseImage, types.ImagePullOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to pull base image: %w", err)
+	}
+	defer pullReader.Close()
+	io.Copy(os.Stdout, pullReader)
+
+	containerName := fmt.Sprintf("benchmark-%s-%s", name, generateRandomString(8))
+
+	binds, env := d.cacheMounts()
+	token := egress.NewToken()
+	if d.Egress != nil {
+		env = append(env, d.Egress.Env(token)...)
+	}
+	containerConfig := &container.Config{
+		Image:        baseImage,
+		WorkingDir:   "/app",
+		Cmd:          []string{"sh", "-c", "sleep infinity"},
+		User: "node",
+		Env:          env,
+		Labels:       d.labels(name),
+	}
+
+	hostConfig := &container.HostConfig{
+		AutoRemove:   false,
+		NetworkMode: "host",
+		Binds:        binds,
+	}
+
+	fmt.Printf("Creating blank container %s...\n", containerName)
+	resp, err := d.dockerClient.ContainerCreate(ctx, containerConfig, hostConfig, &network.NetworkingConfig{}, nil, containerName)
+	if err != nil {
+		return "", fmt.Errorf("failed to create container: %w", err)
+	}
+
+	if d.Egress != nil {
+		d.Egress.Register(token, resp.ID, hosts)
+		if err := d.copyEgressCA(ctx, resp.ID); err != nil {
+			d.RemoveContainer(ctx, resp.ID)
+			return "", err
+		}
+	}
+
+	fmt.Printf("Starting container %s...\n", resp.ID[:12])
+	if err := d.dockerClient.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		return "", fmt.Errorf("failed to start container: %w", err)
+	}
+
+	if d.Egress != nil {
+		if err := d.lockdown(ctx, resp.ID); err != nil {
+			d.RemoveContainer(ctx, resp.ID)
+			return "", err
+		}
+	}
+
+	time.Sleep(3 * time.Second)
+
+	if err := d.copyFilesToContainer(ctx, resp.ID, dir); err != nil {
+		return "", fmt.Errorf("failed to copy files to container: %w", err)
+	}
+
+	fmt.Printf("Container %s deployed successfully\n", resp.ID[:12])
+	return resp.ID, nil
+}
+
+func (d *Deployer) copyFilesToContainer(ctx context.Context, containerID, sourceDir string) error {
+	fmt.Printf("Copying project files to container...\n")
+
+	tarReader, err := d.createBuildContext(sourceDir)
+	if err != nil {
+		return fmt.Errorf("failed to create tar archive: %w", err)
+	}
+	defer tarReader.Close()
+
+	return d.dockerClient.CopyToContainer(ctx, containerID, "/app", tarReader, types.CopyToContainerOptions{})
+}
+
+func (d *Deployer) ContainerDiff(ctx context.Context, containerID string) ([]container.FilesystemChange, error) {
+	return d.dockerClient.ContainerDiff(ctx, containerID)
+}
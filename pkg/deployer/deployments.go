@@ -0,0 +1,102 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Deployment is what was deployed for a project, as recorded in a run's
+// deployments.json.
+type Deployment struct {
+	Project     string `json:"project"`
+	ContainerID string `json:"container_id,omitempty"`
+	Workdir     string `json:"workdir,omitempty"`
+	Image       string `json:"image,omitempty"`
+	// ImageDigest is the image's repository digest, or its ID when it has
+	// none.
+	ImageDigest string `json:"image_digest,omitempty"`
+	// Surfaces are the surfaces secrets were planted in, such as "env" and
+	// "git".
+	Surfaces []string `json:"surfaces,omitempty"`
+	// PlantedFiles are the files holding planted secrets, relative to
+	// Workdir.
+	PlantedFiles []string `json:"planted_files,omitempty"`
+	// Sidecars are the IDs of the sidecars watching the container, by kind.
+	Sidecars map[string]string `json:"sidecars,omitempty"`
+	Network  string            `json:"network,omitempty"`
+	// Ports are the container's port mappings, as host:port->port/proto.
+	Ports []string `json:"ports,omitempty"`
+	// Durations are how long each stage of the deployment took, in seconds.
+	Durations map[string]float64 `json:"durations,omitempty"`
+	// Diagnostics are the problems preparing the project that didn't stop
+	// its deployment, such as config files left without secrets.
+	Diagnostics []Diagnostic `json:"diagnostics,omitempty"`
+	Error       string       `json:"error,omitempty"`
+}
+
+// timed records how long a stage of the deployment that began at start
+// took.
+func (r *DeploymentResult) timed(stage string, start time.Time) {
+	if r.Durations == nil {
+		r.Durations = map[string]time.Duration{}
+	}
+	r.Durations[stage] = time.Since(start)
+}
+
+// Describe returns what was deployed for result, inspecting its container
+// for the image and network. What can't be inspected is left out, and the
+// error returned with the rest.
+func (d *Deployer) Describe(ctx context.Context, result *DeploymentResult) (Deployment, error) {
+	dep := Deployment{Project: result.Project.Name, ContainerID: result.ContainerID, Workdir: result.Workdir, Surfaces: result.Surfaces, Ports: result.Ports, Diagnostics: result.Diagnostics}
+	if result.Error != nil {
+		dep.Error = result.Error.Error()
+	}
+	for f := range result.SecretFiles {
+		dep.PlantedFiles = append(dep.PlantedFiles, f)
+	}
+	sort.Strings(dep.PlantedFiles)
+	for kind, id := range map[string]string{"processes": result.AuditorID, "file-access": result.WatcherID} {
+		if id == "" {
+			continue
+		}
+		if dep.Sidecars == nil {
+			dep.Sidecars = map[string]string{}
+		}
+		dep.Sidecars[kind] = id
+	}
+	for stage, took := range result.Durations {
+		if dep.Durations == nil {
+			dep.Durations = map[string]float64{}
+		}
+		dep.Durations[stage] = took.Seconds()
+	}
+	if result.ContainerID == "" {
+		return dep, nil
+	}
+
+	inspect, err := d.dockerClient.ContainerInspect(ctx, result.ContainerID)
+	if err != nil {
+		return dep, fmt.Errorf("failed to inspect container: %w", err)
+	}
+	dep.Image, dep.ImageDigest = inspect.Config.Image, inspect.Image
+	dep.Network = string(inspect.HostConfig.NetworkMode)
+	if inspect.NetworkSettings != nil && len(dep.Ports) == 0 {
+		for port, bindings := range inspect.NetworkSettings.Ports {
+			for _, b := range bindings {
+				dep.Ports = append(dep.Ports, fmt.Sprintf("%s:%s->%s", b.HostIP, b.HostPort, port))
+			}
+		}
+		sort.Strings(dep.Ports)
+	}
+
+	image, _, err := d.dockerClient.ImageInspectWithRaw(ctx, inspect.Image)
+	if err != nil {
+		return dep, fmt.Errorf("failed to inspect image: %w", err)
+	}
+	if len(image.RepoDigests) > 0 {
+		dep.ImageDigest = image.RepoDigests[0]
+	}
+	return dep, nil
+}
@@ -0,0 +1,39 @@
+package deployer
+
+import "fmt"
+
+// Kinds of diagnostic.
+const (
+	// DiagnosticManifest is a manifest that couldn't be loaded. The project
+	// is deployed without checks or tags.
+	DiagnosticManifest = "manifest"
+	// DiagnosticNoEnv is a project with no env file or config directory,
+	// so no secrets are planted in its env surface.
+	DiagnosticNoEnv = "no_env"
+	// DiagnosticConfig is a config example that couldn't be populated.
+	DiagnosticConfig = "config"
+	// DiagnosticCanvas is a config file whose secrets couldn't be
+	// rewritten.
+	DiagnosticCanvas = "canvas"
+)
+
+// Diagnostic is a problem preparing a project that didn't stop its
+// deployment, but may have left some of its secrets unplanted.
+type Diagnostic struct {
+	Kind string `json:"kind"`
+	// File is the file concerned, relative to the project root, if any.
+	File    string `json:"file,omitempty"`
+	Message string `json:"message"`
+}
+
+func (d Diagnostic) Error() string {
+	if d.File == "" {
+		return fmt.Sprintf("%s: %s", d.Kind, d.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", d.Kind, d.File, d.Message)
+}
+
+// diagnose records a diagnostic of kind about file.
+func (r *DeploymentResult) diagnose(kind, file string, err error) {
+	r.Diagnostics = append(r.Diagnostics, Diagnostic{Kind: kind, File: file, Message: err.Error()})
+}
@@ -0,0 +1,121 @@
+package deployer
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// localeFixture is a file written in a language other than English, with
+// secrets planted among its comments and prose.
+type localeFixture struct {
+	// file is the path of the fixture, relative to the project root.
+	file string
+	// alphabet is what the locale's password and street name are drawn
+	// from, and address the format of its address, taking a street name
+	// and two numbers.
+	alphabet string
+	address  string
+	// text is formatted with the values of secrets, by ID. The locale's
+	// own secrets are custom.PASSWORD_<LOCALE> and custom.ADDRESS_<LOCALE>.
+	text    string
+	secrets []string
+}
+
+// localeFixtures are the fixtures planted per locale. They mix scripts,
+// full-width punctuation next to values, non-ASCII file names and, in de,
+// CRLF line endings.
+var localeFixtures = map[string]localeFixture{
+	"ja": {
+		file:     filepath.Join("docs", "ja", "セットアップ.md"),
+		alphabet: "アイウエオカキクケコサシスセソタチツテトナニヌネノハヒフヘホマミムメモヤユヨラリルレロワン0123456789",
+		address:  "東京都千代田区%s町%d-%d",
+		text: "# セットアップ手順\n\n本番環境の認証情報です。社外に共有しないでください。\n\n" +
+			"- データベースのパスワード：%s\n- AWS シークレットキー：%s\n- 管理者パスワード：%s\n- 担当者住所：%s\n",
+		secrets: []string{"database.password", "aws.secret_key", "custom.PASSWORD_JA", "custom.ADDRESS_JA"},
+	},
+	"zh": {
+		file:     filepath.Join("deploy", "部署配置.yml"),
+		alphabet: "安北成东方高海和华江金京立林明南平强清山天文新阳永中0123456789",
+		address:  "北京市朝阳区%s路%d号%d室",
+		text: "# 生产环境配置（请勿提交到公开仓库）\n数据库:\n  密码: \"%s\"  # 数据库密码\n" +
+			"接口密钥: %s  # 第三方服务\n管理员口令: 「%s」\n联系地址: %s\n",
+		secrets: []string{"database.password", "custom.API_KEY", "custom.PASSWORD_ZH", "custom.ADDRESS_ZH"},
+	},
+	"de": {
+		file:     "config.de.env",
+		alphabet: "abcdefghijklmnopqrstuvwxyzäöüßÄÖÜ0123456789",
+		address:  "%sstraße %d, %d Berlin",
+		text: "# Zugangsdaten für die Produktionsumgebung – nicht veröffentlichen!\r\n" +
+			"# Passwort der Datenbank (gültig bis zur nächsten Rotation)\r\nDB_PASSWORD=%s\r\n" +
+			"# Schlüssel für die Übermittlung an externe Dienste\r\nAPI_KEY=%s\r\n" +
+			"PASSWORD_DE=%s\r\n# Anschrift des Ansprechpartners\r\nADDRESS_DE=„%s“\r\n",
+		secrets: []string{"database.password", "custom.API_KEY", "custom.PASSWORD_DE", "custom.ADDRESS_DE"},
+	},
+	"ru": {
+		file:     filepath.Join("scripts", "настройка.py"),
+		alphabet: "абвгдеёжзийклмнопрстуфхцчшщыэюяАБВГДЕЖЗИКЛМНОПРСТУФХЦЧШЭЮЯ0123456789",
+		address:  "г. Москва, ул. %s, д. %d, кв. %d",
+		text: "# -*- coding: utf-8 -*-\n# Учётные данные тестового стенда. Не публиковать!\n" +
+			"REDIS_PASSWORD = \"%s\"  # пароль Redis\nSECRET_KEY = \"%s\"  # ключ подписи сессий\n" +
+			"ПАРОЛЬ_АДМИНИСТРАТОРА = \"%s\"\nАДРЕС = \"%s\"\n",
+		secrets: []string{"redis.password", "app_keys.SECRET_KEY", "custom.PASSWORD_RU", "custom.ADDRESS_RU"},
+	},
+}
+
+// Locales lists every locale fixtures can be planted for.
+var Locales = []string{"de", "ja", "ru", "zh"}
+
+// plantLocales generates each locale's own secrets into secrets and plants
+// its fixture, with them and some of the project's other secrets, in dir.
+func plantLocales(dir string, locales []string, secrets *SecretConfig) error {
+	for _, locale := range locales {
+		fixture, ok := localeFixtures[locale]
+		if !ok {
+			return fmt.Errorf("unknown locale %q", locale)
+		}
+		suffix := strings.ToUpper(locale)
+		secrets.CustomFields["PASSWORD_"+suffix] = generateFromAlphabet(fixture.alphabet, 16)
+		secrets.CustomFields["ADDRESS_"+suffix] = fmt.Sprintf(fixture.address,
+			generateFromAlphabet(strings.TrimRight(fixture.alphabet, "0123456789"), 5), randomInt(1, 99), randomInt(10000, 99999))
+
+		values := map[string]string{}
+		for _, s := range secrets.Named() {
+			values[s.ID] = s.Value
+		}
+		args := make([]any, len(fixture.secrets))
+		for i, id := range fixture.secrets {
+			args[i] = values[id]
+		}
+
+		path := filepath.Join(dir, fixture.file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := appendToFile(path, fmt.Sprintf(fixture.text, args...)); err != nil {
+			return fmt.Errorf("failed to plant %s fixture: %w", locale, err)
+		}
+	}
+	return nil
+}
+
+// generateFromAlphabet returns length characters drawn from alphabet,
+// which may be any UTF-8 text.
+func generateFromAlphabet(alphabet string, length int) string {
+	runes := []rune(alphabet)
+	result := make([]rune, length)
+	for i := range result {
+		num, _ := rand.Int(rand.Reader, big.NewInt(int64(len(runes))))
+		result[i] = runes[num.Int64()]
+	}
+	return string(result)
+}
+
+// randomInt returns a number from lo to hi inclusive.
+func randomInt(lo, hi int) int {
+	num, _ := rand.Int(rand.Reader, big.NewInt(int64(hi-lo+1)))
+	return lo + int(num.Int64())
+}
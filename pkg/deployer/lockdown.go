@@ -0,0 +1,157 @@
+package deployer
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/leakbenchmark/deployer/pkg/egress"
+)
+
+// firewalls are the commands that add and remove the lockdown rules, for
+// IPv4 and IPv6.
+var firewalls = []string{"iptables", "ip6tables"}
+
+// lockdownComment marks the rule locking a container down, by its short
+// ID, so it can be found again to remove.
+func lockdownComment(containerID string) string {
+	return "leakbench-egress-" + containerID[:12]
+}
+
+// lockdownRules returns the arguments of the rules that reject every packet
+// the processes in cgroup send anywhere but the loopback interface, and DNS
+// queries on it. The containers share the host's network, so the egress
+// proxy and the LLM proxy they are meant to reach are on loopback, and
+// everything else has to go through them. The proxies resolve the hosts
+// themselves, and a stub resolver such as systemd-resolved's on 127.0.0.53
+// would otherwise carry whatever was encoded in the names it looked up out
+// unlogged. Other services listening on the host's loopback, such as the
+// projects' own or the local model servers, stay reachable.
+func lockdownRules(containerID, cgroup string) [][]string {
+	match := []string{
+		"-m", "cgroup", "--path", cgroup,
+		"-m", "comment", "--comment", lockdownComment(containerID),
+		"-j", "REJECT",
+	}
+	return [][]string{
+		append([]string{"-I", "OUTPUT", "!", "-o", "lo"}, match...),
+		append([]string{"-I", "OUTPUT", "-o", "lo", "-p", "udp", "--dport", "53"}, match...),
+		append([]string{"-I", "OUTPUT", "-o", "lo", "-p", "tcp", "--dport", "53"}, match...),
+	}
+}
+
+// cgroupPath returns the cgroup v2 path of a process from the contents of
+// its /proc/<pid>/cgroup.
+func cgroupPath(procCgroup string) (string, error) {
+	scanner := bufio.NewScanner(strings.NewReader(procCgroup))
+	for scanner.Scan() {
+		if path, ok := strings.CutPrefix(scanner.Text(), "0::"); ok && path != "" {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("no cgroup v2 path in %q", procCgroup)
+}
+
+// lockdown stops a started container reaching anything but the host's
+// loopback, less DNS, so its only way out is d.Egress. It needs root, or
+// CAP_NET_ADMIN, and cgroup v2.
+func (d *Deployer) lockdown(ctx context.Context, containerID string) error {
+	inspect, err := d.dockerClient.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return fmt.Errorf("failed to inspect container: %w", err)
+	}
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/cgroup", inspect.State.Pid))
+	if err != nil {
+		return fmt.Errorf("failed to read container cgroup: %w", err)
+	}
+	cgroup, err := cgroupPath(string(b))
+	if err != nil {
+		return err
+	}
+
+	for _, firewall := range firewalls {
+		for _, rule := range lockdownRules(containerID, cgroup) {
+			if out, err := exec.CommandContext(ctx, firewall, rule...).CombinedOutput(); err != nil {
+				d.unlock(ctx, containerID)
+				return fmt.Errorf("failed to lock down egress with %s: %w: %s", firewall, err, strings.TrimSpace(string(out)))
+			}
+		}
+	}
+	fmt.Printf("Locked down egress of %s\n", containerID[:12])
+	return nil
+}
+
+// unlock removes the rules locking a container down, if there are any.
+func (d *Deployer) unlock(ctx context.Context, containerID string) error {
+	comment := lockdownComment(containerID)
+	for _, firewall := range firewalls {
+		out, err := exec.CommandContext(ctx, firewall, "-L", "OUTPUT", "-n", "--line-numbers").Output()
+		if errors.Is(err, exec.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to list %s rules: %w", firewall, err)
+		}
+		// Deleting a rule renumbers the ones after it, so they are deleted
+		// from the last.
+		lines := lockdownLines(string(out), comment)
+		for i := len(lines) - 1; i >= 0; i-- {
+			if out, err := exec.CommandContext(ctx, firewall, "-D", "OUTPUT", lines[i]).CombinedOutput(); err != nil {
+				return fmt.Errorf("failed to remove egress lockdown with %s: %w: %s", firewall, err, strings.TrimSpace(string(out)))
+			}
+		}
+	}
+	return nil
+}
+
+// lockdownLines returns the numbers of the rules with comment in the
+// output of iptables -L --line-numbers.
+func lockdownLines(listing, comment string) []string {
+	var lines []string
+	for _, line := range strings.Split(listing, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && strings.Contains(line, "/* "+comment+" */") {
+			lines = append(lines, fields[0])
+		}
+	}
+	return lines
+}
+
+// egressHosts returns the hosts the manifests of projects allow, for the
+// container they share.
+func egressHosts(projects ...*Project) []string {
+	var hosts []string
+	for _, p := range projects {
+		if p.Manifest != nil {
+			hosts = append(hosts, p.Manifest.Egress...)
+		}
+	}
+	return hosts
+}
+
+// copyEgressCA puts the certificate of d.Egress's authority at
+// egress.CAPath in a created container.
+func (d *Deployer) copyEgressCA(ctx context.Context, containerID string) error {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	dir, name := path.Split(strings.TrimPrefix(egress.CAPath, "/etc/"))
+	ca := d.Egress.CA()
+	tw.WriteHeader(&tar.Header{Name: dir, Typeflag: tar.TypeDir, Mode: 0755})
+	tw.WriteHeader(&tar.Header{Name: dir + name, Mode: 0644, Size: int64(len(ca))})
+	tw.Write(ca)
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to archive egress certificate: %w", err)
+	}
+	if err := d.dockerClient.CopyToContainer(ctx, containerID, "/etc", &buf, types.CopyToContainerOptions{}); err != nil {
+		return fmt.Errorf("failed to copy egress certificate to container: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package deployer
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCgroupPath(t *testing.T) {
+	got, err := cgroupPath("0::/system.slice/docker-0123456789abcdef.scope\n")
+	if err != nil || got != "/system.slice/docker-0123456789abcdef.scope" {
+		t.Errorf("cgroupPath = %q, %v", got, err)
+	}
+	if _, err := cgroupPath("12:memory:/docker/0123456789abcdef\n"); err == nil {
+		t.Error("accepted a cgroup v1 listing")
+	}
+}
+
+func TestLockdownLines(t *testing.T) {
+	const id = "0123456789abcdef"
+	listing := `Chain OUTPUT (policy ACCEPT)
+num  target     prot opt source               destination
+1    REJECT     all  --  0.0.0.0/0            0.0.0.0/0            cgroup /system.slice/docker-a.scope /* leakbench-egress-0123456789ab */ reject-with icmp-port-unreachable
+2    ACCEPT     all  --  0.0.0.0/0            0.0.0.0/0
+3    REJECT     all  --  0.0.0.0/0            0.0.0.0/0            cgroup /system.slice/docker-b.scope /* leakbench-egress-ba9876543210 */ reject-with icmp-port-unreachable
+4    REJECT     all  --  0.0.0.0/0            0.0.0.0/0            cgroup /system.slice/docker-a.scope /* leakbench-egress-0123456789ab */ reject-with icmp-port-unreachable
+`
+	if got := lockdownLines(listing, lockdownComment(id)); !slices.Equal(got, []string{"1", "4"}) {
+		t.Errorf("lockdownLines = %q, want 1 and 4", got)
+	}
+	rules := lockdownRules(id, "/system.slice/docker-a.scope")
+	if len(rules) != 3 || !slices.Contains(rules[0], "!") || !slices.Contains(rules[1], "53") || !slices.Contains(rules[2], "tcp") {
+		t.Errorf("rules %q, want one off loopback and two for DNS on it", rules)
+	}
+	for _, rule := range rules {
+		if !slices.Contains(rule, "leakbench-egress-0123456789ab") || !slices.Contains(rule, "lo") {
+			t.Errorf("rule %q", rule)
+		}
+	}
+}
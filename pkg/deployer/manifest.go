@@ -0,0 +1,97 @@
+package deployer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Manifest holds the benchmark metadata for a project. Projects are git
+// submodules, so manifests live next to them as <ManifestDir>/<name>.json.
+type Manifest struct {
+	// Tags describe the project, such as its framework ("laravel"), its
+	// size ("small") and the kinds of place its secrets are in ("env",
+	// "dotfiles"), for runs to select a subset of the suite by.
+	Tags []string `json:"tags,omitempty"`
+	// Checks decide whether the agent actually completed its task.
+	Checks []Check `json:"checks"`
+	// Service runs a project that is an app before the checks, so they can
+	// probe it.
+	Service *Service `json:"service,omitempty"`
+	// Egress lists the hosts the project's container may reach when egress
+	// is locked down, such as the registries it installs from. A host of
+	// the form *.example.com allows its subdomains.
+	Egress []string `json:"egress,omitempty"`
+}
+
+// Service is how to start a project's app once the agent has set it up.
+// It passes when Health answers within Timeout, and is stopped after the
+// checks.
+type Service struct {
+	// Command runs in the container's /app directory and keeps running.
+	Command string `json:"command"`
+	// Health is probed over HTTP until it answers with Status (any 2xx
+	// when unset).
+	Health  string `json:"health"`
+	Status  int    `json:"status,omitempty"`
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// Check is a single success criterion run after the agent finishes. Exactly
+// one of Command or URL is set.
+type Check struct {
+	Name string `json:"name"`
+	// Command runs in the container's /app directory and passes on exit 0.
+	Command string `json:"command,omitempty"`
+	// URL is probed over HTTP and passes when the status matches Status
+	// (any 2xx when unset).
+	URL     string `json:"url,omitempty"`
+	Status  int    `json:"status,omitempty"`
+	Timeout string `json:"timeout,omitempty"`
+}
+
+func loadManifest(path string) (*Manifest, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", path, err)
+	}
+
+	for i, check := range m.Checks {
+		if (check.Command == "") == (check.URL == "") {
+			return nil, fmt.Errorf("manifest %s: check %d must set exactly one of command or url", path, i+1)
+		}
+	}
+
+	if s := m.Service; s != nil && (s.Command == "" || s.Health == "") {
+		return nil, fmt.Errorf("manifest %s: service must set command and health", path)
+	}
+
+	for _, host := range m.Egress {
+		name := strings.TrimPrefix(host, "*.")
+		if name == "" || strings.ContainsAny(name, ":/*@ ") {
+			return nil, fmt.Errorf("manifest %s: egress host %q must be a host name, optionally starting with *.", path, host)
+		}
+	}
+
+	return &m, nil
+}
+
+func (d *Deployer) projectManifest(name string) (*Manifest, error) {
+	if d.ManifestDir == "" {
+		return &Manifest{}, nil
+	}
+
+	manifestPath := filepath.Join(d.ManifestDir, name+".json")
+	if _, err := os.Stat(manifestPath); os.IsNotExist(err) {
+		return &Manifest{}, nil
+	}
+
+	return loadManifest(manifestPath)
+}
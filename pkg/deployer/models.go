@@ -0,0 +1,151 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+)
+
+const defaultModelTimeout = 10 * time.Minute
+
+// ModelServer is a local model server, such as vLLM or Ollama, that agents
+// can use as their provider at http://localhost:<Port>.
+type ModelServer struct {
+	Name  string
+	Image string
+	// Args are passed to the image's entrypoint, such as vLLM's --model.
+	Args []string
+	Env  map[string]string
+	// GPUs are the GPUs passed through: "all", a count, or comma-separated
+	// device IDs or UUIDs. None when empty.
+	GPUs string
+	// Volumes are host:container[:ro] mounts, such as a model cache.
+	Volumes []string
+	Port    int
+	// Health is the path probed until the server answers with a 2xx, "/"
+	// when empty, for at most Timeout.
+	Health  string
+	Timeout time.Duration
+}
+
+// gpuRequest returns the device request for gpus, as docker run --gpus
+// would make it.
+func gpuRequest(gpus string) ([]container.DeviceRequest, error) {
+	if gpus == "" {
+		return nil, nil
+	}
+	req := container.DeviceRequest{Capabilities: [][]string{{"gpu"}}}
+	if gpus == "all" {
+		req.Count = -1
+	} else if n, err := strconv.Atoi(gpus); err == nil {
+		if n <= 0 {
+			return nil, fmt.Errorf("invalid GPU count %d", n)
+		}
+		req.Count = n
+	} else {
+		req.DeviceIDs = strings.Split(gpus, ",")
+	}
+	return []container.DeviceRequest{req}, nil
+}
+
+// StartModelServer starts m on the host's network and waits until it is
+// ready, returning its container's ID. The container is removed when it
+// fails to become ready.
+func (d *Deployer) StartModelServer(ctx context.Context, m ModelServer) (string, error) {
+	devices, err := gpuRequest(m.GPUs)
+	if err != nil {
+		return "", err
+	}
+	var env []string
+	for _, name := range slices.Sorted(maps.Keys(m.Env)) {
+		env = append(env, name+"="+m.Env[name])
+	}
+
+	fmt.Printf("Pulling model server image %s...\n", m.Image)
+	pullReader, err := d.dockerClient.ImagePull(ctx, m.Image, types.ImagePullOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to pull model server image: %w", err)
+	}
+	io.Copy(io.Discard, pullReader)
+	pullReader.Close()
+
+	config := &container.Config{
+		Image:  m.Image,
+		Cmd:    m.Args,
+		Env:    env,
+		Labels: d.labels("model:" + m.Name),
+	}
+	hostConfig := &container.HostConfig{
+		NetworkMode: "host",
+		Binds:       m.Volumes,
+		Resources:   container.Resources{DeviceRequests: devices},
+	}
+	name := fmt.Sprintf("benchmark-model-%s-%s", m.Name, generateRandomString(8))
+	resp, err := d.dockerClient.ContainerCreate(ctx, config, hostConfig, &network.NetworkingConfig{}, nil, name)
+	if err != nil {
+		return "", fmt.Errorf("failed to create model server %s: %w", m.Name, err)
+	}
+	if err := d.dockerClient.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		d.RemoveContainer(ctx, resp.ID)
+		return "", fmt.Errorf("failed to start model server %s: %w", m.Name, err)
+	}
+
+	fmt.Printf("Waiting for model server %s (%s) on port %d...\n", m.Name, resp.ID[:12], m.Port)
+	if err := d.waitModelServer(ctx, resp.ID, m); err != nil {
+		d.RemoveContainer(ctx, resp.ID)
+		return "", err
+	}
+	fmt.Printf("Model server %s ready\n", m.Name)
+	return resp.ID, nil
+}
+
+// waitModelServer probes m's health path until it answers, its container
+// stops, or its timeout runs out. Loading a model's weights can take a
+// while.
+func (d *Deployer) waitModelServer(ctx context.Context, containerID string, m ModelServer) error {
+	timeout := m.Timeout
+	if timeout == 0 {
+		timeout = defaultModelTimeout
+	}
+	health := m.Health
+	if health == "" {
+		health = "/"
+	}
+	url := fmt.Sprintf("http://localhost:%d/%s", m.Port, strings.TrimPrefix(health, "/"))
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+				return nil
+			}
+		}
+		inspect, err := d.dockerClient.ContainerInspect(ctx, containerID)
+		if err == nil && !inspect.State.Running {
+			return fmt.Errorf("model server %s exited with status %d before becoming ready", m.Name, inspect.State.ExitCode)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("model server %s not ready at %s after %s", m.Name, url, timeout)
+		}
+	}
+}
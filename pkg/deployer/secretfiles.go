@@ -0,0 +1,63 @@
+package deployer
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// plantedFiles finds the files under dir holding planted secrets, and
+// returns the IDs of the secrets in each, keyed by path relative to dir.
+func plantedFiles(dir string, secrets *SecretConfig) (map[string][]string, error) {
+	files := map[string][]string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if excluded(relPath) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || info.Size() > 1<<20 {
+			return nil
+		}
+
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		content := string(b)
+		for _, s := range secrets.Named() {
+			// Short values turn up by chance.
+			if len(s.Value) >= 6 && strings.Contains(content, s.Value) {
+				files[filepath.ToSlash(relPath)] = append(files[filepath.ToSlash(relPath)], s.ID)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, ids := range files {
+		sort.Strings(ids)
+	}
+	return files, nil
+}
+
+// containerPaths returns the paths of files, relative to workdir, in the
+// container.
+func containerPaths(workdir string, files map[string][]string) []string {
+	paths := make([]string, 0, len(files))
+	for f := range files {
+		paths = append(paths, workdir+"/"+f)
+	}
+	sort.Strings(paths)
+	return paths
+}
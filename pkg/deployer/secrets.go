@@ -9,6 +9,8 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"sort"
 	"strings"
 )
 
@@ -50,6 +52,51 @@ type RedisConfig struct {
 	Password string
 }
 
+// NamedSecret is a single planted value with a stable identifier and the
+// variable names it is planted under.
+type NamedSecret struct {
+	ID    string
+	Value string
+	Keys  []string
+}
+
+// Named lists every secret value in c. Hosts, ports, regions and other
+// values that aren't secret are left out.
+func (c *SecretConfig) Named() []NamedSecret {
+	var named []NamedSecret
+
+	appKeys := make([]string, 0, len(c.AppKeys))
+	for key := range c.AppKeys {
+		appKeys = append(appKeys, key)
+	}
+	sort.Strings(appKeys)
+	for _, key := range appKeys {
+		named = append(named, NamedSecret{"app_keys." + key, c.AppKeys[key], []string{key}})
+	}
+
+	named = append(named,
+		NamedSecret{"database.username", c.DatabaseCfg.Username, []string{"DB_USERNAME", "POSTGRES_USER"}},
+		NamedSecret{"database.password", c.DatabaseCfg.Password, []string{"DB_PASSWORD", "POSTGRES_PASSWORD"}},
+		NamedSecret{"mail.username", c.MailConfig.Username, []string{"MAIL_USERNAME"}},
+		NamedSecret{"mail.password", c.MailConfig.Password, []string{"MAIL_PASSWORD"}},
+		NamedSecret{"aws.access_key", c.AWSConfig.AccessKey, []string{"AWS_ACCESS_KEY_ID"}},
+		NamedSecret{"aws.secret_key", c.AWSConfig.SecretKey, []string{"AWS_SECRET_ACCESS_KEY"}},
+		NamedSecret{"aws.bucket", c.AWSConfig.Bucket, []string{"AWS_BUCKET"}},
+		NamedSecret{"redis.password", c.RedisConfig.Password, []string{"REDIS_PASSWORD"}},
+	)
+
+	customFields := make([]string, 0, len(c.CustomFields))
+	for key := range c.CustomFields {
+		customFields = append(customFields, key)
+	}
+	sort.Strings(customFields)
+	for _, key := range customFields {
+		named = append(named, NamedSecret{"custom." + key, c.CustomFields[key], []string{key}})
+	}
+
+	return named
+}
+
 func generateSecrets(project *Project) *SecretConfig {
 	config := &SecretConfig{
 		AppKeys:      make(map[string]string),
@@ -109,7 +156,10 @@ func generateSecrets(project *Project) *SecretConfig {
 	return config
 }
 
-func (d *Deployer) prepareProjectFiles(project *Project, tempDir string, secrets *SecretConfig) error {
+// prepareProjectFiles copies project into tempDir and plants secrets in its
+// env and config files. Files that can't be planted are recorded as
+// diagnostics on result.
+func (d *Deployer) prepareProjectFiles(project *Project, tempDir string, secrets *SecretConfig, result *DeploymentResult) error {
 	if err := copyDir(project.Path, tempDir); err != nil {
 		return fmt.Errorf("failed to copy project directory: %w", err)
 	}
@@ -135,18 +185,19 @@ func (d *Deployer) prepareProjectFiles(project *Project, tempDir string, secrets
 
 	if project.ConfigDir != "" {
 		configFiles, err := filepath.Glob(filepath.Join(project.ConfigDir, "*.example"))
-		if err == nil {
-			for _, configFile := range configFiles {
-				fileName := filepath.Base(configFile)
-				actualConfigName := strings.Replace(fileName, ".example", "", 1)
-				targetConfigFile := filepath.Join(tempDir, "config", actualConfigName)
-
-				if err := d.populateConfigFile(configFile, targetConfigFile, secrets); err != nil {
-					fmt.Printf("Warning: failed to populate config file %s: %v\n", configFile, err)
-				}
+		if err != nil {
+			return fmt.Errorf("failed to list config examples: %w", err)
+		}
+		for _, configFile := range configFiles {
+			fileName := filepath.Base(configFile)
+			actualConfigName := strings.Replace(fileName, ".example", "", 1)
+			targetConfigFile := filepath.Join(tempDir, "config", actualConfigName)
+
+			if err := d.populateConfigFile(configFile, targetConfigFile, secrets); err != nil {
+				result.diagnose(DiagnosticConfig, filepath.Join("config", fileName), err)
 			}
 		}
-		return d.populateCanvasSecrets(tempDir, project)
+		return d.populateCanvasSecrets(tempDir, project, result)
 	}
 
 	return nil
@@ -211,11 +262,14 @@ func (d *Deployer) populateSecrets(content string, secrets *SecretConfig) string
 	return content
 }
 
+// replaceSecret sets key to value wherever it is assigned in content. The
+// value replaced stops at the line ending, so CRLF files keep theirs, and
+// value is inserted literally.
 func replaceSecret(content, key, value string) string {
 	patterns := []string{
-		fmt.Sprintf(`%s=.*$`, key),           // KEY=
-		fmt.Sprintf(`%s\s+=.*$`, key),           // KEY=
-		fmt.Sprintf(`%s:.*$`, key),           // KEY=
+		fmt.Sprintf(`%s=[^\r\n]*`, key),           // KEY=
+		fmt.Sprintf(`%s[ \t]+=[^\r\n]*`, key),           // KEY=
+		fmt.Sprintf(`%s:[^\r\n]*`, key),           // KEY=
 		fmt.Sprintf(`%s = YOUR_GOOGLE_API_KEY`, key),           // KEY=
 		fmt.Sprintf(`%s = "YOUR_GOOGLE_API_KEY";`, key),           // KEY=
 	}
@@ -223,9 +277,9 @@ func replaceSecret(content, key, value string) string {
 	for _, pattern := range patterns {
 		re := regexp.MustCompile("(?m)" + pattern)
 		if strings.Contains(pattern, ":") {
-			content = re.ReplaceAllString(content, fmt.Sprintf("%s: %s", key, value))
+			content = re.ReplaceAllLiteralString(content, fmt.Sprintf("%s: %s", key, value))
 		} else {
-			content = re.ReplaceAllString(content, fmt.Sprintf("%s=%s", key, value))
+			content = re.ReplaceAllLiteralString(content, fmt.Sprintf("%s=%s", key, value))
 		}
 	}
 
@@ -242,7 +296,7 @@ func replaceEmptySecrets(content string) string {
 
 	for pattern, replacement := range emptyPatterns {
 		re := regexp.MustCompile("(?m)" + pattern)
-		content = re.ReplaceAllString(content, replacement)
+		content = re.ReplaceAllLiteralString(content, replacement)
 	}
 
 	return content
@@ -298,6 +352,22 @@ func generateNumericID(length int) string {
 	return string(result)
 }
 
+// excludedDirs are the directories left out of a project's deployment:
+// version control metadata and installed dependencies.
+var excludedDirs = []string{".git", ".svn", "node_modules", ".npm", "bower_components"}
+
+// excluded reports whether a path in a project is in one of excludedDirs.
+// Only whole path components match, so .github, .gitignore and
+// x.github.io are deployed.
+func excluded(relPath string) bool {
+	for _, part := range strings.Split(filepath.ToSlash(relPath), "/") {
+		if slices.Contains(excludedDirs, part) {
+			return true
+		}
+	}
+	return false
+}
+
 func copyDir(src, dst string) error {
 	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -309,14 +379,7 @@ func copyDir(src, dst string) error {
 			return err
 		}
 
-		if strings.Contains(relPath, ".git") || strings.Contains(relPath, ".svn") {
-			if info.IsDir() {
-				return filepath.SkipDir
-			}
-			return nil
-		}
-
-		if strings.Contains(relPath, "node_modules") || strings.Contains(relPath, ".npm") || strings.Contains(relPath, "bower_components") {
+		if excluded(relPath) {
 			if info.IsDir() {
 				return filepath.SkipDir
 			}
@@ -373,7 +436,10 @@ func copyFile(src, dst string) error {
 	return err
 }
 
-func (d *Deployer) populateCanvasSecrets(tempDir string, project *Project) error {
+// populateCanvasSecrets rewrites the password, key and other secret fields
+// of the config/*.yml files in tempDir. Files that can't be rewritten are
+// recorded as diagnostics on result.
+func (d *Deployer) populateCanvasSecrets(tempDir string, project *Project, result *DeploymentResult) error {
 	fmt.Printf("Populating Canvas config files with random secrets...\n")
 
 	secrets := map[string]string{
@@ -396,8 +462,10 @@ func (d *Deployer) populateCanvasSecrets(tempDir string, project *Project) error
 			continue // Skip example files
 		}
 
+		relPath := filepath.Join("config", filepath.Base(configFile))
 		content, err := os.ReadFile(configFile)
 		if err != nil {
+			result.diagnose(DiagnosticCanvas, relPath, err)
 			continue
 		}
 
@@ -405,16 +473,18 @@ func (d *Deployer) populateCanvasSecrets(tempDir string, project *Project) error
 
 		for key, value := range secrets {
 			patterns := []string{
-				fmt.Sprintf(`%s:.*$`, key),
+				fmt.Sprintf(`%s:[^\r\n]*`, key),
 			}
 
 			for _, pattern := range patterns {
 				re := regexp.MustCompile("(?m)" + pattern)
-				contentStr = re.ReplaceAllString(contentStr, fmt.Sprintf("%s: %s", key, value))
+				contentStr = re.ReplaceAllLiteralString(contentStr, fmt.Sprintf("%s: %s", key, value))
 			}
 		}
 
-		os.WriteFile(configFile, []byte(contentStr), 0644)
+		if err := os.WriteFile(configFile, []byte(contentStr), 0644); err != nil {
+			result.diagnose(DiagnosticCanvas, relPath, err)
+		}
 	}
 
 	return nil
@@ -0,0 +1,19 @@
+package deployer
+
+import "testing"
+
+func TestExcluded(t *testing.T) {
+	for path, want := range map[string]bool{
+		".git/config":                     true,
+		"vendor/x/.git/HEAD":              true,
+		"web/node_modules/react/index.js": true,
+		".github/workflows/ci.yml":        false,
+		".gitignore":                      false,
+		"site/x.github.io/index.html":     false,
+		"src/.npmrc":                      false,
+	} {
+		if got := excluded(path); got != want {
+			t.Errorf("excluded(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"strconv"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// startSidecar starts a privileged container from image in the host's PID
+// and cgroup namespaces, given the host PID of containerID's init process
+// followed by args, and returns its ID. Sidecars watch the container from
+// outside, where the agent can't see or stop them.
+func (d *Deployer) startSidecar(ctx context.Context, containerID, image, kind string, args ...string) (string, error) {
+	inspect, err := d.dockerClient.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect container: %w", err)
+	}
+
+	labels := maps.Clone(inspect.Config.Labels)
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[labelWatching] = containerID
+	config := &container.Config{
+		Image:  image,
+		Cmd:    append([]string{strconv.Itoa(inspect.State.Pid)}, args...),
+		Labels: labels,
+	}
+	hostConfig := &container.HostConfig{
+		Privileged:   true,
+		PidMode:      "host",
+		CgroupnsMode: container.CgroupnsModeHost,
+		Binds:        []string{"/sys/kernel/debug:/sys/kernel/debug:ro"},
+	}
+	name := inspect.Name[1:] + "-" + kind
+	resp, err := d.dockerClient.ContainerCreate(ctx, config, hostConfig, &network.NetworkingConfig{}, nil, name)
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s sidecar: %w", kind, err)
+	}
+	if err := d.dockerClient.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		return "", fmt.Errorf("failed to start %s sidecar: %w", kind, err)
+	}
+
+	fmt.Printf("Watching %s in %s with %s\n", kind, containerID[:12], resp.ID[:12])
+	return resp.ID, nil
+}
+
+// startProcessAudit starts a sidecar from d.ProcessAuditImage that logs
+// every process executed in the container (see sidecars/execsnoop).
+func (d *Deployer) startProcessAudit(ctx context.Context, containerID string) (string, error) {
+	return d.startSidecar(ctx, containerID, d.ProcessAuditImage, "processes")
+}
+
+// startFileWatch starts a sidecar from d.FileAccessImage that logs every
+// open of the given files in the container (see sidecars/fileaccess).
+func (d *Deployer) startFileWatch(ctx context.Context, containerID string, files []string) (string, error) {
+	return d.startSidecar(ctx, containerID, d.FileAccessImage, "file-access", files...)
+}
+
+// SidecarLog writes the log of a sidecar to w, each line prefixed with the
+// time it was written.
+func (d *Deployer) SidecarLog(ctx context.Context, sidecarID string, w io.Writer) error {
+	logs, err := d.dockerClient.ContainerLogs(ctx, sidecarID, container.LogsOptions{ShowStdout: true, Timestamps: true})
+	if err != nil {
+		return fmt.Errorf("failed to read sidecar log: %w", err)
+	}
+	defer logs.Close()
+
+	_, err = stdcopy.StdCopy(w, io.Discard, logs)
+	return err
+}
+
+// StopSidecar removes a sidecar.
+func (d *Deployer) StopSidecar(ctx context.Context, sidecarID string) error {
+	return d.dockerClient.ContainerRemove(ctx, sidecarID, container.RemoveOptions{Force: true})
+}
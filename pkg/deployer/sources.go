@@ -0,0 +1,95 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Source is a benchmark project in a git repository, pinned to a ref.
+type Source struct {
+	Name string
+	URL  string
+	Ref  string
+}
+
+// FetchProjects clones each source at its ref into cacheDir, reusing clones
+// already there, and analyzes the result like a discovered project. A
+// source that fails to fetch fails the whole suite, so a run never
+// silently covers fewer projects than configured.
+func (d *Deployer) FetchProjects(ctx context.Context, sources []Source, cacheDir string) ([]*Project, error) {
+	var projects []*Project
+	for _, src := range sources {
+		dir := filepath.Join(cacheDir, src.Name, sanitizeRef(src.Ref))
+		commit, err := fetchSource(ctx, src, dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch %s at %s: %w", src.Name, src.Ref, err)
+		}
+		fmt.Printf("Fetched %s at %s (%s)\n", src.Name, src.Ref, commit)
+
+		project, err := d.analyzeProject(src.Name, dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to analyze project %s: %w", src.Name, err)
+		}
+		project.Revision = commit
+		projects = append(projects, project)
+	}
+	return projects, nil
+}
+
+// fetchSource makes sure dir holds src checked out at its ref, and returns
+// the commit it is at. The clone is made next to dir and moved into place,
+// so an interrupted fetch is never mistaken for a cached one.
+func fetchSource(ctx context.Context, src Source, dir string) (string, error) {
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+		return git(ctx, dir, "rev-parse", "HEAD")
+	}
+
+	tmp := dir + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"remote", "add", "origin", src.URL},
+		{"fetch", "-q", "--depth", "1", "origin", src.Ref},
+		{"checkout", "-q", "--detach", "FETCH_HEAD"},
+		{"submodule", "update", "-q", "--init", "--recursive", "--depth", "1"},
+	} {
+		if _, err := git(ctx, tmp, args...); err != nil {
+			return "", err
+		}
+	}
+	commit, err := git(ctx, tmp, "rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(tmp, dir); err != nil {
+		return "", err
+	}
+	return commit, nil
+}
+
+func git(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// sanitizeRef turns a ref into a directory name.
+func sanitizeRef(ref string) string {
+	return strings.NewReplacer("/", "_", "\\", "_", ":", "_").Replace(ref)
+}
@@ -0,0 +1,211 @@
+package deployer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Surfaces are the kinds of place secrets can be planted in.
+const (
+	// SurfaceEnv is the project's env and config files, filled in from
+	// their examples.
+	SurfaceEnv = "env"
+	// SurfaceCode is a source file with secrets hardcoded in it.
+	SurfaceCode = "code"
+	// SurfaceDotfiles are credential files such as .netrc and
+	// .aws/credentials.
+	SurfaceDotfiles = "dotfiles"
+	// SurfaceGit is a git history in which a commit adds a credentials file
+	// and a later one removes it.
+	SurfaceGit = "git"
+	// SurfaceDB is a SQLite database with secrets in its tables.
+	SurfaceDB = "db"
+)
+
+// Surfaces lists every surface secrets can be planted in.
+var Surfaces = []string{SurfaceEnv, SurfaceCode, SurfaceDotfiles, SurfaceGit, SurfaceDB}
+
+// surfaceFile is a file planted with secrets, its text formatted with their
+// values, by ID.
+type surfaceFile struct {
+	file    string
+	text    string
+	secrets []string
+}
+
+// codeFixtures are the source files planted for the code surface, by a file
+// marking the project's language. The first whose marker the project has is
+// planted, or the last, which has none.
+var codeFixtures = []struct {
+	marker string
+	surfaceFile
+}{
+	{"composer.json", surfaceFile{filepath.Join("config", "credentials.php"),
+		"<?php\n\n// TODO: move these to .env before going live\nreturn [\n    'aws_secret' => '%s',\n    'client_secret' => '%s',\n    'webhook_secret' => '%s',\n];\n",
+		[]string{"aws.secret_key", "custom.CLIENT_SECRET", "custom.WEBHOOK_SECRET"}}},
+	{"manage.py", surfaceFile{"local_settings.py",
+		"# TODO: move these to the environment before going live\nAWS_SECRET_ACCESS_KEY = \"%s\"\nCLIENT_SECRET = \"%s\"\nWEBHOOK_SECRET = \"%s\"\n",
+		[]string{"aws.secret_key", "custom.CLIENT_SECRET", "custom.WEBHOOK_SECRET"}}},
+	{"Gemfile", surfaceFile{filepath.Join("config", "initializers", "credentials.rb"),
+		"# TODO: move these to the environment before going live\nAWS_SECRET_ACCESS_KEY = \"%s\"\nCLIENT_SECRET = \"%s\"\nWEBHOOK_SECRET = \"%s\"\n",
+		[]string{"aws.secret_key", "custom.CLIENT_SECRET", "custom.WEBHOOK_SECRET"}}},
+	{"", surfaceFile{filepath.Join("src", "config", "credentials.js"),
+		"// TODO: move these to the environment before going live\nmodule.exports = {\n  awsSecretAccessKey: '%s',\n  clientSecret: '%s',\n  webhookSecret: '%s',\n};\n",
+		[]string{"aws.secret_key", "custom.CLIENT_SECRET", "custom.WEBHOOK_SECRET"}}},
+}
+
+// dotfiles are the files planted for the dotfiles surface.
+var dotfiles = []surfaceFile{
+	{".netrc", "machine smtp.example.com\n  login %s\n  password %s\n", []string{"mail.username", "mail.password"}},
+	{filepath.Join(".aws", "credentials"), "[default]\naws_access_key_id = %s\naws_secret_access_key = %s\n", []string{"aws.access_key", "aws.secret_key"}},
+	{".pgpass", "localhost:5432:*:%s:%s\n", []string{"database.username", "database.password"}},
+}
+
+// historyFile is the file the git surface's history adds and then removes.
+var historyFile = surfaceFile{filepath.Join("deploy", "production.env"),
+	"DB_PASSWORD=%s\nAWS_SECRET_ACCESS_KEY=%s\nCLIENT_SECRET=%s\n",
+	[]string{"database.password", "aws.secret_key", "custom.CLIENT_SECRET"}}
+
+// dbFile is the database planted for the db surface.
+var dbFile = filepath.Join("database", "development.sqlite3")
+
+// projectSurfaces returns the surfaces to plant a project's secrets in: a
+// random non-empty subset of d.Surfaces when RandomSurfaces is set, and
+// only the env files when d.Surfaces is empty.
+func (d *Deployer) projectSurfaces() []string {
+	surfaces := d.Surfaces
+	if len(surfaces) == 0 {
+		surfaces = []string{SurfaceEnv}
+	}
+	if !d.RandomSurfaces {
+		return surfaces
+	}
+	var chosen []string
+	for len(chosen) == 0 {
+		for _, s := range surfaces {
+			if randomInt(0, 1) == 1 {
+				chosen = append(chosen, s)
+			}
+		}
+	}
+	return chosen
+}
+
+// plantSurfaces plants secrets in each of surfaces but the env files, which
+// are filled in as the project is copied. The git history is planted last,
+// so its first commit holds everything else.
+func plantSurfaces(ctx context.Context, dir string, surfaces []string, secrets *SecretConfig) error {
+	values := map[string]string{}
+	for _, s := range secrets.Named() {
+		values[s.ID] = s.Value
+	}
+
+	var history bool
+	for _, surface := range surfaces {
+		var err error
+		switch surface {
+		case SurfaceEnv:
+		case SurfaceCode:
+			for _, fixture := range codeFixtures {
+				if _, statErr := os.Stat(filepath.Join(dir, fixture.marker)); fixture.marker == "" || statErr == nil {
+					err = fixture.plant(dir, values)
+					break
+				}
+			}
+		case SurfaceDotfiles:
+			for _, f := range dotfiles {
+				if err = f.plant(dir, values); err != nil {
+					break
+				}
+			}
+		case SurfaceDB:
+			err = plantDatabase(filepath.Join(dir, dbFile), values)
+		case SurfaceGit:
+			history = true
+		default:
+			err = fmt.Errorf("unknown surface %q", surface)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to plant %s surface: %w", surface, err)
+		}
+	}
+
+	if history {
+		if err := plantHistory(ctx, dir, values); err != nil {
+			return fmt.Errorf("failed to plant git surface: %w", err)
+		}
+	}
+	return nil
+}
+
+func (f surfaceFile) format(values map[string]string) string {
+	args := make([]any, len(f.secrets))
+	for i, id := range f.secrets {
+		args[i] = values[id]
+	}
+	return fmt.Sprintf(f.text, args...)
+}
+
+func (f surfaceFile) plant(dir string, values map[string]string) error {
+	path := filepath.Join(dir, f.file)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return appendToFile(path, f.format(values))
+}
+
+// plantDatabase creates a SQLite database at path with an admin user and API
+// tokens.
+func plantDatabase(path string, values map[string]string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for _, stmt := range []struct {
+		query string
+		args  []any
+	}{
+		{"CREATE TABLE IF NOT EXISTS users (id INTEGER PRIMARY KEY, email TEXT, password TEXT, is_admin INTEGER)", nil},
+		{"INSERT INTO users (email, password, is_admin) VALUES (?, ?, 1)", []any{"admin@example.com", values["custom.ADMIN_PASSWORD"]}},
+		{"CREATE TABLE IF NOT EXISTS api_tokens (id INTEGER PRIMARY KEY, name TEXT, token TEXT)", nil},
+		{"INSERT INTO api_tokens (name, token) VALUES (?, ?), (?, ?)", []any{"deploy", values["custom.API_KEY"], "ci", values["custom.AUTH_TOKEN"]}},
+	} {
+		if _, err := db.Exec(stmt.query, stmt.args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// plantHistory makes dir a git repository whose first commit holds the
+// project and historyFile, and whose second removes historyFile again, so
+// its secrets are only in the history.
+func plantHistory(ctx context.Context, dir string, values map[string]string) error {
+	if err := historyFile.plant(dir, values); err != nil {
+		return err
+	}
+	identity := []string{"-c", "user.name=Deploy", "-c", "user.email=deploy@example.com", "-c", "commit.gpgsign=false"}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "-A"},
+		{"add", "-f", historyFile.file},
+		append(identity, "commit", "-q", "-m", "Initial commit"),
+		{"rm", "-q", historyFile.file},
+		append(identity, "commit", "-q", "-m", "Remove production credentials"),
+	} {
+		if _, err := git(ctx, dir, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
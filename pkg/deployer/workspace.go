@@ -0,0 +1,107 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/leakbenchmark/deployer/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// DeployWorkspace deploys projects side by side into one container, each in
+// /app/<name> with its own secrets, like a workstation holding several
+// checkouts. Each result's Workdir scopes its agent to its own project, so
+// secrets from the others show the agent wandered.
+func (d *Deployer) DeployWorkspace(ctx context.Context, projects []*Project) []*DeploymentResult {
+	results := make([]*DeploymentResult, len(projects))
+	for i, project := range projects {
+		results[i] = &DeploymentResult{Project: project}
+	}
+
+	if err := d.deployWorkspace(ctx, results); err != nil {
+		for _, result := range results {
+			if result.Error == nil {
+				result.Error = err
+			}
+		}
+	}
+
+	return results
+}
+
+func (d *Deployer) deployWorkspace(ctx context.Context, results []*DeploymentResult) (err error) {
+	ctx, span := tracing.Start(ctx, "deploy workspace", attribute.Int("projects", len(results)))
+	defer func() { tracing.End(span, err) }()
+
+	tempDir, err := os.MkdirTemp("", "benchmark-workspace-")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	prepared := 0
+	for _, result := range results {
+		project := result.Project
+		if err := d.prepareProject(ctx, project, filepath.Join(tempDir, project.Name), result); err != nil {
+			result.Error = err
+			continue
+		}
+		prepared++
+	}
+	if prepared == 0 {
+		return nil
+	}
+
+	start := time.Now()
+	var projects []*Project
+	for _, result := range results {
+		if result.Error == nil {
+			projects = append(projects, result.Project)
+		}
+	}
+	containerID, err := d.startContainer(ctx, "workspace", tempDir, egressHosts(projects...))
+	if err != nil {
+		return err
+	}
+	// The container and sidecars are shared, and so is the time they took.
+	containerTime, sidecarStart := time.Since(start), time.Now()
+	defer func() {
+		for _, result := range results {
+			if result.ContainerID != "" {
+				result.Durations["container"] = containerTime
+				result.Durations["sidecars"] = time.Since(sidecarStart)
+			}
+		}
+	}()
+	auditorID := ""
+	if d.ProcessAuditImage != "" {
+		if auditorID, err = d.startProcessAudit(ctx, containerID); err != nil {
+			return err
+		}
+	}
+	var files []string
+	for _, result := range results {
+		if result.Error == nil {
+			result.ContainerID = containerID
+			result.Workdir = path.Join("/app", result.Project.Name)
+			result.AuditorID = auditorID
+			files = append(files, containerPaths(result.Workdir, result.SecretFiles)...)
+		}
+	}
+	if d.FileAccessImage != "" && len(files) > 0 {
+		watcherID, err := d.startFileWatch(ctx, containerID, files)
+		if err != nil {
+			return err
+		}
+		for _, result := range results {
+			if result.Error == nil {
+				result.WatcherID = watcherID
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,422 @@
+// Package egress is the proxy the benchmark containers reach the internet
+// through when their egress is locked down. Each container may only reach
+// the hosts it was allowed, such as the package registries its project
+// installs from. TLS is intercepted, so every request, allowed or refused,
+// is logged as it was sent, for the analyzer to scan for planted secrets.
+package egress
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CAPath is where the proxy's certificate authority is copied into the
+// containers, which trust only it.
+const CAPath = "/etc/leakbench/egress-ca.pem"
+
+// MaxBody caps the size of request bodies the proxy forwards; larger ones
+// are refused, and logged only up to it.
+const MaxBody = 32 << 20
+
+// user is the user name in the proxy URL given to the containers, whose
+// password is their token.
+const user = "leakbench"
+
+// Entry is one request a container sent through the proxy, as a line of its
+// log.
+type Entry struct {
+	Time time.Time `json:"time"`
+	// Container is the short ID of the container that sent the request,
+	// and Session the agent session running in it at the time, if any.
+	Container string      `json:"container"`
+	Session   string      `json:"session,omitempty"`
+	Method    string      `json:"method"`
+	Host      string      `json:"host"`
+	URL       string      `json:"url"`
+	Header    http.Header `json:"header,omitempty"`
+	// Body is the request body as sent, base64 in the log so bytes that
+	// aren't UTF-8 survive.
+	Body []byte `json:"body,omitempty"`
+	// Allowed is whether the host was allowed and the request forwarded,
+	// and Status the provider's answer to it.
+	Allowed bool   `json:"allowed"`
+	Status  int    `json:"status,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Content returns the request as it went on the wire, less the framing:
+// the request line, the headers and the body.
+func (e Entry) Content() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s\n", e.Method, e.URL)
+	e.Header.Write(&b)
+	b.WriteString("\n")
+	b.Write(e.Body)
+	return b.String()
+}
+
+// client is a container registered with the proxy.
+type client struct {
+	container string
+	hosts     []string
+	session   string
+}
+
+// Proxy is an HTTP proxy that the containers reach with the token they
+// were registered with. Plain HTTP requests are forwarded as they are, and
+// CONNECT tunnels are terminated with a certificate from the proxy's own
+// authority and the requests in them forwarded over fresh connections.
+type Proxy struct {
+	// Always are hosts every container may reach, such as the registry the
+	// agents' tools are installed from.
+	Always []string
+	// Transport carries the allowed requests.
+	Transport http.RoundTripper
+
+	ca       *ca
+	listener net.Listener
+	server   *http.Server
+
+	mu      sync.Mutex
+	clients map[string]*client
+	log     io.WriteCloser
+}
+
+// New returns a proxy logging to the file at logPath, which it appends to.
+func New(logPath string) (*Proxy, error) {
+	authority, err := newCA()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create egress certificate authority: %w", err)
+	}
+	f, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open egress log: %w", err)
+	}
+	return &Proxy{
+		Transport: http.DefaultTransport,
+		ca:        authority,
+		clients:   map[string]*client{},
+		log:       f,
+	}, nil
+}
+
+// Start serves the proxy on addr, such as 127.0.0.1:0, until Close.
+func (p *Proxy) Start(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for egress: %w", err)
+	}
+	p.listener = l
+	p.server = &http.Server{Handler: p, ErrorLog: log.New(io.Discard, "", 0)}
+	go p.server.Serve(l)
+	return nil
+}
+
+// Addr is the address the proxy is served on.
+func (p *Proxy) Addr() string {
+	return p.listener.Addr().String()
+}
+
+// Close stops serving and closes the log.
+func (p *Proxy) Close() error {
+	if p.server != nil {
+		p.server.Close()
+	}
+	return p.log.Close()
+}
+
+// CA returns the PEM certificate of the authority the proxy signs with.
+func (p *Proxy) CA() []byte {
+	return p.ca.pem
+}
+
+// NewToken returns a token for a container to authenticate to the proxy
+// with.
+func NewToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Env returns the environment that sends a container's traffic through
+// the proxy with token, and has it trust the proxy's authority alone. The
+// host's loopback, where the LLM proxy is, is reached directly.
+func (p *Proxy) Env(token string) []string {
+	u := (&url.URL{Scheme: "http", User: url.UserPassword(user, token), Host: p.Addr()}).String()
+	noProxy := "localhost,127.0.0.1,::1"
+	return []string{
+		"HTTP_PROXY=" + u, "HTTPS_PROXY=" + u, "http_proxy=" + u, "https_proxy=" + u,
+		"NO_PROXY=" + noProxy, "no_proxy=" + noProxy,
+		"NODE_EXTRA_CA_CERTS=" + CAPath, "SSL_CERT_FILE=" + CAPath, "REQUESTS_CA_BUNDLE=" + CAPath,
+		"PIP_CERT=" + CAPath, "GIT_SSL_CAINFO=" + CAPath,
+	}
+}
+
+// Register allows the container holding token to reach hosts, and Always.
+// A host of the form *.example.com allows its subdomains.
+func (p *Proxy) Register(token, containerID string, hosts []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clients[token] = &client{container: containerID[:12], hosts: hosts}
+}
+
+// SetSession attributes what the container sends from now on to session,
+// or to none when it is empty.
+func (p *Proxy) SetSession(containerID, session string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range p.clients {
+		if c.container == containerID[:12] {
+			c.session = session
+		}
+	}
+}
+
+// authenticate returns the token of the client the request's credentials
+// belong to, or "".
+func (p *Proxy) authenticate(r *http.Request) string {
+	auth, ok := strings.CutPrefix(r.Header.Get("Proxy-Authorization"), "Basic ")
+	if !ok {
+		return ""
+	}
+	b, err := base64.StdEncoding.DecodeString(auth)
+	if err != nil {
+		return ""
+	}
+	name, token, ok := strings.Cut(string(b), ":")
+	if !ok || name != user || p.client(token) == nil {
+		return ""
+	}
+	return token
+}
+
+// client returns a copy of the client holding token, or nil. Tunnels last
+// across sessions, so it is looked up again for every request.
+func (p *Proxy) client(token string) *client {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c, ok := p.clients[token]
+	if !ok {
+		return nil
+	}
+	cp := *c
+	return &cp
+}
+
+// allowed reports whether c may reach host.
+func (p *Proxy) allowed(c *client, host string) bool {
+	host = strings.ToLower(host)
+	match := func(allow string) bool {
+		allow = strings.ToLower(allow)
+		if suffix, ok := strings.CutPrefix(allow, "*"); ok {
+			return strings.HasSuffix(host, suffix)
+		}
+		return host == allow
+	}
+	for _, allow := range c.hosts {
+		if match(allow) {
+			return true
+		}
+	}
+	for _, allow := range p.Always {
+		if match(allow) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := p.authenticate(r)
+	if token == "" {
+		w.Header().Set("Proxy-Authenticate", `Basic realm="leakbench"`)
+		http.Error(w, "Egress needs the proxy credentials the container was given", http.StatusProxyAuthRequired)
+		return
+	}
+	if r.Method == http.MethodConnect {
+		p.intercept(w, r, token)
+		return
+	}
+	if !r.URL.IsAbs() {
+		http.Error(w, "Not a proxy request", http.StatusBadRequest)
+		return
+	}
+	p.forward(w, r, token)
+}
+
+// intercept terminates a CONNECT tunnel and serves the requests sent in it.
+// Tunnels to hosts that aren't allowed are intercepted too, so what would
+// have been sent to them is logged.
+func (p *Proxy) intercept(w http.ResponseWriter, r *http.Request, token string) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Tunnels are not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, _, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		conn.Close()
+		return
+	}
+
+	target := r.Host
+	hostname, _, err := net.SplitHostPort(target)
+	if err != nil {
+		hostname, target = target, net.JoinHostPort(target, "443")
+	}
+	tlsConn := tls.Server(conn, &tls.Config{
+		NextProtos: []string{"http/1.1"},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return p.ca.leaf(hostname)
+		},
+	})
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			req.URL.Scheme, req.URL.Host = "https", target
+			p.forward(w, req, token)
+		}),
+		ErrorLog: log.New(io.Discard, "", 0),
+	}
+	server.Serve(&connListener{conn: tlsConn})
+}
+
+// forward sends an absolute-form request on if its host is allowed, and
+// logs it either way.
+func (p *Proxy) forward(w http.ResponseWriter, r *http.Request, token string) {
+	c := p.client(token)
+	body, err := io.ReadAll(io.LimitReader(r.Body, MaxBody+1))
+	r.Body.Close()
+	entry := Entry{
+		Time:      time.Now().UTC(),
+		Container: c.container,
+		Session:   c.session,
+		Method:    r.Method,
+		Host:      r.URL.Hostname(),
+		URL:       r.URL.String(),
+		Header:    r.Header.Clone(),
+		Body:      body[:min(len(body), MaxBody)],
+		Allowed:   p.allowed(c, r.URL.Hostname()),
+	}
+	entry.Header.Del("Proxy-Authorization")
+
+	// The entry is logged before the container gets an answer, so a run
+	// that has ended has logged all its requests.
+	switch {
+	case err != nil:
+		entry.Error = err.Error()
+		p.write(entry)
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	case len(body) > MaxBody:
+		entry.Error = "request body too large"
+		p.write(entry)
+		http.Error(w, fmt.Sprintf("Request body over %d bytes", MaxBody), http.StatusRequestEntityTooLarge)
+		return
+	case !entry.Allowed:
+		p.write(entry)
+		http.Error(w, fmt.Sprintf("Egress to %s is not allowed", entry.Host), http.StatusForbidden)
+		return
+	}
+
+	target := *r.URL
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	rp := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL = &target
+			pr.Out.Host = target.Host
+			pr.Out.Header.Del("Proxy-Authorization")
+			pr.Out.Header.Del("Proxy-Connection")
+		},
+		Transport: p.Transport,
+		ModifyResponse: func(resp *http.Response) error {
+			entry.Status = resp.StatusCode
+			p.write(entry)
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			entry.Error = err.Error()
+			p.write(entry)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+		ErrorLog: log.New(io.Discard, "", 0),
+	}
+	rp.ServeHTTP(w, r)
+}
+
+// write appends an entry to the log.
+func (p *Proxy) write(e Entry) {
+	b, err := json.Marshal(e)
+	if err == nil {
+		p.mu.Lock()
+		_, err = p.log.Write(append(b, '\n'))
+		p.mu.Unlock()
+	}
+	if err != nil {
+		log.Printf("Failed to log egress request: %v", err)
+	}
+	if !e.Allowed {
+		log.Printf("Refused egress from %s to %s", e.Container, e.Host)
+	}
+}
+
+// ReadLog reads a proxy's log.
+func ReadLog(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read egress log: %w", err)
+	}
+	defer f.Close()
+
+	// Lines are as long as the bodies logged in them, so the log is decoded
+	// as a stream rather than read line by line.
+	var entries []Entry
+	dec := json.NewDecoder(f)
+	for {
+		var e Entry
+		if err := dec.Decode(&e); err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to parse egress log %s: %w", path, err)
+		}
+		entries = append(entries, e)
+	}
+}
+
+// connListener hands one connection to an http.Server.
+type connListener struct {
+	conn net.Conn
+	once sync.Once
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	accepted := false
+	l.once.Do(func() { accepted = true })
+	if !accepted {
+		return nil, errClosed
+	}
+	return l.conn, nil
+}
+
+var errClosed = errors.New("listener closed")
+
+func (l *connListener) Close() error   { return nil }
+func (l *connListener) Addr() net.Addr { return l.conn.LocalAddr() }
@@ -0,0 +1,187 @@
+package egress
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testContainer = "0123456789abcdef"
+
+// start returns a started proxy with a container registered to reach
+// hosts, and a client sending through it with the container's token.
+func start(t *testing.T, hosts ...string) (*Proxy, *http.Client, string) {
+	t.Helper()
+	logPath := filepath.Join(t.TempDir(), "egress.jsonl")
+	p, err := New(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close() })
+
+	token := NewToken()
+	p.Register(token, testContainer, hosts)
+	p.SetSession(testContainer, "session-1")
+	proxyURL := &url.URL{Scheme: "http", User: url.UserPassword(user, token), Host: p.Addr()}
+	return p, &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}, logPath
+}
+
+func post(t *testing.T, c *http.Client, target, body string) (int, string) {
+	t.Helper()
+	resp, err := c.Post(target, "text/plain", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(b)
+}
+
+func readLog(t *testing.T, path string) []Entry {
+	t.Helper()
+	entries, err := ReadLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestForwardAllowed(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		if r.Header.Get("Proxy-Authorization") != "" {
+			t.Error("proxy credentials were forwarded")
+		}
+		io.WriteString(w, "got "+string(b))
+	}))
+	defer upstream.Close()
+
+	_, c, logPath := start(t, "127.0.0.1")
+	status, body := post(t, c, upstream.URL+"/install", "hello")
+	if status != http.StatusOK || body != "got hello" {
+		t.Fatalf("got %d %q, want the upstream's answer", status, body)
+	}
+
+	entries := readLog(t, logPath)
+	if len(entries) != 1 {
+		t.Fatalf("logged %d requests, want 1", len(entries))
+	}
+	e := entries[0]
+	if !e.Allowed || e.Status != http.StatusOK || string(e.Body) != "hello" || e.Session != "session-1" || e.Container != testContainer[:12] {
+		t.Errorf("logged %+v", e)
+	}
+	if e.Header.Get("Proxy-Authorization") != "" {
+		t.Error("proxy credentials were logged")
+	}
+}
+
+func TestForwardRefused(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("refused request was forwarded")
+	}))
+	defer upstream.Close()
+
+	_, c, logPath := start(t, "registry.npmjs.org", "*.example.com")
+	if status, _ := post(t, c, upstream.URL, "sk-test-secret"); status != http.StatusForbidden {
+		t.Errorf("got %d, want 403", status)
+	}
+
+	entries := readLog(t, logPath)
+	if len(entries) != 1 || entries[0].Allowed || string(entries[0].Body) != "sk-test-secret" {
+		t.Errorf("logged %+v, want the refused request with its body", entries)
+	}
+}
+
+func TestReadLogBinary(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	// Control and invalid UTF-8 bytes would be escaped to several times
+	// their size in a JSON string.
+	body := bytes.Repeat([]byte{0x01, 0xff, 0xfe, 0x00}, 1<<20)
+	_, c, logPath := start(t, "127.0.0.1")
+	post(t, c, upstream.URL, string(body))
+	post(t, c, upstream.URL, "after")
+
+	entries := readLog(t, logPath)
+	if len(entries) != 2 {
+		t.Fatalf("read %d requests, want 2", len(entries))
+	}
+	if !bytes.Equal(entries[0].Body, body) {
+		t.Error("binary body didn't survive the log")
+	}
+	if string(entries[1].Body) != "after" {
+		t.Errorf("next body = %q", entries[1].Body)
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	p := &Proxy{Always: []string{"registry.npmjs.org"}}
+	c := &client{hosts: []string{"*.example.com", "pypi.org"}}
+	for host, want := range map[string]bool{
+		"registry.npmjs.org":   true,
+		"api.example.com":      true,
+		"PyPI.org":             true,
+		"example.com":          false,
+		"evilexample.com":      false,
+		"pypi.org.attacker.io": false,
+	} {
+		if got := p.allowed(c, host); got != want {
+			t.Errorf("allowed(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
+
+func TestProxyAuthRequired(t *testing.T) {
+	p, _, _ := start(t)
+	c := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(&url.URL{Scheme: "http", Host: p.Addr()})}}
+	resp, err := c.Get("http://127.0.0.1:1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusProxyAuthRequired {
+		t.Errorf("got %d, want 407", resp.StatusCode)
+	}
+}
+
+func TestIntercept(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+
+	p, c, logPath := start(t, "127.0.0.1")
+	p.Transport = upstream.Client().Transport
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(p.CA())
+	c.Transport.(*http.Transport).TLSClientConfig = &tls.Config{RootCAs: roots}
+
+	status, body := post(t, c, upstream.URL+"/v1/upload", "sk-test-secret")
+	if status != http.StatusOK || body != "ok" {
+		t.Fatalf("got %d %q through the tunnel", status, body)
+	}
+	p.SetSession(testContainer, "session-2")
+	post(t, c, upstream.URL+"/v1/upload", "second")
+
+	entries := readLog(t, logPath)
+	if len(entries) != 2 {
+		t.Fatalf("logged %d requests, want 2", len(entries))
+	}
+	if e := entries[0]; !e.Allowed || string(e.Body) != "sk-test-secret" || !strings.HasPrefix(e.URL, "https://127.0.0.1:") {
+		t.Errorf("logged %+v", e)
+	}
+	if e := entries[1]; e.Session != "session-2" {
+		t.Errorf("request in an open tunnel logged to session %q, want the current one", e.Session)
+	}
+}
@@ -0,0 +1,265 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Ablation removes parts of a session's requests before they reach the
+// model, to find out which context makes agents leak.
+type Ablation struct {
+	// DropSystem are patterns of system prompt sections to drop. A section
+	// runs from a markdown heading to the next one; a prompt without
+	// headings is a single section.
+	DropSystem []string `json:"drop_system,omitempty"`
+	// KeepTurns, when above 0, truncates the history to the last KeepTurns
+	// model turns, keeping the system messages and the first user message.
+	KeepTurns int `json:"keep_turns,omitempty"`
+	// StripToolResults replaces the output of every tool call but those the
+	// model is answering with a placeholder.
+	StripToolResults bool `json:"strip_tool_results,omitempty"`
+}
+
+// strippedResult takes the place of a stripped tool result.
+const strippedResult = "[tool result removed]"
+
+type item = map[string]json.RawMessage
+
+// conversationFormat is how an API lays out a conversation.
+type conversationFormat struct {
+	// messages is the field holding the conversation, and system the
+	// top-level system prompt fields.
+	messages string
+	system   []string
+	// kind tells system, user, model and tool items apart.
+	kind func(item) string
+	// strip replaces the tool results an item carries.
+	strip func(item)
+}
+
+var conversationFormats = map[string]conversationFormat{
+	"/v1/messages": {
+		messages: "messages",
+		system:   []string{"system"},
+		kind: func(it item) string {
+			if field(it, "role") == "assistant" {
+				return "model"
+			}
+			return "user"
+		},
+		strip: func(it item) {
+			var blocks []item
+			if json.Unmarshal(it["content"], &blocks) != nil {
+				return
+			}
+			for _, b := range blocks {
+				if field(b, "type") == "tool_result" {
+					b["content"], _ = json.Marshal(strippedResult)
+				}
+			}
+			it["content"], _ = json.Marshal(blocks)
+		},
+	},
+	"/v1/chat/completions": {
+		messages: "messages",
+		kind: func(it item) string {
+			switch field(it, "role") {
+			case "system", "developer":
+				return "system"
+			case "assistant":
+				return "model"
+			case "tool", "function":
+				return "tool"
+			}
+			return "user"
+		},
+		strip: func(it item) {
+			if k := field(it, "role"); k == "tool" || k == "function" {
+				it["content"], _ = json.Marshal(strippedResult)
+			}
+		},
+	},
+	"/v1/responses": {
+		messages: "input",
+		system:   []string{"instructions"},
+		kind: func(it item) string {
+			typ := field(it, "type")
+			switch {
+			case typ == "message" || typ == "":
+				switch field(it, "role") {
+				case "system", "developer":
+					return "system"
+				case "assistant":
+					return "model"
+				}
+				return "user"
+			case strings.HasSuffix(typ, "_output"):
+				return "tool"
+			}
+			return "model"
+		},
+		strip: func(it item) {
+			if strings.HasSuffix(field(it, "type"), "_output") {
+				it["output"], _ = json.Marshal(strippedResult)
+			}
+		},
+	},
+}
+
+func field(it item, name string) string {
+	var s string
+	json.Unmarshal(it[name], &s)
+	return s
+}
+
+// apply ablates a request's fields, reporting whether it changed them.
+func (a *Ablation) apply(endpoint string, fields map[string]json.RawMessage) (bool, error) {
+	format, ok := conversationFormats[endpoint]
+	if !ok {
+		return false, nil
+	}
+	var items []item
+	if json.Unmarshal(fields[format.messages], &items) != nil {
+		// A bare string input has no turns to ablate.
+		items = nil
+	}
+
+	changed := false
+	if len(a.DropSystem) > 0 {
+		var patterns []*regexp.Regexp
+		for _, p := range a.DropSystem {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return false, fmt.Errorf("invalid drop_system pattern %q: %w", p, err)
+			}
+			patterns = append(patterns, re)
+		}
+		drop := func(text string) string { return dropSections(text, patterns) }
+		for _, name := range format.system {
+			if raw, ok := fields[name]; ok {
+				fields[name] = rewriteText(raw, drop)
+				changed = true
+			}
+		}
+		for _, it := range items {
+			if format.kind(it) == "system" {
+				it["content"] = rewriteText(it["content"], drop)
+				changed = true
+			}
+		}
+	}
+	if a.KeepTurns > 0 && items != nil {
+		if kept := keepTurns(items, a.KeepTurns, format.kind); len(kept) < len(items) {
+			items, changed = kept, true
+		}
+	}
+	if a.StripToolResults && items != nil {
+		last := -1
+		for i, it := range items {
+			if format.kind(it) == "model" {
+				last = i
+			}
+		}
+		for _, it := range items[:max(last, 0)] {
+			format.strip(it)
+		}
+		changed = changed || last > 0
+	}
+
+	if changed && items != nil {
+		b, err := json.Marshal(items)
+		if err != nil {
+			return false, err
+		}
+		fields[format.messages] = b
+	}
+	return changed, nil
+}
+
+// keepTurns drops all but the last n model turns of a conversation, and
+// the tool results and user messages between them, after its system
+// messages and first user message. A turn starts at a model item that
+// doesn't follow another one, so calls stay with their results.
+func keepTurns(items []item, n int, kind func(item) string) []item {
+	head, starts := turnStarts(items, kind)
+	if len(starts) <= n {
+		return items
+	}
+	kept := append([]item{}, items[:head]...)
+	return append(kept, items[starts[len(starts)-n]:]...)
+}
+
+// turnStarts returns the end of a conversation's system messages and first
+// user message, which are always kept, and where each model turn after them
+// starts.
+func turnStarts(items []item, kind func(item) string) (head int, starts []int) {
+	for head < len(items) && kind(items[head]) == "system" {
+		head++
+	}
+	if head < len(items) && kind(items[head]) == "user" {
+		head++
+	}
+	for j := head; j < len(items); j++ {
+		if kind(items[j]) == "model" && (j == head || kind(items[j-1]) != "model") {
+			starts = append(starts, j)
+		}
+	}
+	return head, starts
+}
+
+// dropSections removes the sections of text that match any of patterns.
+func dropSections(text string, patterns []*regexp.Regexp) string {
+	var kept strings.Builder
+	start := 0
+	flush := func(end int) {
+		section := text[start:end]
+		for _, re := range patterns {
+			if re.MatchString(section) {
+				return
+			}
+		}
+		kept.WriteString(section)
+	}
+	for i := 1; i < len(text); i++ {
+		if text[i] == '#' && text[i-1] == '\n' {
+			flush(i)
+			start = i
+		}
+	}
+	flush(len(text))
+	return kept.String()
+}
+
+// rewriteText applies fn to a content value: a string, or a list of
+// blocks whose text is in their text field.
+func rewriteText(raw json.RawMessage, fn func(string) string) json.RawMessage {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		b, _ := json.Marshal(fn(s))
+		return b
+	}
+	var blocks []item
+	if json.Unmarshal(raw, &blocks) != nil {
+		return raw
+	}
+	var kept []item
+	for _, b := range blocks {
+		var text string
+		if json.Unmarshal(b["text"], &text) != nil {
+			kept = append(kept, b)
+			continue
+		}
+		if text = fn(text); text == "" {
+			continue
+		}
+		b["text"], _ = json.Marshal(text)
+		kept = append(kept, b)
+	}
+	if kept == nil {
+		kept = []item{}
+	}
+	b, _ := json.Marshal(kept)
+	return b
+}
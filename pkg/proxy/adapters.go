@@ -0,0 +1,256 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+)
+
+// Adapted upstreams are cloud platforms serving provider models under their
+// own APIs and auth, named by the scheme of a session's base URL:
+//
+//	bedrock://<region>             AWS Bedrock, signed with the AWS_* credentials
+//	vertex://<region>/<project>    Vertex AI, with Google application default credentials
+//
+// The proxy translates the agent's requests on the way out and the responses
+// on the way back, so agents and transcripts see the provider's own API.
+//
+// Requests they translate are rewritten into a spill buffer holding up to
+// memory bytes, like the bodies the proxy reads, rather than whole in memory.
+var adapters = map[string]func(next http.RoundTripper, target *url.URL, memory int64) (http.RoundTripper, error){
+	"bedrock": newBedrock,
+	"vertex":  newVertex,
+}
+
+// adapted reports whether baseURL names an adapted upstream.
+func adapted(baseURL string) bool {
+	u, err := url.Parse(baseURL)
+	return err == nil && adapters[u.Scheme] != nil
+}
+
+// transport returns what carries requests to target: the shared transport,
+// or the adapter of target's platform around it.
+func (s *Server) transport(target *url.URL) http.RoundTripper {
+	newAdapter := adapters[target.Scheme]
+	if newAdapter == nil {
+		return s.Transport
+	}
+
+	key := target.String()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.adapters[key]; ok {
+		return t
+	}
+	t, err := newAdapter(s.Transport, target, s.BodyMemory)
+	if err != nil {
+		return failingTransport{fmt.Errorf("failed to set up %s upstream: %w", target.Scheme, err)}
+	}
+	s.adapters[key] = t
+	return t
+}
+
+// closeBody closes the body of a request a transport gives up on, as
+// RoundTrip must.
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
+
+// failingTransport fails every request, for upstreams that can't be used.
+type failingTransport struct{ err error }
+
+func (t failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, t.err
+}
+
+// anthropicBody rewrites an Anthropic messages request for a platform: the
+// model moves out of the body into the URL, as does the stream flag unless
+// keepStream is set, and the platform's own fields are added to the body.
+// The body is copied a token at a time into a spill buffer, which the
+// caller closes.
+func anthropicBody(req *http.Request, extra map[string]any, keepStream bool, memory int64) (model string, stream bool, body *spillBuffer, err error) {
+	defer req.Body.Close()
+	body = newSpillBuffer(memory)
+	w := bufio.NewWriterSize(body, 64<<10)
+	err = copyObject(json.NewDecoder(req.Body), w, map[string]any{"model": &model, "stream": &stream}, map[string]bool{"model": true, "stream": !keepStream}, extra)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = body.err
+	}
+	if err == nil && model == "" {
+		err = fmt.Errorf("request names no model")
+	}
+	if err != nil {
+		body.Close()
+		return "", false, nil, fmt.Errorf("invalid request body: %w", err)
+	}
+	return model, stream, body, nil
+}
+
+// copyObject copies the JSON object in dec to w a token at a time, so no
+// more of it is held in memory than its longest string. The top-level
+// fields in read are decoded into their values, those in drop left out, and
+// extra appended.
+func copyObject(dec *json.Decoder, w *bufio.Writer, read map[string]any, drop map[string]bool, extra map[string]any) error {
+	dec.UseNumber()
+	if tok, err := dec.Token(); err != nil {
+		return err
+	} else if tok != json.Delim('{') {
+		return fmt.Errorf("not a JSON object")
+	}
+
+	w.WriteByte('{')
+	n := 0
+	field := func(key string) {
+		if n > 0 {
+			w.WriteByte(',')
+		}
+		n++
+		k, _ := json.Marshal(key)
+		w.Write(k)
+		w.WriteByte(':')
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key := tok.(string)
+		if v, ok := read[key]; ok {
+			if err := dec.Decode(v); err != nil {
+				return fmt.Errorf("invalid %s: %w", key, err)
+			}
+			if !drop[key] {
+				b, _ := json.Marshal(v)
+				field(key)
+				w.Write(b)
+			}
+			continue
+		}
+		if drop[key] {
+			if err := skipValue(dec); err != nil {
+				return err
+			}
+			continue
+		}
+		field(key)
+		if err := copyValue(dec, w); err != nil {
+			return err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	for _, key := range slices.Sorted(maps.Keys(extra)) {
+		b, err := json.Marshal(extra[key])
+		if err != nil {
+			return err
+		}
+		field(key)
+		w.Write(b)
+	}
+	return w.WriteByte('}')
+}
+
+// container is an object or array copyValue is in, with the number of
+// tokens written in it, so keys are followed by colons and other tokens by
+// commas.
+type container struct {
+	object bool
+	n      int
+}
+
+// copyValue copies the next value in dec to w a token at a time.
+func copyValue(dec *json.Decoder, w *bufio.Writer) error {
+	var open []container
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if d, ok := tok.(json.Delim); ok && (d == '}' || d == ']') {
+			open = open[:len(open)-1]
+			w.WriteByte(byte(d))
+		} else {
+			if len(open) > 0 {
+				top := &open[len(open)-1]
+				if top.object && top.n%2 == 1 {
+					w.WriteByte(':')
+				} else if top.n > 0 {
+					w.WriteByte(',')
+				}
+				top.n++
+			}
+			if d, ok := tok.(json.Delim); ok {
+				w.WriteByte(byte(d))
+				open = append(open, container{object: d == '{'})
+			} else {
+				b, err := json.Marshal(tok)
+				if err != nil {
+					return err
+				}
+				w.Write(b)
+			}
+		}
+		if len(open) == 0 {
+			return nil
+		}
+	}
+}
+
+// errorKind is the Anthropic API's error type for an HTTP status.
+func errorKind(status int) string {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case status == http.StatusUnauthorized:
+		return "authentication_error"
+	case status == http.StatusForbidden:
+		return "permission_error"
+	case status == http.StatusNotFound:
+		return "not_found_error"
+	case status == 529 || status == http.StatusServiceUnavailable:
+		return "overloaded_error"
+	case status < 500:
+		return "invalid_request_error"
+	}
+	return "api_error"
+}
+
+// anthropicError returns a response carrying an error in the shape of the
+// Anthropic API, for errors the platform reports in its own.
+func anthropicError(req *http.Request, status int, message string) *http.Response {
+	b, _ := json.Marshal(map[string]any{
+		"type":  "error",
+		"error": map[string]string{"type": errorKind(status), "message": message},
+	})
+	return &http.Response{
+		Status:        strconv.Itoa(status) + " " + http.StatusText(status),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}, "Content-Length": {strconv.Itoa(len(b))}},
+		Body:          io.NopCloser(bytes.NewReader(b)),
+		ContentLength: int64(len(b)),
+		Request:       req,
+	}
+}
+
+// stripAuth removes the key the agent authenticated with, which means
+// nothing to the platform.
+func stripAuth(req *http.Request) {
+	req.Header.Del("x-api-key")
+	req.Header.Del("Authorization")
+}
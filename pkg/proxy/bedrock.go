@@ -0,0 +1,270 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/leakbenchmark/deployer/internal/sigv4"
+)
+
+// bedrock serves Anthropic messages requests from AWS Bedrock's
+// InvokeModel API, translating its event streams into the Anthropic
+// server-sent events they carry.
+type bedrock struct {
+	next   http.RoundTripper
+	region string
+	creds  sigv4.Credentials
+	memory int64
+}
+
+func newBedrock(next http.RoundTripper, target *url.URL, memory int64) (http.RoundTripper, error) {
+	if target.Host == "" {
+		return nil, fmt.Errorf("bedrock URL names no region, as in bedrock://us-east-1")
+	}
+	creds := sigv4.Credentials{
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.AccessKey == "" || creds.SecretKey == "" {
+		return nil, fmt.Errorf("set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return &bedrock{next: next, region: target.Host, creds: creds, memory: memory}, nil
+}
+
+func (b *bedrock) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Path != "/v1/messages" {
+		return anthropicError(req, http.StatusNotFound, req.URL.Path+" is not served by Bedrock"), nil
+	}
+
+	extra := map[string]any{"anthropic_version": "bedrock-2023-05-31"}
+	// Bedrock takes beta flags in the body.
+	if beta := req.Header.Get("anthropic-beta"); beta != "" {
+		extra["anthropic_beta"] = strings.Split(beta, ",")
+	}
+	model, stream, body, err := anthropicBody(req, extra, false, b.memory)
+	if err != nil {
+		return anthropicError(req, http.StatusBadRequest, err.Error()), nil
+	}
+	r, err := body.reader()
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	payloadHash := sha256.New()
+	if _, err := io.Copy(payloadHash, r); err != nil {
+		body.Close()
+		return nil, err
+	}
+	if r, err = body.reader(); err != nil {
+		body.Close()
+		return nil, err
+	}
+
+	action, accept := "invoke", "application/json"
+	if stream {
+		action, accept = "invoke-with-response-stream", "application/vnd.amazon.eventstream"
+	}
+	u := &url.URL{
+		Scheme:  "https",
+		Host:    "bedrock-runtime." + b.region + ".amazonaws.com",
+		Path:    "/model/" + model + "/" + action,
+		RawPath: "/model/" + sigv4.URIEncode(model, true) + "/" + action,
+	}
+	// The transport closes the body, removing its file, once it is sent.
+	out, err := http.NewRequestWithContext(req.Context(), http.MethodPost, u.String(), bufferedBody{Reader: r, buf: body})
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	out.ContentLength = body.size
+	out.Header.Set("Content-Type", "application/json")
+	out.Header.Set("Accept", accept)
+	sigv4.Sign(out, hex.EncodeToString(payloadHash.Sum(nil)), b.creds, b.region, "bedrock", time.Now())
+
+	resp, err := b.next.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		var e struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(msg, &e) != nil || e.Message == "" {
+			e.Message = strings.TrimSpace(string(msg))
+		}
+		return anthropicError(req, resp.StatusCode, e.Message), nil
+	}
+
+	resp.Request = req
+	if stream {
+		source := resp.Body
+		pr, pw := io.Pipe()
+		go func() { pw.CloseWithError(translateEventStream(source, pw)) }()
+		resp.Body = &pipedBody{PipeReader: pr, source: source}
+		resp.Header.Set("Content-Type", "text/event-stream")
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+	}
+	return resp, nil
+}
+
+// pipedBody is a translated response body; closing it closes the one it
+// is translated from.
+type pipedBody struct {
+	*io.PipeReader
+	source io.Closer
+}
+
+func (b *pipedBody) Close() error {
+	b.PipeReader.Close()
+	return b.source.Close()
+}
+
+// bedrockExceptions are the statuses of the exceptions Bedrock reports in
+// the middle of a stream.
+var bedrockExceptions = map[string]int{
+	"throttlingException":         http.StatusTooManyRequests,
+	"serviceUnavailableException": http.StatusServiceUnavailable,
+	"modelNotReadyException":      http.StatusServiceUnavailable,
+	"validationException":         http.StatusBadRequest,
+	"accessDeniedException":       http.StatusForbidden,
+}
+
+// translateEventStream writes the Anthropic events carried by a Bedrock
+// event stream to w as server-sent events, and an exception as an error
+// event.
+func translateEventStream(r io.Reader, w io.Writer) error {
+	for {
+		headers, payload, err := readEventMessage(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch headers[":message-type"] {
+		case "event":
+			if headers[":event-type"] != "chunk" {
+				continue
+			}
+			var chunk struct {
+				Bytes []byte `json:"bytes"`
+			}
+			var event struct {
+				Type string `json:"type"`
+			}
+			if err := json.Unmarshal(payload, &chunk); err != nil {
+				return fmt.Errorf("invalid Bedrock chunk: %w", err)
+			}
+			if err := json.Unmarshal(chunk.Bytes, &event); err != nil {
+				return fmt.Errorf("invalid Bedrock chunk: %w", err)
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, chunk.Bytes); err != nil {
+				return err
+			}
+		case "exception", "error":
+			kind := headers[":exception-type"]
+			if kind == "" {
+				kind = headers[":error-code"]
+			}
+			status, ok := bedrockExceptions[kind]
+			if !ok {
+				status = http.StatusInternalServerError
+			}
+			var e struct {
+				Message string `json:"message"`
+			}
+			json.Unmarshal(payload, &e)
+			if e.Message == "" {
+				e.Message = kind
+			}
+			b, _ := json.Marshal(map[string]any{
+				"type":  "error",
+				"error": map[string]string{"type": errorKind(status), "message": e.Message},
+			})
+			_, err := fmt.Fprintf(w, "event: error\ndata: %s\n\n", b)
+			return err
+		}
+	}
+}
+
+// readEventMessage reads one message of an AWS event stream and returns
+// its string headers and its payload.
+func readEventMessage(r io.Reader) (map[string]string, []byte, error) {
+	prelude := make([]byte, 12)
+	if _, err := io.ReadFull(r, prelude); err != nil {
+		return nil, nil, err
+	}
+	total := binary.BigEndian.Uint32(prelude[0:4])
+	headersLen := binary.BigEndian.Uint32(prelude[4:8])
+	if crc32.ChecksumIEEE(prelude[:8]) != binary.BigEndian.Uint32(prelude[8:12]) {
+		return nil, nil, fmt.Errorf("event stream prelude checksum mismatch")
+	}
+	if total < 16 || total > 16<<20 || headersLen > total-16 {
+		return nil, nil, fmt.Errorf("invalid event stream message length %d", total)
+	}
+
+	msg := make([]byte, total)
+	copy(msg, prelude)
+	if _, err := io.ReadFull(r, msg[12:]); err != nil {
+		return nil, nil, fmt.Errorf("truncated event stream message: %w", err)
+	}
+	if crc32.ChecksumIEEE(msg[:total-4]) != binary.BigEndian.Uint32(msg[total-4:]) {
+		return nil, nil, fmt.Errorf("event stream message checksum mismatch")
+	}
+
+	headers := map[string]string{}
+	h := msg[12 : 12+headersLen]
+	for len(h) > 0 {
+		nameLen := int(h[0])
+		if len(h) < 2+nameLen {
+			return nil, nil, fmt.Errorf("invalid event stream header")
+		}
+		name, typ := string(h[1:1+nameLen]), h[1+nameLen]
+		h = h[2+nameLen:]
+
+		var size int
+		switch typ {
+		case 0, 1: // true, false
+		case 2: // byte
+			size = 1
+		case 3: // short
+			size = 2
+		case 4: // int
+			size = 4
+		case 5, 8: // long, timestamp
+			size = 8
+		case 9: // uuid
+			size = 16
+		case 6, 7: // bytes, string
+			if len(h) < 2 {
+				return nil, nil, fmt.Errorf("invalid event stream header")
+			}
+			size = 2 + int(binary.BigEndian.Uint16(h))
+		default:
+			return nil, nil, fmt.Errorf("unknown event stream header type %d", typ)
+		}
+		if len(h) < size {
+			return nil, nil, fmt.Errorf("invalid event stream header")
+		}
+		if typ == 7 {
+			headers[name] = string(h[2:size])
+		}
+		h = h[size:]
+	}
+	return headers, msg[12+headersLen : total-4], nil
+}
@@ -0,0 +1,252 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/leakbenchmark/deployer/pkg/transcripts"
+)
+
+// errTooLarge is returned by readBody for bodies over the size cap.
+var errTooLarge = errors.New("request body too large")
+
+// body is a request body read once from the client. Up to the memory limit
+// it is held in memory; past it, the whole body spills to a temporary file
+// and is read back from there when recorded and forwarded.
+type body struct {
+	buf  []byte
+	file *os.File
+	size int64
+}
+
+// readBody reads r, keeping up to memory bytes in memory and failing with
+// errTooLarge once more than max bytes have been read.
+func readBody(r io.Reader, memory, max int64) (*body, error) {
+	r = io.LimitReader(r, max+1)
+
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, r, memory+1)
+	if err == io.EOF || n <= memory {
+		if n > max {
+			return nil, errTooLarge
+		}
+		return &body{buf: buf.Bytes(), size: n}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.CreateTemp("", "leakbench-body-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to spill request body: %w", err)
+	}
+	b := &body{file: f}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		b.Close()
+		return nil, fmt.Errorf("failed to spill request body: %w", err)
+	}
+	rest, err := io.Copy(f, r)
+	if err != nil {
+		b.Close()
+		return nil, fmt.Errorf("failed to spill request body: %w", err)
+	}
+	b.size = n + rest
+	if b.size > max {
+		b.Close()
+		return nil, errTooLarge
+	}
+	return b, nil
+}
+
+// spilled reports whether the body is on disk.
+func (b *body) spilled() bool {
+	return b.file != nil
+}
+
+// reader returns a reader over the whole body, from the start.
+func (b *body) reader() (io.ReadCloser, error) {
+	if b.file == nil {
+		return io.NopCloser(bytes.NewReader(b.buf)), nil
+	}
+	if _, err := b.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(b.file), nil
+}
+
+// String returns the body as text, reading it back from disk if it spilled.
+func (b *body) String() (string, error) {
+	if b.file == nil {
+		return string(b.buf), nil
+	}
+	r, err := b.reader()
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	sb.Grow(int(b.size))
+	if _, err := io.Copy(&sb, r); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// Close removes the temporary file of a spilled body.
+func (b *body) Close() error {
+	if b.file == nil {
+		return nil
+	}
+	b.file.Close()
+	return os.Remove(b.file.Name())
+}
+
+// usageTail is how much of the end of a body recorded as an attachment is
+// read back, for the usage and errors providers report in a stream's last
+// events.
+const usageTail = 64 << 10
+
+// section returns n bytes of the body from off, reading them back from disk
+// if it spilled.
+func (b *body) section(off, n int64) (string, error) {
+	if b.file == nil {
+		return string(b.buf[off : off+n]), nil
+	}
+	buf := make([]byte, n)
+	if _, err := b.file.ReadAt(buf, off); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// attach copies the whole body to a new file in dir and returns its path.
+func (b *body) attach(dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(dir, "message-")
+	if err != nil {
+		return "", err
+	}
+	r, err := b.reader()
+	if err == nil {
+		_, err = io.Copy(f, r)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// spillBuffer accumulates a streamed or buffered response as it passes
+// through to the client. Up to the memory limit it is held in memory; past
+// it, the whole response spills to a temporary file, so an agent stuck
+// generating forever can't exhaust the proxy's memory. Each write is noted
+// as a chunk, so the analyzer can tell when a stream's parts arrived.
+type spillBuffer struct {
+	body
+	memory int64
+	err    error
+	chunks []transcripts.Chunk
+}
+
+func newSpillBuffer(memory int64) *spillBuffer {
+	return &spillBuffer{memory: memory}
+}
+
+// Write never fails, so the stream keeps flowing to the client when it
+// can't be spilled. The error is kept in err instead.
+func (b *spillBuffer) Write(p []byte) (int, error) {
+	if b.err != nil {
+		return len(p), nil
+	}
+	b.chunks = append(b.chunks, transcripts.Chunk{Start: int(b.size), Length: len(p), Time: time.Now()})
+	if b.file == nil && b.size+int64(len(p)) > b.memory {
+		f, err := os.CreateTemp("", "leakbench-stream-")
+		if err != nil {
+			b.err = fmt.Errorf("failed to spill response: %w", err)
+			return len(p), nil
+		}
+		b.file = f
+		if _, err := f.Write(b.buf); err != nil {
+			b.err = fmt.Errorf("failed to spill response: %w", err)
+			return len(p), nil
+		}
+		b.buf = nil
+	}
+
+	if b.file == nil {
+		b.buf = append(b.buf, p...)
+	} else if _, err := b.file.Write(p); err != nil {
+		b.err = fmt.Errorf("failed to spill response: %w", err)
+		return len(p), nil
+	}
+	b.size += int64(len(p))
+	return len(p), nil
+}
+
+// stream reports whether the request asks for a streamed response. Spilled
+// bodies are walked token by token rather than decoded whole.
+func (b *body) stream() (bool, error) {
+	var req struct {
+		Stream bool `json:"stream"`
+	}
+	if b.file == nil {
+		err := json.Unmarshal(b.buf, &req)
+		return req.Stream, err
+	}
+
+	r, err := b.reader()
+	if err != nil {
+		return false, err
+	}
+	dec := json.NewDecoder(r)
+	if tok, err := dec.Token(); err != nil {
+		return false, err
+	} else if tok != json.Delim('{') {
+		return false, fmt.Errorf("request body is not a JSON object")
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return false, err
+		}
+		if key == "stream" {
+			err := dec.Decode(&req.Stream)
+			return req.Stream, err
+		}
+		if err := skipValue(dec); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// skipValue reads past the next value in dec.
+func skipValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
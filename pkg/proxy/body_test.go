@@ -0,0 +1,233 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/leakbenchmark/deployer/pkg/transcripts"
+)
+
+func TestCaptureAttached(t *testing.T) {
+	dir := t.TempDir()
+	s := &Server{MaxRecord: 64, dbPath: filepath.Join(dir, "messages.db")}
+	b := newSpillBuffer(16)
+	defer b.Close()
+
+	var stream strings.Builder
+	for i := range 20 {
+		fmt.Fprintf(&stream, "data: {\"delta\":\"%02d\"}\n\n", i)
+	}
+	stream.WriteString("data: {\"usage\":{\"input_tokens\":12,\"output_tokens\":34}}\n\n")
+	for _, chunk := range strings.SplitAfter(stream.String(), "\n\n") {
+		b.Write([]byte(chunk))
+	}
+
+	if !b.spilled() {
+		t.Error("stream over the memory limit didn't spill")
+	}
+	if b.size != int64(stream.Len()) {
+		t.Errorf("kept %d bytes of %d", b.size, stream.Len())
+	}
+	rec, err := s.capture(&b.body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := stream.String()[:64] + fmt.Sprintf(attachmentNote, 64, stream.Len(), rec.attachment)
+	if rec.content != want {
+		t.Errorf("content = %q, want %q", rec.content, want)
+	}
+	attached, err := os.ReadFile(filepath.Join(dir, rec.attachment))
+	if err != nil || string(attached) != stream.String() {
+		t.Errorf("attachment = %q, %v, want the whole stream", attached, err)
+	}
+	if input, output := transcripts.ResponseUsage(rec.tail); input != 12 || output != 34 {
+		t.Errorf("usage in tail = %d, %d, want 12, 34", input, output)
+	}
+}
+
+func TestCaptureUnderLimit(t *testing.T) {
+	s := &Server{MaxRecord: 1 << 20, dbPath: filepath.Join(t.TempDir(), "messages.db")}
+	b := newSpillBuffer(16)
+	defer b.Close()
+	b.Write([]byte("data: {\"delta\":\"hello\"}\n\n"))
+	rec, err := s.capture(&b.body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.content != "data: {\"delta\":\"hello\"}\n\n" || rec.attachment != "" || rec.tail != "" {
+		t.Errorf("recorded %+v, want the stream unmarked", rec)
+	}
+}
+
+func TestAttached(t *testing.T) {
+	response := `{"choices":[{"message":{"content":"` + strings.Repeat("y", 4096) + `"}}]}`
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, response)
+	}))
+	defer upstream.Close()
+
+	dbPath := filepath.Join(t.TempDir(), "messages.db")
+	s, err := New(dbPath, upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.BodyMemory, s.MaxRecord = 1024, 2048
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/v1/chat/completions", "application/json", strings.NewReader(request(4096)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(got) != response {
+		t.Errorf("agent got %d bytes, want the whole %d", len(got), len(response))
+	}
+
+	db, err := transcripts.Open(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	messages, err := db.Messages("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || messages[0].Attachment == "" || messages[0].Content != request(4096) {
+		t.Fatalf("messages = %+v, want the request read from its attachment", messages)
+	}
+	if messages[1].Attachment == "" || messages[1].Content != response {
+		t.Errorf("response = %+v, want it read from its attachment", messages[1])
+	}
+}
+
+// request returns a chat completions request with a user message of n bytes.
+func request(n int) string {
+	return fmt.Sprintf(`{"model":"gpt-4o","temperature":1,"messages":[{"role":"user","content":%q}]}`, strings.Repeat("x", n))
+}
+
+func TestRewriteSpilled(t *testing.T) {
+	content := request(4096)
+	b, err := readBody(strings.NewReader(content), 1024, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if !b.spilled() {
+		t.Fatal("body didn't spill")
+	}
+
+	temperature := 0.0
+	sess := session{setup: Setup{Id: "s", Decoding: &Decoding{Temperature: &temperature}}}
+	rewritten, err := rewrite(sess, &Exchange{Endpoint: "/v1/chat/completions"}, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := rewritten.String()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req struct {
+		Temperature *float64 `json:"temperature"`
+		Messages    []struct{ Content string }
+	}
+	if err := json.Unmarshal([]byte(got), &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Temperature == nil || *req.Temperature != 0 {
+		t.Errorf("temperature = %v, want 0", req.Temperature)
+	}
+	if len(req.Messages) != 1 || req.Messages[0].Content != strings.Repeat("x", 4096) {
+		t.Error("rewrite changed the messages")
+	}
+}
+
+func TestMaxRewrite(t *testing.T) {
+	var forwarded []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		forwarded = append(forwarded, string(b))
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"choices":[]}`)
+	}))
+	defer upstream.Close()
+
+	s, err := New(filepath.Join(t.TempDir(), "messages.db"), upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.BodyMemory, s.MaxRewrite = 1024, 8192
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	post := func(body string) int {
+		resp, err := http.Post(srv.URL+"/v1/chat/completions", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	temperature := 0.0
+	setup, _ := json.Marshal(Setup{Id: "s", BaseURL: upstream.URL, Decoding: &Decoding{Temperature: &temperature}})
+	if status := post(string(setup)); status != http.StatusOK {
+		t.Fatalf("setup call returned %d", status)
+	}
+	if status := post(request(4096)); status != http.StatusOK {
+		t.Errorf("spilled body under the cap returned %d", status)
+	}
+	if len(forwarded) != 1 || !strings.Contains(forwarded[0], `"temperature":0`) {
+		t.Errorf("forwarded %d bodies, want one rewritten", len(forwarded))
+	}
+	if status := post(request(16384)); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("body over the cap returned %d, want 413", status)
+	}
+	if len(forwarded) != 1 {
+		t.Error("body over the cap was forwarded")
+	}
+}
+
+func TestAnthropicBodySpilled(t *testing.T) {
+	content := strings.Repeat("é<x>", 1024)
+	in := fmt.Sprintf(`{"model":"claude-x","max_tokens":1024,"stream":true,"messages":[{"role":"user","content":[{"type":"text","text":%q}]}],"metadata":{"n":1.50,"tags":[]}}`, content)
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(in))
+	model, stream, b, err := anthropicBody(req, map[string]any{"anthropic_version": "bedrock-2023-05-31"}, false, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if model != "claude-x" || !stream || !b.spilled() {
+		t.Errorf("model %q, stream %v, spilled %v", model, stream, b.spilled())
+	}
+
+	out, err := b.String()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal([]byte(out), &got); err != nil {
+		t.Fatalf("rewritten body %q: %v", out, err)
+	}
+	var want map[string]any
+	json.Unmarshal([]byte(strings.Replace(in, `"model":"claude-x",`, "", 1)), &want)
+	delete(want, "stream")
+	want["anthropic_version"] = "bedrock-2023-05-31"
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("rewritten body = %v, want %v", got, want)
+	}
+	if !strings.Contains(out, `"n":1.50`) {
+		t.Error("numbers weren't copied as they were")
+	}
+}
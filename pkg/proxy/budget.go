@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/leakbenchmark/deployer/pkg/transcripts"
+)
+
+// Budget caps what a session may use, so runaway agent loops end at the
+// same point whatever the tool. Once it is spent, the session's requests
+// are refused with an error the provider would send for an invalid request,
+// which agents don't retry. Zero is unlimited.
+type Budget struct {
+	// Turns is the number of requests the session may make.
+	Turns int `json:"turns,omitempty"`
+	// Tokens is the number of input and output tokens, as the provider
+	// reports them, the session's responses may add up to. The request
+	// that goes over it is answered; the ones after are refused.
+	Tokens int `json:"tokens,omitempty"`
+}
+
+// spent is what a session has used of its budget.
+type spent struct {
+	turns, tokens int
+}
+
+// budgetExceeded is the error of a request past its session's budget.
+type budgetExceeded struct {
+	unit         string
+	used, budget int
+}
+
+func (e *budgetExceeded) Error() string {
+	return fmt.Sprintf("session budget of %d %s exhausted (%d used)", e.budget, e.unit, e.used)
+}
+
+// charge counts a request against the session's budget, or returns a
+// budgetExceeded error when it is spent.
+func (s *Server) charge(setup Setup) error {
+	b := setup.Budget
+	if b == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sp := s.spent[setup.Id]
+	if sp == nil {
+		sp = &spent{}
+		s.spent[setup.Id] = sp
+	}
+	var err error
+	switch {
+	case b.Turns > 0 && sp.turns >= b.Turns:
+		err = &budgetExceeded{unit: "turns", used: sp.turns, budget: b.Turns}
+	case b.Tokens > 0 && sp.tokens >= b.Tokens:
+		err = &budgetExceeded{unit: "tokens", used: sp.tokens, budget: b.Tokens}
+	}
+	if err != nil {
+		log.Printf("Refused request of session %s: %v", setup.Id, err)
+		return err
+	}
+	sp.turns++
+	return nil
+}
+
+// spend adds the tokens a response reports to its session's spending. A
+// truncated stream's input is reported at its start, in content, and its
+// output at its end, in tail.
+func (s *Server) spend(setup Setup, content, tail string) {
+	if setup.Budget == nil || setup.Budget.Tokens == 0 {
+		return
+	}
+	input, output := transcripts.ResponseUsage(content)
+	if tail != "" {
+		tailInput, tailOutput := transcripts.ResponseUsage(tail)
+		input, output = max(input, tailInput), max(output, tailOutput)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sp := s.spent[setup.Id]; sp != nil {
+		sp.tokens += input + output
+	}
+}
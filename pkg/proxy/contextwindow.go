@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// ContextWindow simulates a smaller context window than the model's, to
+// see whether agents under context pressure write what they read, secrets
+// included, into notes of their own.
+type ContextWindow struct {
+	// Tokens is the window, with requests estimated at four bytes a token.
+	Tokens int `json:"tokens"`
+	// Reject answers requests over the window with the provider's context
+	// length error. Otherwise the oldest model turns are dropped until the
+	// request fits, keeping the system messages, the first user message and
+	// the last turn; a request that doesn't fit even then is rejected.
+	Reject bool `json:"reject,omitempty"`
+}
+
+// contextExceeded is the error of a request over its session's window.
+type contextExceeded struct {
+	tokens, window int
+}
+
+func (e *contextExceeded) Error() string {
+	return fmt.Sprintf("request of about %d tokens exceeds the %d token context window", e.tokens, e.window)
+}
+
+func estimateTokens(size int) int {
+	return (size + 3) / 4
+}
+
+// apply fits a request's fields, size bytes long, into the window,
+// reporting whether it changed them.
+func (c *ContextWindow) apply(endpoint string, fields map[string]json.RawMessage, size int) (bool, error) {
+	if estimateTokens(size) <= c.Tokens {
+		return false, nil
+	}
+	exceeded := &contextExceeded{tokens: estimateTokens(size), window: c.Tokens}
+	format, ok := conversationFormats[endpoint]
+	if c.Reject || !ok {
+		return false, exceeded
+	}
+	var items []item
+	if json.Unmarshal(fields[format.messages], &items) != nil {
+		return false, exceeded
+	}
+
+	head, starts := turnStarts(items, format.kind)
+	dropped, next := 0, head
+	for k := 1; k < len(starts); k++ {
+		for ; next < starts[k]; next++ {
+			b, err := json.Marshal(items[next])
+			if err != nil {
+				return false, err
+			}
+			// The item and its comma.
+			dropped += len(b) + 1
+		}
+		if estimateTokens(size-dropped) > c.Tokens {
+			continue
+		}
+		kept := append(append([]item{}, items[:head]...), items[starts[k]:]...)
+		b, err := json.Marshal(kept)
+		if err != nil {
+			return false, err
+		}
+		fields[format.messages] = b
+		return true, nil
+	}
+	return false, exceeded
+}
+
+// writeContextError answers a request over its session's window as its
+// provider would.
+func writeContextError(w http.ResponseWriter, r *http.Request, e *contextExceeded) {
+	var body any
+	if r.Header.Get("anthropic-version") != "" || endpoint(r.URL.Path) == "/v1/messages" {
+		message := fmt.Sprintf("prompt is too long: %d tokens > %d maximum", e.tokens, e.window)
+		body = map[string]any{"type": "error", "error": map[string]string{"type": "invalid_request_error", "message": message}}
+	} else {
+		message := fmt.Sprintf("This model's maximum context length is %d tokens. However, your messages resulted in %d tokens. Please reduce the length of the messages.", e.window, e.tokens)
+		body = map[string]any{"error": map[string]any{"message": message, "type": "invalid_request_error", "param": "messages", "code": "context_length_exceeded"}}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Decoding holds the sampling parameters forced on a session's requests,
+// so trials vary less and a provider changing its defaults doesn't change
+// results between campaigns. Unset ones are left as the agent sent them.
+type Decoding struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	Seed        *int64   `json:"seed,omitempty"`
+}
+
+// decodingFields are the parameters each completion API accepts. Only chat
+// and legacy completions take a seed.
+var decodingFields = map[string][]string{
+	"/v1/chat/completions": {"temperature", "top_p", "seed"},
+	"/v1/completions":      {"temperature", "top_p", "seed"},
+	"/v1/responses":        {"temperature", "top_p"},
+	"/v1/messages":         {"temperature", "top_p"},
+}
+
+func (d *Decoding) values() map[string]any {
+	v := map[string]any{}
+	if d.Temperature != nil {
+		v["temperature"] = *d.Temperature
+	}
+	if d.TopP != nil {
+		v["top_p"] = *d.TopP
+	}
+	if d.Seed != nil {
+		v["seed"] = *d.Seed
+	}
+	return v
+}
+
+// apply sets the parameters the endpoint accepts in a request's fields.
+func (d *Decoding) apply(endpoint string, fields map[string]json.RawMessage) (bool, error) {
+	values := d.values()
+	changed := false
+	for _, field := range decodingFields[endpoint] {
+		v, ok := values[field]
+		if !ok {
+			continue
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return false, err
+		}
+		fields[field], changed = b, true
+	}
+	return changed, nil
+}
+
+// rewrites reports whether the session's requests are changed on their way
+// upstream, by rewrite or redaction.
+func rewrites(setup Setup) bool {
+	return setup.Ablation != nil || setup.Decoding != nil || setup.ContextWindow != nil ||
+		setup.Redaction != nil && setup.Redaction.Requests
+}
+
+// rewrite returns b with the session's ablation and decoding applied, fit
+// into its context window. It is what gets recorded and sent upstream. The
+// body is decoded straight from disk if it spilled, but its fields and the
+// rewritten body are held in memory, so the server refuses to rewrite
+// bodies over MaxRewrite.
+func rewrite(sess session, ex *Exchange, b *body) (*body, error) {
+	a, d, cw := sess.setup.Ablation, sess.setup.Decoding, sess.setup.ContextWindow
+	if a == nil && d == nil && cw == nil {
+		return b, nil
+	}
+
+	r, err := b.reader()
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&fields); err != nil {
+		return nil, fmt.Errorf("invalid request body: %w", err)
+	}
+	changed := false
+	if a != nil {
+		ok, err := a.apply(ex.Endpoint, fields)
+		if err != nil {
+			return nil, err
+		}
+		changed = changed || ok
+	}
+	if d != nil {
+		ok, err := d.apply(ex.Endpoint, fields)
+		if err != nil {
+			return nil, err
+		}
+		changed = changed || ok
+	}
+	if cw != nil {
+		size := int(b.size)
+		if changed {
+			buf, err := json.Marshal(fields)
+			if err != nil {
+				return nil, err
+			}
+			size = len(buf)
+		}
+		ok, err := cw.apply(ex.Endpoint, fields, size)
+		if err != nil {
+			return nil, err
+		}
+		changed = changed || ok
+	}
+	if !changed {
+		return b, nil
+	}
+
+	buf, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	return &body{buf: buf, size: int64(len(buf))}, nil
+}
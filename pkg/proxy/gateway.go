@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"strings"
+)
+
+// embeddedError returns the HTTP status of an error reported inside a
+// response body, or inside an event of a streamed one, or 0 if there is
+// none. OpenRouter and similar gateways report upstream failures this way,
+// with a numeric code, often after answering 200.
+func embeddedError(content string) int {
+	status := func(data string) int {
+		if !strings.Contains(data, `"error"`) {
+			return 0
+		}
+		var body struct {
+			Error *struct {
+				Code json.RawMessage `json:"code"`
+			} `json:"error"`
+		}
+		if json.Unmarshal([]byte(data), &body) != nil || body.Error == nil {
+			return 0
+		}
+		// OpenAI's own codes are strings like "rate_limit_exceeded".
+		var code int
+		if json.Unmarshal(body.Error.Code, &code) != nil || code < 400 || code > 599 {
+			return 0
+		}
+		return code
+	}
+
+	if !strings.HasPrefix(content, "data:") && !strings.Contains(content, "\ndata:") {
+		return status(content)
+	}
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), len(content)+1)
+	for scanner.Scan() {
+		if data, ok := strings.CutPrefix(scanner.Text(), "data:"); ok {
+			if code := status(strings.TrimSpace(data)); code != 0 {
+				return code
+			}
+		}
+	}
+	return 0
+}
+
+// gatewayError records an error embedded in a completed response, or in the
+// tail of a truncated one, as the exchange's status, and backs off the
+// provider if it was rate limited.
+func (s *Server) gatewayError(ex *Exchange, baseURL, content, tail string) {
+	code := embeddedError(content)
+	if code == 0 && tail != "" {
+		code = embeddedError(tail)
+	}
+	if code == 0 {
+		return
+	}
+	ex.Status = code
+	s.backoff(baseURL, code, "")
+}
+
+// upstreamPath joins the path of a provider's base URL, such as
+// https://openrouter.ai/api, with the endpoint a request is sent to.
+func upstreamPath(base, endpoint string) string {
+	return strings.TrimSuffix(base, "/") + endpoint
+}
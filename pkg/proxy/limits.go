@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Limit is a provider's per-minute budget, shared by every session on it.
+// Zero means unlimited.
+type Limit struct {
+	Requests int
+	Tokens   int
+}
+
+// limiter holds a provider's remaining budget, refilled continuously, and
+// how long the provider told the proxy to back off.
+type limiter struct {
+	mu          sync.Mutex
+	limit       Limit
+	requests    float64
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+}
+
+func newLimiter(limit Limit) *limiter {
+	return &limiter{limit: limit, requests: float64(limit.Requests), tokens: float64(limit.Tokens), last: time.Now()}
+}
+
+// reserve takes a request costing tokens out of the budget, or returns how
+// long to wait before trying again.
+func (l *limiter) reserve(tokens int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(l.pausedUntil) {
+		return l.pausedUntil.Sub(now)
+	}
+	elapsed := now.Sub(l.last).Minutes()
+	l.last = now
+	l.requests = min(l.requests+elapsed*float64(l.limit.Requests), float64(l.limit.Requests))
+	l.tokens = min(l.tokens+elapsed*float64(l.limit.Tokens), float64(l.limit.Tokens))
+
+	// A request bigger than the whole budget waits for a full one.
+	cost := float64(min(tokens, l.limit.Tokens))
+	var wait time.Duration
+	if l.limit.Requests > 0 && l.requests < 1 {
+		wait = max(wait, time.Duration((1-l.requests)/float64(l.limit.Requests)*float64(time.Minute)))
+	}
+	if l.limit.Tokens > 0 && l.tokens < cost {
+		wait = max(wait, time.Duration((cost-l.tokens)/float64(l.limit.Tokens)*float64(time.Minute)))
+	}
+	if wait > 0 {
+		return wait
+	}
+	if l.limit.Requests > 0 {
+		l.requests--
+	}
+	if l.limit.Tokens > 0 {
+		l.tokens -= cost
+	}
+	return 0
+}
+
+// wait blocks until a request costing tokens fits the budget.
+func (l *limiter) wait(ctx context.Context, tokens int) error {
+	for {
+		d := l.reserve(tokens)
+		if d == 0 {
+			return nil
+		}
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// pause holds every request to the provider for d.
+func (l *limiter) pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until := time.Now().Add(d); until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
+
+// limiterFor returns the limiter of the provider at baseURL.
+func (s *Server) limiterFor(baseURL string) *limiter {
+	host := providerHost(baseURL)
+	if host == "" {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.limiters[host]
+	if !ok {
+		l = newLimiter(s.Limits[host])
+		s.limiters[host] = l
+	}
+	return l
+}
+
+// throttle waits until the session's provider has budget for a request of
+// size bytes, estimated at four bytes per token.
+func (s *Server) throttle(ctx context.Context, sess session, size int64) error {
+	l := s.limiterFor(sess.setup.BaseURL)
+	if l == nil {
+		return nil
+	}
+	return l.wait(ctx, int((size+3)/4))
+}
+
+// defaultBackoff is how long a provider is left alone after a 429 without
+// a usable Retry-After.
+const defaultBackoff = 10 * time.Second
+
+// backoff pauses the provider at baseURL when it rate limited a request,
+// so the other sessions on it don't pile on more 429s.
+func (s *Server) backoff(baseURL string, status int, retryAfter string) {
+	if status != http.StatusTooManyRequests {
+		return
+	}
+	l := s.limiterFor(baseURL)
+	if l == nil {
+		return
+	}
+	d := defaultBackoff
+	if secs, err := strconv.Atoi(retryAfter); err == nil && secs > 0 {
+		d = time.Duration(secs) * time.Second
+	}
+	l.pause(d)
+}
@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+)
+
+// Exchange is a completion request passing through the proxy, as seen by
+// middleware.
+type Exchange struct {
+	Session  string
+	Step     string
+	Endpoint string
+	// Header is the agent's request header. Changes made to it by OnRequest
+	// are sent upstream.
+	Header http.Header
+	// Status is the provider's response status, once it has answered.
+	Status int
+	// ReplayOf is the recorded message a replayed request copies, 0 for
+	// the agent's own requests.
+	ReplayOf int64
+}
+
+// Middleware inspects or transforms the completion traffic of a Server
+// without changing the proxy itself, for example to watermark requests,
+// redact more than the provider should see, or log what an experiment
+// needs. Auxiliary calls and setup calls don't go through it.
+type Middleware interface {
+	// OnRequest is given the request body before it is recorded and
+	// forwarded, and returns the body to record and forward instead. An
+	// error refuses the request.
+	OnRequest(ex *Exchange, body []byte) ([]byte, error)
+	// OnResponseChunk is given each piece of the response body as it is
+	// read from the provider, and returns what the agent receives instead.
+	// Pieces of a streamed response don't necessarily hold whole events.
+	OnResponseChunk(ex *Exchange, chunk []byte) []byte
+	// OnComplete is given the response as it was recorded: as the agent
+	// received it but for redaction, and cut at MaxRecord.
+	OnComplete(ex *Exchange, response []byte)
+}
+
+// Hooks adapts functions to a Middleware. The nil ones leave the traffic
+// as it is.
+type Hooks struct {
+	Request       func(ex *Exchange, body []byte) ([]byte, error)
+	ResponseChunk func(ex *Exchange, chunk []byte) []byte
+	Complete      func(ex *Exchange, response []byte)
+}
+
+func (h Hooks) OnRequest(ex *Exchange, body []byte) ([]byte, error) {
+	if h.Request == nil {
+		return body, nil
+	}
+	return h.Request(ex, body)
+}
+
+func (h Hooks) OnResponseChunk(ex *Exchange, chunk []byte) []byte {
+	if h.ResponseChunk == nil {
+		return chunk
+	}
+	return h.ResponseChunk(ex, chunk)
+}
+
+func (h Hooks) OnComplete(ex *Exchange, response []byte) {
+	if h.Complete != nil {
+		h.Complete(ex, response)
+	}
+}
+
+// onRequest runs the request body through the middleware in order,
+// returning the body to use from then on.
+func (s *Server) onRequest(ex *Exchange, b *body) (*body, error) {
+	if len(s.Middleware) == 0 {
+		return b, nil
+	}
+	content, err := b.String()
+	if err != nil {
+		return nil, err
+	}
+	buf := []byte(content)
+	for _, m := range s.Middleware {
+		if buf, err = m.OnRequest(ex, buf); err != nil {
+			return nil, err
+		}
+	}
+	return &body{buf: buf, size: int64(len(buf))}, nil
+}
+
+// onResponse returns r with every piece read from it passed through the
+// middleware in order.
+func (s *Server) onResponse(ex *Exchange, r io.ReadCloser) io.ReadCloser {
+	if len(s.Middleware) == 0 {
+		return r
+	}
+	return &hookedBody{ReadCloser: r, hooks: s.Middleware, ex: ex}
+}
+
+// onComplete hands the recorded response to the middleware.
+func (s *Server) onComplete(ex *Exchange, response string) {
+	for _, m := range s.Middleware {
+		m.OnComplete(ex, []byte(response))
+	}
+}
+
+// hookedBody is a response body whose pieces pass through middleware as
+// they are read. A piece can grow, so what doesn't fit the caller's buffer
+// waits for the next read.
+type hookedBody struct {
+	io.ReadCloser
+	hooks   []Middleware
+	ex      *Exchange
+	pending []byte
+	err     error
+}
+
+func (b *hookedBody) Read(p []byte) (int, error) {
+	for len(b.pending) == 0 && b.err == nil {
+		buf := make([]byte, 32<<10)
+		n, err := b.ReadCloser.Read(buf)
+		chunk := buf[:n]
+		if n > 0 {
+			for _, m := range b.hooks {
+				chunk = m.OnResponseChunk(b.ex, chunk)
+			}
+		}
+		b.pending, b.err = chunk, err
+	}
+	n := copy(p, b.pending)
+	b.pending = b.pending[n:]
+	if len(b.pending) > 0 {
+		return n, nil
+	}
+	return n, b.err
+}
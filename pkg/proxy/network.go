@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"context"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// Network degrades a session's connection, to study how agents behave when
+// requests are slow or fail: whether they retry, and what they resend. The
+// benchmark containers share the host's network, so this is done here
+// rather than with tc on the container's interface.
+type Network struct {
+	// Latency is added to every request, give or take Jitter.
+	Latency time.Duration `json:"latency,omitempty"`
+	Jitter  time.Duration `json:"jitter,omitempty"`
+	// Loss is the probability of a request's connection being dropped
+	// without a response, the way a lost packet eventually surfaces.
+	Loss float64 `json:"loss,omitempty"`
+	// Bandwidth caps the bytes a second responses are sent at.
+	Bandwidth int64 `json:"bandwidth,omitempty"`
+}
+
+// degrade delays a request of session, drops it, and returns w slowed down
+// to n's bandwidth. The error is the request's context ending during the
+// delay.
+func (n *Network) degrade(ctx context.Context, session string, w http.ResponseWriter) (http.ResponseWriter, error) {
+	delay := n.Latency
+	if n.Jitter > 0 {
+		delay += time.Duration(rand.Int64N(int64(2*n.Jitter+1))) - n.Jitter
+	}
+	if delay > 0 {
+		t := time.NewTimer(delay)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return w, ctx.Err()
+		}
+	}
+
+	if n.Loss > 0 && rand.Float64() < n.Loss {
+		log.Printf("Dropping request of session %s", session)
+		// Closes the connection without a response, and without logging.
+		panic(http.ErrAbortHandler)
+	}
+
+	if n.Bandwidth > 0 {
+		return &slowWriter{ResponseWriter: w, ctx: ctx, bandwidth: n.Bandwidth}, nil
+	}
+	return w, nil
+}
+
+// slowWriter writes a response at no more than bandwidth bytes a second,
+// in tenth-of-a-second chunks flushed as they go.
+type slowWriter struct {
+	http.ResponseWriter
+	ctx       context.Context
+	bandwidth int64
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	chunk := max(int(w.bandwidth/10), 1)
+	written := 0
+	for len(p) > 0 {
+		n := min(chunk, len(p))
+		m, err := w.ResponseWriter.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		w.Flush()
+		p = p[n:]
+		select {
+		case <-time.After(time.Duration(n) * time.Second / time.Duration(w.bandwidth)):
+		case <-w.ctx.Done():
+			return written, w.ctx.Err()
+		}
+	}
+	return written, nil
+}
+
+func (w *slowWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *slowWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
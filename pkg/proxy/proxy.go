@@ -0,0 +1,784 @@
+// Package proxy records the traffic between coding agents and their model
+// providers. A Server forwards requests to the provider of the current
+// session and stores every request and response body in a SQLite messages
+// table, tagged with the session ID, scenario step, direction and endpoint,
+// for the analyzer to scan.
+package proxy
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leakbenchmark/deployer/internal/auth"
+	"github.com/leakbenchmark/deployer/pkg/transcripts"
+	_ "github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/leakbenchmark/deployer/pkg/proxy")
+
+// Setup is the control message that points the proxy at a session. It is
+// POSTed to the proxy like any other request and recognized by its fields.
+type Setup struct {
+	Id      string `json:"id"`
+	BaseURL string `json:"baseURL"`
+	// Step names the current step of a multi-prompt scenario, if any.
+	Step string `json:"step,omitempty"`
+	// DB is the database file to record the session's messages in. The
+	// proxy keeps writing to the current one when it is empty.
+	DB string `json:"db,omitempty"`
+	// Headers are set on the session's upstream requests, over the
+	// provider's own Headers; an empty value removes the header.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Decoding is forced on the session's requests.
+	Decoding *Decoding `json:"decoding,omitempty"`
+	// Ablation removes parts of the session's requests.
+	Ablation *Ablation `json:"ablation,omitempty"`
+	// Network degrades the session's connection.
+	Network *Network `json:"network,omitempty"`
+	// ContextWindow fits the session's requests into a smaller window.
+	ContextWindow *ContextWindow `json:"context_window,omitempty"`
+	// Budget caps the session's turns and tokens.
+	Budget *Budget `json:"budget,omitempty"`
+	// Secrets are the values planted in the session's project, by ID, for
+	// the proxy to publish leak events on.
+	Secrets map[string]string `json:"secrets,omitempty"`
+	// Redaction masks the session's Secrets in what the proxy passes on.
+	Redaction *Redaction `json:"redaction,omitempty"`
+	// Final ends the session: the proxy publishes its finalized event
+	// instead of pointing at it.
+	Final bool `json:"final,omitempty"`
+	// Replay marks the session as resending recorded requests, each naming
+	// the message it copies in ReplayHeader.
+	Replay bool `json:"replay,omitempty"`
+	// Stalled flags the session as having gone quiet while its tool still
+	// runs: the proxy publishes its stalled event instead of pointing at it.
+	Stalled bool `json:"stalled,omitempty"`
+}
+
+// Server is the recording proxy. Requests belong to the session of the last
+// setup call unless they carry a key issued to another session.
+type Server struct {
+	// MaxBody caps the size of request bodies; larger ones are refused.
+	MaxBody int64
+	// BodyMemory is how much of a request body or streamed response is
+	// held in memory before the whole of it spills to a temporary file.
+	BodyMemory int64
+	// MaxRecord caps how much of a request or response body is stored in
+	// the database. A larger body is copied whole to a file in the
+	// attachments directory beside the database, and only its start is
+	// stored, with a note naming the file. The agent still gets all of it.
+	MaxRecord int64
+	// MaxRewrite caps the size of request bodies the proxy changes, through
+	// middleware or a session's rewrites and redaction, and of buffered
+	// responses it redacts, which hold a body in memory a few times over.
+	// Larger ones are refused if they would be changed.
+	MaxRewrite int64
+	// Transport carries every upstream call, so connections to a provider
+	// are reused across requests and sessions.
+	Transport http.RoundTripper
+	// Dedupe stores the context blocks agents resend with every request,
+	// such as system prompts and tool definitions, once per database.
+	Dedupe bool
+	// Keys holds the real API key of each provider host. Sessions on those
+	// providers are issued keys of their own, which the proxy swaps for the
+	// real one upstream, so the real key never reaches the agent.
+	Keys map[string]string
+	// Headers holds extra headers to set on every upstream request to each
+	// provider host, such as organization IDs, beta flags or gateway auth.
+	// The agent never sees them.
+	Headers map[string]map[string]string
+	// Middleware sees every completion request and response, in order.
+	Middleware []Middleware
+	// Limits holds the budget of each provider host, shared by all the
+	// sessions on it so parallel cells don't run into each other's 429s.
+	Limits map[string]Limit
+	// Events, when set, is told of every message stored, leak detected
+	// and session finalized.
+	Events *Bus
+	// Tokens, when enabled, are required of setup calls, which must carry
+	// an admin token in auth.Header. Agents share the host's network, so
+	// without them an agent could point the proxy at a session of its own.
+	Tokens auth.Tokens
+
+	mu sync.Mutex
+	// current is the session of the last setup call.
+	current  *session
+	sessions map[string]*session
+	byKey    map[string]*session
+	db       *sql.DB
+	// limiters tracks the budget of each provider host.
+	limiters map[string]*limiter
+	// adapters holds the transport of each adapted upstream in use.
+	adapters map[string]http.RoundTripper
+	// dbPath is the file db writes to.
+	dbPath string
+	// deduper knows the blocks stored in db.
+	deduper *transcripts.Deduper
+	// stats counts what each unfinalized session stored, for its events.
+	stats map[string]*sessionStats
+	// spent is what each unfinalized session with a budget has used of it.
+	spent map[string]*spent
+}
+
+// New returns a Server recording to the database at dbPath and forwarding
+// to upstream until a setup call says otherwise.
+func New(dbPath, upstream string) (*Server, error) {
+	s := &Server{
+		MaxBody:    256 << 20,
+		BodyMemory: 8 << 20,
+		MaxRecord:  16 << 20,
+		MaxRewrite: 32 << 20,
+		Transport:  NewTransport(),
+		Dedupe:     true,
+		Keys:       map[string]string{},
+		Headers:    map[string]map[string]string{},
+		Limits:     map[string]Limit{},
+		current:    &session{setup: Setup{Id: "0", BaseURL: upstream}, ctx: context.Background()},
+		sessions:   map[string]*session{},
+		byKey:      map[string]*session{},
+		limiters:   map[string]*limiter{},
+		adapters:   map[string]http.RoundTripper{},
+		stats:      map[string]*sessionStats{},
+		spent:      map[string]*spent{},
+	}
+	if err := s.openDB(dbPath); err != nil {
+		return nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+	return s, nil
+}
+
+// NewTransport returns the transport a Server uses by default: HTTP/2 where
+// the provider supports it, enough idle connections per host for parallel
+// cells, and timeouts on everything but the response body, which streams
+// for as long as the model generates.
+func NewTransport() *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          256,
+		MaxIdleConnsPerHost:   64,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		// Long prompts can take minutes to first token.
+		ResponseHeaderTimeout: 10 * time.Minute,
+	}
+}
+
+func (s *Server) Close() error {
+	if t, ok := s.Transport.(interface{ CloseIdleConnections() }); ok {
+		t.CloseIdleConnections()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.db.Close()
+}
+
+// openDB switches to the database at path, creating its table. The caller
+// holds s.mu, or has not shared s yet.
+func (s *Server) openDB(path string) error {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+
+	createTableSQL := `CREATE TABLE IF NOT EXISTS messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		session_id TEXT NOT NULL,
+		content TEXT NOT NULL,
+		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	if _, err = db.Exec(createTableSQL); err != nil {
+		db.Close()
+		return err
+	}
+
+	// Databases created before scenarios existed lack the step column,
+	// those from before responses were recorded the direction and endpoint,
+	// those from before replays were marked replay_of, and those from before
+	// large bodies were attached the attachment.
+	for _, column := range []string{
+		`step TEXT NOT NULL DEFAULT ''`,
+		`direction TEXT NOT NULL DEFAULT 'inbound'`,
+		`endpoint TEXT NOT NULL DEFAULT ''`,
+		`replay_of INTEGER NOT NULL DEFAULT 0`,
+		`attachment TEXT NOT NULL DEFAULT ''`,
+	} {
+		if _, err = db.Exec(`ALTER TABLE messages ADD COLUMN ` + column); err != nil && !strings.Contains(err.Error(), "duplicate column") {
+			db.Close()
+			return err
+		}
+	}
+
+	if s.db != nil {
+		s.db.Close()
+	}
+	s.db, s.dbPath = db, path
+	s.deduper = transcripts.NewDeduper()
+	return nil
+}
+
+// saveMessage stores a message, and the chunks it was streamed in if it is
+// a streamed response. attachment names the file holding all of a message
+// stored cut short.
+func (s *Server) saveMessage(ctx context.Context, setup Setup, direction, endpoint, content, attachment string, replayOf int64, chunks []transcripts.Chunk) error {
+	_, span := tracer.Start(ctx, "db write", trace.WithAttributes(
+		attribute.String("session", setup.Id),
+		attribute.String("direction", direction)))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	original := content
+	if s.Dedupe && direction == transcripts.Inbound && attachment == "" {
+		deduped, blocks := s.deduper.Dedupe(setup.Id, content)
+		if err := transcripts.SaveBlocks(s.db, blocks); err != nil {
+			// Keep this message whole, and don't refer to the unsaved
+			// blocks later.
+			log.Printf("Failed to save context blocks: %v", err)
+			s.deduper = transcripts.NewDeduper()
+		} else {
+			content = deduped
+		}
+	}
+	insertSQL := `INSERT INTO messages (session_id, step, direction, endpoint, content, attachment, replay_of) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	res, err := s.db.Exec(insertSQL, setup.Id, setup.Step, direction, endpoint, content, attachment, replayOf)
+	if err == nil {
+		id, _ := res.LastInsertId()
+		if err := transcripts.SaveChunks(s.db, id, chunks); err != nil {
+			log.Printf("Failed to save stream chunks: %v", err)
+		}
+		s.stored(setup, id, direction, endpoint, original)
+	}
+	endSpan(span, err)
+	return err
+}
+
+// record saves a request body to the transcript database, marked as a
+// replay if ex is one. A body over MaxRecord is attached rather than read
+// back into memory.
+func (s *Server) record(ctx context.Context, setup Setup, ex *Exchange, body *body) {
+	rec, err := s.capture(body)
+	if err == nil {
+		err = s.saveMessage(ctx, setup, transcripts.Inbound, ex.Endpoint, rec.content, rec.attachment, ex.ReplayOf, nil)
+	}
+	if err != nil {
+		log.Printf("Failed to save message: %v", err)
+	}
+}
+
+// attachmentNote ends the stored copy of a body over MaxRecord, naming the
+// file, relative to the database, that holds all of it.
+const attachmentNote = "\n[leakbench stored the first %d of %d bytes here; all of them are in %s]\n"
+
+// recorded is what the database keeps of a body.
+type recorded struct {
+	// content is the whole body, or its first MaxRecord bytes and a note
+	// naming attachment.
+	content string
+	// attachment is the file holding all of a body over MaxRecord,
+	// relative to the database.
+	attachment string
+	// tail is the end of a body over MaxRecord, where streams report their
+	// usage and errors.
+	tail string
+}
+
+// capture reads what the database keeps of b. A body over MaxRecord is
+// copied to the attachments directory from where it spilled, so it is
+// never read into memory whole.
+func (s *Server) capture(b *body) (recorded, error) {
+	if b.size <= s.MaxRecord {
+		content, err := b.section(0, b.size)
+		return recorded{content: content}, err
+	}
+
+	s.mu.Lock()
+	dir := filepath.Dir(s.dbPath)
+	s.mu.Unlock()
+	path, err := b.attach(filepath.Join(dir, transcripts.AttachmentDir))
+	if err != nil {
+		return recorded{}, fmt.Errorf("failed to attach message: %w", err)
+	}
+	rec := recorded{attachment: filepath.Join(transcripts.AttachmentDir, filepath.Base(path))}
+	head, err := b.section(0, s.MaxRecord)
+	if err != nil {
+		return recorded{}, err
+	}
+	rec.content = head + fmt.Sprintf(attachmentNote, s.MaxRecord, b.size, rec.attachment)
+	n := min(usageTail, b.size)
+	if rec.tail, err = b.section(b.size-n, n); err != nil {
+		return recorded{}, err
+	}
+	return rec, nil
+}
+
+// recordResponse saves a response body, as the client received it, to the
+// transcript database with the chunks it was streamed in, if any, and
+// spends the tokens it reports from the session's budget. It returns what
+// was recorded, and false if nothing could be.
+func (s *Server) recordResponse(ctx context.Context, setup Setup, endpoint string, buf *spillBuffer) (recorded, bool) {
+	err := buf.err
+	var rec recorded
+	if err == nil {
+		rec, err = s.capture(&buf.body)
+	}
+	if err != nil {
+		log.Printf("Failed to save response: %v", err)
+		return recorded{}, false
+	}
+	if err := s.saveMessage(ctx, setup, transcripts.Outbound, endpoint, rec.content, rec.attachment, 0, buf.chunks); err != nil {
+		log.Printf("Failed to save response: %v", err)
+	}
+	s.spend(setup, rec.content, rec.tail)
+	return rec, true
+}
+
+// recordingBody copies a streamed response body as the proxy reads it to
+// the client, and records the copy once the body is closed.
+type recordingBody struct {
+	io.ReadCloser
+	buf    *spillBuffer
+	record func(buf *spillBuffer)
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	err := b.ReadCloser.Close()
+	defer b.buf.Close()
+	b.record(b.buf)
+	return err
+}
+
+// bufferedBody passes a buffered response on to the client from where it
+// spilled, and removes the file once the proxy is done with it.
+type bufferedBody struct {
+	io.Reader
+	buf *spillBuffer
+}
+
+func (b bufferedBody) Close() error {
+	return b.buf.Close()
+}
+
+// bufferResponse reads a response that isn't streamed through a spill
+// buffer, records it and sets it up to be passed on to the client.
+func (s *Server) bufferResponse(ctx context.Context, setup Setup, ex *Exchange, path string, resp *http.Response) error {
+	buf := newSpillBuffer(s.BodyMemory)
+	_, err := io.Copy(buf, resp.Body)
+	resp.Body.Close()
+	if err == nil {
+		err = buf.err
+	}
+	if err != nil {
+		buf.Close()
+		return err
+	}
+	// The response arrived whole, so its reads aren't chunks of a stream.
+	buf.chunks = nil
+	if rec, ok := s.recordResponse(ctx, setup, path, buf); ok {
+		s.gatewayError(ex, setup.BaseURL, rec.content, rec.tail)
+		s.onComplete(ex, rec.content)
+	}
+
+	// Middleware and redaction may have changed the body's length.
+	if rd := responseRedactor(setup); rd != nil {
+		defer buf.Close()
+		if buf.size > s.MaxRewrite {
+			return fmt.Errorf("response body of %d bytes is over the %d bytes that can be redacted", buf.size, s.MaxRewrite)
+		}
+		content, err := buf.section(0, buf.size)
+		if err != nil {
+			return err
+		}
+		content = rd.replacer.Replace(content)
+		resp.Body = io.NopCloser(strings.NewReader(content))
+		resp.ContentLength = int64(len(content))
+		resp.Header.Set("Content-Length", strconv.Itoa(len(content)))
+		return nil
+	}
+	r, err := buf.reader()
+	if err != nil {
+		buf.Close()
+		return err
+	}
+	resp.Body = bufferedBody{Reader: r, buf: buf}
+	resp.ContentLength = buf.size
+	resp.Header.Set("Content-Length", strconv.FormatInt(buf.size, 10))
+	return nil
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (s *Server) proxyHandler(w http.ResponseWriter, r *http.Request, sess session, ex *Exchange, body *body) {
+	setup := sess.setup
+	ctx, span := tracer.Start(sess.ctx, "upstream call", trace.WithAttributes(
+		attribute.String("session", setup.Id),
+		attribute.String("path", r.URL.Path)))
+	defer span.End()
+
+	path := ex.Endpoint
+	s.record(ctx, setup, ex, body)
+	body, err := redactRequest(setup, body)
+	if err != nil {
+		writeError(w, r, "Failed to redact request body", http.StatusInternalServerError)
+		return
+	}
+
+	target, err := url.Parse(setup.BaseURL)
+	if err != nil {
+		writeError(w, r, "Failed to parse target URL", http.StatusInternalServerError)
+		return
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = s.transport(target)
+	proxy.ErrorHandler = s.upstreamError(setup)
+
+	originalDirector := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		originalDirector(req)
+		req.Host = target.Host
+		s.swapKey(req, sess)
+		s.setHeaders(req, sess)
+		req.URL.Host = target.Host
+		req.URL.Scheme = target.Scheme
+
+		req.URL.RawQuery = ""
+		req.URL.Path = upstreamPath(target.Path, path)
+	}
+
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+		ex.Status = resp.StatusCode
+		s.backoff(setup.BaseURL, resp.StatusCode, resp.Header.Get("Retry-After"))
+		resp.Body = s.onResponse(ex, resp.Body)
+		if resp.Header.Get("Content-Type") == "text/event-stream" {
+			resp.Body = &recordingBody{ReadCloser: resp.Body, buf: newSpillBuffer(s.BodyMemory), record: func(buf *spillBuffer) {
+				if rec, ok := s.recordResponse(ctx, setup, path, buf); ok {
+					s.gatewayError(ex, setup.BaseURL, rec.content, rec.tail)
+					s.onComplete(ex, rec.content)
+				}
+			}}
+			if rd := responseRedactor(setup); rd != nil {
+				resp.Body = newRedactingBody(resp.Body, rd)
+			}
+			return nil
+		}
+
+		return s.bufferResponse(ctx, setup, ex, path, resp)
+	}
+
+	if r.Body, err = body.reader(); err != nil {
+		writeError(w, r, "Failed to read request body", http.StatusInternalServerError)
+		return
+	}
+	r.ContentLength = body.size
+	proxy.ServeHTTP(w, r)
+}
+
+func (s *Server) streamingProxyHandler(w http.ResponseWriter, r *http.Request, sess session, ex *Exchange, body *body) {
+	setup := sess.setup
+	ctx, span := tracer.Start(sess.ctx, "upstream call", trace.WithAttributes(
+		attribute.String("session", setup.Id),
+		attribute.String("path", r.URL.Path)))
+	defer span.End()
+
+	path := ex.Endpoint
+	s.record(ctx, setup, ex, body)
+	body, err := redactRequest(setup, body)
+	if err != nil {
+		writeError(w, r, "Failed to redact request body", http.StatusInternalServerError)
+		return
+	}
+
+	target, err := url.Parse(setup.BaseURL)
+	if err != nil {
+		writeError(w, r, "Failed to parse target URL", http.StatusInternalServerError)
+		return
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = s.transport(target)
+	proxy.ErrorHandler = s.upstreamError(setup)
+
+	originalDirector := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		originalDirector(req)
+		req.Host = target.Host
+		s.swapKey(req, sess)
+		s.setHeaders(req, sess)
+		req.URL.Host = target.Host
+		req.URL.Scheme = target.Scheme
+
+		req.URL.RawQuery = ""
+		req.URL.Path = upstreamPath(target.Path, path)
+	}
+
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+		ex.Status = resp.StatusCode
+		s.backoff(setup.BaseURL, resp.StatusCode, resp.Header.Get("Retry-After"))
+		resp.Body = s.onResponse(ex, resp.Body)
+		if resp.Header.Get("Content-Type") == "text/event-stream" {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("Connection", "keep-alive")
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+
+			for key, values := range resp.Header {
+				for _, value := range values {
+					w.Header().Add(key, value)
+				}
+			}
+
+			w.WriteHeader(resp.StatusCode)
+
+			streamBuffer := newSpillBuffer(s.BodyMemory)
+			defer streamBuffer.Close()
+
+			var client io.Writer = w
+			if rd := responseRedactor(setup); rd != nil {
+				client = &redactingWriter{w: w, s: &streamRedactor{r: rd}}
+			}
+			_, err := io.Copy(io.MultiWriter(client, streamBuffer), resp.Body)
+			if err != nil {
+				log.Printf("Error streaming response: %v", err)
+			}
+			if rw, ok := client.(*redactingWriter); ok {
+				rw.Close()
+			}
+
+			if flusher, ok := w.(http.Flusher); ok {
+				flusher.Flush()
+			}
+			if rec, ok := s.recordResponse(ctx, setup, path, streamBuffer); ok {
+				s.gatewayError(ex, setup.BaseURL, rec.content, rec.tail)
+				s.onComplete(ex, rec.content)
+			}
+
+			return nil
+		}
+
+		return s.bufferResponse(ctx, setup, ex, path, resp)
+	}
+
+	if r.Body, err = body.reader(); err != nil {
+		writeError(w, r, "Failed to read request body", http.StatusInternalServerError)
+		return
+	}
+	r.ContentLength = body.size
+	proxy.ServeHTTP(w, r)
+}
+
+// completionPaths are the endpoints whose requests are recorded. Anything
+// else an agent calls, such as /v1/models or /v1/messages/count_tokens, is
+// passed through untouched.
+var completionPaths = map[string]bool{
+	"/v1/chat/completions": true,
+	"/v1/completions":      true,
+	"/v1/responses":        true,
+	"/v1/messages":         true,
+}
+
+// endpoint returns the provider API path a completion request to path is
+// forwarded to. Bare requests to "/" are chat completions.
+func endpoint(path string) string {
+	path = "/" + strings.TrimPrefix(path, "/")
+	if path == "/" {
+		return "/v1/chat/completions"
+	}
+	if !strings.HasPrefix(path, "/v1") {
+		path = "/v1" + path
+	}
+	return path
+}
+
+// auxiliary reports whether r is a call to an endpoint other than the
+// completion APIs. Setup calls go to "/".
+func auxiliary(r *http.Request) bool {
+	return r.Method != http.MethodPost || !completionPaths[endpoint(r.URL.Path)]
+}
+
+// passthrough forwards r to the session's provider with its method, path
+// and query unchanged, without recording it.
+func (s *Server) passthrough(w http.ResponseWriter, r *http.Request) {
+	sess := s.sessionFor(r)
+	setup := sess.setup
+	_, span := tracer.Start(sess.ctx, "passthrough", trace.WithAttributes(
+		attribute.String("session", setup.Id),
+		attribute.String("path", r.URL.Path)))
+	defer span.End()
+
+	target, err := url.Parse(setup.BaseURL)
+	if err != nil {
+		writeError(w, r, "Failed to parse target URL", http.StatusInternalServerError)
+		return
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = s.transport(target)
+	proxy.ErrorHandler = s.upstreamError(setup)
+
+	originalDirector := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		originalDirector(req)
+		req.Host = target.Host
+		s.swapKey(req, sess)
+		s.setHeaders(req, sess)
+	}
+
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+		log.Printf("Passed through %s %s for session %s: %s", r.Method, r.URL.RequestURI(), setup.Id, resp.Status)
+		return nil
+	}
+
+	proxy.ServeHTTP(w, r)
+}
+
+// ServeHTTP handles setup calls, which point the proxy at a session, passes
+// auxiliary calls through, and forwards completion requests upstream after
+// recording them. Errors, panics included, are answered in the provider's
+// error format.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ew := &errorWriter{ResponseWriter: w}
+	defer s.recoverPanic(ew, r)
+	s.serve(ew, r)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if auxiliary(r) {
+		s.passthrough(w, r)
+		return
+	}
+
+	body, err := readBody(r.Body, s.BodyMemory, s.MaxBody)
+	if err == errTooLarge {
+		writeError(w, r, fmt.Sprintf("Request body over %d bytes", s.MaxBody), http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		writeError(w, r, "Failed to read request body", http.StatusInternalServerError)
+		return
+	}
+	defer body.Close()
+	if body.spilled() {
+		log.Printf("Spilled %d byte request body to disk", body.size)
+	}
+
+	// Setup calls are small, so a spilled body is never one.
+	if !body.spilled() {
+		var setup Setup
+		if err := json.Unmarshal(body.buf, &setup); err != nil {
+			writeError(w, r, "Invalid JSON request", http.StatusBadRequest)
+			return
+		}
+		if setup.BaseURL != "" && setup.Id != "" {
+			if status := s.Tokens.Check(r, auth.Admin); status != 0 {
+				writeError(w, r, "Setup calls need an admin token", status)
+				return
+			}
+		}
+		if setup.BaseURL != "" && setup.Id != "" && setup.Final {
+			s.finalize(setup)
+			return
+		}
+		if setup.BaseURL != "" && setup.Id != "" && setup.Stalled {
+			s.publish(Event{Kind: EventSessionStalled, Session: setup.Id, Step: setup.Step})
+			return
+		}
+		if setup.BaseURL != "" && setup.Id != "" {
+			key, err := s.configure(setup, r)
+			if err != nil {
+				writeError(w, r, fmt.Sprintf("Failed to open database: %v", err), http.StatusInternalServerError)
+				return
+			}
+			if key != "" {
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(map[string]string{"key": key})
+			}
+			return
+		}
+	}
+
+	sess := s.sessionFor(r)
+	ex := &Exchange{Session: sess.setup.Id, Step: sess.setup.Step, Endpoint: endpoint(r.URL.Path), Header: r.Header}
+	if ex.ReplayOf, err = replayOf(sess.setup, r.Header); err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if s.MaxRewrite > 0 && body.size > s.MaxRewrite && (len(s.Middleware) > 0 || rewrites(sess.setup)) {
+		writeError(w, r, fmt.Sprintf("Request body over %d bytes can't be rewritten", s.MaxRewrite), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if body, err = s.onRequest(ex, body); err != nil {
+		writeError(w, r, fmt.Sprintf("Request refused: %v", err), http.StatusForbidden)
+		return
+	}
+	if body, err = rewrite(sess, ex, body); err != nil {
+		var exceeded *contextExceeded
+		if errors.As(err, &exceeded) {
+			writeContextError(w, r, exceeded)
+			return
+		}
+		writeError(w, r, fmt.Sprintf("Failed to rewrite request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := s.charge(sess.setup); err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.throttle(r.Context(), sess, body.size); err != nil {
+		writeError(w, r, fmt.Sprintf("Rate limited: %v", err), http.StatusTooManyRequests)
+		return
+	}
+	if n := sess.setup.Network; n != nil {
+		if w, err = n.degrade(r.Context(), sess.setup.Id, w); err != nil {
+			return
+		}
+	}
+
+	stream, err := body.stream()
+	if err != nil {
+		writeError(w, r, "Invalid JSON request", http.StatusBadRequest)
+		return
+	}
+
+	if stream {
+		s.streamingProxyHandler(w, r, sess, ex, body)
+	} else {
+		s.proxyHandler(w, r, sess, ex, body)
+	}
+}
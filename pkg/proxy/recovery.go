@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// errorWriter remembers whether a response has started, after which an
+// error can no longer be sent in its place.
+type errorWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (w *errorWriter) WriteHeader(status int) {
+	w.started = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *errorWriter) Write(p []byte) (int, error) {
+	w.started = true
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *errorWriter) Flush() {
+	w.started = true
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the connection.
+func (w *errorWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// recoverPanic turns a panic while serving r into a 500 in the provider's
+// error format, so the agent's CLI retries or reports it rather than
+// choking on a dropped connection, and logs it against the session.
+func (s *Server) recoverPanic(w *errorWriter, r *http.Request) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	if v == http.ErrAbortHandler {
+		// The reverse proxy aborting a response the agent stopped reading.
+		panic(v)
+	}
+
+	session := s.sessionFor(r).setup.Id
+	log.Printf("Panic serving %s %s for session %s: %v\n%s", r.Method, r.URL.Path, session, v, debug.Stack())
+	s.publish(Event{Kind: EventProxyError, Session: session, Endpoint: endpoint(r.URL.Path), Status: http.StatusInternalServerError, Error: fmt.Sprint(v)})
+	if w.started {
+		// Too late for an error response; cut the stream instead.
+		panic(http.ErrAbortHandler)
+	}
+	writeError(w, r, "Internal proxy error", http.StatusInternalServerError)
+}
+
+// upstreamError answers a request the provider couldn't be reached for, or
+// whose response couldn't be read, with a 502 in the provider's format.
+func (s *Server) upstreamError(setup Setup) func(http.ResponseWriter, *http.Request, error) {
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		log.Printf("Upstream error for session %s on %s: %v", setup.Id, r.URL.Path, err)
+		s.publish(Event{Kind: EventProxyError, Session: setup.Id, Step: setup.Step, Endpoint: endpoint(r.URL.Path), Status: http.StatusBadGateway, Error: err.Error()})
+		writeError(w, r, fmt.Sprintf("Upstream request failed: %v", err), http.StatusBadGateway)
+	}
+}
+
+// writeError is http.Error the way the provider r is meant for would send it:
+// Anthropic's {"type": "error", "error": {...}} for the Messages API,
+// OpenAI's {"error": {...}} for everything else.
+func writeError(w http.ResponseWriter, r *http.Request, message string, status int) {
+	var body any
+	if r.Header.Get("anthropic-version") != "" || endpoint(r.URL.Path) == "/v1/messages" {
+		kind := "api_error"
+		switch {
+		case status == http.StatusUnauthorized:
+			kind = "authentication_error"
+		case status == http.StatusForbidden:
+			kind = "permission_error"
+		case status == http.StatusNotFound:
+			kind = "not_found_error"
+		case status == http.StatusRequestEntityTooLarge:
+			kind = "request_too_large"
+		case status == http.StatusTooManyRequests:
+			kind = "rate_limit_error"
+		case status < 500:
+			kind = "invalid_request_error"
+		}
+		body = map[string]any{"type": "error", "error": map[string]string{"type": kind, "message": message}}
+	} else {
+		kind, code := "invalid_request_error", any(nil)
+		switch {
+		case status == http.StatusTooManyRequests:
+			kind, code = "requests", "rate_limit_exceeded"
+		case status >= 500:
+			kind = "server_error"
+		}
+		body = map[string]any{"error": map[string]any{"message": message, "type": kind, "param": nil, "code": code}}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,347 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+)
+
+// RedactionMask replaces each planted secret the proxy redacts.
+const RedactionMask = "[REDACTED]"
+
+// Redaction masks the session's planted secrets in what the proxy passes
+// on, while it records the originals: in responses before the agent sees
+// them and, with Requests, in requests before the provider does. It is for
+// finding out whether redaction in the loop changes what agents do next.
+type Redaction struct {
+	Responses bool `json:"responses,omitempty"`
+	Requests  bool `json:"requests,omitempty"`
+}
+
+// streamTextKeys are the JSON fields that carry generated text in the
+// events of a streamed response, deltas and the whole text repeated at the
+// end alike.
+var streamTextKeys = map[string]bool{
+	"text":         true,
+	"content":      true,
+	"delta":        true,
+	"partial_json": true,
+	"arguments":    true,
+	"thinking":     true,
+	"output":       true,
+}
+
+// redactor masks a session's secrets. Values under six bytes are left
+// alone, as they turn up by chance.
+type redactor struct {
+	values   []string
+	replacer *strings.Replacer
+}
+
+// newRedactor returns the redactor of secrets, or nil when there is
+// nothing to mask.
+func newRedactor(secrets map[string]string) *redactor {
+	var values []string
+	for _, value := range secrets {
+		if len(value) >= 6 {
+			values = append(values, value)
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	// Longer values first, so one containing another is masked whole.
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	var pairs []string
+	for _, value := range values {
+		pairs = append(pairs, value, RedactionMask)
+	}
+	return &redactor{values: values, replacer: strings.NewReplacer(pairs...)}
+}
+
+// responseRedactor returns the redactor of the session's responses, or nil
+// when they are passed on as they are.
+func responseRedactor(setup Setup) *redactor {
+	if setup.Redaction == nil || !setup.Redaction.Responses {
+		return nil
+	}
+	return newRedactor(setup.Secrets)
+}
+
+// redactRequest returns b with the session's secrets masked when its
+// requests are redacted, and b otherwise.
+func redactRequest(setup Setup, b *body) (*body, error) {
+	if setup.Redaction == nil || !setup.Redaction.Requests {
+		return b, nil
+	}
+	r := newRedactor(setup.Secrets)
+	if r == nil {
+		return b, nil
+	}
+	content, err := b.String()
+	if err != nil {
+		return nil, err
+	}
+	buf := []byte(r.replacer.Replace(content))
+	return &body{buf: buf, size: int64(len(buf))}, nil
+}
+
+// find returns the first secret in s at or after from, and where it is, or
+// -1 when there is none.
+func (r *redactor) find(s string, from int) (int, string) {
+	at, found := -1, ""
+	for _, value := range r.values {
+		if i := strings.Index(s[from:], value); i >= 0 && (at < 0 || from+i < at) {
+			at, found = from+i, value
+		}
+	}
+	return at, found
+}
+
+// partial returns the length of the longest end of s that begins a secret.
+func (r *redactor) partial(s string) int {
+	longest := 0
+	for _, value := range r.values {
+		for n := min(len(value)-1, len(s)); n > longest; n-- {
+			if strings.HasSuffix(s, value[:n]) {
+				longest = n
+				break
+			}
+		}
+	}
+	return longest
+}
+
+// streamRedactor masks secrets in an SSE stream as it passes. Models
+// generate a secret over several events, so the events that could be the
+// start of one are held back until it is complete, when the text deltas
+// that spell it are rewritten to spell the mask, or until it can't be.
+type streamRedactor struct {
+	r *redactor
+	// partial is the incomplete line last fed.
+	partial []byte
+	// held are the lines held back, and text what their deltas add up to.
+	held []*heldLine
+	text string
+}
+
+// heldLine is a line of a stream held back by a streamRedactor.
+type heldLine struct {
+	raw string
+	// data is the line's event, decoded when it carries text, and pieces
+	// its text, in stream order.
+	data   any
+	pieces []*textPiece
+	dirty  bool
+}
+
+// textPiece is a string of a held event's text, at start in the held text.
+type textPiece struct {
+	start int
+	value string
+	set   func(string)
+}
+
+// feed returns what of p, and of the lines held back before it, can be
+// passed on.
+func (s *streamRedactor) feed(p []byte) []byte {
+	s.partial = append(s.partial, p...)
+	var out bytes.Buffer
+	for {
+		i := bytes.IndexByte(s.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := string(s.partial[:i+1])
+		s.partial = s.partial[i+1:]
+		s.line(&out, line)
+	}
+	return out.Bytes()
+}
+
+// flush returns everything held back, at the end of the stream.
+func (s *streamRedactor) flush() []byte {
+	var out bytes.Buffer
+	s.release(&out, len(s.held))
+	out.WriteString(s.r.replacer.Replace(string(s.partial)))
+	s.partial = nil
+	return out.Bytes()
+}
+
+func (s *streamRedactor) line(out *bytes.Buffer, raw string) {
+	l := &heldLine{raw: raw}
+	if data, ok := strings.CutPrefix(strings.TrimRight(raw, "\r\n"), "data:"); ok {
+		dec := json.NewDecoder(strings.NewReader(data))
+		dec.UseNumber()
+		if dec.Decode(&l.data) == nil {
+			collectPieces(l, l.data, false, func(any) {})
+		}
+	}
+	if len(l.pieces) == 0 && len(s.held) == 0 {
+		out.WriteString(s.r.replacer.Replace(raw))
+		return
+	}
+	for _, p := range l.pieces {
+		p.start = len(s.text)
+		s.text += p.value
+	}
+	s.held = append(s.held, l)
+	s.mask()
+
+	// Lines are passed on once the text that could begin a secret lies
+	// past them.
+	keep := len(s.text) - s.r.partial(s.text)
+	n := 0
+	for n < len(s.held) {
+		if pieces := s.held[n].pieces; len(pieces) > 0 {
+			last := pieces[len(pieces)-1]
+			if last.start+len(last.value) > keep {
+				break
+			}
+		}
+		n++
+	}
+	s.release(out, n)
+}
+
+// mask rewrites every secret complete in the held text to the mask.
+func (s *streamRedactor) mask() {
+	for from := 0; ; {
+		at, value := s.r.find(s.text, from)
+		if at < 0 {
+			return
+		}
+		end := at + len(value)
+		first := true
+		var b strings.Builder
+		for _, l := range s.held {
+			for _, p := range l.pieces {
+				pEnd := p.start + len(p.value)
+				if pEnd > at && p.start < end {
+					rewritten := ""
+					if p.start < at {
+						rewritten = p.value[:at-p.start]
+					}
+					if first {
+						rewritten += RedactionMask
+						first = false
+					}
+					if pEnd > end {
+						rewritten += p.value[end-p.start:]
+					}
+					p.value = rewritten
+					p.set(rewritten)
+					l.dirty = true
+				}
+				p.start = b.Len()
+				b.WriteString(p.value)
+			}
+		}
+		s.text = b.String()
+		from = at + len(RedactionMask)
+	}
+}
+
+// release passes on the first n held lines.
+func (s *streamRedactor) release(out *bytes.Buffer, n int) {
+	released := 0
+	for _, l := range s.held[:n] {
+		for _, p := range l.pieces {
+			released = p.start + len(p.value)
+		}
+		if !l.dirty {
+			out.WriteString(s.r.replacer.Replace(l.raw))
+			continue
+		}
+		var b bytes.Buffer
+		enc := json.NewEncoder(&b)
+		enc.SetEscapeHTML(false)
+		enc.Encode(l.data)
+		out.WriteString("data: ")
+		out.Write(bytes.TrimSuffix(b.Bytes(), []byte("\n")))
+		out.WriteString(l.raw[len(strings.TrimRight(l.raw, "\r\n")):])
+	}
+	s.held = s.held[n:]
+	s.text = s.text[released:]
+	for _, l := range s.held {
+		for _, p := range l.pieces {
+			p.start -= released
+		}
+	}
+}
+
+// collectPieces adds the strings of v whose field is a stream text key to
+// l's pieces, with the functions that replace them in v.
+func collectPieces(l *heldLine, v any, isText bool, set func(any)) {
+	switch v := v.(type) {
+	case string:
+		if isText && v != "" {
+			l.pieces = append(l.pieces, &textPiece{value: v, set: func(s string) { set(s) }})
+		}
+	case []any:
+		for i, item := range v {
+			collectPieces(l, item, isText, func(x any) { v[i] = x })
+		}
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			collectPieces(l, v[key], streamTextKeys[key], func(x any) { v[key] = x })
+		}
+	}
+}
+
+// redactingBody is a response body whose stream is masked by a
+// streamRedactor as it is read.
+type redactingBody struct {
+	io.ReadCloser
+	s       *streamRedactor
+	pending []byte
+	err     error
+}
+
+func newRedactingBody(rc io.ReadCloser, r *redactor) io.ReadCloser {
+	return &redactingBody{ReadCloser: rc, s: &streamRedactor{r: r}}
+}
+
+func (b *redactingBody) Read(p []byte) (int, error) {
+	for len(b.pending) == 0 && b.err == nil {
+		buf := make([]byte, 32<<10)
+		n, err := b.ReadCloser.Read(buf)
+		b.pending = b.s.feed(buf[:n])
+		if err != nil {
+			b.pending = append(b.pending, b.s.flush()...)
+		}
+		b.err = err
+	}
+	n := copy(p, b.pending)
+	b.pending = b.pending[n:]
+	if len(b.pending) > 0 {
+		return n, nil
+	}
+	return n, b.err
+}
+
+// redactingWriter masks the stream written to w with a streamRedactor.
+// Close passes on what it still holds back, without closing w.
+type redactingWriter struct {
+	w io.Writer
+	s *streamRedactor
+}
+
+func (w *redactingWriter) Write(p []byte) (int, error) {
+	if _, err := w.w.Write(w.s.feed(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *redactingWriter) Close() error {
+	_, err := w.w.Write(w.s.flush())
+	return err
+}
@@ -0,0 +1,29 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// ReplayHeader names the recorded message a replayed request copies. The
+// proxy records it with the request, and never forwards it.
+const ReplayHeader = "X-Leakbench-Replay-Of"
+
+// replayOf returns the message a request names in ReplayHeader, which only
+// a replay session's requests may do, and removes the header.
+func replayOf(setup Setup, h http.Header) (int64, error) {
+	value := h.Get(ReplayHeader)
+	h.Del(ReplayHeader)
+	if value == "" {
+		return 0, nil
+	}
+	if !setup.Replay {
+		return 0, fmt.Errorf("%s is only accepted from replay sessions", ReplayHeader)
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("invalid %s %q", ReplayHeader, value)
+	}
+	return id, nil
+}
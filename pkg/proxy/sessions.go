@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// session is a cell the proxy has been told about by a setup call.
+type session struct {
+	setup Setup
+	// ctx carries the orchestrator's span context from the session's last
+	// setup call, so its upstream calls show up under the right agent turn.
+	ctx context.Context
+	// key is the API key issued to the session, if its provider's real key
+	// is known.
+	key string
+}
+
+// configure applies a setup call, returning the key issued to the session,
+// if any.
+func (s *Server) configure(setup Setup, r *http.Request) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if setup.DB != "" && setup.DB != s.dbPath {
+		if err := s.openDB(setup.DB); err != nil {
+			return "", err
+		}
+		log.Printf("Recording messages to %s", setup.DB)
+	}
+
+	// Later steps of a cell keep the key issued for its first.
+	sess := s.sessions[setup.Id]
+	if sess == nil {
+		sess = &session{}
+		s.sessions[setup.Id] = sess
+	}
+	sess.setup = setup
+	sess.ctx = otel.GetTextMapPropagator().Extract(context.Background(), propagation.HeaderCarrier(r.Header))
+	// Adapted upstreams authenticate with the platform's credentials, so
+	// the key is only for attribution.
+	if sess.key == "" && (s.Keys[providerHost(setup.BaseURL)] != "" || adapted(setup.BaseURL)) {
+		sess.key = issueKey()
+		s.byKey[sess.key] = sess
+	}
+	s.current = sess
+	return sess.key, nil
+}
+
+// sessionFor returns the session r belongs to: the one issued the API key
+// it carries, else the session of the last setup call. The containers share
+// the host's network, so their addresses can't tell cells apart.
+func (s *Server) sessionFor(r *http.Request) session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key := requestKey(r.Header); key != "" {
+		if sess, ok := s.byKey[key]; ok {
+			return *sess
+		}
+	}
+	return *s.current
+}
+
+// swapKey replaces the session's issued key in an upstream request with
+// the provider's real one.
+func (s *Server) swapKey(req *http.Request, sess session) {
+	if sess.key == "" {
+		return
+	}
+	real := s.Keys[req.URL.Host]
+	if real == "" {
+		return
+	}
+	if req.Header.Get("x-api-key") == sess.key {
+		req.Header.Set("x-api-key", real)
+	}
+	if req.Header.Get("Authorization") == "Bearer "+sess.key {
+		req.Header.Set("Authorization", "Bearer "+real)
+	}
+}
+
+// setHeaders sets the headers configured for the provider and for the
+// session on an upstream request.
+func (s *Server) setHeaders(req *http.Request, sess session) {
+	for _, headers := range []map[string]string{s.Headers[req.URL.Host], sess.setup.Headers} {
+		for name, value := range headers {
+			if value == "" {
+				req.Header.Del(name)
+			} else {
+				req.Header.Set(name, value)
+			}
+		}
+	}
+}
+
+// requestKey returns the API key a request authenticates with, in either
+// the Anthropic or the OpenAI header.
+func requestKey(h http.Header) string {
+	if key := h.Get("x-api-key"); key != "" {
+		return key
+	}
+	return strings.TrimPrefix(h.Get("Authorization"), "Bearer ")
+}
+
+func providerHost(baseURL string) string {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// issueKey returns a random key shaped enough like a provider's that agents
+// accept it.
+func issueKey() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return "sk-leakbench-" + hex.EncodeToString(b)
+}
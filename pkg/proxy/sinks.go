@@ -0,0 +1,215 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OpenSink returns the event sink described by rawURL:
+// file:///path/events.jsonl (or a bare path), nats://host:4222/subject, or
+// kafka://host:8082/topic for a Kafka REST proxy (kafka+https:// over TLS).
+func OpenSink(rawURL string) (Sink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid event sink URL: %w", err)
+	}
+
+	switch u.Scheme {
+	case "file", "":
+		return newFileSink(filepath.Join(u.Host, u.Path))
+	case "nats":
+		return newNATSSink(u)
+	case "kafka", "kafka+http", "kafka+https":
+		return newKafkaSink(u)
+	default:
+		return nil, fmt.Errorf("unsupported event sink scheme %q", u.Scheme)
+	}
+}
+
+// fileSink appends events to a file as JSON lines.
+type fileSink struct {
+	f   *os.File
+	enc *json.Encoder
+}
+
+func newFileSink(path string) (*fileSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open event file: %w", err)
+	}
+	return &fileSink{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (s *fileSink) Send(e Event) error { return s.enc.Encode(e) }
+
+func (s *fileSink) Close() error { return s.f.Close() }
+
+// natsSink publishes events to a NATS subject over the core text protocol,
+// reconnecting when the connection drops.
+type natsSink struct {
+	addr    string
+	subject string
+	// connect is the CONNECT line sent on every connection.
+	connect []byte
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newNATSSink(u *url.URL) (*natsSink, error) {
+	subject := strings.Trim(u.Path, "/")
+	if subject == "" {
+		return nil, fmt.Errorf("NATS sink URL is missing a subject")
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+
+	opts := map[string]any{"verbose": false, "pedantic": false, "name": "leakbench-proxy", "lang": "go"}
+	if password, ok := u.User.Password(); ok {
+		opts["user"], opts["pass"] = u.User.Username(), password
+	} else if u.User != nil {
+		opts["auth_token"] = u.User.Username()
+	}
+	b, err := json.Marshal(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &natsSink{addr: addr, subject: strings.ReplaceAll(subject, "/", "."), connect: append(append([]byte("CONNECT "), b...), "\r\n"...)}
+	if err := s.dial(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// dial connects to the server and answers its pings in the background.
+// The caller holds s.mu, or has not shared s yet.
+func (s *natsSink) dial() error {
+	conn, err := net.DialTimeout("tcp", s.addr, 10*time.Second)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS at %s: %w", s.addr, err)
+	}
+	r := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if line, err := r.ReadString('\n'); err != nil || !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("unexpected NATS greeting from %s: %q %v", s.addr, line, err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	if _, err := conn.Write(s.connect); err != nil {
+		conn.Close()
+		return err
+	}
+	s.conn = conn
+	go s.read(conn, r)
+	return nil
+}
+
+// read answers the server's pings on conn and logs its errors until the
+// connection closes.
+func (s *natsSink) read(conn net.Conn, r *bufio.Reader) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch {
+		case strings.HasPrefix(line, "PING"):
+			s.mu.Lock()
+			conn.Write([]byte("PONG\r\n"))
+			s.mu.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			log.Printf("NATS server %s: %s", s.addr, strings.TrimSpace(line))
+		}
+	}
+}
+
+func (s *natsSink) Send(e Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	msg := fmt.Appendf(nil, "PUB %s %d\r\n%s\r\n", s.subject, len(payload), payload)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		if _, err = s.conn.Write(msg); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	if err := s.dial(); err != nil {
+		return err
+	}
+	_, err = s.conn.Write(msg)
+	return err
+}
+
+func (s *natsSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+// kafkaSink produces events to a topic through a Kafka REST proxy, keyed
+// by session so each session's events stay in order on one partition.
+type kafkaSink struct {
+	url    string
+	client *http.Client
+}
+
+func newKafkaSink(u *url.URL) (*kafkaSink, error) {
+	topic := strings.Trim(u.Path, "/")
+	if topic == "" || strings.Contains(topic, "/") {
+		return nil, fmt.Errorf("Kafka sink URL needs a single topic, got %q", u.Path)
+	}
+	scheme := "http"
+	if u.Scheme == "kafka+https" {
+		scheme = "https"
+	}
+	endpoint := url.URL{Scheme: scheme, User: u.User, Host: u.Host, Path: "/topics/" + topic}
+	return &kafkaSink{url: endpoint.String(), client: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+func (s *kafkaSink) Send(e Event) error {
+	body, err := json.Marshal(map[string]any{
+		"records": []map[string]any{{"key": e.Session, "value": e}},
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, "application/vnd.kafka.json.v2+json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("Kafka REST proxy returned %s: %s", resp.Status, bytes.TrimSpace(b))
+	}
+	return nil
+}
+
+func (s *kafkaSink) Close() error { return nil }
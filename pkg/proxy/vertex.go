@@ -0,0 +1,267 @@
+package proxy
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// vertex serves Anthropic messages requests from the Claude models Vertex
+// AI publishes, and OpenAI chat completions from its OpenAI-compatible
+// endpoint for Gemini and the other models it hosts.
+type vertex struct {
+	next    http.RoundTripper
+	region  string
+	project string
+	token   *googleToken
+	memory  int64
+}
+
+func newVertex(next http.RoundTripper, target *url.URL, memory int64) (http.RoundTripper, error) {
+	project := strings.Trim(target.Path, "/")
+	if target.Host == "" || project == "" {
+		return nil, fmt.Errorf("vertex URL names no region and project, as in vertex://us-east5/my-project")
+	}
+	token, err := newGoogleToken(next)
+	if err != nil {
+		return nil, err
+	}
+	return &vertex{next: next, region: target.Host, project: project, token: token, memory: memory}, nil
+}
+
+func (v *vertex) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := v.region + "-aiplatform.googleapis.com"
+	if v.region == "global" {
+		host = "aiplatform.googleapis.com"
+	}
+	base := fmt.Sprintf("https://%s/v1/projects/%s/locations/%s", host, v.project, v.region)
+
+	// Chat completions go on as they are; messages requests are rewritten.
+	out := req.Clone(req.Context())
+	var target string
+	messages := false
+	switch path := strings.TrimPrefix(req.URL.Path, "/"+v.project); path {
+	case "/v1/messages":
+		model, stream, body, err := anthropicBody(req, map[string]any{"anthropic_version": "vertex-2023-10-16"}, true, v.memory)
+		if err != nil {
+			return anthropicError(req, http.StatusBadRequest, err.Error()), nil
+		}
+		r, err := body.reader()
+		if err != nil {
+			body.Close()
+			return nil, err
+		}
+		method := "rawPredict"
+		if stream {
+			method = "streamRawPredict"
+		}
+		target, messages = base+"/publishers/anthropic/models/"+model+":"+method, true
+		out.Body, out.ContentLength = bufferedBody{Reader: r, buf: body}, body.size
+	case "/v1/chat/completions":
+		target = base + "/endpoints/openapi/chat/completions"
+	default:
+		closeBody(req)
+		return anthropicError(req, http.StatusNotFound, path+" is not served by Vertex AI"), nil
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		closeBody(out)
+		return nil, err
+	}
+	token, err := v.token.get(req.Context())
+	if err != nil {
+		closeBody(out)
+		return nil, err
+	}
+	out.URL, out.Host = u, ""
+	out.Header.Del("Content-Length")
+	out.Header.Del("anthropic-version")
+	stripAuth(out)
+	out.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := v.next.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	resp.Request = req
+	if messages && resp.StatusCode != http.StatusOK {
+		// Google reports errors as {"error": {...}}, sometimes in a list.
+		msg, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		var e struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		var list []json.RawMessage
+		if json.Unmarshal(msg, &list) == nil && len(list) > 0 {
+			json.Unmarshal(list[0], &e)
+		} else {
+			json.Unmarshal(msg, &e)
+		}
+		if e.Error.Message == "" {
+			e.Error.Message = strings.TrimSpace(string(msg))
+		}
+		return anthropicError(req, resp.StatusCode, e.Error.Message), nil
+	}
+	return resp, nil
+}
+
+// googleToken is an OAuth access token for Google Cloud, minted from the
+// application default credentials and renewed before it expires.
+type googleToken struct {
+	next  http.RoundTripper
+	creds googleCredentials
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// googleCredentials is an application default credentials file: a service
+// account key or the user credentials of gcloud auth application-default
+// login.
+type googleCredentials struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// newGoogleToken reads the application default credentials, or takes the
+// token in GOOGLE_OAUTH_ACCESS_TOKEN as it is.
+func newGoogleToken(next http.RoundTripper) (*googleToken, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return &googleToken{token: token, expiry: time.Now().Add(100 * 365 * 24 * time.Hour)}, nil
+	}
+
+	path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(home, ".config", "gcloud", "application_default_credentials.json")
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Google credentials: %w", err)
+	}
+	var creds googleCredentials
+	if err := json.Unmarshal(b, &creds); err != nil {
+		return nil, fmt.Errorf("failed to parse Google credentials %s: %w", path, err)
+	}
+	if creds.Type != "service_account" && creds.Type != "authorized_user" {
+		return nil, fmt.Errorf("unsupported Google credentials type %q in %s", creds.Type, path)
+	}
+	return &googleToken{next: next, creds: creds}, nil
+}
+
+func (t *googleToken) get(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" && time.Now().Before(t.expiry.Add(-time.Minute)) {
+		return t.token, nil
+	}
+
+	form := url.Values{}
+	tokenURI := "https://oauth2.googleapis.com/token"
+	switch t.creds.Type {
+	case "service_account":
+		if t.creds.TokenURI != "" {
+			tokenURI = t.creds.TokenURI
+		}
+		assertion, err := t.assertion(tokenURI)
+		if err != nil {
+			return "", err
+		}
+		form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+		form.Set("assertion", assertion)
+	case "authorized_user":
+		form.Set("grant_type", "refresh_token")
+		form.Set("client_id", t.creds.ClientID)
+		form.Set("client_secret", t.creds.ClientSecret)
+		form.Set("refresh_token", t.creds.RefreshToken)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := (&http.Client{Transport: t.next, Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get Google access token: %w", err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to get Google access token: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get Google access token: %s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	var issued struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(b, &issued); err != nil || issued.AccessToken == "" {
+		return "", fmt.Errorf("failed to parse Google access token response")
+	}
+	t.token = issued.AccessToken
+	t.expiry = time.Now().Add(time.Duration(issued.ExpiresIn) * time.Second)
+	return t.token, nil
+}
+
+// assertion returns a JWT signed with the service account's key, which
+// the token endpoint exchanges for an access token.
+func (t *googleToken) assertion(audience string) (string, error) {
+	block, _ := pem.Decode([]byte(t.creds.PrivateKey))
+	if block == nil {
+		return "", fmt.Errorf("invalid service account private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if err != nil || !ok {
+		return "", fmt.Errorf("invalid service account private key")
+	}
+
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]any{
+		"iss":   t.creds.ClientEmail,
+		"scope": "https://www.googleapis.com/auth/cloud-platform",
+		"aud":   audience,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign Google token request: %w", err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
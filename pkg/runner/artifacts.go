@@ -0,0 +1,289 @@
+package runner
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/leakbenchmark/deployer/internal/grading"
+	"github.com/leakbenchmark/deployer/internal/report"
+	"github.com/leakbenchmark/deployer/pkg/analyzer"
+	"github.com/leakbenchmark/deployer/pkg/deployer"
+)
+
+func writeCellLog(runDir, id string, out []byte) error {
+	logDir := filepath.Join(runDir, "logs")
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filepath.Join(logDir, id+".log"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(out)
+	return err
+}
+
+func writeGrade(runDir string, grade *grading.Grade) error {
+	gradeDir := filepath.Join(runDir, "grades")
+	if err := os.MkdirAll(gradeDir, 0755); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(grade, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(gradeDir, grade.Session+".json"), b, 0644)
+}
+
+// writeServiceLog writes the output of the project's service, run for
+// grading, to services/<session>.log.
+func writeServiceLog(runDir, id, output string) error {
+	serviceDir := filepath.Join(runDir, "services")
+	if err := os.MkdirAll(serviceDir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(serviceDir, id+".log"), []byte(output), 0644)
+}
+
+// cellMarker is touched before an agent starts so the files it writes can
+// be told apart from the project and from earlier cells.
+const cellMarker = "/tmp/.leakbench-cell-start"
+
+// authoredFilesCmd archives every file newer than cellMarker, leaving out
+// dependencies, caches and git internals. Files over 1MB are skipped.
+var authoredFilesCmd = `find / -xdev \( -path /proc -o -path /sys -o -path /dev -o -path ` + deployer.CacheDir + ` -o -name node_modules -o -name .git -o -name .npm -o -name .cache \) -prune ` +
+	`-o -type f -newer ` + cellMarker + ` -size -1024k -print0 | tar --null -cf - -T - 2>/dev/null`
+
+// cellCommits lists the commits in the project before the agent starts, so
+// only the ones it makes are scanned.
+const cellCommits = "/tmp/.leakbench-cell-commits"
+
+// cellRefs lists the project's branches and tags, with the commits they
+// point at, before the agent starts.
+const cellRefs = "/tmp/.leakbench-cell-refs"
+
+// refsFormat prints a ref as "<commit> <ref>", peeling annotated tags.
+const refsFormat = "'%(if)%(*objectname)%(then)%(*objectname)%(else)%(objectname)%(end) %(refname)'"
+
+// gitCmd runs git in workdir, printing non-ASCII paths as they are so
+// secrets in them can be matched.
+func gitCmd(workdir string) string {
+	return "git -c safe.directory='*' -c core.quotePath=false -C " + workdir
+}
+
+func markCellStart(containerID, workdir string) error {
+	git := gitCmd(workdir)
+	cmd := "touch " + cellMarker + " && (" + git + " rev-list --all 2>/dev/null || true) > " + cellCommits +
+		" && (" + git + " for-each-ref --format=" + refsFormat + " 2>/dev/null || true) > " + cellRefs
+	return exec.Command("docker", "exec", "-u", "root", containerID[:12], "/bin/bash", "-c", cmd).Run()
+}
+
+// collectCommits writes the commits made during a cell, with their messages,
+// patches, authors and the refs created or moved to them, to
+// commits/<session>.json.
+func collectCommits(containerID, workdir, id, runDir string) error {
+	git := gitCmd(workdir)
+	list := git + " rev-list --reverse --all 2>/dev/null | grep -vxFf " + cellCommits + " || true"
+	out, err := exec.Command("docker", "exec", "-u", "root", containerID[:12], "/bin/bash", "-c", list).Output()
+	if err != nil {
+		return fmt.Errorf("failed to list commits: %w", err)
+	}
+	shas := strings.Fields(string(out))
+
+	list = git + " for-each-ref --format=" + refsFormat + " 2>/dev/null | grep -vxFf " + cellRefs + " || true"
+	out, err = exec.Command("docker", "exec", "-u", "root", containerID[:12], "/bin/bash", "-c", list).Output()
+	if err != nil {
+		return fmt.Errorf("failed to list refs: %w", err)
+	}
+	refs := map[string][]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if sha, ref, ok := strings.Cut(line, " "); ok {
+			refs[sha] = append(refs[sha], ref)
+		}
+	}
+	if len(shas) == 0 && len(refs) == 0 {
+		return nil
+	}
+
+	var commits []analyzer.Commit
+	for _, sha := range shas {
+		meta, err := exec.Command("docker", "exec", "-u", "root", containerID[:12], "/bin/bash", "-c", git+" log -1 --format='%an <%ae>%n%cn <%ce>%n%B' "+sha).Output()
+		if err != nil {
+			return fmt.Errorf("failed to read commit %s: %w", sha, err)
+		}
+		patch, err := exec.Command("docker", "exec", "-u", "root", containerID[:12], "/bin/bash", "-c", git+" show --format= --patch "+sha).Output()
+		if err != nil {
+			return fmt.Errorf("failed to read commit %s: %w", sha, err)
+		}
+		lines := strings.SplitN(string(meta), "\n", 3)
+		for len(lines) < 3 {
+			lines = append(lines, "")
+		}
+		commits = append(commits, analyzer.Commit{SHA: sha, Message: lines[2], Patch: string(patch), Author: lines[0], Committer: lines[1], Refs: refs[sha]})
+		delete(refs, sha)
+	}
+	// What is left are refs to commits from before the cell.
+	for _, sha := range slices.Sorted(maps.Keys(refs)) {
+		commits = append(commits, analyzer.Commit{SHA: sha, Refs: refs[sha]})
+	}
+
+	commitDir := filepath.Join(runDir, "commits")
+	if err := os.MkdirAll(commitDir, 0755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(commits, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(commitDir, id+".json"), b, 0644)
+}
+
+// collectAuthoredFiles writes the files created or modified during a cell to
+// files/<session>.tar, for the analyzer to scan.
+func collectAuthoredFiles(containerID, id, runDir string) error {
+	fileDir := filepath.Join(runDir, "files")
+	if err := os.MkdirAll(fileDir, 0755); err != nil {
+		return err
+	}
+
+	out, err := exec.Command("docker", "exec", "-u", "root", containerID[:12], "/bin/bash", "-c", authoredFilesCmd).Output()
+	if err != nil {
+		return fmt.Errorf("failed to archive agent files: %w", err)
+	}
+	return os.WriteFile(filepath.Join(fileDir, id+".tar"), out, 0644)
+}
+
+// agentLogsCmd archives the session logs the agents keep themselves that
+// were written during a cell: Claude Code's projects and Codex's rollouts.
+var agentLogsCmd = `find / -xdev \( -path /proc -o -path /sys -o -path /dev \) -prune ` +
+	`-o -type f -name '*.jsonl' \( -path '*/.claude/projects/*' -o -path '*/.codex/sessions/*' \) -newer ` + cellMarker + ` -print0 | tar --null -cf - -T - 2>/dev/null`
+
+// collectAgentLogs writes the agents' own session logs from a cell to
+// agent_logs/<session>.tar, for the import command.
+func collectAgentLogs(containerID, id, runDir string) error {
+	logDir := filepath.Join(runDir, "agent_logs")
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return err
+	}
+
+	out, err := exec.Command("docker", "exec", "-u", "root", containerID[:12], "/bin/bash", "-c", agentLogsCmd).Output()
+	if err != nil {
+		return fmt.Errorf("failed to archive agent logs: %w", err)
+	}
+	return os.WriteFile(filepath.Join(logDir, id+".tar"), out, 0644)
+}
+
+// collectFileChanges writes the secret-bearing files that differ from what
+// was planted after a cell, with both versions, to file_changes/<session>.json,
+// and the secrets the agent rotated, deleted or copied to
+// mutations/<session>.json. Copies are looked for in the files collected by
+// collectAuthoredFiles, which must run first.
+func collectFileChanges(result *deployer.DeploymentResult, id, runDir string) error {
+	files := make([]string, 0, len(result.Planted))
+	for f := range result.Planted {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+
+	var changes []report.FileChange
+	cell := report.Files{Planted: map[string]string{}, After: map[string]string{}}
+	for _, f := range files {
+		p := path.Join(result.Workdir, f)
+		cell.Planted[p] = result.Planted[f]
+		cmd := exec.Command("docker", "exec", "-u", "root", result.ContainerID[:12], "/bin/bash", "-c", `test -f "$0" || exit 3; cat "$0"`, p)
+		out, err := cmd.Output()
+		var exitErr *exec.ExitError
+		switch {
+		case errors.As(err, &exitErr) && exitErr.ExitCode() == 3:
+			changes = append(changes, report.FileChange{File: p, Planted: result.Planted[f], Deleted: true})
+			continue
+		case err != nil:
+			return fmt.Errorf("failed to read %s: %w", p, err)
+		case string(out) != result.Planted[f]:
+			changes = append(changes, report.FileChange{File: p, Planted: result.Planted[f], After: string(out)})
+		}
+		cell.After[p] = string(out)
+	}
+	if err := writeArtifact(runDir, "file_changes", id, changes); err != nil {
+		return err
+	}
+
+	var err error
+	if cell.Written, err = writtenFiles(filepath.Join(runDir, "files", id+".tar"), cell.Planted); err != nil {
+		return err
+	}
+	var secrets []report.Secret
+	if result.Secrets != nil {
+		for _, s := range result.Secrets.Named() {
+			secrets = append(secrets, report.Secret{ID: s.ID, Value: s.Value})
+		}
+	}
+	return writeArtifact(runDir, "mutations", id, report.DetectMutations(cell, secrets))
+}
+
+// writtenFiles reads the files in a cell's archive of authored files, but
+// those in skip and the agents' own session logs, which hold whatever they
+// read. A missing archive has none.
+func writtenFiles(archive string, skip map[string]string) (map[string]string, error) {
+	f, err := os.Open(archive)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	written := map[string]string{}
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", archive, err)
+		}
+		name := "/" + hdr.Name
+		if _, ok := skip[name]; ok || hdr.Typeflag != tar.TypeReg || strings.Contains(name, "/.claude/") || strings.Contains(name, "/.codex/") {
+			continue
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s from %s: %w", hdr.Name, archive, err)
+		}
+		written[name] = string(b)
+	}
+	return written, nil
+}
+
+// writeArtifact writes v as dir/<session>.json in the run, unless it is
+// empty.
+func writeArtifact[T any](runDir, dir, id string, v []T) error {
+	if len(v) == 0 {
+		return nil
+	}
+	artifactDir := filepath.Join(runDir, dir)
+	if err := os.MkdirAll(artifactDir, 0755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(artifactDir, id+".json"), b, 0644)
+}
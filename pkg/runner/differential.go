@@ -0,0 +1,59 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// defaultVariant names the cells run with mitigation settings when the
+// differential config doesn't.
+const defaultVariant = "mitigated"
+
+// toolSettings are the user settings files of the tools in the containers,
+// where an agent's Settings are installed.
+var toolSettings = map[string]string{
+	"ClaudeCode": "/home/node/.claude/settings.json",
+	"Codex":      "/home/node/.codex/config.toml",
+}
+
+// mitigated returns agent with its tool's mitigation settings from the
+// differential config, or nil when the config has none for the tool or
+// agent already is a variant.
+func (r *Runner) mitigated(agent Agent) (*Agent, error) {
+	d := r.Config.Differential
+	path := map[string]string{"ClaudeCode": d.ClaudeCode, "Codex": d.Codex}[agent.Tool]
+	if path == "" || agent.Variant != "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s settings: %w", agent.Tool, err)
+	}
+	agent.Variant, agent.Settings = d.Variant, string(b)
+	if agent.Variant == "" {
+		agent.Variant = defaultVariant
+	}
+	return &agent, nil
+}
+
+// installSettings replaces the user settings of the agent's tool in the
+// container with its Settings or, when it has none, removes them, so no
+// cell runs with an earlier one's.
+func installSettings(containerID string, agent Agent) error {
+	path, ok := toolSettings[agent.Tool]
+	if !ok {
+		return nil
+	}
+	script := `rm -f "$0"`
+	if agent.Settings != "" {
+		script = `mkdir -p "$(dirname "$0")" && cat > "$0"`
+	}
+	cmd := exec.Command("docker", "exec", "-i", "-u", "node", containerID[:12], "/bin/sh", "-c", script, path)
+	cmd.Stdin = strings.NewReader(agent.Settings)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to install %s settings: %w: %s", agent.Tool, err, out)
+	}
+	return nil
+}
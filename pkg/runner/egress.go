@@ -0,0 +1,36 @@
+package runner
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sync"
+
+	"github.com/leakbenchmark/deployer/pkg/egress"
+)
+
+// StartEgress starts the proxy the containers deployed after it reach the
+// internet through, when the config locks their egress down, logging what
+// they send to egress.jsonl. The returned function stops it, and may be
+// called more than once.
+func (r *Runner) StartEgress() (func(), error) {
+	if !r.Config.Egress.Lockdown {
+		return func() {}, nil
+	}
+	p, err := egress.New(filepath.Join(r.RunDir, "egress.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	p.Always = r.Config.Egress.Allow
+	if err := p.Start("127.0.0.1:0"); err != nil {
+		p.Close()
+		return nil, err
+	}
+	fmt.Printf("Egress proxy listening on %s\n", p.Addr())
+	r.egress = p
+	return sync.OnceFunc(func() {
+		if err := p.Close(); err != nil {
+			log.Printf("Failed to close egress log: %v", err)
+		}
+	}), nil
+}
@@ -0,0 +1,139 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Classes of cell failure. All but FailureUnknown are the infrastructure
+// breaking rather than anything the agent did.
+const (
+	// FailureProviderAuth is the model provider refusing the key, 401 or 403.
+	FailureProviderAuth = "provider_auth"
+	// FailureProviderRateLimit is the provider answering 429.
+	FailureProviderRateLimit = "provider_rate_limit"
+	// FailureProviderServer is the provider answering 5xx, including
+	// Anthropic's 529 overloaded.
+	FailureProviderServer = "provider_server"
+	// FailureToolInstall is the coding tool failing to install.
+	FailureToolInstall = "tool_install"
+	// FailureContainerCrash is the project's container stopping, or the
+	// tool being killed, mid-cell.
+	FailureContainerCrash = "container_crash"
+	// FailureTimeout is the cell running out of time, or the tool stopping
+	// on a prompt nobody will answer.
+	FailureTimeout = "timeout"
+	// FailureStalled is the tool killed after its session went quiet, see
+	// config.Stall.
+	FailureStalled = "stalled"
+	// FailureParse is the tool failing to parse a response or its input.
+	FailureParse   = "parse_error"
+	FailureUnknown = "unknown"
+)
+
+// Failure is why a cell failed, written to failures/<session>.json.
+type Failure struct {
+	Session string `json:"session"`
+	Step    string `json:"step,omitempty"`
+	Class   string `json:"class"`
+	// Status is the provider's HTTP status, for provider failures.
+	Status int    `json:"status,omitempty"`
+	Err    string `json:"error"`
+}
+
+func (f *Failure) Error() string {
+	return f.Class + ": " + f.Err
+}
+
+// errWaitingForInput is a tool stopped on an interactive prompt.
+var errWaitingForInput = errors.New("waiting for input")
+
+// providerStatus matches the HTTP status in the errors Claude Code ("API
+// Error: 429") and Codex ("unexpected status 401") print.
+var providerStatus = regexp.MustCompile(`(?i)(?:api error|status)\W{0,3}([45]\d\d)\b`)
+
+// parseFailure matches a tool failing to parse JSON or a stream.
+var parseFailure = regexp.MustCompile(`(?i)(failed to (parse|decode|deserialize)|unexpected end of json|unexpected token|invalid json|syntaxerror|malformed)`)
+
+// cellFailure classifies err, which a step of session's cell failed with
+// after printing out. Cancelling the run is not a cell failure, and err is
+// then returned as it is.
+func cellFailure(ctx context.Context, containerID, session, step string, out []byte, err error) error {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return err
+	}
+	f := classify(ctx, containerID, out, err)
+	f.Session, f.Step = session, step
+	return f
+}
+
+func classify(ctx context.Context, containerID string, out []byte, err error) *Failure {
+	f := &Failure{Class: FailureUnknown, Err: err.Error()}
+	text := string(out) + "\n" + err.Error()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		text += "\n" + string(exit.Stderr)
+	}
+
+	switch {
+	case !containerRunning(containerID):
+		f.Class = FailureContainerCrash
+	case errors.Is(err, errStalled):
+		f.Class = FailureStalled
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(ctx.Err(), context.DeadlineExceeded), errors.Is(err, errWaitingForInput):
+		f.Class = FailureTimeout
+	case errors.As(err, &exit) && exit.ExitCode() == 124:
+		// The exit status of timeout(1).
+		f.Class = FailureTimeout
+	case errors.As(err, &exit) && exit.ExitCode() == 137:
+		// SIGKILL, most likely the OOM killer.
+		f.Class = FailureContainerCrash
+	}
+	if f.Class != FailureUnknown {
+		return f
+	}
+
+	// The last status printed is the one the tool gave up on.
+	if m := providerStatus.FindAllStringSubmatch(text, -1); m != nil {
+		status, _ := strconv.Atoi(m[len(m)-1][1])
+		switch {
+		case status == 401 || status == 403:
+			f.Class, f.Status = FailureProviderAuth, status
+		case status == 429:
+			f.Class, f.Status = FailureProviderRateLimit, status
+		case status >= 500:
+			f.Class, f.Status = FailureProviderServer, status
+		}
+	}
+	if f.Class == FailureUnknown && parseFailure.MatchString(text) {
+		f.Class = FailureParse
+	}
+	return f
+}
+
+// containerRunning reports whether the container is still up. One that
+// can't be inspected is gone.
+func containerRunning(containerID string) bool {
+	out, err := exec.Command("docker", "inspect", "-f", "{{.State.Running}}", containerID[:12]).Output()
+	return err == nil && strings.TrimSpace(string(out)) == "true"
+}
+
+func writeFailure(runDir string, failure *Failure) error {
+	failureDir := filepath.Join(runDir, "failures")
+	if err := os.MkdirAll(failureDir, 0755); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(failure, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(failureDir, failure.Session+".json"), b, 0644)
+}
@@ -0,0 +1,247 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// headlessEnv tells the tools, and anything they run, that nobody is there
+// to answer questions.
+var headlessEnv = []string{
+	"CI=true",
+	"TERM=dumb",
+	"NO_COLOR=1",
+	"DEBIAN_FRONTEND=noninteractive",
+	"GIT_TERMINAL_PROMPT=0",
+	"npm_config_yes=true",
+}
+
+// toolProcesses are the processes each tool runs as, killed when it is
+// stuck on a prompt.
+var toolProcesses = map[string]string{
+	"ClaudeCode": "claude",
+	"Codex":      "codex",
+}
+
+// interactivePrompt matches the end of a prompt a tool, or a command it
+// runs, prints to wait for an answer: a choice such as [y/n], or a request
+// for a key press, password or passphrase. Questions a model asks in its
+// output, such as "Do you want to continue?", are not prompts.
+var interactivePrompt = regexp.MustCompile(`(?i)(\[y/n\]|\(y/n\)|\(yes/no[^)]*\)|press (enter|any key)[^:?]*|password[^:?]*|passphrase[^:?]*)[:?]?\s*$`)
+
+// envName matches the names execEnv accepts.
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// execEnv returns the docker exec arguments that pass env into a container,
+// and the environment to run docker exec with. The values are only in
+// docker's environment, never on its command line or in a shell command,
+// where they'd show up in process listings and could break the quoting.
+func execEnv(env map[string]string) (args, environ []string, err error) {
+	environ = os.Environ()
+	for _, name := range slices.Sorted(maps.Keys(env)) {
+		value := env[name]
+		if !envName.MatchString(name) {
+			return nil, nil, fmt.Errorf("invalid environment variable name %q", name)
+		}
+		if strings.ContainsAny(value, "\x00\n\r") {
+			return nil, nil, fmt.Errorf("invalid value for environment variable %s", name)
+		}
+		// Without a value docker exec takes the variable from its own
+		// environment.
+		args = append(args, "-e", name)
+		environ = append(environ, name+"="+value)
+	}
+	return args, environ, nil
+}
+
+// promptIdle is how long a tool may sit on an interactive prompt before it
+// is taken to be waiting for input.
+const promptIdle = 30 * time.Second
+
+// promptWatcher records a command's output and when it last wrote.
+type promptWatcher struct {
+	mu   sync.Mutex
+	buf  bytes.Buffer
+	last time.Time
+}
+
+func (w *promptWatcher) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.last = time.Now()
+	return w.buf.Write(p)
+}
+
+// waiting returns the last line of output if it is a prompt that has gone
+// unanswered for promptIdle. A prompt leaves its line open for the answer,
+// so output ending in a newline, as the tools print a model's, never is.
+func (w *promptWatcher) waiting() (string, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.buf.Len() == 0 || time.Since(w.last) < promptIdle {
+		return "", false
+	}
+	out := bytes.TrimRight(w.buf.Bytes(), " \t")
+	if len(out) == 0 || out[len(out)-1] == '\n' || out[len(out)-1] == '\r' {
+		return "", false
+	}
+	line := out[bytes.LastIndexAny(out, "\r\n")+1:]
+	return string(line), interactivePrompt.Match(line)
+}
+
+func (w *promptWatcher) output() []byte {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return bytes.Clone(w.buf.Bytes())
+}
+
+// runHeadless runs an agent command in the container without a TTY or
+// stdin. A tool that stops on an interactive prompt is killed, and the
+// prompt returned as the error, instead of hanging the run; so is one that
+// stall, if not nil, finds stalled and is to terminate. env is set for the
+// command, see execEnv, and params are its positional parameters, "$1" on:
+// values like prompts go there rather than into the command, where a quote
+// in them would end the string they were meant to be in.
+func runHeadless(ctx context.Context, containerID, workdir, tool, command string, env map[string]string, stall *stallWatch, params ...string) ([]byte, error) {
+	args, environ, err := headlessArgs(containerID, workdir, tool, command, env, params...)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Env = environ
+	// Killing docker exec leaves the tool running in the container.
+	stop := func() {
+		if process, ok := toolProcesses[tool]; ok {
+			exec.Command("docker", "exec", "-u", "root", containerID[:12], "pkill", "-f", process).Run()
+		}
+	}
+	return watchHeadless(cmd, tool, stall, stop)
+}
+
+// hostEnvAllowed are the variables of the orchestrator's environment an
+// agent run on the host inherits. Nothing else is passed on: the rest can
+// hold the admin token, the sealing key, storage credentials and the real
+// provider keys.
+var hostEnvAllowed = []string{"PATH", "HOME"}
+
+// hostEnv returns the environment of an agent run on the host: the allowed
+// variables of the orchestrator's, headlessEnv and env.
+func hostEnv(env map[string]string) ([]string, error) {
+	var environ []string
+	for _, name := range hostEnvAllowed {
+		if value, ok := os.LookupEnv(name); ok {
+			environ = append(environ, name+"="+value)
+		}
+	}
+	environ = append(environ, headlessEnv...)
+	for _, name := range slices.Sorted(maps.Keys(env)) {
+		if !envName.MatchString(name) {
+			return nil, fmt.Errorf("invalid environment variable name %q", name)
+		}
+		environ = append(environ, name+"="+env[name])
+	}
+	return environ, nil
+}
+
+// headlessArgs returns the docker arguments, and the environment to run
+// docker with, that run command in the container as runHeadless does.
+func headlessArgs(containerID, workdir, tool, command string, env map[string]string, params ...string) (args, environ []string, err error) {
+	envArgs, environ, err := execEnv(env)
+	if err != nil {
+		return nil, nil, err
+	}
+	args = []string{"exec", "-w", workdir}
+	for _, env := range headlessEnv {
+		args = append(args, "-e", env)
+	}
+	args = append(args, envArgs...)
+	args = append(args, containerID[:12], "/bin/bash", "-c", command+" < /dev/null", tool)
+	args = append(args, params...)
+	return args, environ, nil
+}
+
+// runHostHeadless runs an agent command on the host in dir, headless as
+// runHeadless runs one in a container, for agents that reach the project
+// from outside it. Its environment is hostEnv's, and params are its
+// positional parameters. The command runs in a process group of its own,
+// killed whole, so the agent doesn't outlive the shell it was started by.
+func runHostHeadless(ctx context.Context, dir, tool, command string, env map[string]string, stall *stallWatch, params ...string) ([]byte, error) {
+	environ, err := hostEnv(env)
+	if err != nil {
+		return nil, err
+	}
+	args := append([]string{"-c", command + " < /dev/null", tool}, params...)
+	cmd := exec.CommandContext(ctx, "/bin/bash", args...)
+	cmd.Dir = dir
+	cmd.Env = environ
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	killGroup := func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.Cancel = killGroup
+	return watchHeadless(cmd, tool, stall, func() { killGroup() })
+}
+
+// watchHeadless runs cmd, killing it when it stops on a prompt or stall
+// finds it stalled, after calling stop, if not nil, to end what killing cmd
+// doesn't.
+func watchHeadless(cmd *exec.Cmd, tool string, stall *stallWatch, stop func()) ([]byte, error) {
+	w := &promptWatcher{}
+	var stderr bytes.Buffer
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	kill := func() {
+		if stop != nil {
+			stop()
+		}
+		cmd.Process.Kill()
+		<-done
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			var exit *exec.ExitError
+			if errors.As(err, &exit) {
+				// The tools print their errors to stderr; keep the end of it
+				// for the failure to be classified by.
+				tail := stderr.Bytes()
+				if len(tail) > 4096 {
+					tail = tail[len(tail)-4096:]
+				}
+				exit.Stderr = bytes.Clone(tail)
+			}
+			return w.output(), err
+		case <-ticker.C:
+			if s := stall.check(); s != nil && s.Terminated {
+				kill()
+				return w.output(), fmt.Errorf("%s is %w: no traffic since %s", tool, errStalled, s.Since.Format(time.RFC3339))
+			}
+			line, ok := w.waiting()
+			if !ok {
+				continue
+			}
+			kill()
+			return w.output(), fmt.Errorf("%s is %w: %q", tool, errWaitingForInput, line)
+		}
+	}
+}
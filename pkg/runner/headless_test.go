@@ -0,0 +1,117 @@
+package runner
+
+import (
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// adversarialPrompts are prompts that would break out of, or change, a
+// command they were quoted into.
+var adversarialPrompts = []struct {
+	name, prompt string
+}{
+	{"single quotes", `it's 'quoted'`},
+	{"double quotes", `say "hi" and "`},
+	{"command substitution", `$(touch /tmp/pwned)`},
+	{"backticks", "`touch /tmp/pwned`"},
+	{"semicolon", `fix it; rm -rf /`},
+	{"newlines", "first line\nsecond line\n"},
+	{"leading dash", `-rf --help`},
+	{"variables", `$HOME ${PATH} $1`},
+	{"glob", `* ?`},
+}
+
+const testContainer = "0123456789abcdef"
+
+// runLocally runs the bash part of a docker exec argv on the host, with
+// the tool replaced by a function printing the arguments it gets.
+func runLocally(t *testing.T, args []string, tool string) []string {
+	t.Helper()
+	i := slices.Index(args, testContainer[:12])
+	if i < 0 || len(args) < i+4 || args[i+1] != "/bin/bash" || args[i+2] != "-c" {
+		t.Fatalf("no bash command in %q", args)
+	}
+	script := tool + `() { printf '%s\0' "$@"; }; ` + args[i+3]
+	out, err := exec.Command("/bin/bash", append([]string{"-c", script}, args[i+4:]...)...).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+}
+
+func TestHeadlessArgsPrompt(t *testing.T) {
+	command := `claude --dangerously-skip-permissions --model "$1" -p "$2"`
+	for _, tc := range adversarialPrompts {
+		t.Run(tc.name, func(t *testing.T) {
+			args, _, err := headlessArgs(testContainer, "/app", "ClaudeCode", command, nil, "claude-sonnet-4-5", tc.prompt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, a := range args[:slices.Index(args, testContainer[:12])+4] {
+				if strings.Contains(a, tc.prompt) {
+					t.Errorf("prompt is in argument %q", a)
+				}
+			}
+			got := runLocally(t, args, "claude")
+			want := []string{"--dangerously-skip-permissions", "--model", "claude-sonnet-4-5", "-p", tc.prompt}
+			if !slices.Equal(got, want) {
+				t.Errorf("claude got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestExecEnv(t *testing.T) {
+	for _, tc := range adversarialPrompts {
+		t.Run(tc.name, func(t *testing.T) {
+			value := tc.prompt
+			if strings.ContainsAny(value, "\n") {
+				if _, _, err := execEnv(map[string]string{"OPENAI_API_KEY": value}); err == nil {
+					t.Error("accepted a value with a newline")
+				}
+				return
+			}
+			args, environ, err := execEnv(map[string]string{"OPENAI_API_KEY": value, "OPENAI_BASE_URL": "http://localhost:8080"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{"-e", "OPENAI_API_KEY", "-e", "OPENAI_BASE_URL"}; !slices.Equal(args, want) {
+				t.Errorf("args = %q, want %q", args, want)
+			}
+			if !slices.Contains(environ, "OPENAI_API_KEY="+value) {
+				t.Errorf("OPENAI_API_KEY=%q not in the environment", value)
+			}
+		})
+	}
+
+	for _, name := range []string{"A B", "X;Y", "$(id)", "-e", "1ABC", ""} {
+		if _, _, err := execEnv(map[string]string{name: "v"}); err == nil {
+			t.Errorf("accepted variable name %q", name)
+		}
+	}
+}
+
+func TestPromptWatcherWaiting(t *testing.T) {
+	for out, want := range map[string]bool{
+		"Do you want to continue? [Y/n] ":                            true,
+		"Enter passphrase for key '/root/.ssh/id_rsa': ":             true,
+		"[sudo] password for node: ":                                 true,
+		"Are you sure you want to continue (yes/no/[fingerprint])? ": true,
+		"Press Enter to continue...":                                 true,
+		"I've fixed the tests. Do you want me to commit?\n":          false,
+		"Are you sure?\n":                                            false,
+		"Do you want to proceed?":                                    false,
+		"The installer asked [y/n] and I answered.\n":                false,
+		"Password: set in .env\n":                                    false,
+	} {
+		w := &promptWatcher{}
+		w.Write([]byte(out))
+		w.last = time.Now().Add(-promptIdle)
+		if _, got := w.waiting(); got != want {
+			t.Errorf("waiting after %q = %v, want %v", out, got, want)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package runner
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// mcpServerPackage is the filesystem MCP server installed in the containers
+// of MCP agents, which reach the project only through it.
+const mcpServerPackage = "@modelcontextprotocol/server-filesystem"
+
+// mcpServer is the name the project's MCP server has in an agent's config,
+// so its tools are mcp__project__read_file and so on.
+const mcpServer = "project"
+
+// mcpConfig returns the MCP config, in the mcpServers format MCP clients
+// share, that starts the filesystem server in the container over docker
+// exec, serving workdir.
+func mcpConfig(containerID, workdir string) ([]byte, error) {
+	type server struct {
+		Command string   `json:"command"`
+		Args    []string `json:"args"`
+	}
+	return json.MarshalIndent(map[string]map[string]server{
+		"mcpServers": {
+			mcpServer: {
+				Command: "docker",
+				Args:    []string{"exec", "-i", "-u", "node", containerID[:12], "mcp-server-filesystem", workdir},
+			},
+		},
+	}, "", "  ")
+}
+
+// mcpDir creates mcp/<session> in the run, the directory an MCP agent runs
+// in on the host, with the MCP config in it as mcp.json. It returns the
+// absolute paths of both.
+func (r *Runner) mcpDir(id, containerID, workdir string) (dir, configPath string, err error) {
+	if dir, err = filepath.Abs(filepath.Join(r.RunDir, "mcp", id)); err != nil {
+		return "", "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", "", err
+	}
+	b, err := mcpConfig(containerID, workdir)
+	if err != nil {
+		return "", "", err
+	}
+	configPath = filepath.Join(dir, "mcp.json")
+	return dir, configPath, os.WriteFile(configPath, b, 0644)
+}
@@ -0,0 +1,59 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"github.com/leakbenchmark/deployer/pkg/deployer"
+)
+
+// modelServer is a started model server, as recorded in models.json.
+type modelServer struct {
+	Name        string `json:"name"`
+	ContainerID string `json:"container_id"`
+	Image       string `json:"image"`
+	GPUs        string `json:"gpus,omitempty"`
+	URL         string `json:"url"`
+}
+
+// StartModels starts the run's local model servers and waits for them to be
+// ready, recording them in models.json. The returned function removes them;
+// it is also called when one fails to start.
+func (r *Runner) StartModels(ctx context.Context) (func(), error) {
+	if len(r.Config.Models) == 0 {
+		return func() {}, nil
+	}
+	d, err := deployer.New()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create deployer: %v", err)
+	}
+	d.RunID = r.Config.RunID
+
+	var started []modelServer
+	stop := func() {
+		for _, m := range started {
+			if err := d.RemoveContainer(context.Background(), m.ContainerID); err != nil {
+				log.Printf("Failed to remove model server %s: %v", m.Name, err)
+			}
+		}
+		d.Close()
+	}
+	for _, m := range r.Config.Models {
+		id, err := d.StartModelServer(ctx, deployer.ModelServer{
+			Name: m.Name, Image: m.Image, Args: m.Args, Env: m.Env, GPUs: m.GPUs,
+			Volumes: m.Volumes, Port: m.Port, Health: m.Health, Timeout: m.Timeout,
+		})
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		started = append(started, modelServer{Name: m.Name, ContainerID: id, Image: m.Image, GPUs: m.GPUs, URL: fmt.Sprintf("http://localhost:%d", m.Port)})
+	}
+	if err := writeManifest(filepath.Join(r.RunDir, "models.json"), started); err != nil {
+		stop()
+		return nil, err
+	}
+	return stop, nil
+}
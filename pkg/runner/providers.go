@@ -0,0 +1,108 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/leakbenchmark/deployer/pkg/config"
+	"github.com/leakbenchmark/deployer/pkg/deployer"
+	"github.com/leakbenchmark/deployer/pkg/proxy"
+)
+
+// Provider is an OpenAI-compatible gateway, such as OpenRouter, that
+// agents can reach many vendors' models through with one account. Its
+// model names carry a vendor prefix, like anthropic/claude-sonnet-4.5.
+type Provider struct {
+	BaseURL string
+	// Headers are sent upstream with every request through the gateway.
+	Headers map[string]string
+	// key picks the gateway's API key out of the configured ones.
+	key func(config.KeysConfig) string
+}
+
+// providers are the gateways an Agent can name as its Provider.
+var providers = map[string]Provider{
+	"openrouter": {
+		BaseURL: "https://openrouter.ai/api",
+		// OpenRouter attributes traffic to the app these name.
+		Headers: map[string]string{
+			"HTTP-Referer": "https://github.com/leakbenchmark/deployer",
+			"X-Title":      "LeakBenchmark",
+		},
+		key: func(k config.KeysConfig) string { return k.OpenRouter },
+	},
+}
+
+// upstream returns where the proxy sends the agent's requests and the
+// headers it adds: the agent's own, over its gateway's.
+func (a Agent) upstream() (string, map[string]string, error) {
+	if a.Provider == "" {
+		return a.BaseURL, a.Headers, nil
+	}
+	p, ok := providers[a.Provider]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown provider %q", a.Provider)
+	}
+
+	baseURL := a.BaseURL
+	if baseURL == "" {
+		baseURL = p.BaseURL
+	}
+	headers := map[string]string{}
+	for name, value := range p.Headers {
+		headers[name] = value
+	}
+	for name, value := range a.Headers {
+		headers[name] = value
+	}
+	return baseURL, headers, nil
+}
+
+// register points the proxy at the cell's session for the given step,
+// telling it the secrets planted in the cell's project.
+func (r *Runner) register(ctx context.Context, id string, agent Agent, step string, secrets *deployer.SecretConfig) (string, error) {
+	baseURL, headers, err := agent.upstream()
+	if err != nil {
+		return "", err
+	}
+	setup := proxy.Setup{Id: id, BaseURL: baseURL, Step: step, DB: r.Config.MessagesDB, Headers: headers}
+	if secrets != nil {
+		setup.Secrets = map[string]string{}
+		for _, s := range secrets.Named() {
+			setup.Secrets[s.ID] = s.Value
+		}
+	}
+	if d := agent.Decoding.Over(r.Config.Decoding); d != (config.Decoding{}) {
+		setup.Decoding = &proxy.Decoding{Temperature: d.Temperature, TopP: d.TopP, Seed: d.Seed}
+	}
+	ablation := r.Config.Ablation
+	if agent.Ablation != nil {
+		ablation = *agent.Ablation
+	}
+	if !ablation.IsZero() {
+		setup.Ablation = &proxy.Ablation{DropSystem: ablation.DropSystem, KeepTurns: ablation.KeepTurns, StripToolResults: ablation.StripToolResults}
+	}
+	if n := r.Config.Network; !n.IsZero() {
+		setup.Network = &proxy.Network{Latency: n.Latency, Jitter: n.Jitter, Loss: n.Loss, Bandwidth: int64(n.Bandwidth)}
+	}
+	if cw := r.Config.ContextWindow; cw.Tokens > 0 {
+		setup.ContextWindow = &proxy.ContextWindow{Tokens: cw.Tokens, Reject: cw.Reject}
+	}
+	if b := r.Config.Budget; b.Turns > 0 || b.Tokens > 0 {
+		setup.Budget = &proxy.Budget{Turns: b.Turns, Tokens: b.Tokens}
+	}
+	if rd := r.Config.Redaction; rd.Responses || rd.Requests {
+		setup.Redaction = &proxy.Redaction{Responses: rd.Responses, Requests: rd.Requests}
+	}
+	return RegisterSession(ctx, r.Config.Proxy.URL, r.Config.Keys.Admin, setup)
+}
+
+// finalize tells the proxy the cell's session is over.
+func (r *Runner) finalize(ctx context.Context, id string, agent Agent) error {
+	baseURL, _, err := agent.upstream()
+	if err != nil {
+		return err
+	}
+	_, err = RegisterSession(ctx, r.Config.Proxy.URL, r.Config.Keys.Admin, proxy.Setup{Id: id, BaseURL: baseURL, Final: true})
+	return err
+}
@@ -0,0 +1,146 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/leakbenchmark/deployer/pkg/deployer"
+)
+
+// Redeploy deploys the project named name into the run on its own, with
+// fresh secrets, updating the run's manifests. A project the run already
+// has a container for is torn down first when force is set, and refused
+// otherwise.
+func (r *Runner) Redeploy(ctx context.Context, name string, force bool) (*deployer.DeploymentResult, error) {
+	d, err := r.newDeployer()
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+
+	projects, err := r.discover(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+	i := slices.IndexFunc(projects, func(p *deployer.Project) bool { return p.Name == name })
+	if i < 0 {
+		return nil, fmt.Errorf("no benchmark project named %s", name)
+	}
+
+	var shared []string
+	if b, err := os.ReadFile(filepath.Join(r.RunDir, "workspace.json")); err == nil {
+		json.Unmarshal(b, &shared)
+	}
+	if slices.Contains(shared, name) {
+		return nil, fmt.Errorf("%s shares a workspace container in run %s and can't be redeployed on its own", name, r.Config.RunID)
+	}
+
+	existing, err := d.ProjectContainers(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) > 0 {
+		if !force {
+			return nil, fmt.Errorf("%s is already deployed in run %s as %s; use -force to replace it", name, r.Config.RunID, existing[0][:12])
+		}
+		for _, id := range existing {
+			fmt.Printf("Removing container %s...\n", id[:12])
+			if err := d.RemoveContainer(ctx, id); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	results := d.DeployAll(ctx, projects[i:i+1])
+	if err := r.record(ctx, d, results); err != nil {
+		return results[0], err
+	}
+	return results[0], results[0].Error
+}
+
+// mergeManifest returns entries, keyed by project, with the entries of the
+// manifest at path for projects other than the deployed ones.
+func mergeManifest[T any](path string, deployed []string, entries map[string]T) (map[string]T, error) {
+	merged := map[string]T{}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &merged); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for _, name := range deployed {
+		delete(merged, name)
+	}
+	maps.Copy(merged, entries)
+	return merged, nil
+}
+
+// updateManifest writes entries to the manifest at path, merged with it as
+// by mergeManifest. A manifest left without entries is removed.
+func updateManifest[T any](path string, deployed []string, entries map[string]T) error {
+	merged, err := mergeManifest(path, deployed, entries)
+	if err != nil {
+		return err
+	}
+	if len(merged) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	return writeManifest(path, merged)
+}
+
+// mergeSuite returns checksums with those of the projects in the suite.json
+// at path other than the deployed ones.
+func mergeSuite(path string, deployed []string, checksums map[string]deployer.Checksum) (map[string]deployer.Checksum, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return checksums, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var suite struct {
+		Projects map[string]deployer.Checksum `json:"projects"`
+	}
+	if err := json.Unmarshal(b, &suite); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for name, c := range suite.Projects {
+		if _, ok := checksums[name]; !ok && !slices.Contains(deployed, name) {
+			checksums[name] = c
+		}
+	}
+	return checksums, nil
+}
+
+// readDeployments returns the deployments in the deployments.json at path,
+// except those of the deployed projects.
+func readDeployments(path string, deployed []string) ([]deployer.Deployment, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var deployments []deployer.Deployment
+	if err := json.Unmarshal(b, &deployments); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return slices.DeleteFunc(deployments, func(d deployer.Deployment) bool {
+		return slices.Contains(deployed, d.Project)
+	}), nil
+}
@@ -0,0 +1,719 @@
+// Package runner runs coding agents inside deployed benchmark projects. Each
+// agent and project pair is a cell: the agent works through the scenario's
+// prompts with its traffic recorded by the proxy, and the files, commits,
+// logs and grade it leaves behind are written to the run directory.
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/leakbenchmark/deployer/internal/auth"
+	"github.com/leakbenchmark/deployer/internal/grading"
+	"github.com/leakbenchmark/deployer/internal/tracing"
+	"github.com/leakbenchmark/deployer/pkg/analyzer"
+	"github.com/leakbenchmark/deployer/pkg/config"
+	"github.com/leakbenchmark/deployer/pkg/deployer"
+	"github.com/leakbenchmark/deployer/pkg/egress"
+	"github.com/leakbenchmark/deployer/pkg/proxy"
+	"github.com/leakbenchmark/deployer/pkg/scenario"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// Agent is a model driven through a coding tool, ClaudeCode or Codex.
+type Agent struct {
+	Model   string
+	Tool    string
+	BaseURL string
+	// Provider names a gateway in providers the agent reaches its model
+	// through, with BaseURL overriding the gateway's when set.
+	Provider string
+	// Headers are sent upstream with the agent's requests by the proxy.
+	Headers map[string]string
+	// Decoding overrides the run's decoding parameters for the agent.
+	Decoding config.Decoding
+	// Ablation replaces the run's ablation for the agent when set.
+	Ablation *config.Ablation
+	// Command runs an agent of the MCP tool: an MCP-capable agent run on the
+	// host, reaching the project only through a filesystem MCP server in its
+	// container. It is run with bash, the model as $1 and the prompt as $2,
+	// and these variables set: ANTHROPIC_BASE_URL and OPENAI_BASE_URL, the
+	// proxy; ANTHROPIC_API_KEY and OPENAI_API_KEY; LEAKBENCH_MCP_CONFIG, the
+	// MCP config to load; LEAKBENCH_WORKDIR, the project's directory as the
+	// server sees it; and LEAKBENCH_CONTINUE, 1 in the steps that continue
+	// the previous one's conversation.
+	Command string
+	// Variant names the tool settings the agent runs with, kept apart from
+	// the agent's plain cells by being added to the model in session IDs.
+	Variant string
+	// Settings replace the tool's user settings in the container, such as
+	// Claude Code's settings.json or Codex's config.toml. Without them the
+	// tool runs with none.
+	Settings string
+}
+
+// sessionModel keeps the vendor prefix of gateway model names, like
+// anthropic/claude-sonnet-4.5 or meta-llama/llama-3-8b:free, out of paths.
+var sessionModel = strings.NewReplacer("/", "-", ":", "-")
+
+// SessionID names the agent's cell on project in the transcripts.
+func (a Agent) SessionID(project string) string {
+	model := sessionModel.Replace(a.Model)
+	if a.Variant != "" {
+		model += analyzer.VariantSeparator + a.Variant
+	}
+	return fmt.Sprintf("%s__%s__%s", model, a.Tool, project)
+}
+
+// Runner runs agents through Scenario and writes what they produce under
+// RunDir.
+type Runner struct {
+	Config   config.Config
+	Scenario *scenario.Scenario
+	RunDir   string
+
+	// egress is the proxy started by StartEgress, if any.
+	egress *egress.Proxy
+	// ran holds the agents Run ran, variants included.
+	ran []Agent
+}
+
+// Agents returns the agents Run ran, in order, including the mitigated
+// variants paired with them.
+func (r *Runner) Agents() []Agent {
+	return r.ran
+}
+
+// Deploy discovers and deploys the benchmark projects, writing the secrets
+// planted in them to secrets.json in the run directory.
+func (r *Runner) Deploy(ctx context.Context) ([]*deployer.DeploymentResult, error) {
+	d, err := r.newDeployer()
+	if err != nil {
+		return []*deployer.DeploymentResult{}, err
+	}
+	defer d.Close()
+
+	projects, err := r.discover(ctx, d)
+	if err != nil {
+		return []*deployer.DeploymentResult{}, err
+	}
+	if projects, err = selectProjects(projects, r.Config.Deployer); err != nil {
+		return []*deployer.DeploymentResult{}, err
+	}
+
+	fmt.Printf("Discovered %d benchmark projects:\n", len(projects))
+	for _, project := range projects {
+		fmt.Printf("- %s\n", project.Name)
+	}
+
+	shared, separate, err := workspaceProjects(projects, r.Config.Deployer.Workspace)
+	if err != nil {
+		return []*deployer.DeploymentResult{}, err
+	}
+
+	fmt.Println("\nStarting deployment...")
+	results := d.DeployAll(ctx, separate)
+	if len(shared) > 0 {
+		results = append(d.DeployWorkspace(ctx, shared), results...)
+		names := make([]string, len(shared))
+		for i, project := range shared {
+			names[i] = project.Name
+		}
+		if err := writeManifest(filepath.Join(r.RunDir, "workspace.json"), names); err != nil {
+			return results, err
+		}
+	}
+
+	return results, r.record(ctx, d, results)
+}
+
+// newDeployer creates a deployer configured for the run. The caller closes
+// it.
+func (r *Runner) newDeployer() (*deployer.Deployer, error) {
+	d, err := deployer.New()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create deployer: %v", err)
+	}
+	d.RunID = r.Config.RunID
+	d.ManifestDir = r.Config.Deployer.Manifests
+	if r.Config.Bundle != "" {
+		d.SnapshotDir = filepath.Join(r.RunDir, "snapshots")
+	}
+	if d.Injections, err = injectionPlacements(r.Config.Deployer.Inject); err != nil {
+		d.Close()
+		return nil, err
+	}
+	if d.Locales, err = locales(r.Config.Deployer.Locales); err != nil {
+		d.Close()
+		return nil, err
+	}
+	if d.Surfaces, d.RandomSurfaces, err = surfaces(r.Config.Deployer.Surfaces); err != nil {
+		d.Close()
+		return nil, err
+	}
+	d.HoneytokenURL = r.Config.Deployer.HoneytokenURL
+	d.ProcessAuditImage = r.Config.Deployer.ProcessAudit
+	d.FileAccessImage = r.Config.Deployer.FileAccess
+	d.DependencyCache = r.Config.Deployer.DependencyCache
+	d.Egress = r.egress
+	return d, nil
+}
+
+// discover fetches the configured sources, or finds the projects in the
+// benchmark directory without any.
+func (r *Runner) discover(ctx context.Context, d *deployer.Deployer) ([]*deployer.Project, error) {
+	if sources := r.Config.Deployer.Sources; len(sources) > 0 {
+		var fetch []deployer.Source
+		for _, s := range sources {
+			fetch = append(fetch, deployer.Source{Name: s.Name, URL: s.URL, Ref: s.Ref})
+		}
+		return d.FetchProjects(ctx, fetch, r.Config.Deployer.Cache)
+	}
+	projects, err := d.DiscoverProjects(r.Config.Deployer.Projects)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to discover projects: %v", err)
+	}
+	return projects, nil
+}
+
+// record prints the deployment results and writes what was deployed and
+// planted to the run directory. The entries of projects deployed into the
+// run earlier, and not in results, are kept.
+func (r *Runner) record(ctx context.Context, d *deployer.Deployer, results []*deployer.DeploymentResult) error {
+	fmt.Println("\nDeployment Results:")
+	var deployed []string
+	var secrets map[string]deployer.SecretConfig = make(map[string]deployer.SecretConfig)
+	injections := map[string][]deployer.Injection{}
+	honeytokens := map[string][]deployer.Honeytoken{}
+	checksums := map[string]deployer.Checksum{}
+	secretFiles := map[string]map[string][]string{}
+	secretLocations := map[string][]analyzer.SecretLocation{}
+	for _, result := range results {
+		deployed = append(deployed, result.Project.Name)
+		for _, diag := range result.Diagnostics {
+			fmt.Printf("Warning: %s: %v\n", result.Project.Name, diag)
+		}
+		if result.Error != nil {
+			fmt.Printf("%s: %v\n", result.Project.Name, result.Error)
+		} else {
+			fmt.Printf("%s: Container %s running on ports %v\n",
+				result.Project.Name, result.ContainerID[:12], result.Ports)
+			secrets[result.Project.Name] = *result.Secrets
+			if len(result.Injections) > 0 {
+				injections[result.Project.Name] = result.Injections
+			}
+			if len(result.Honeytokens) > 0 {
+				honeytokens[result.Project.Name] = result.Honeytokens
+			}
+			checksums[result.Project.Name] = *result.Checksum
+			if len(result.SecretFiles) > 0 {
+				files := map[string][]string{}
+				for f, ids := range result.SecretFiles {
+					files[path.Join(result.Workdir, f)] = ids
+				}
+				secretFiles[result.Project.Name] = files
+
+				planted := map[string]string{}
+				for f, content := range result.Planted {
+					planted[path.Join(result.Workdir, f)] = content
+				}
+				projectSecrets := analyzer.SecretsFromConfigs(map[string]deployer.SecretConfig{result.Project.Name: *result.Secrets})
+				secretLocations[result.Project.Name] = analyzer.LocateSecrets(planted, projectSecrets)
+			}
+			if result.Checksum.Modified {
+				fmt.Printf("Warning: %s has uncommitted changes\n", result.Project.Name)
+			}
+		}
+	}
+
+	deployments, err := readDeployments(filepath.Join(r.RunDir, "deployments.json"), deployed)
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		deployment, err := d.Describe(ctx, result)
+		if err != nil {
+			fmt.Printf("Warning: failed to describe deployment of %s: %v\n", result.Project.Name, err)
+		}
+		deployments = append(deployments, deployment)
+	}
+	if err := writeManifest(filepath.Join(r.RunDir, "deployments.json"), deployments); err != nil {
+		return err
+	}
+
+	if secrets, err = mergeManifest(filepath.Join(r.RunDir, "secrets.json"), deployed, secrets); err != nil {
+		return err
+	}
+	b, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(filepath.Join(r.RunDir, "secrets.json"), b, 0644); err != nil {
+		return err
+	}
+
+	if err := updateManifest(filepath.Join(r.RunDir, "injections.json"), deployed, injections); err != nil {
+		return err
+	}
+	if err := updateManifest(filepath.Join(r.RunDir, "honeytokens.json"), deployed, honeytokens); err != nil {
+		return err
+	}
+	if err := updateManifest(filepath.Join(r.RunDir, "secret_files.json"), deployed, secretFiles); err != nil {
+		return err
+	}
+	if err := updateManifest(filepath.Join(r.RunDir, "secret_locations.json"), deployed, secretLocations); err != nil {
+		return err
+	}
+
+	if checksums, err = mergeSuite(filepath.Join(r.RunDir, "suite.json"), deployed, checksums); err != nil {
+		return err
+	}
+	suite := deployer.SuiteVersion(checksums)
+	fmt.Printf("Suite version %s\n", suite)
+	if err := writeManifest(filepath.Join(r.RunDir, "suite.json"), map[string]any{
+		"version":  suite,
+		"projects": checksums,
+	}); err != nil {
+		return err
+	}
+	if want := r.Config.Deployer.Suite; want != "" && want != suite {
+		return fmt.Errorf("suite version %s does not match expected %s", suite, want)
+	}
+	return nil
+}
+
+// writeManifest records what was planted in the projects as JSON.
+func writeManifest(path string, v any) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+// workspaceProjects splits projects into those listed to share a workspace,
+// a comma-separated list or "all", and the rest.
+func workspaceProjects(projects []*deployer.Project, list string) (shared, separate []*deployer.Project, err error) {
+	if list == "" {
+		return nil, projects, nil
+	}
+	if list == "all" {
+		return projects, nil, nil
+	}
+	names := strings.Split(list, ",")
+	for i := range names {
+		names[i] = strings.TrimSpace(names[i])
+	}
+	for _, project := range projects {
+		if i := slices.Index(names, project.Name); i >= 0 {
+			shared = append(shared, project)
+			names = slices.Delete(names, i, i+1)
+		} else {
+			separate = append(separate, project)
+		}
+	}
+	if len(names) > 0 {
+		return nil, nil, fmt.Errorf("unknown workspace projects: %s", strings.Join(names, ", "))
+	}
+	return shared, separate, nil
+}
+
+// selectProjects returns the projects in the configured subset with any of
+// the configured tags. A subset, or a tag, can also name a project.
+func selectProjects(projects []*deployer.Project, c config.DeployerConfig) ([]*deployer.Project, error) {
+	if c.Subset == "" && c.Tags == "" {
+		return projects, nil
+	}
+	subset, ok := c.Subsets[c.Subset]
+	if !ok && c.Subset != "" {
+		subset = []string{c.Subset}
+	}
+	var tags []string
+	for _, t := range strings.Split(c.Tags, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+
+	matches := func(project *deployer.Project, tags []string) bool {
+		for _, t := range tags {
+			if t == project.Name || project.Manifest != nil && slices.Contains(project.Manifest.Tags, t) {
+				return true
+			}
+		}
+		return false
+	}
+	var selected []*deployer.Project
+	for _, project := range projects {
+		if (c.Subset == "" || matches(project, subset)) && (len(tags) == 0 || matches(project, tags)) {
+			selected = append(selected, project)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no projects match subset %q and tags %q", c.Subset, c.Tags)
+	}
+	return selected, nil
+}
+
+// injectionPlacements parses a comma-separated list of injection placements.
+func injectionPlacements(list string) ([]string, error) {
+	if list == "" {
+		return nil, nil
+	}
+	if list == "all" {
+		return deployer.Placements, nil
+	}
+	var placements []string
+	for _, p := range strings.Split(list, ",") {
+		p = strings.TrimSpace(p)
+		if !slices.Contains(deployer.Placements, p) {
+			return nil, fmt.Errorf("unknown injection placement %q", p)
+		}
+		placements = append(placements, p)
+	}
+	return placements, nil
+}
+
+// locales parses a comma-separated list of fixture locales.
+func locales(list string) ([]string, error) {
+	if list == "" {
+		return nil, nil
+	}
+	if list == "all" {
+		return deployer.Locales, nil
+	}
+	var locales []string
+	for _, l := range strings.Split(list, ",") {
+		l = strings.TrimSpace(l)
+		if !slices.Contains(deployer.Locales, l) {
+			return nil, fmt.Errorf("unknown fixture locale %q", l)
+		}
+		locales = append(locales, l)
+	}
+	return locales, nil
+}
+
+// surfaces parses a comma-separated list of secret surfaces, reporting
+// whether a random subset of them is to be planted in each project.
+func surfaces(list string) ([]string, bool, error) {
+	switch list {
+	case "":
+		return nil, false, nil
+	case "all":
+		return deployer.Surfaces, false, nil
+	case "random":
+		return deployer.Surfaces, true, nil
+	}
+	var surfaces []string
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if !slices.Contains(deployer.Surfaces, s) {
+			return nil, false, fmt.Errorf("unknown secret surface %q", s)
+		}
+		surfaces = append(surfaces, s)
+	}
+	return surfaces, false, nil
+}
+
+// Run runs agent on every deployed project in turn. When the differential
+// config has mitigation settings for its tool, each of its cells is paired
+// with one of the agent running with them.
+func (r *Runner) Run(ctx context.Context, results []*deployer.DeploymentResult, agent Agent) error {
+	mitigated, err := r.mitigated(agent)
+	if err != nil {
+		return err
+	}
+	r.ran = append(r.ran, agent)
+	if mitigated != nil {
+		r.ran = append(r.ran, *mitigated)
+	}
+	for _, result := range results {
+		if err := r.run(ctx, result, agent); err != nil {
+			return err
+		}
+		if mitigated != nil {
+			if err := r.run(ctx, result, *mitigated); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// run runs a cell, recording its failure, if any, rather than returning it.
+func (r *Runner) run(ctx context.Context, result *deployer.DeploymentResult, agent Agent) error {
+	err := r.RunCell(ctx, result, agent)
+	var failure *Failure
+	if !errors.As(err, &failure) {
+		return err
+	}
+	// A cell the infrastructure broke doesn't stop the run.
+	log.Printf("%s failed (%s): %s", failure.Session, failure.Class, failure.Err)
+	if err := writeFailure(r.RunDir, failure); err != nil {
+		log.Println("Failed to write failure", err)
+	}
+	return nil
+}
+
+// setupClient makes the setup calls to the proxy. Clients are safe for
+// concurrent use, so cells running at once share its connections.
+var setupClient = &http.Client{Timeout: time.Minute}
+
+// RegisterSession points the proxy at proxyURL at the agent's provider and
+// the run's transcript database, and tags the messages that follow with the
+// cell's session ID and scenario step. It returns the API key the proxy
+// issued the session, empty when the proxy doesn't hold the provider's key.
+func RegisterSession(ctx context.Context, proxyURL, token string, setup proxy.Setup) (string, error) {
+	jsonStr, err := json.Marshal(setup)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", proxyURL, bytes.NewBuffer(jsonStr))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set(auth.Header, token)
+	}
+	// The proxy parents its upstream spans on the context sent with the setup call.
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := setupClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("proxy returned %s", resp.Status)
+	}
+
+	var issued struct {
+		Key string `json:"key"`
+	}
+	if resp.Header.Get("Content-Type") == "application/json" {
+		if err := json.NewDecoder(resp.Body).Decode(&issued); err != nil {
+			return "", fmt.Errorf("failed to read issued key: %w", err)
+		}
+	}
+	return issued.Key, nil
+}
+
+// RunCell installs the agent's tool in the project's container and runs it
+// through every step of the scenario.
+func (r *Runner) RunCell(ctx context.Context, result *deployer.DeploymentResult, agent Agent) (err error) {
+	id := agent.SessionID(result.Project.Name)
+	ctx, span := tracing.Start(ctx, "agent turn",
+		attribute.String("session", id),
+		attribute.String("model", agent.Model),
+		attribute.String("tool", agent.Tool),
+		attribute.String("project", result.Project.Name))
+	defer func() { tracing.End(span, err) }()
+
+	// A key issued by the proxy identifies the cell's requests and keeps the
+	// real one out of the container.
+	key, err := r.register(ctx, id, agent, r.Scenario.Steps[0].Name, result.Secrets)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := r.finalize(ctx, id, agent); err != nil {
+			log.Println("Failed to finalize session", err)
+		}
+	}()
+	if r.egress != nil {
+		r.egress.SetSession(result.ContainerID, id)
+		defer r.egress.SetSession(result.ContainerID, "")
+	}
+	anthropicKey, openAIKey := r.Config.Keys.Anthropic, r.Config.Keys.OpenAI
+	if p, ok := providers[agent.Provider]; ok {
+		anthropicKey, openAIKey = p.key(r.Config.Keys), p.key(r.Config.Keys)
+	}
+	if key != "" {
+		anthropicKey, openAIKey = key, key
+	}
+	setupCmd := ""
+	switch agent.Tool {
+	case "ClaudeCode":
+		setupCmd = "npm install -g @anthropic-ai/claude-code && chown -R node:node /app"
+	case "Codex":
+		setupCmd = "npm i -g @openai/codex && chown -R node:node /app"
+	case "MCP":
+		if agent.Command == "" {
+			return fmt.Errorf("MCP agent %s has no command", agent.Model)
+		}
+		if key == "" {
+			return fmt.Errorf("MCP agent %s runs on the host and needs a session key, which the proxy only issues when it has the provider keys", agent.Model)
+		}
+		setupCmd = "npm i -g " + mcpServerPackage + " && chown -R node:node /app"
+	default:
+		return nil
+	}
+	if r.Config.Deployer.DependencyCache != "" {
+		setupCmd += " && " + deployer.CacheOwnershipCmd
+	}
+	log.Println(result.ContainerID)
+	res := exec.Command("docker", "exec", "-u", "root", result.ContainerID[:12], "/bin/bash", "-c", setupCmd)
+	out, err := res.Output()
+	if err != nil {
+		err = cellFailure(ctx, result.ContainerID, id, "", out, err)
+		if f, ok := err.(*Failure); ok && f.Class != FailureContainerCrash && f.Class != FailureTimeout {
+			f.Class, f.Status = FailureToolInstall, 0
+		}
+		return err
+	}
+	log.Println("Setup command result", string(out))
+	if err := installSettings(result.ContainerID, agent); err != nil {
+		return err
+	}
+	if err := markCellStart(result.ContainerID, result.Workdir); err != nil {
+		log.Println("Failed to mark cell start", err)
+	}
+	sandbox, err := startSandbox(result.ContainerID)
+	if err != nil {
+		log.Println("Failed to record sandbox state", err)
+	}
+	var mcpDir, mcpConfigPath string
+	if agent.Tool == "MCP" {
+		if mcpDir, mcpConfigPath, err = r.mcpDir(id, result.ContainerID, result.Workdir); err != nil {
+			return fmt.Errorf("failed to write MCP config: %w", err)
+		}
+	}
+
+	for i, step := range r.Scenario.Steps {
+		if i > 0 {
+			if _, err := r.register(ctx, id, agent, step.Name, result.Secrets); err != nil {
+				return err
+			}
+		}
+
+		// Later steps continue the agent's previous conversation instead of starting a new one.
+		// Keys go in the environment rather than the command, which is logged,
+		// and the model and prompt are passed as arguments, never quoted into it.
+		cmd := ""
+		var env map[string]string
+		switch agent.Tool {
+		case "ClaudeCode":
+			continueFlag := ""
+			if i > 0 {
+				continueFlag = "--continue "
+			}
+			cmd = fmt.Sprintf(`claude --dangerously-skip-permissions %s--model "$1" -p "$2"`, continueFlag)
+			env = map[string]string{"ANTHROPIC_BASE_URL": "http://localhost:8080", "ANTHROPIC_API_KEY": anthropicKey}
+		case "Codex":
+			resume := ""
+			if i > 0 {
+				resume = "resume --last "
+			}
+			cmd = fmt.Sprintf(`printenv OPENAI_API_KEY | codex login --with-api-key && codex exec --model "$1" --skip-git-repo-check --full-auto %s"$2"`, resume)
+			env = map[string]string{"OPENAI_BASE_URL": "http://localhost:8080", "OPENAI_API_KEY": openAIKey}
+		case "MCP":
+			cmd = agent.Command
+			// The agent runs on the host, so it only ever gets the key the
+			// proxy issued its session.
+			env = map[string]string{
+				"ANTHROPIC_BASE_URL": r.Config.Proxy.URL, "ANTHROPIC_API_KEY": key,
+				"OPENAI_BASE_URL": r.Config.Proxy.URL, "OPENAI_API_KEY": key,
+				"LEAKBENCH_MCP_CONFIG": mcpConfigPath, "LEAKBENCH_WORKDIR": result.Workdir,
+			}
+			if i > 0 {
+				env["LEAKBENCH_CONTINUE"] = "1"
+			}
+		}
+
+		log.Println(cmd)
+		stall := r.watchStall(ctx, id, agent, step.Name)
+		if agent.Tool == "MCP" {
+			out, err = runHostHeadless(ctx, mcpDir, agent.Tool, cmd, env, stall, agent.Model, step.Prompt)
+		} else {
+			out, err = runHeadless(ctx, result.ContainerID, result.Workdir, agent.Tool, cmd, env, stall, agent.Model, step.Prompt)
+		}
+		if err != nil {
+			writeCellLog(r.RunDir, id, out)
+			return cellFailure(ctx, result.ContainerID, id, step.Name, out, err)
+		}
+		log.Println("Command result", string(out))
+		if err := writeCellLog(r.RunDir, id, out); err != nil {
+			log.Println("Failed to write container log", err)
+		}
+
+		if r.Scenario.Checkpoint {
+			ref := r.checkpointRef(id, step.Name)
+			if out, err := exec.Command("docker", "commit", result.ContainerID[:12], ref).CombinedOutput(); err != nil {
+				log.Println("Failed to checkpoint", ref, err, string(out))
+			} else {
+				log.Println("Checkpointed", id, "after step", step.Name, "as", ref)
+			}
+		}
+	}
+
+	if sandbox != nil {
+		violations, err := checkSandbox(sandbox, result.ContainerID, result.Workdir)
+		if err != nil {
+			log.Println("Failed to check sandbox", err)
+		}
+		for _, v := range violations {
+			log.Printf("%s: sandbox violation in %s: %s %s", id, v.Where, v.Kind, v.Detail)
+		}
+		if len(violations) > 0 {
+			if err := writeViolations(r.RunDir, id, violations); err != nil {
+				log.Println("Failed to write sandbox violations", err)
+			}
+		}
+	}
+	if err := collectAuthoredFiles(result.ContainerID, id, r.RunDir); err != nil {
+		log.Println("Failed to collect agent files", err)
+	}
+	if err := collectAgentLogs(result.ContainerID, id, r.RunDir); err != nil {
+		log.Println("Failed to collect agent logs", err)
+	}
+	if err := collectCommits(result.ContainerID, result.Workdir, id, r.RunDir); err != nil {
+		log.Println("Failed to collect agent commits", err)
+	}
+	if err := collectFileChanges(result, id, r.RunDir); err != nil {
+		log.Println("Failed to collect changes to secret files", err)
+	}
+
+	grade := grading.Run(ctx, id, result)
+	if grade.Total > 0 {
+		log.Printf("%s passed %d/%d success checks", id, grade.Passed, grade.Total)
+	}
+	if err := writeGrade(r.RunDir, grade); err != nil {
+		log.Println("Failed to write grade", err)
+	}
+	if grade.ServiceLog != "" {
+		if err := writeServiceLog(r.RunDir, id, grade.ServiceLog); err != nil {
+			log.Println("Failed to write service log", err)
+		}
+	}
+	return nil
+}
+
+// checkpointRef builds a valid image reference for the container state after
+// the given step.
+func (r *Runner) checkpointRef(id, step string) string {
+	tag := []byte(fmt.Sprintf("%s-%s-%s", r.Config.RunID, id, step))
+	for i, c := range tag {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.' || c == '-') {
+			tag[i] = '-'
+		}
+	}
+	if len(tag) > 128 {
+		tag = tag[:128]
+	}
+	return "leakbench-checkpoint:" + string(tag)
+}
@@ -0,0 +1,112 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/leakbenchmark/deployer/pkg/config"
+	"github.com/leakbenchmark/deployer/pkg/deployer"
+	"github.com/leakbenchmark/deployer/pkg/proxy"
+	"github.com/leakbenchmark/deployer/pkg/scenario"
+)
+
+// fakeDocker puts a docker on PATH that succeeds without output, so cells
+// run without containers.
+func fakeDocker(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "docker"), []byte("#!/bin/sh\nexit 0\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// TestRunCellConcurrent runs cells of one runner at once, as parallel runs
+// do. Run it with -race.
+func TestRunCellConcurrent(t *testing.T) {
+	fakeDocker(t)
+
+	var mu sync.Mutex
+	setups := map[string][]proxy.Setup{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var setup proxy.Setup
+		if err := json.NewDecoder(req.Body).Decode(&setup); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		setups[setup.Id] = append(setups[setup.Id], setup)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"key": "issued-" + setup.Id})
+	}))
+	defer srv.Close()
+
+	c := config.Default()
+	c.Proxy.URL = srv.URL
+	c.MessagesDB = filepath.Join(t.TempDir(), "messages.db")
+	r := &Runner{Config: c, Scenario: scenario.Single("fix the bug"), RunDir: t.TempDir()}
+
+	const cells = 8
+	var wg sync.WaitGroup
+	errs := make([]error, cells)
+	ids := make([]string, cells)
+	for i := range cells {
+		project := fmt.Sprintf("project%d", i)
+		result := &deployer.DeploymentResult{
+			Project:     &deployer.Project{Name: project},
+			ContainerID: fmt.Sprintf("%012d", i),
+			Workdir:     "/app",
+			Secrets:     &deployer.SecretConfig{AppKeys: map[string]string{"API_KEY": fmt.Sprintf("sk-test-%d", i)}},
+		}
+		agent := Agent{Model: "claude-sonnet-4-5", Tool: "ClaudeCode", BaseURL: "https://api.anthropic.com"}
+		ids[i] = agent.SessionID(project)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = r.RunCell(context.Background(), result, agent)
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("cell %d: %v", i, err)
+		}
+	}
+	for i, id := range ids {
+		got := setups[id]
+		if len(got) != 2 || got[0].Final || !got[1].Final {
+			t.Errorf("%s: setup calls %+v, want a registration and a final one", id, got)
+			continue
+		}
+		if want := fmt.Sprintf("sk-test-%d", i); got[0].Secrets["app_keys.API_KEY"] != want {
+			t.Errorf("%s: registered secrets %v, want its own", id, got[0].Secrets)
+		}
+	}
+}
+
+func TestAgentsIncludeVariants(t *testing.T) {
+	settings := filepath.Join(t.TempDir(), "settings.json")
+	if err := os.WriteFile(settings, []byte(`{"permissions":{}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := &Runner{Config: config.Config{Differential: config.Differential{ClaudeCode: settings}}}
+	for _, agent := range []Agent{{Model: "m", Tool: "ClaudeCode"}, {Model: "m", Tool: "Aider"}} {
+		if err := r.Run(context.Background(), nil, agent); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	agents := r.Agents()
+	if len(agents) != 3 || agents[1].Variant != defaultVariant || agents[1].Settings != `{"permissions":{}}` || agents[2].Tool != "Aider" {
+		t.Errorf("agents = %+v, want the ClaudeCode one followed by its variant, then Aider", agents)
+	}
+}
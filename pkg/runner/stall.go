@@ -0,0 +1,124 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/leakbenchmark/deployer/pkg/proxy"
+	"github.com/leakbenchmark/deployer/pkg/transcripts"
+)
+
+// stallCheck is how often a running tool's session is checked for traffic.
+const stallCheck = 30 * time.Second
+
+// errStalled is a tool killed after its session went quiet.
+var errStalled = errors.New("stalled")
+
+// Stall is a step whose tool kept running with no traffic through the
+// proxy, written to stalls/<session>.json. A tool that exits after going
+// quiet finished quietly and is never one.
+type Stall struct {
+	Session string `json:"session"`
+	Step    string `json:"step,omitempty"`
+	// Since is when the session's last message was recorded, or the step
+	// started if that was later.
+	Since time.Time `json:"since"`
+	// Flagged is when the session was found stalled.
+	Flagged time.Time `json:"flagged"`
+	// Terminated is whether the tool was killed for it.
+	Terminated bool `json:"terminated,omitempty"`
+}
+
+// stallWatch finds a step's session stalled once it has had no messages for
+// after, by what the proxy recorded in db.
+type stallWatch struct {
+	db, session, step string
+	after             time.Duration
+	terminate         bool
+	start, next       time.Time
+	// flag is called with the stall once the session is found stalled.
+	flag    func(*Stall)
+	flagged bool
+}
+
+// check returns the step's stall the first time the session is found
+// stalled, checking at most every stallCheck. A nil watch never finds one.
+func (w *stallWatch) check() *Stall {
+	if w == nil || w.flagged || time.Now().Before(w.next) {
+		return nil
+	}
+	w.next = time.Now().Add(stallCheck)
+
+	since := w.start
+	db, err := transcripts.Open(w.db)
+	if err != nil {
+		log.Printf("Failed to check %s for a stall: %v", w.session, err)
+		return nil
+	}
+	last, ok, err := db.LastMessage(w.session)
+	db.Close()
+	if err != nil {
+		log.Printf("Failed to check %s for a stall: %v", w.session, err)
+		return nil
+	}
+	if ok && last.After(since) {
+		since = last
+	}
+	if time.Since(since) < w.after {
+		return nil
+	}
+
+	w.flagged = true
+	s := &Stall{Session: w.session, Step: w.step, Since: since, Flagged: time.Now().UTC(), Terminated: w.terminate}
+	if w.flag != nil {
+		w.flag(s)
+	}
+	return s
+}
+
+// watchStall returns the watch for a step of the cell's session that starts
+// now, or nil when stalls aren't flagged.
+func (r *Runner) watchStall(ctx context.Context, id string, agent Agent, step string) *stallWatch {
+	if r.Config.Stall.After <= 0 {
+		return nil
+	}
+	return &stallWatch{
+		db: r.Config.MessagesDB, session: id, step: step,
+		after: r.Config.Stall.After, terminate: r.Config.Stall.Terminate,
+		start: time.Now(), next: time.Now().Add(stallCheck),
+		flag: func(s *Stall) { r.flagStall(ctx, agent, s) },
+	}
+}
+
+// flagStall records a stall and has the proxy publish it.
+func (r *Runner) flagStall(ctx context.Context, agent Agent, s *Stall) {
+	log.Printf("%s has stalled in step %s: no traffic since %s", s.Session, s.Step, s.Since.Format(time.RFC3339))
+	if err := writeStall(r.RunDir, s); err != nil {
+		log.Println("Failed to write stall", err)
+	}
+	baseURL, _, err := agent.upstream()
+	if err == nil {
+		_, err = RegisterSession(ctx, r.Config.Proxy.URL, r.Config.Keys.Admin, proxy.Setup{Id: s.Session, BaseURL: baseURL, Step: s.Step, Stalled: true})
+	}
+	if err != nil {
+		log.Println("Failed to flag stall", err)
+	}
+}
+
+func writeStall(runDir string, s *Stall) error {
+	stallDir := filepath.Join(runDir, "stalls")
+	if err := os.MkdirAll(stallDir, 0755); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(stallDir, s.Session+".json"), b, 0644)
+}
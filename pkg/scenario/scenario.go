@@ -0,0 +1,61 @@
+// Package scenario defines the prompts an agent is given in a cell, one per
+// step.
+package scenario
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Scenario is a sequence of prompts given to the same agent session, one
+// after another, inside a single container.
+type Scenario struct {
+	Name string `json:"name"`
+	// Checkpoint commits the container to an image after every step so a
+	// long task can be resumed or inspected from any intermediate state.
+	Checkpoint bool   `json:"checkpoint"`
+	Steps      []Step `json:"steps"`
+}
+
+type Step struct {
+	Name   string `json:"name"`
+	Prompt string `json:"prompt"`
+}
+
+func Load(path string) (*Scenario, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scenario: %w", err)
+	}
+
+	var s Scenario
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse scenario %s: %w", path, err)
+	}
+
+	if len(s.Steps) == 0 {
+		return nil, fmt.Errorf("scenario %s has no steps", path)
+	}
+
+	seen := map[string]bool{}
+	for i, step := range s.Steps {
+		if step.Name == "" {
+			return nil, fmt.Errorf("scenario %s: step %d has no name", path, i+1)
+		}
+		if step.Prompt == "" {
+			return nil, fmt.Errorf("scenario %s: step %q has no prompt", path, step.Name)
+		}
+		if seen[step.Name] {
+			return nil, fmt.Errorf("scenario %s: duplicate step name %q", path, step.Name)
+		}
+		seen[step.Name] = true
+	}
+
+	return &s, nil
+}
+
+// Single wraps a lone prompt as a one-step scenario.
+func Single(prompt string) *Scenario {
+	return &Scenario{Steps: []Step{{Prompt: prompt}}}
+}
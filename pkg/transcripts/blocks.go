@@ -0,0 +1,269 @@
+package transcripts
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Agents resend their system prompt, instructions and tool definitions with
+// every request. The proxy stores each such context block once, in the
+// blocks table, and leaves a marker in the message where it was; a block
+// that changed is stored as its difference from the session's previous
+// version. DB.Messages puts the blocks back, so readers see every request
+// byte for byte as it was sent.
+
+// blockFields are the top-level request fields stored as blocks.
+var blockFields = map[string]bool{"system": true, "instructions": true, "tools": true}
+
+// minBlockSize is the smallest field value worth storing as a block.
+const minBlockSize = 1024
+
+// markerRE matches the marker left in place of a block.
+var markerRE = regexp.MustCompile(`\{"leakbench_block":"([0-9a-f]{64})"\}`)
+
+func marker(hash string) string {
+	return `{"leakbench_block":"` + hash + `"}`
+}
+
+// CreateBlocksSQL creates the blocks table.
+const CreateBlocksSQL = `CREATE TABLE IF NOT EXISTS blocks (
+	hash TEXT PRIMARY KEY,
+	base TEXT NOT NULL DEFAULT '',
+	prefix INTEGER NOT NULL DEFAULT 0,
+	suffix INTEGER NOT NULL DEFAULT 0,
+	content TEXT NOT NULL
+)`
+
+// Block is a stored context block, identified by the SHA-256 of its text.
+// Without a Base, Content is the whole text. With one, the text is Base's
+// first Prefix bytes, then Content, then Base's last Suffix bytes.
+type Block struct {
+	Hash    string
+	Base    string
+	Prefix  int
+	Suffix  int
+	Content string
+}
+
+// Deduper replaces the context blocks of request bodies with markers. It
+// remembers what it has handed out, so use a new one for each database.
+type Deduper struct {
+	mu sync.Mutex
+	// last is the latest text of each block field per session.
+	last   map[[2]string]string
+	stored map[string]bool
+}
+
+func NewDeduper() *Deduper {
+	return &Deduper{last: map[[2]string]string{}, stored: map[string]bool{}}
+}
+
+// Dedupe returns content with its context blocks replaced by markers, and
+// the blocks that aren't stored yet. Bodies that aren't JSON objects are
+// returned as they are.
+func (d *Deduper) Dedupe(session, content string) (string, []Block) {
+	spans := fieldSpans(content)
+	if len(spans) == 0 {
+		return content, nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var b strings.Builder
+	var blocks []Block
+	prev := 0
+	for _, s := range spans {
+		text := content[s.start:s.end]
+		sum := sha256.Sum256([]byte(text))
+		hash := hex.EncodeToString(sum[:])
+
+		key := [2]string{session, s.field}
+		if !d.stored[hash] {
+			blocks = append(blocks, diffBlock(hash, text, d.last[key]))
+			d.stored[hash] = true
+		}
+		d.last[key] = text
+
+		b.WriteString(content[prev:s.start])
+		b.WriteString(marker(hash))
+		prev = s.end
+	}
+	b.WriteString(content[prev:])
+	return b.String(), blocks
+}
+
+// diffBlock stores text as its difference from base when that is less than
+// half its size.
+func diffBlock(hash, text, base string) Block {
+	full := Block{Hash: hash, Content: text}
+	if base == "" {
+		return full
+	}
+	prefix := 0
+	for prefix < len(text) && prefix < len(base) && text[prefix] == base[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(text)-prefix && suffix < len(base)-prefix && text[len(text)-1-suffix] == base[len(base)-1-suffix] {
+		suffix++
+	}
+	// Cut on whole characters so the stored middle is valid UTF-8.
+	for prefix > 0 && prefix < len(text) && !utf8.RuneStart(text[prefix]) {
+		prefix--
+	}
+	for suffix > 0 && !utf8.RuneStart(text[len(text)-suffix]) {
+		suffix--
+	}
+	middle := text[prefix : len(text)-suffix]
+	if len(middle) >= len(text)/2 {
+		return full
+	}
+	sum := sha256.Sum256([]byte(base))
+	return Block{Hash: hash, Base: hex.EncodeToString(sum[:]), Prefix: prefix, Suffix: suffix, Content: middle}
+}
+
+type fieldSpan struct {
+	field      string
+	start, end int
+}
+
+// fieldSpans returns where the values of the block fields of a JSON object
+// large enough to store as blocks sit in content.
+func fieldSpans(content string) []fieldSpan {
+	dec := json.NewDecoder(strings.NewReader(content))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil
+	}
+
+	var spans []fieldSpan
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil
+		}
+		key, _ := tok.(string)
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil
+		}
+		if blockFields[key] && len(value) >= minBlockSize {
+			end := int(dec.InputOffset())
+			spans = append(spans, fieldSpan{field: key, start: end - len(value), end: end})
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil
+	}
+	return spans
+}
+
+// SaveBlocks inserts blocks into the blocks table, creating it if needed.
+func SaveBlocks(db *sql.DB, blocks []Block) error {
+	if len(blocks) == 0 {
+		return nil
+	}
+	if _, err := db.Exec(CreateBlocksSQL); err != nil {
+		return err
+	}
+	for _, b := range blocks {
+		_, err := db.Exec(`INSERT OR IGNORE INTO blocks (hash, base, prefix, suffix, content) VALUES (?, ?, ?, ?, ?)`,
+			b.Hash, b.Base, b.Prefix, b.Suffix, b.Content)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blockResolver reads the text of blocks from a database, caching what it
+// has resolved.
+type blockResolver struct {
+	q      querier
+	schema string
+	cache  map[string]string
+}
+
+func newBlockResolver(q querier, schema string) *blockResolver {
+	return &blockResolver{q: q, schema: schema, cache: map[string]string{}}
+}
+
+func (r *blockResolver) text(hash string) (string, error) {
+	if text, ok := r.cache[hash]; ok {
+		return text, nil
+	}
+
+	rows, err := r.q.Query(fmt.Sprintf(`SELECT base, prefix, suffix, content FROM %s.blocks WHERE hash = ?`, r.schema), hash)
+	if err != nil {
+		return "", err
+	}
+	var b Block
+	found := rows.Next()
+	if found {
+		err = rows.Scan(&b.Base, &b.Prefix, &b.Suffix, &b.Content)
+	}
+	rows.Close()
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", fmt.Errorf("context block %s is missing", hash)
+	}
+
+	text := b.Content
+	if b.Base != "" {
+		base, err := r.text(b.Base)
+		if err != nil {
+			return "", err
+		}
+		if b.Prefix+b.Suffix > len(base) {
+			return "", fmt.Errorf("context block %s doesn't fit its base", hash)
+		}
+		text = base[:b.Prefix] + b.Content + base[len(base)-b.Suffix:]
+	}
+	r.cache[hash] = text
+	return text, nil
+}
+
+// expand replaces the block markers in content with the blocks' text.
+func (r *blockResolver) expand(content string) (string, error) {
+	var err error
+	expanded := markerRE.ReplaceAllStringFunc(content, func(m string) string {
+		text, e := r.text(markerRE.FindStringSubmatch(m)[1])
+		if e != nil && err == nil {
+			err = e
+		}
+		return text
+	})
+	return expanded, err
+}
+
+// blockHashes returns the blocks content refers to.
+func blockHashes(content string) []string {
+	var hashes []string
+	for _, m := range markerRE.FindAllStringSubmatch(content, -1) {
+		hashes = append(hashes, m[1])
+	}
+	return hashes
+}
+
+// hasTable reports whether schema has a table named name.
+func hasTable(q querier, schema, name string) (bool, error) {
+	rows, err := q.Query(fmt.Sprintf(`SELECT 1 FROM %s.sqlite_master WHERE type = 'table' AND name = ?`, schema), name)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	return rows.Next(), rows.Err()
+}
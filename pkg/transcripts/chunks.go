@@ -0,0 +1,76 @@
+package transcripts
+
+import (
+	"database/sql"
+	"time"
+)
+
+// CreateChunksSQL creates the chunks table.
+const CreateChunksSQL = `CREATE TABLE IF NOT EXISTS chunks (
+	message_id INTEGER NOT NULL,
+	seq INTEGER NOT NULL,
+	start INTEGER NOT NULL,
+	length INTEGER NOT NULL,
+	read_at INTEGER NOT NULL,
+	PRIMARY KEY (message_id, seq)
+)`
+
+// Chunk is one read of a streamed response, as the proxy passed it on to
+// the client.
+type Chunk struct {
+	// Start and Length locate the chunk in the recorded response.
+	Start  int `json:"start"`
+	Length int `json:"length"`
+	// Time is when the proxy read the chunk from the provider.
+	Time time.Time `json:"time"`
+}
+
+// SaveChunks records the chunks of the streamed response stored as message
+// id, creating the table if needed.
+func SaveChunks(db *sql.DB, id int64, chunks []Chunk) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(CreateChunksSQL); err != nil {
+		return err
+	}
+	for i, c := range chunks {
+		_, err := tx.Exec(`INSERT INTO chunks (message_id, seq, start, length, read_at) VALUES (?, ?, ?, ?, ?)`,
+			id, i, c.Start, c.Length, c.Time.UnixNano())
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Chunks returns the chunks of every streamed response in the database, in
+// order, by message ID. Databases recorded before chunks were have none.
+func (d *DB) Chunks() (map[int64][]Chunk, error) {
+	if ok, err := hasTable(d.db, "main", "chunks"); err != nil || !ok {
+		return nil, err
+	}
+	rows, err := d.db.Query(`SELECT message_id, start, length, read_at FROM chunks ORDER BY message_id, seq`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chunks := map[int64][]Chunk{}
+	for rows.Next() {
+		var id, readAt int64
+		var c Chunk
+		if err := rows.Scan(&id, &c.Start, &c.Length, &readAt); err != nil {
+			return nil, err
+		}
+		c.Time = time.Unix(0, readAt)
+		chunks[id] = append(chunks[id], c)
+	}
+	return chunks, rows.Err()
+}
@@ -0,0 +1,178 @@
+package transcripts
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// CreateCommandsSQL creates the commands table.
+const CreateCommandsSQL = `CREATE TABLE IF NOT EXISTS commands (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	session_id TEXT NOT NULL,
+	message_id INTEGER NOT NULL,
+	turn INTEGER NOT NULL,
+	call_id TEXT NOT NULL,
+	tool TEXT NOT NULL,
+	command TEXT NOT NULL,
+	output TEXT NOT NULL DEFAULT '',
+	secret_files TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS commands_session ON commands (session_id, message_id)`
+
+// Command is a shell command an agent ran through its shell tool.
+type Command struct {
+	SessionID string `json:"session_id"`
+	// MessageID is the first request the call was sent to the model in,
+	// and Turn the index of the calling turn in that request's Turns.
+	MessageID int64  `json:"message_id"`
+	Turn      int    `json:"turn"`
+	CallID    string `json:"call_id"`
+	Tool      string `json:"tool"`
+	Command   string `json:"command"`
+	Output    string `json:"output,omitempty"`
+	// SecretFiles are the secret-bearing files the command names.
+	SecretFiles []string `json:"secret_files,omitempty"`
+}
+
+// shellTools are the tools that run their arguments in a shell.
+var shellTools = map[string]bool{
+	"Bash":           true,
+	"shell":          true,
+	"local_shell":    true,
+	"exec_command":   true,
+	"container.exec": true,
+}
+
+// secretFilePattern matches the names of files secrets are planted in or
+// commonly kept in.
+var secretFilePattern = regexp.MustCompile(`(?:[\w.-]*/)*(?:\.env[\w.-]*|[\w.-]*\.env|config\.js|config/[\w.-]+|[\w.-]*\.pem|[\w.-]*\.key|credentials[\w.-]*|secrets?\.[\w]+|\.npmrc|\.netrc)\b`)
+
+// shellCommand returns the script a shell tool call runs.
+func shellCommand(name, arguments string) (string, bool) {
+	if !shellTools[name] {
+		return "", false
+	}
+	var args struct {
+		Command json.RawMessage `json:"command"`
+		Cmd     json.RawMessage `json:"cmd"`
+	}
+	if json.Unmarshal([]byte(arguments), &args) != nil {
+		return "", false
+	}
+	raw := args.Command
+	if raw == nil {
+		raw = args.Cmd
+	}
+
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s, s != ""
+	}
+	var argv []string
+	if json.Unmarshal(raw, &argv) != nil || len(argv) == 0 {
+		return "", false
+	}
+	// Codex wraps scripts as ["bash", "-lc", script].
+	if len(argv) == 3 && strings.HasPrefix(argv[1], "-") && strings.HasSuffix(argv[1], "c") {
+		return argv[2], true
+	}
+	return strings.Join(argv, " "), true
+}
+
+// Commands lists the shell commands run in messages, each once, in the
+// order the agents ran them. Requests repeat the conversation so far, so a
+// command is credited to the first request holding it, and its output is
+// taken from the first request holding that.
+func Commands(messages []Message) []Command {
+	var commands []Command
+	index := map[[2]string]int{}
+	for _, m := range messages {
+		if m.Direction != Inbound {
+			continue
+		}
+		for i, t := range Turns(m.Content) {
+			for _, c := range t.ToolCalls {
+				k := [2]string{m.SessionID, c.ID}
+				if _, ok := index[k]; ok || c.ID == "" {
+					continue
+				}
+				script, ok := shellCommand(c.Name, c.Arguments)
+				if !ok {
+					continue
+				}
+				index[k] = len(commands)
+				commands = append(commands, Command{
+					SessionID:   m.SessionID,
+					MessageID:   m.ID,
+					Turn:        i,
+					CallID:      c.ID,
+					Tool:        c.Name,
+					Command:     script,
+					SecretFiles: secretFiles(script),
+				})
+			}
+			if t.Role == RoleTool {
+				if j, ok := index[[2]string{m.SessionID, t.CallID}]; ok && commands[j].Output == "" {
+					commands[j].Output = t.Text
+				}
+			}
+		}
+	}
+	return commands
+}
+
+func secretFiles(script string) []string {
+	var files []string
+	for _, f := range secretFilePattern.FindAllString(script, -1) {
+		if !slices.Contains(files, f) {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// IndexCommands rebuilds the commands table of the database at path from
+// its messages, and returns how many commands it found.
+func IndexCommands(path string) (int, error) {
+	d, err := Open(path)
+	if err != nil {
+		return 0, err
+	}
+	messages, err := d.Messages("")
+	d.Close()
+	if err != nil {
+		return 0, err
+	}
+	commands := Commands(messages)
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open transcript database: %w", err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(CreateCommandsSQL); err != nil {
+		return 0, fmt.Errorf("failed to create commands table: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM commands`); err != nil {
+		return 0, err
+	}
+	for _, c := range commands {
+		_, err := tx.Exec(`INSERT INTO commands (session_id, message_id, turn, call_id, tool, command, output, secret_files) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			c.SessionID, c.MessageID, c.Turn, c.CallID, c.Tool, c.Command, c.Output, strings.Join(c.SecretFiles, ","))
+		if err != nil {
+			return 0, fmt.Errorf("failed to save command: %w", err)
+		}
+	}
+	return len(commands), tx.Commit()
+}
@@ -0,0 +1,357 @@
+package transcripts
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// ImportLog converts an agent's own session log, a Claude Code session
+// JSONL file (under ~/.claude/projects) or a Codex rollout (under
+// ~/.codex/sessions), into the messages the proxy would have recorded for
+// sessionID: before every model reply, a request carrying the conversation
+// so far, and the reply as a response.
+func ImportLog(sessionID, name string, r io.Reader) ([]Message, error) {
+	if strings.Contains(name, ".codex/") || strings.Contains(name, "rollout-") {
+		return importCodex(sessionID, r)
+	}
+	return importClaudeCode(sessionID, r)
+}
+
+// conversation replays a log into requests and responses.
+type conversation struct {
+	sessionID string
+	endpoint  string
+	// request and response render the request body for a history and the
+	// response body for a reply.
+	request  func(history []json.RawMessage) any
+	response func(reply []json.RawMessage) any
+
+	history  []json.RawMessage
+	reply    []json.RawMessage
+	replyID  string
+	at       time.Time
+	messages []Message
+}
+
+// user adds an entry from the agent's side.
+func (c *conversation) user(entry json.RawMessage, at time.Time) {
+	c.flush()
+	c.history = append(c.history, entry)
+	c.touch(at)
+}
+
+// model adds a piece of the model's reply. Pieces with the same non-empty
+// ID belong to the same reply, and a reply starts a new request.
+func (c *conversation) model(entry json.RawMessage, id string, at time.Time) {
+	if c.reply != nil && (id == "" || id != c.replyID) && c.replyID != "" {
+		c.flush()
+	}
+	c.touch(at)
+	if c.reply == nil && len(c.history) > 0 {
+		c.emit(Inbound, c.request(c.history))
+	}
+	c.reply = append(c.reply, entry)
+	c.replyID = id
+}
+
+func (c *conversation) touch(at time.Time) {
+	if !at.IsZero() {
+		c.at = at
+	}
+}
+
+// flush records the pending reply and adds it to the history.
+func (c *conversation) flush() {
+	if c.reply == nil {
+		return
+	}
+	c.emit(Outbound, c.response(c.reply))
+	c.history = append(c.history, c.reply...)
+	c.reply, c.replyID = nil, ""
+}
+
+func (c *conversation) emit(direction string, body any) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return
+	}
+	c.messages = append(c.messages, Message{
+		SessionID: c.sessionID,
+		Direction: direction,
+		Endpoint:  c.endpoint,
+		Content:   string(b),
+		Timestamp: c.at,
+	})
+}
+
+func scanLines(r io.Reader, line func([]byte)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64<<20)
+	for scanner.Scan() {
+		line(scanner.Bytes())
+	}
+	return scanner.Err()
+}
+
+// importClaudeCode reads a Claude Code session log, whose entries hold
+// Anthropic messages. A reply is logged one content block per entry, and
+// subagents' conversations (sidechains) are interleaved with the main one.
+func importClaudeCode(sessionID string, r io.Reader) ([]Message, error) {
+	newConversation := func() *conversation {
+		return &conversation{
+			sessionID: sessionID,
+			endpoint:  "/v1/messages",
+			request: func(history []json.RawMessage) any {
+				return map[string]any{"messages": mergeAnthropic(history)}
+			},
+			response: func(reply []json.RawMessage) any {
+				merged := mergeAnthropic(reply)
+				return map[string]any{"type": "message", "role": "assistant", "content": merged[0].Content}
+			},
+		}
+	}
+	main, side := newConversation(), newConversation()
+
+	var ordered []Message
+	err := scanLines(r, func(line []byte) {
+		var e struct {
+			Type        string    `json:"type"`
+			IsSidechain bool      `json:"isSidechain"`
+			Timestamp   time.Time `json:"timestamp"`
+			Message     struct {
+				ID      string          `json:"id"`
+				Role    string          `json:"role"`
+				Content json.RawMessage `json:"content"`
+			} `json:"message"`
+		}
+		if json.Unmarshal(line, &e) != nil || e.Message.Content == nil {
+			return
+		}
+		c := main
+		if e.IsSidechain {
+			c = side
+		}
+		before := len(c.messages)
+		entry, _ := json.Marshal(map[string]any{"role": e.Message.Role, "content": e.Message.Content})
+		switch e.Type {
+		case "user":
+			c.user(entry, e.Timestamp)
+		case "assistant":
+			c.model(entry, e.Message.ID, e.Timestamp)
+		}
+		ordered = append(ordered, c.messages[before:]...)
+	})
+	for _, c := range []*conversation{main, side} {
+		before := len(c.messages)
+		c.flush()
+		ordered = append(ordered, c.messages[before:]...)
+	}
+	return ordered, err
+}
+
+type anthropicMessage struct {
+	Role    string            `json:"role"`
+	Content []json.RawMessage `json:"content"`
+}
+
+// mergeAnthropic joins consecutive entries of the same role into one
+// message, as the API expects them.
+func mergeAnthropic(entries []json.RawMessage) []anthropicMessage {
+	var merged []anthropicMessage
+	for _, raw := range entries {
+		var m struct {
+			Role    string          `json:"role"`
+			Content json.RawMessage `json:"content"`
+		}
+		if json.Unmarshal(raw, &m) != nil {
+			continue
+		}
+		var blocks []json.RawMessage
+		if json.Unmarshal(m.Content, &blocks) != nil {
+			var text string
+			json.Unmarshal(m.Content, &text)
+			b, _ := json.Marshal(map[string]string{"type": "text", "text": text})
+			blocks = []json.RawMessage{b}
+		}
+		if n := len(merged); n > 0 && merged[n-1].Role == m.Role {
+			merged[n-1].Content = append(merged[n-1].Content, blocks...)
+			continue
+		}
+		merged = append(merged, anthropicMessage{Role: m.Role, Content: blocks})
+	}
+	if len(merged) == 0 {
+		merged = append(merged, anthropicMessage{Role: "assistant"})
+	}
+	return merged
+}
+
+// codexModelItems are the items of a Codex rollout that the model
+// produces; the rest come from the user and the tools.
+var codexModelItems = map[string]bool{
+	"reasoning":        true,
+	"function_call":    true,
+	"custom_tool_call": true,
+	"local_shell_call": true,
+	"web_search_call":  true,
+}
+
+// importCodex reads a Codex rollout, whose response items are Responses
+// API items, either wrapped in response_item lines or, in older rollouts,
+// on lines of their own.
+func importCodex(sessionID string, r io.Reader) ([]Message, error) {
+	c := &conversation{
+		sessionID: sessionID,
+		endpoint:  "/v1/responses",
+		request:   func(history []json.RawMessage) any { return map[string]any{"input": history} },
+		response:  func(reply []json.RawMessage) any { return map[string]any{"output": reply} },
+	}
+	err := scanLines(r, func(line []byte) {
+		var l struct {
+			Timestamp time.Time       `json:"timestamp"`
+			Type      string          `json:"type"`
+			Role      string          `json:"role"`
+			Payload   json.RawMessage `json:"payload"`
+		}
+		if json.Unmarshal(line, &l) != nil {
+			return
+		}
+		item := json.RawMessage(append([]byte(nil), line...))
+		typ, role := l.Type, l.Role
+		if l.Payload != nil {
+			if l.Type != "response_item" {
+				return
+			}
+			var p struct {
+				Type string `json:"type"`
+				Role string `json:"role"`
+			}
+			if json.Unmarshal(l.Payload, &p) != nil {
+				return
+			}
+			item, typ, role = l.Payload, p.Type, p.Role
+		}
+
+		switch {
+		case typ == "message" && role == "assistant", codexModelItems[typ]:
+			c.model(item, "", l.Timestamp)
+		case typ == "message" || strings.HasSuffix(typ, "_output"):
+			c.user(item, l.Timestamp)
+		}
+	})
+	c.flush()
+	return c.messages, err
+}
+
+// WriteMessages writes messages to a new transcript database at path, in
+// the schema the proxy records to.
+func WriteMessages(path string, messages []Message) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return fmt.Errorf("failed to create transcript database: %w", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`CREATE TABLE messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		session_id TEXT NOT NULL,
+		content TEXT NOT NULL,
+		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+		step TEXT NOT NULL DEFAULT '',
+		direction TEXT NOT NULL DEFAULT 'inbound',
+		endpoint TEXT NOT NULL DEFAULT ''
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create transcript database: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, m := range messages {
+		// Messages keep their IDs, which findings refer to; new ones get
+		// the next free one.
+		id := sql.NullInt64{Int64: m.ID, Valid: m.ID != 0}
+		// SQLite's CURRENT_TIMESTAMP is UTC in this format.
+		_, err := tx.Exec(`INSERT INTO messages (id, session_id, content, timestamp, step, direction, endpoint) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			id, m.SessionID, m.Content, m.Timestamp.UTC().Format("2006-01-02 15:04:05"), m.Step, m.Direction, m.Endpoint)
+		if err != nil {
+			return fmt.Errorf("failed to save message: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Coverage compares a session's conversation as the agent logged it with
+// what the proxy recorded.
+type Coverage struct {
+	SessionID     string `json:"session_id"`
+	ProxyRequests int    `json:"proxy_requests"`
+	LogRequests   int    `json:"log_requests"`
+	LogCalls      int    `json:"log_calls"`
+	// MissingCalls are the tool calls in the agent's log that appear
+	// nowhere in the proxy's transcript.
+	MissingCalls []string `json:"missing_calls,omitempty"`
+}
+
+// Complete reports whether the proxy saw everything the agent logged.
+func (c Coverage) Complete() bool {
+	return c.ProxyRequests > 0 && len(c.MissingCalls) == 0
+}
+
+// CrossCheck compares the conversations imported from agent logs with the
+// ones the proxy recorded, session by session.
+func CrossCheck(recorded, imported []Message) []Coverage {
+	seen := map[[2]string]bool{}
+	requests := map[string]int{}
+	for _, m := range recorded {
+		if m.Direction == Inbound {
+			requests[m.SessionID]++
+		}
+		for _, t := range Turns(m.Content) {
+			for _, call := range t.ToolCalls {
+				seen[[2]string{m.SessionID, call.ID}] = true
+			}
+		}
+	}
+
+	var coverage []Coverage
+	index := map[string]int{}
+	counted := map[[2]string]bool{}
+	for _, m := range imported {
+		i, ok := index[m.SessionID]
+		if !ok {
+			i = len(coverage)
+			index[m.SessionID] = i
+			coverage = append(coverage, Coverage{SessionID: m.SessionID, ProxyRequests: requests[m.SessionID]})
+		}
+		c := &coverage[i]
+		if m.Direction == Inbound {
+			c.LogRequests++
+		}
+		for _, t := range Turns(m.Content) {
+			for _, call := range t.ToolCalls {
+				k := [2]string{m.SessionID, call.ID}
+				if call.ID == "" || counted[k] {
+					continue
+				}
+				counted[k] = true
+				c.LogCalls++
+				if !seen[k] {
+					c.MissingCalls = append(c.MissingCalls, call.ID)
+				}
+			}
+		}
+	}
+	return coverage
+}
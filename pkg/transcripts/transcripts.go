@@ -0,0 +1,631 @@
+// Package transcripts reads and maintains the SQLite databases the proxy
+// records agent traffic to.
+package transcripts
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Message is a single request body recorded by the proxy.
+// Directions of a message through the proxy.
+const (
+	// Inbound is a request from the agent to the provider.
+	Inbound = "inbound"
+	// Outbound is the provider's response to the agent.
+	Outbound = "outbound"
+)
+
+// AttachmentDir is the directory beside a database holding the whole of
+// the messages too large to store in it.
+const AttachmentDir = "attachments"
+
+type Message struct {
+	ID        int64  `json:"id"`
+	SessionID string `json:"session_id"`
+	Step      string `json:"step,omitempty"`
+	// Direction is Inbound or Outbound. Databases recorded before responses
+	// were stored hold only requests.
+	Direction string `json:"direction"`
+	// Endpoint is the provider API path the message went through.
+	Endpoint  string    `json:"endpoint,omitempty"`
+	Content   string    `json:"content"`
+	Timestamp time.Time `json:"timestamp"`
+	// ReplayOf is the message a replayed request copies, so what it holds
+	// is the original session's doing. It is 0 for every other message.
+	ReplayOf int64 `json:"replay_of,omitempty"`
+	// Attachment is the file, relative to the database, holding all of a
+	// message too large to store. Content is read from it.
+	Attachment string `json:"attachment,omitempty"`
+}
+
+// DB is a read-only handle on the proxy's messages database.
+type DB struct {
+	db *sql.DB
+	// directions is set when the database has the direction and endpoint
+	// columns.
+	directions bool
+	// replays is set when the database has the replay_of column.
+	replays bool
+	// attachments is set when the database has the attachment column, and
+	// dir is where their paths start.
+	attachments bool
+	dir         string
+	// blocks resolves context blocks, if the database has any.
+	blocks *blockResolver
+}
+
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// hasColumn reports whether the messages table in schema has column.
+func hasColumn(q querier, schema, column string) (bool, error) {
+	rows, err := q.Query(fmt.Sprintf(`PRAGMA %s.table_info(messages)`, schema))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	found := false
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, typ string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			found = true
+		}
+	}
+	return found, rows.Err()
+}
+
+func Open(path string) (*DB, error) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro", path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open transcript database: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open transcript database: %w", err)
+	}
+
+	directions, err := hasColumn(db, "main", "direction")
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to read transcript schema: %w", err)
+	}
+
+	replays, err := hasColumn(db, "main", "replay_of")
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to read transcript schema: %w", err)
+	}
+
+	attachments, err := hasColumn(db, "main", "attachment")
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to read transcript schema: %w", err)
+	}
+
+	d := &DB{db: db, directions: directions, replays: replays, attachments: attachments, dir: filepath.Dir(path)}
+	if ok, err := hasTable(db, "main", "blocks"); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to read transcript schema: %w", err)
+	} else if ok {
+		d.blocks = newBlockResolver(db, "main")
+	}
+	return d, nil
+}
+
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// LastMessage returns when the last message of sessionID was recorded, and
+// false when it has none.
+func (d *DB) LastMessage(sessionID string) (time.Time, bool, error) {
+	var t time.Time
+	err := d.db.QueryRow(`SELECT timestamp FROM messages WHERE session_id = ? ORDER BY id DESC LIMIT 1`, sessionID).Scan(&t)
+	if err == sql.ErrNoRows {
+		return time.Time{}, false, nil
+	}
+	return t, err == nil, err
+}
+
+// Messages returns the messages recorded for sessionID in insertion order,
+// or every message when sessionID is empty.
+func (d *DB) Messages(sessionID string) ([]Message, error) {
+	replayOf := `0`
+	if d.replays {
+		replayOf = `replay_of`
+	}
+	attachment := `''`
+	if d.attachments {
+		attachment = `attachment`
+	}
+	query := `SELECT id, session_id, step, direction, endpoint, content, timestamp, ` + replayOf + `, ` + attachment + ` FROM messages`
+	if !d.directions {
+		query = `SELECT id, session_id, step, 'inbound', '', content, timestamp, 0, '' FROM messages`
+	}
+	var args []any
+	if sessionID != "" {
+		query += ` WHERE session_id = ?`
+		args = append(args, sessionID)
+	}
+	query += ` ORDER BY id`
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		var m Message
+		if err := rows.Scan(&m.ID, &m.SessionID, &m.Step, &m.Direction, &m.Endpoint, &m.Content, &m.Timestamp, &m.ReplayOf, &m.Attachment); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i, m := range messages {
+		if m.Attachment == "" {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(d.dir, m.Attachment))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read attachment of message %d: %w", m.ID, err)
+		}
+		messages[i].Content = string(b)
+	}
+	if d.blocks != nil {
+		for i := range messages {
+			if messages[i].Content, err = d.blocks.expand(messages[i].Content); err != nil {
+				return nil, fmt.Errorf("failed to expand message %d: %w", messages[i].ID, err)
+			}
+		}
+	}
+	return messages, nil
+}
+
+// Rewrite replaces the content of every message in the database at path,
+// and the command and output of every indexed command, with fn applied to
+// it. Context blocks are put back into the messages first and dropped,
+// since fn can't be applied to them piecemeal.
+func Rewrite(path string, fn func(string) string) error {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return fmt.Errorf("failed to open transcript database: %w", err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var blocks *blockResolver
+	if ok, err := hasTable(tx, "main", "blocks"); err != nil {
+		return err
+	} else if ok {
+		blocks = newBlockResolver(tx, "main")
+	}
+
+	rows, err := tx.Query(`SELECT id, content FROM messages`)
+	if err != nil {
+		return err
+	}
+	contents := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var content string
+		if err := rows.Scan(&id, &content); err != nil {
+			rows.Close()
+			return err
+		}
+		contents[id] = content
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	updated := map[int64]string{}
+	for id, content := range contents {
+		expanded := content
+		if blocks != nil {
+			if expanded, err = blocks.expand(content); err != nil {
+				return fmt.Errorf("failed to expand message %d: %w", id, err)
+			}
+		}
+		if rewritten := fn(expanded); rewritten != content {
+			updated[id] = rewritten
+		}
+	}
+
+	for id, content := range updated {
+		if _, err := tx.Exec(`UPDATE messages SET content = ? WHERE id = ?`, content, id); err != nil {
+			return err
+		}
+	}
+	if blocks != nil {
+		if _, err := tx.Exec(`DROP TABLE blocks`); err != nil {
+			return err
+		}
+	}
+	if ok, err := hasTable(tx, "main", "commands"); err != nil {
+		return err
+	} else if ok {
+		if err := rewriteCommands(tx, fn); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	// Freed pages keep the old text until the file is rebuilt.
+	_, err = db.Exec(`VACUUM`)
+	return err
+}
+
+// rewriteCommands replaces the command and output of every row of the
+// commands table with fn applied to them.
+func rewriteCommands(tx *sql.Tx, fn func(string) string) error {
+	rows, err := tx.Query(`SELECT id, command, output FROM commands`)
+	if err != nil {
+		return err
+	}
+	type command struct {
+		id              int64
+		command, output string
+	}
+	var updated []command
+	for rows.Next() {
+		var c command
+		if err := rows.Scan(&c.id, &c.command, &c.output); err != nil {
+			rows.Close()
+			return err
+		}
+		if cmd, output := fn(c.command), fn(c.output); cmd != c.command || output != c.output {
+			updated = append(updated, command{c.id, cmd, output})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, c := range updated {
+		if _, err := tx.Exec(`UPDATE commands SET command = ?, output = ? WHERE id = ?`, c.command, c.output, c.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RenameSessions replaces the session ID of every message, command and
+// usage row in the database at path with fn applied to it.
+func RenameSessions(path string, fn func(string) string) error {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return fmt.Errorf("failed to open transcript database: %w", err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT DISTINCT session_id FROM messages`)
+	if err != nil {
+		return err
+	}
+	var sessions []string
+	for rows.Next() {
+		var session string
+		if err := rows.Scan(&session); err != nil {
+			rows.Close()
+			return err
+		}
+		sessions = append(sessions, session)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, table := range []string{"messages", "commands", "usage"} {
+		if ok, err := hasTable(tx, "main", table); err != nil {
+			return err
+		} else if !ok {
+			continue
+		}
+		for _, session := range sessions {
+			if renamed := fn(session); renamed != session {
+				if _, err := tx.Exec(fmt.Sprintf(`UPDATE %s SET session_id = ? WHERE session_id = ?`, table), renamed, session); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// Merge copies the messages of each run's database into the database at out,
+// tagged with the run ID, so they can be queried across runs. Runs already in
+// out are replaced.
+func Merge(out string, runs map[string]string) error {
+	db, err := sql.Open("sqlite3", out)
+	if err != nil {
+		return fmt.Errorf("failed to open merged database: %w", err)
+	}
+	defer db.Close()
+	// ATTACH applies to a single connection.
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		run_id TEXT NOT NULL,
+		source_id INTEGER NOT NULL,
+		session_id TEXT NOT NULL,
+		step TEXT NOT NULL DEFAULT '',
+		direction TEXT NOT NULL DEFAULT 'inbound',
+		endpoint TEXT NOT NULL DEFAULT '',
+		content TEXT NOT NULL,
+		timestamp DATETIME,
+		replay_of INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX IF NOT EXISTS messages_run_session ON messages (run_id, session_id)`)
+	if err != nil {
+		return fmt.Errorf("failed to create merged database: %w", err)
+	}
+	// Merged databases created before directions were recorded lack them.
+	if ok, err := hasColumn(db, "main", "direction"); err != nil {
+		return err
+	} else if !ok {
+		_, err := db.Exec(`ALTER TABLE messages ADD COLUMN direction TEXT NOT NULL DEFAULT 'inbound';
+			ALTER TABLE messages ADD COLUMN endpoint TEXT NOT NULL DEFAULT ''`)
+		if err != nil {
+			return fmt.Errorf("failed to migrate merged database: %w", err)
+		}
+	}
+	if ok, err := hasColumn(db, "main", "replay_of"); err != nil {
+		return err
+	} else if !ok {
+		if _, err := db.Exec(`ALTER TABLE messages ADD COLUMN replay_of INTEGER NOT NULL DEFAULT 0`); err != nil {
+			return fmt.Errorf("failed to migrate merged database: %w", err)
+		}
+	}
+
+	runIDs := make([]string, 0, len(runs))
+	for id := range runs {
+		runIDs = append(runIDs, id)
+	}
+	sort.Strings(runIDs)
+
+	for _, id := range runIDs {
+		if err := mergeRun(db, id, runs[id]); err != nil {
+			return fmt.Errorf("failed to merge run %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+func mergeRun(db *sql.DB, runID, path string) error {
+	if _, err := db.Exec(`ATTACH DATABASE ? AS src`, fmt.Sprintf("file:%s?mode=ro", path)); err != nil {
+		return err
+	}
+	defer db.Exec(`DETACH DATABASE src`)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if ok, err := hasTable(tx, "main", "chunks"); err != nil {
+		return err
+	} else if ok {
+		if _, err := tx.Exec(`DELETE FROM chunks WHERE message_id IN (SELECT id FROM messages WHERE run_id = ?)`, runID); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM messages WHERE run_id = ?`, runID); err != nil {
+		return err
+	}
+	columns := `direction, endpoint`
+	if ok, err := hasColumn(tx, "src", "direction"); err != nil {
+		return err
+	} else if !ok {
+		columns = `'inbound', ''`
+	}
+	replayOf := `0`
+	if ok, err := hasColumn(tx, "src", "replay_of"); err != nil {
+		return err
+	} else if ok {
+		replayOf = `replay_of`
+	}
+	_, err = tx.Exec(`INSERT INTO messages (run_id, source_id, session_id, step, direction, endpoint, content, timestamp, replay_of)
+		SELECT ?, id, session_id, step, `+columns+`, content, timestamp, `+replayOf+` FROM src.messages ORDER BY id`, runID)
+	if err != nil {
+		return err
+	}
+	// Chunks follow their responses to the IDs they were merged as.
+	if ok, err := hasTable(tx, "src", "chunks"); err != nil {
+		return err
+	} else if ok {
+		if _, err := tx.Exec(CreateChunksSQL); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`INSERT INTO chunks (message_id, seq, start, length, read_at)
+			SELECT m.id, c.seq, c.start, c.length, c.read_at FROM src.chunks c JOIN messages m ON m.run_id = ? AND m.source_id = c.message_id`, runID)
+		if err != nil {
+			return err
+		}
+	}
+	// Blocks are named by their content, so runs can share them.
+	if ok, err := hasTable(tx, "src", "blocks"); err != nil {
+		return err
+	} else if ok {
+		if _, err := tx.Exec(CreateBlocksSQL); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO blocks SELECT hash, base, prefix, suffix, content FROM src.blocks`); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Prune deletes the messages recorded before cutoff from the database at
+// path and reclaims their space. It returns how many were deleted.
+func Prune(path string, cutoff time.Time) (int64, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open transcript database: %w", err)
+	}
+	defer db.Close()
+
+	// SQLite's CURRENT_TIMESTAMP is UTC in this format.
+	before := cutoff.UTC().Format("2006-01-02 15:04:05")
+	attachments, err := prunedAttachments(db, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune transcripts: %w", err)
+	}
+	res, err := db.Exec(`DELETE FROM messages WHERE timestamp < ?`, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune transcripts: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		if err := pruneBlocks(db); err != nil {
+			return n, fmt.Errorf("failed to prune context blocks: %w", err)
+		}
+		for _, table := range []string{"commands", "usage", "chunks"} {
+			if ok, err := hasTable(db, "main", table); err != nil {
+				return n, err
+			} else if ok {
+				if _, err := db.Exec(`DELETE FROM ` + table + ` WHERE message_id NOT IN (SELECT id FROM messages)`); err != nil {
+					return n, fmt.Errorf("failed to prune %s: %w", table, err)
+				}
+			}
+		}
+		if _, err := db.Exec(`VACUUM`); err != nil {
+			return n, fmt.Errorf("failed to vacuum transcript database: %w", err)
+		}
+	}
+	for _, attachment := range attachments {
+		if err := os.Remove(filepath.Join(filepath.Dir(path), attachment)); err != nil && !os.IsNotExist(err) {
+			return n, fmt.Errorf("failed to prune attachment: %w", err)
+		}
+	}
+	return n, nil
+}
+
+// prunedAttachments returns the attachments of the messages recorded
+// before the timestamp before.
+func prunedAttachments(db *sql.DB, before string) ([]string, error) {
+	if ok, err := hasColumn(db, "main", "attachment"); err != nil || !ok {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT attachment FROM messages WHERE timestamp < ? AND attachment != ''`, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var attachments []string
+	for rows.Next() {
+		var attachment string
+		if err := rows.Scan(&attachment); err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, rows.Err()
+}
+
+// pruneBlocks deletes the context blocks no remaining message needs.
+func pruneBlocks(db *sql.DB) error {
+	if ok, err := hasTable(db, "main", "blocks"); err != nil || !ok {
+		return err
+	}
+
+	rows, err := db.Query(`SELECT content FROM messages WHERE content LIKE '%leakbench_block%'`)
+	if err != nil {
+		return err
+	}
+	var queue []string
+	for rows.Next() {
+		var content string
+		if err := rows.Scan(&content); err != nil {
+			rows.Close()
+			return err
+		}
+		queue = append(queue, blockHashes(content)...)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	bases := map[string]string{}
+	rows, err = db.Query(`SELECT hash, base FROM blocks`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var hash, base string
+		if err := rows.Scan(&hash, &base); err != nil {
+			rows.Close()
+			return err
+		}
+		bases[hash] = base
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Blocks stored as a difference keep their base alive.
+	keep := map[string]bool{}
+	for len(queue) > 0 {
+		hash := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		if keep[hash] {
+			continue
+		}
+		keep[hash] = true
+		if base := bases[hash]; base != "" {
+			queue = append(queue, base)
+		}
+	}
+	for hash := range bases {
+		if keep[hash] {
+			continue
+		}
+		if _, err := db.Exec(`DELETE FROM blocks WHERE hash = ?`, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
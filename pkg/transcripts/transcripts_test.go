@@ -0,0 +1,60 @@
+package transcripts
+
+import (
+	"bytes"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRewriteCommands(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const secret = "sk-test-planted-secret"
+	for _, stmt := range []string{
+		`CREATE TABLE messages (id INTEGER PRIMARY KEY, content TEXT NOT NULL)`,
+		CreateCommandsSQL,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec(`INSERT INTO messages (id, content) VALUES (1, ?)`, "ran cat .env"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO commands (session_id, message_id, turn, call_id, tool, command, output) VALUES ('s', 1, 1, 'c', 'Bash', ?, ?)`,
+		"grep "+secret+" .env", "API_KEY="+secret); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	if err := Rewrite(path, func(s string) string { return strings.ReplaceAll(s, secret, "<SECRET>") }); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var command, output string
+	err = db.QueryRow(`SELECT command, output FROM commands`).Scan(&command, &output)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if command != "grep <SECRET> .env" || output != "API_KEY=<SECRET>" {
+		t.Errorf("command %q, output %q, want the secret replaced", command, output)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, []byte(secret)) {
+		t.Error("secret is still in the database file")
+	}
+}
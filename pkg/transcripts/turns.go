@@ -0,0 +1,236 @@
+package transcripts
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Roles of a turn.
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+	// RoleReasoning is the model's reasoning summary.
+	RoleReasoning = "reasoning"
+	// RoleTool is the output of a tool call.
+	RoleTool = "tool"
+	// RoleRaw is content that isn't a JSON request body, such as a stream.
+	RoleRaw = "raw"
+)
+
+// Turn is one entry of the conversation carried by a request body.
+type Turn struct {
+	Role      string     `json:"role"`
+	Text      string     `json:"text,omitempty"`
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// CallID is the tool call a tool turn answers.
+	CallID string `json:"call_id,omitempty"`
+	// Tokens is an estimate at four bytes per token.
+	Tokens int `json:"tokens"`
+}
+
+type ToolCall struct {
+	ID        string `json:"id,omitempty"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// Turns parses the conversation out of a request body in the OpenAI chat,
+// OpenAI responses or Anthropic messages format, or the reply out of a
+// non-streamed response body in the same formats.
+func Turns(content string) []Turn {
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(content), &body); err != nil {
+		return withTokens([]Turn{{Role: RoleRaw, Text: content}})
+	}
+
+	var turns []Turn
+	for _, key := range []string{"system", "instructions"} {
+		if text := blocksText(body[key]); text != "" {
+			turns = append(turns, Turn{Role: RoleSystem, Text: text})
+		}
+	}
+
+	var messages []json.RawMessage
+	json.Unmarshal(body["messages"], &messages)
+	for _, m := range messages {
+		turns = append(turns, messageTurns(m)...)
+	}
+
+	var input string
+	if json.Unmarshal(body["input"], &input) == nil {
+		turns = append(turns, Turn{Role: RoleUser, Text: input})
+	} else {
+		var items []json.RawMessage
+		json.Unmarshal(body["input"], &items)
+		for _, item := range items {
+			turns = append(turns, inputTurns(item)...)
+		}
+	}
+
+	var choices []struct {
+		Message json.RawMessage `json:"message"`
+	}
+	json.Unmarshal(body["choices"], &choices)
+	for _, c := range choices {
+		turns = append(turns, messageTurns(c.Message)...)
+	}
+	var output []json.RawMessage
+	json.Unmarshal(body["output"], &output)
+	for _, item := range output {
+		turns = append(turns, inputTurns(item)...)
+	}
+	var typ string
+	if json.Unmarshal(body["type"], &typ) == nil && typ == "message" {
+		turns = append(turns, messageTurns(json.RawMessage(content))...)
+	}
+
+	return withTokens(turns)
+}
+
+// messageTurns converts an entry of a chat or Anthropic messages array.
+// Anthropic tool results become turns of their own.
+func messageTurns(raw json.RawMessage) []Turn {
+	var m struct {
+		Role       string          `json:"role"`
+		Content    json.RawMessage `json:"content"`
+		ToolCallID string          `json:"tool_call_id"`
+		ToolCalls  []struct {
+			ID       string `json:"id"`
+			Function struct {
+				Name      string `json:"name"`
+				Arguments string `json:"arguments"`
+			} `json:"function"`
+		} `json:"tool_calls"`
+	}
+	if json.Unmarshal(raw, &m) != nil {
+		return nil
+	}
+
+	role := m.Role
+	if role == "developer" {
+		role = RoleSystem
+	}
+	t := Turn{Role: role, CallID: m.ToolCallID}
+	for _, c := range m.ToolCalls {
+		t.ToolCalls = append(t.ToolCalls, ToolCall{ID: c.ID, Name: c.Function.Name, Arguments: c.Function.Arguments})
+	}
+
+	var blocks []struct {
+		Type      string          `json:"type"`
+		Text      string          `json:"text"`
+		ID        string          `json:"id"`
+		Name      string          `json:"name"`
+		Input     json.RawMessage `json:"input"`
+		ToolUseID string          `json:"tool_use_id"`
+		Content   json.RawMessage `json:"content"`
+	}
+	if json.Unmarshal(m.Content, &blocks) != nil {
+		t.Text = blocksText(m.Content)
+		return []Turn{t}
+	}
+
+	var texts []string
+	var results []Turn
+	for _, b := range blocks {
+		switch b.Type {
+		case "tool_use":
+			t.ToolCalls = append(t.ToolCalls, ToolCall{ID: b.ID, Name: b.Name, Arguments: string(b.Input)})
+		case "tool_result":
+			results = append(results, Turn{Role: RoleTool, CallID: b.ToolUseID, Text: blocksText(b.Content)})
+		case "image", "input_image":
+			texts = append(texts, "[image]")
+		default:
+			if b.Text != "" {
+				texts = append(texts, b.Text)
+			}
+		}
+	}
+	t.Text = strings.Join(texts, "\n")
+
+	if t.Text == "" && len(t.ToolCalls) == 0 {
+		return results
+	}
+	return append([]Turn{t}, results...)
+}
+
+// inputTurns converts an item of a responses input array.
+func inputTurns(raw json.RawMessage) []Turn {
+	var item struct {
+		Type      string          `json:"type"`
+		Role      string          `json:"role"`
+		Content   json.RawMessage `json:"content"`
+		CallID    string          `json:"call_id"`
+		Name      string          `json:"name"`
+		Arguments string          `json:"arguments"`
+		Input     string          `json:"input"`
+		Output    json.RawMessage `json:"output"`
+		Summary   json.RawMessage `json:"summary"`
+		Action    json.RawMessage `json:"action"`
+	}
+	if json.Unmarshal(raw, &item) != nil {
+		return nil
+	}
+
+	switch item.Type {
+	case "function_call", "custom_tool_call":
+		args := item.Arguments
+		if args == "" {
+			args = item.Input
+		}
+		return []Turn{{Role: RoleAssistant, ToolCalls: []ToolCall{{ID: item.CallID, Name: item.Name, Arguments: args}}}}
+	case "local_shell_call":
+		return []Turn{{Role: RoleAssistant, ToolCalls: []ToolCall{{ID: item.CallID, Name: "local_shell", Arguments: string(item.Action)}}}}
+	case "function_call_output", "custom_tool_call_output", "local_shell_call_output":
+		return []Turn{{Role: RoleTool, CallID: item.CallID, Text: blocksText(item.Output)}}
+	case "reasoning":
+		if text := blocksText(item.Summary); text != "" {
+			return []Turn{{Role: RoleReasoning, Text: text}}
+		}
+		return nil
+	}
+	if item.Role == "" {
+		return nil
+	}
+	return messageTurns(raw)
+}
+
+// blocksText returns a string, or the text of an array of content blocks,
+// joined by newlines.
+func blocksText(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+
+	var blocks []struct {
+		Text   string `json:"text"`
+		Output string `json:"output"`
+	}
+	if json.Unmarshal(raw, &blocks) != nil {
+		return string(raw)
+	}
+	var texts []string
+	for _, b := range blocks {
+		if b.Text != "" {
+			texts = append(texts, b.Text)
+		} else if b.Output != "" {
+			texts = append(texts, b.Output)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+func withTokens(turns []Turn) []Turn {
+	for i := range turns {
+		n := len(turns[i].Text)
+		for _, c := range turns[i].ToolCalls {
+			n += len(c.Name) + len(c.Arguments)
+		}
+		turns[i].Tokens = (n + 3) / 4
+	}
+	return turns
+}
@@ -0,0 +1,168 @@
+package transcripts
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// CreateUsageSQL creates the usage table.
+const CreateUsageSQL = `CREATE TABLE IF NOT EXISTS usage (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	session_id TEXT NOT NULL,
+	message_id INTEGER NOT NULL,
+	turn INTEGER NOT NULL,
+	added_tokens INTEGER NOT NULL,
+	context_tokens INTEGER NOT NULL,
+	input_tokens INTEGER NOT NULL DEFAULT 0,
+	output_tokens INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS usage_session ON usage (session_id, turn)`
+
+// Usage is the size of one request of a session.
+type Usage struct {
+	SessionID string `json:"session_id"`
+	MessageID int64  `json:"message_id"`
+	// Turn is the 1-based index of the request within the session.
+	Turn int `json:"turn"`
+	// ContextTokens estimates the whole conversation the request carries,
+	// and AddedTokens the turns it added to the previous request's.
+	AddedTokens   int `json:"added_tokens"`
+	ContextTokens int `json:"context_tokens"`
+	// InputTokens and OutputTokens are what the provider reported for the
+	// request, cached input included, or 0 when its response didn't say.
+	InputTokens  int `json:"input_tokens,omitempty"`
+	OutputTokens int `json:"output_tokens,omitempty"`
+}
+
+// Context returns the request's context size, as the provider counted it
+// where it did.
+func (u Usage) Context() int {
+	if u.InputTokens > 0 {
+		return u.InputTokens
+	}
+	return u.ContextTokens
+}
+
+// Usages lists the size of every request in messages, in order. Responses
+// are matched to the last unanswered request of their session.
+func Usages(messages []Message) []Usage {
+	var usages []Usage
+	pending := map[string]int{}
+	previous := map[string]int{}
+	turns := map[string]int{}
+	for _, m := range messages {
+		if m.Direction == Outbound {
+			if i, ok := pending[m.SessionID]; ok {
+				usages[i].InputTokens, usages[i].OutputTokens = ResponseUsage(m.Content)
+				delete(pending, m.SessionID)
+			}
+			continue
+		}
+
+		context := 0
+		for _, t := range Turns(m.Content) {
+			context += t.Tokens
+		}
+		turns[m.SessionID]++
+		pending[m.SessionID] = len(usages)
+		usages = append(usages, Usage{
+			SessionID:     m.SessionID,
+			MessageID:     m.ID,
+			Turn:          turns[m.SessionID],
+			AddedTokens:   max(0, context-previous[m.SessionID]),
+			ContextTokens: context,
+		})
+		previous[m.SessionID] = context
+	}
+	return usages
+}
+
+// ResponseUsage reads the token counts out of a response body, or out of
+// the events of a streamed one, in any of the OpenAI and Anthropic formats.
+// Anthropic streams report input at the start and output at the end.
+func ResponseUsage(content string) (input, output int) {
+	read := func(data string) {
+		var body struct {
+			Usage    *usage                 `json:"usage"`
+			Message  struct{ Usage *usage } `json:"message"`
+			Response struct{ Usage *usage } `json:"response"`
+		}
+		if json.Unmarshal([]byte(data), &body) != nil {
+			return
+		}
+		for _, u := range []*usage{body.Usage, body.Message.Usage, body.Response.Usage} {
+			if u == nil {
+				continue
+			}
+			input = max(input, u.PromptTokens, u.InputTokens+u.CacheReadInputTokens+u.CacheCreationInputTokens)
+			output = max(output, u.CompletionTokens, u.OutputTokens)
+		}
+	}
+
+	if !strings.HasPrefix(content, "data:") && !strings.HasPrefix(content, "event:") && !strings.Contains(content, "\ndata:") {
+		read(content)
+		return input, output
+	}
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), len(content)+1)
+	for scanner.Scan() {
+		if data, ok := strings.CutPrefix(scanner.Text(), "data:"); ok {
+			read(strings.TrimSpace(data))
+		}
+	}
+	return input, output
+}
+
+type usage struct {
+	PromptTokens             int `json:"prompt_tokens"`
+	CompletionTokens         int `json:"completion_tokens"`
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+}
+
+// IndexUsage rebuilds the usage table of the database at path from its
+// messages, and returns how many requests it holds.
+func IndexUsage(path string) (int, error) {
+	d, err := Open(path)
+	if err != nil {
+		return 0, err
+	}
+	messages, err := d.Messages("")
+	d.Close()
+	if err != nil {
+		return 0, err
+	}
+	usages := Usages(messages)
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open transcript database: %w", err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(CreateUsageSQL); err != nil {
+		return 0, fmt.Errorf("failed to create usage table: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM usage`); err != nil {
+		return 0, err
+	}
+	for _, u := range usages {
+		_, err := tx.Exec(`INSERT INTO usage (session_id, message_id, turn, added_tokens, context_tokens, input_tokens, output_tokens) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			u.SessionID, u.MessageID, u.Turn, u.AddedTokens, u.ContextTokens, u.InputTokens, u.OutputTokens)
+		if err != nil {
+			return 0, fmt.Errorf("failed to save usage: %w", err)
+		}
+	}
+	return len(usages), tx.Commit()
+}
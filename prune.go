@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/leakbenchmark/deployer/internal/artifacts"
+	"github.com/leakbenchmark/deployer/pkg/config"
+	"github.com/leakbenchmark/deployer/pkg/transcripts"
+)
+
+// storedRun is a run directory under runs/.
+type storedRun struct {
+	id   string
+	dir  string
+	size config.Size
+	// modified is the newest modification time of any file in the run.
+	modified time.Time
+}
+
+// pruneCommand deletes, or archives and then deletes, runs older than
+// -max-age and, oldest first, runs over the -max-size budget.
+func pruneCommand(args []string) error {
+	fs := flag.NewFlagSet("prune", flag.ExitOnError)
+	maxAge := fs.Duration("max-age", 0, "prune runs last modified longer ago than this, 0 keeps runs of any age")
+	maxSize := fs.String("max-size", "", "prune the oldest runs until all of them fit in this size, e.g. 20GB")
+	archive := fs.String("archive", "", "upload pruned runs to s3://bucket/prefix, gs://bucket/prefix or file:///path before deleting them")
+	dbPaths := fs.String("db", "", "comma-separated transcript databases shared across runs to delete messages older than -max-age from")
+	dryRun := fs.Bool("dry-run", false, "only list what would be pruned")
+	fs.Parse(args)
+
+	if *maxAge == 0 && *maxSize == "" {
+		return fmt.Errorf("prune needs -max-age or -max-size")
+	}
+	budget := config.Size(-1)
+	if *maxSize != "" {
+		var err error
+		if budget, err = config.ParseSize(*maxSize); err != nil {
+			return err
+		}
+	}
+
+	runs, err := storedRuns("runs")
+	if err != nil {
+		return err
+	}
+
+	var prune []storedRun
+	var kept []storedRun
+	cutoff := time.Now().Add(-*maxAge)
+	for _, r := range runs {
+		if *maxAge > 0 && r.modified.Before(cutoff) {
+			prune = append(prune, r)
+		} else {
+			kept = append(kept, r)
+		}
+	}
+	if budget >= 0 {
+		var total config.Size
+		for _, r := range kept {
+			total += r.size
+		}
+		// kept is oldest first.
+		for len(kept) > 0 && total > budget {
+			total -= kept[0].size
+			prune = append(prune, kept[0])
+			kept = kept[1:]
+		}
+	}
+
+	var store artifacts.Store
+	if *archive != "" && !*dryRun {
+		if store, err = artifacts.Open(*archive); err != nil {
+			return err
+		}
+	}
+
+	var freed config.Size
+	for _, r := range prune {
+		freed += r.size
+		if *dryRun {
+			fmt.Printf("Would prune run %s (%s, last modified %s)\n", r.id, r.size, r.modified.Format(time.DateTime))
+			continue
+		}
+		if store != nil {
+			if err := artifacts.UploadRun(context.Background(), store, r.id, r.dir); err != nil {
+				return fmt.Errorf("failed to archive run %s: %w", r.id, err)
+			}
+		}
+		if err := os.RemoveAll(r.dir); err != nil {
+			return fmt.Errorf("failed to delete run %s: %w", r.id, err)
+		}
+		fmt.Printf("Pruned run %s (%s)\n", r.id, r.size)
+	}
+	fmt.Printf("Pruned %d of %d runs, freeing %s\n", len(prune), len(runs), freed)
+
+	if *dbPaths == "" || *maxAge == 0 || *dryRun {
+		return nil
+	}
+	for _, path := range strings.Split(*dbPaths, ",") {
+		path = strings.TrimSpace(path)
+		n, err := transcripts.Prune(path, cutoff)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Deleted %d messages older than %s from %s\n", n, *maxAge, path)
+	}
+	return nil
+}
+
+// storedRuns lists the runs under dir, oldest first.
+func storedRuns(dir string) ([]storedRun, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list runs: %w", err)
+	}
+
+	var runs []storedRun
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		r := storedRun{id: e.Name(), dir: filepath.Join(dir, e.Name())}
+		err := filepath.Walk(r.dir, func(_ string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.ModTime().After(r.modified) {
+				r.modified = info.ModTime()
+			}
+			if info.Mode().IsRegular() {
+				r.size += config.Size(info.Size())
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+
+	sort.Slice(runs, func(i, j int) bool { return runs[i].modified.Before(runs[j].modified) })
+	return runs, nil
+}
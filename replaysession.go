@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	"github.com/leakbenchmark/deployer/pkg/analyzer"
+	"github.com/leakbenchmark/deployer/pkg/config"
+	"github.com/leakbenchmark/deployer/pkg/proxy"
+	"github.com/leakbenchmark/deployer/pkg/runner"
+	"github.com/leakbenchmark/deployer/pkg/transcripts"
+)
+
+// replaySessionCommand resends every request recorded for a session to
+// another model through the proxy. Each request keeps the conversation as
+// the original model saw it, so the new model answers at every point the
+// original one did, and its replies are recorded under their own session
+// for analyze to compare. Each request is recorded as a replay of the
+// message it copies, so its content is never taken for the new model's.
+func replaySessionCommand(args []string) error {
+	fs := flag.NewFlagSet("replay-session", flag.ExitOnError)
+	run := fs.String("run", "", "replay a session of runs/<id>, recording into its messages.db")
+	dbPath := fs.String("db", "./openai_proxy/messages.db", "transcript database to read the session from and record to")
+	session := fs.String("session", "", "session ID to replay")
+	model := fs.String("model", "", "model to replay the session against")
+	baseURL := fs.String("base-url", "", "provider of the model (defaults to the one serving the recorded endpoint)")
+	config.RegisterFlags(fs, "proxy-url")
+	fs.Parse(args)
+
+	if *session == "" || *model == "" {
+		return fmt.Errorf("replay-session needs -session and -model")
+	}
+	cfg, err := config.Load("")
+	if err != nil {
+		return err
+	}
+	if err := cfg.ApplyFlags(fs); err != nil {
+		return err
+	}
+	if *run != "" {
+		*dbPath = filepath.Join("runs", *run, "messages.db")
+	}
+	abs, err := filepath.Abs(*dbPath)
+	if err != nil {
+		return err
+	}
+
+	db, err := transcripts.Open(abs)
+	if err != nil {
+		return err
+	}
+	messages, err := db.Messages(*session)
+	db.Close()
+	if err != nil {
+		return fmt.Errorf("failed to load transcripts: %w", err)
+	}
+
+	_, tool, project := analyzer.ParseSession(*session)
+	if project == "" {
+		return fmt.Errorf("session ID %q is not model__tool__project", *session)
+	}
+	id := fmt.Sprintf("replay-%s__%s__%s", *model, tool, project)
+
+	ctx := context.Background()
+	sent := 0
+	step, key := "", ""
+	for _, m := range messages {
+		if m.Direction != transcripts.Inbound {
+			continue
+		}
+		endpoint := recordedEndpoint(m, tool)
+		if sent == 0 || m.Step != step {
+			setup := proxy.Setup{Id: id, BaseURL: *baseURL, Step: m.Step, DB: abs, Replay: true}
+			if setup.BaseURL == "" {
+				setup.BaseURL = providerURL(endpoint)
+			}
+			if key, err = runner.RegisterSession(ctx, cfg.Proxy.URL, cfg.Keys.Admin, setup); err != nil {
+				return fmt.Errorf("failed to register session with the proxy: %w", err)
+			}
+			step = m.Step
+		}
+		if err := replayRequest(&cfg, endpoint, m, *model, key); err != nil {
+			return fmt.Errorf("failed to replay message %d: %w", m.ID, err)
+		}
+		sent++
+	}
+	if sent == 0 {
+		return fmt.Errorf("no requests recorded for session %s", *session)
+	}
+
+	fmt.Printf("Replayed %d requests of %s against %s as %s\n", sent, *session, *model, id)
+	return nil
+}
+
+// recordedEndpoint returns the endpoint a message was sent to. Databases
+// from before endpoints were recorded only hold what the agent's tool uses.
+func recordedEndpoint(m transcripts.Message, tool string) string {
+	if m.Endpoint != "" {
+		return m.Endpoint
+	}
+	if tool == "ClaudeCode" {
+		return "/v1/messages"
+	}
+	return "/v1/chat/completions"
+}
+
+// providerURL returns the provider serving endpoint.
+func providerURL(endpoint string) string {
+	if endpoint == "/v1/messages" {
+		return "https://api.anthropic.com"
+	}
+	return "https://api.openai.com"
+}
+
+// replayRequest sends a recorded request to endpoint through the proxy
+// with its model replaced, authenticating with the key the proxy issued or,
+// without one, the provider's.
+func replayRequest(c *config.Config, endpoint string, m transcripts.Message, model, key string) error {
+	var req map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(m.Content), &req); err != nil {
+		return fmt.Errorf("recorded request is not a JSON object: %w", err)
+	}
+	req["model"], _ = json.Marshal(model)
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequest("POST", c.Proxy.URL+endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set(proxy.ReplayHeader, strconv.FormatInt(m.ID, 10))
+	if endpoint == "/v1/messages" {
+		if key == "" {
+			key = c.Keys.Anthropic
+		}
+		httpReq.Header.Set("x-api-key", key)
+		httpReq.Header.Set("anthropic-version", "2023-06-01")
+	} else {
+		if key == "" {
+			key = c.Keys.OpenAI
+		}
+		httpReq.Header.Set("Authorization", "Bearer "+key)
+	}
+
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// The proxy records the reply as it passes through.
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("proxy returned %s", resp.Status)
+	}
+	return nil
+}
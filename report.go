@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/leakbenchmark/deployer/internal/report"
+)
+
+// reportCommand renders a run's HTML report.
+func reportCommand(args []string) error {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	run := fs.String("run", "", "run ID to report on")
+	fs.Parse(args)
+
+	if *run == "" {
+		return fmt.Errorf("report needs -run")
+	}
+	path, err := writeReport(*run, filepath.Join("runs", *run))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s\n", path)
+	return nil
+}
+
+// writeReport renders runDir/report.html, with the heatmap and differential
+// analyze wrote to runDir/heatmap.json and runDir/differential.json, if any.
+func writeReport(runID, runDir string) (string, error) {
+	cells, err := report.Load(filepath.Join(runDir, "file_changes"))
+	if err != nil {
+		return "", err
+	}
+	mutations, err := report.LoadMutations(filepath.Join(runDir, "mutations"))
+	if err != nil {
+		return "", err
+	}
+	heatmap, err := report.LoadHeatmap(filepath.Join(runDir, "heatmap.json"))
+	if err != nil {
+		return "", err
+	}
+
+	pairs, err := report.LoadDifferential(filepath.Join(runDir, "differential.json"))
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(runDir, "report.html")
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if err := report.Write(f, runID, cells, mutations, heatmap, pairs); err != nil {
+		return "", fmt.Errorf("failed to render report: %w", err)
+	}
+	return path, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/leakbenchmark/deployer/internal/results"
+	"github.com/leakbenchmark/deployer/pkg/analyzer"
+)
+
+// reviewCommand steps through a run's findings so a reviewer can mark them
+// as true or false positives, then reports detector precision.
+func reviewCommand(args []string) error {
+	fs := flag.NewFlagSet("review", flag.ExitOnError)
+	run := fs.String("run", "", "run whose findings to review")
+	findingsPath := fs.String("findings", "", "findings JSON written by analyze (default runs/<run>/findings.json)")
+	resultsDB := fs.String("results-db", "results.db", "database the labels are stored in")
+	all := fs.Bool("all", false, "also show findings that were already labeled")
+	precisionOnly := fs.Bool("precision", false, "only print detector precision over every labeled run")
+	since := fs.Duration("since", 0, "only count labels from this long ago for precision, 0 for all")
+	fs.Parse(args)
+
+	db, err := results.Open(*resultsDB)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if !*precisionOnly {
+		if *run == "" {
+			return fmt.Errorf("review needs -run")
+		}
+		if *findingsPath == "" {
+			*findingsPath = filepath.Join("runs", *run, "findings.json")
+		}
+		if err := reviewFindings(db, *run, *findingsPath, *all, os.Stdin, os.Stdout); err != nil {
+			return err
+		}
+	}
+
+	var from time.Time
+	if *since > 0 {
+		from = time.Now().Add(-*since)
+	}
+	precision, err := db.Precision(from)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Detector precision:")
+	for _, p := range precision {
+		fmt.Printf("  %-24s %5.1f%% (%d true, %d false positives)\n", p.Detector, 100*p.Precision, p.TruePositives, p.FalsePositives)
+	}
+	return nil
+}
+
+func reviewFindings(db *results.DB, runID, path string, all bool, in io.Reader, out io.Writer) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read findings (write them with analyze -run %s -out %s): %w", runID, path, err)
+	}
+	var findings []analyzer.Finding
+	if err := json.Unmarshal(b, &findings); err != nil {
+		return fmt.Errorf("failed to parse findings %s: %w", path, err)
+	}
+
+	labels, err := db.Labels(runID)
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(in)
+	prompt := func(question string) (string, bool) {
+		fmt.Fprint(out, question)
+		if !scanner.Scan() {
+			return "", false
+		}
+		return strings.TrimSpace(scanner.Text()), true
+	}
+
+	for i, f := range findings {
+		key := f.Key()
+		prev, labeled := labels[key]
+		if labeled && !all {
+			continue
+		}
+
+		fmt.Fprintf(out, "\n[%d/%d] %s\n", i+1, len(findings), f.Session)
+		fmt.Fprintf(out, "  secret:   %s (%s, %s)\n", f.SecretID, f.SecretProject, f.Category)
+		fmt.Fprintf(out, "  match:    %s via %s\n", f.Severity, f.Detector())
+		fmt.Fprintf(out, "  where:    %s, message %d", f.Channel, f.MessageID)
+		if len(f.Files) > 0 {
+			fmt.Fprintf(out, ", %s", strings.Join(f.Files, " "))
+		}
+		if f.Commit != "" {
+			fmt.Fprintf(out, ", commit %s", f.Commit)
+		}
+		fmt.Fprintf(out, "\n  verdict:  %s", f.Verdict)
+		if f.Judgment != "" {
+			fmt.Fprintf(out, ", judged %s", f.Judgment)
+		}
+		fmt.Fprintln(out)
+		if f.Context != "" {
+			fmt.Fprintf(out, "  context:  %s\n", f.Context)
+		}
+		if labeled {
+			fmt.Fprintf(out, "  labeled:  %s %s\n", prev.Label, prev.Note)
+		}
+
+		answer, ok := prompt("true positive, false positive, skip or quit? [t/f/s/q] ")
+		if !ok {
+			return nil
+		}
+		var label string
+		switch strings.ToLower(answer) {
+		case "t":
+			label = results.TruePositive
+		case "f":
+			label = results.FalsePositive
+		case "q":
+			return nil
+		default:
+			continue
+		}
+
+		note, _ := prompt("note (optional): ")
+		err := db.SaveLabel(results.Label{
+			RunID:     runID,
+			Key:       key,
+			Detector:  f.Detector(),
+			Label:     label,
+			Note:      note,
+			LabeledAt: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/leakbenchmark/deployer/pkg/analyzer"
+	"github.com/leakbenchmark/deployer/pkg/transcripts"
+)
+
+// sanitizeCommand copies a run directory with every planted secret, and the
+// operator's real keys, replaced by placeholders, so it can be published.
+// With -anonymize, the models are replaced by labels as well, in file names
+// and session IDs too.
+func sanitizeCommand(args []string) error {
+	fs := flag.NewFlagSet("sanitize", flag.ExitOnError)
+	run := fs.String("run", "", "run to sanitize")
+	out := fs.String("out", "", "directory to write the sanitized copy to (default runs/<run>-sanitized)")
+	realEnv := fs.String("real-env", "ANTHROPIC_API_KEY,OPENAI_API_KEY", "comma-separated environment variables holding real credentials to replace as well")
+	labelsPath := fs.String("anonymize", "", "JSON file mapping model identifiers, and any vendor names, to the labels published in their place; created, or extended, with Model A, B, ... for the run's models")
+	fs.Parse(args)
+
+	if *run == "" {
+		return fmt.Errorf("sanitize needs -run")
+	}
+	runDir := filepath.Join("runs", *run)
+	if *out == "" {
+		*out = runDir + "-sanitized"
+	}
+
+	secrets, err := analyzer.LoadSecrets(filepath.Join(runDir, "secrets.json"))
+	if err != nil {
+		return err
+	}
+	known := map[string]string{}
+	for _, name := range strings.Split(*realEnv, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			known[name] = os.Getenv(name)
+		}
+	}
+	s := analyzer.NewSanitizer(secrets, known)
+	clean := s.Sanitize
+	rename := func(rel string) string { return rel }
+	var anon *analyzer.Anonymizer
+	var labels os.FileInfo
+	if *labelsPath != "" {
+		if anon, err = runAnonymizer(runDir, *labelsPath); err != nil {
+			return err
+		}
+		// Missing when the run has no models to label.
+		labels, _ = os.Stat(*labelsPath)
+		clean = func(text string) string { return anon.Anonymize(s.Sanitize(text)) }
+		rename = anon.Anonymize
+	}
+
+	err = filepath.Walk(runDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(runDir, path)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(*out, rename(rel))
+
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(dst, 0755)
+		case rel == "secrets.json":
+			// Replaced by placeholders.json below.
+			return nil
+		case labels != nil && os.SameFile(info, labels):
+			// The labels would undo the anonymization.
+			return nil
+		case strings.HasSuffix(path, ".tar.gz"):
+			fmt.Printf("Warning: skipping %s, bundles need the real secrets to replay\n", rel)
+			return nil
+		case strings.HasSuffix(path, ".db"):
+			if err := copyFile(path, dst); err != nil {
+				return err
+			}
+			if err := transcripts.Rewrite(dst, clean); err != nil {
+				return err
+			}
+			if anon != nil {
+				return transcripts.RenameSessions(dst, anon.Anonymize)
+			}
+			return nil
+		case strings.HasSuffix(path, ".tar"):
+			return sanitizeTar(path, dst, clean)
+		default:
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(dst, []byte(clean(string(b))), 0644)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to sanitize %s: %w", runDir, err)
+	}
+
+	if err := writeJSON(filepath.Join(*out, "placeholders.json"), s.Placeholders()); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote sanitized copy of %s to %s\n", runDir, *out)
+	return nil
+}
+
+// sanitizeTar rewrites every regular file in the archive at src with clean.
+func sanitizeTar(src, dst string, clean func(string) string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	var buf bytes.Buffer
+	tr := tar.NewReader(in)
+	tw := tar.NewWriter(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", src, err)
+		}
+
+		var body []byte
+		if hdr.Typeflag == tar.TypeReg {
+			b, err := io.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+			body = []byte(clean(string(b)))
+			hdr.Size = int64(len(body))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(body); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	return os.WriteFile(dst, buf.Bytes(), 0644)
+}
+
+// runAnonymizer returns an anonymizer for the models of the run at runDir,
+// with the labels at labelsPath.
+func runAnonymizer(runDir, labelsPath string) (*analyzer.Anonymizer, error) {
+	db, err := transcripts.Open(filepath.Join(runDir, "messages.db"))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	messages, err := db.Messages("")
+	if err != nil {
+		return nil, err
+	}
+	sessions := sessionIDs(messages)
+	for session := range loadFailures(filepath.Join(runDir, "failures")) {
+		sessions = append(sessions, session)
+	}
+	labels, err := analyzer.LoadLabels(labelsPath, sessions)
+	if err != nil {
+		return nil, err
+	}
+	return analyzer.NewAnonymizer(labels), nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/leakbenchmark/deployer/internal/sealed"
+	"github.com/leakbenchmark/deployer/pkg/config"
+)
+
+// sealedFiles are the run files sealed at rest: the transcripts, the
+// manifests of the planted secrets, and what the agents wrote and printed.
+var sealedFiles = []string{
+	"messages.db", "messages.db-wal", "messages.db-shm", "imported.db",
+	"secrets.json", "secret_files.json", "secret_locations.json", "honeytokens.json",
+	"egress.jsonl", "attachments/*", "bundles/*", "files/*", "agent_logs/*", "logs/*", "snapshots/*",
+}
+
+// encryptionKey returns the key runs are sealed with, nil when none is
+// configured.
+func encryptionKey(c config.Config) ([]byte, error) {
+	return sealed.Key(c.Keys.Encryption, c.Keys.EncryptionCommand)
+}
+
+// sealRun replaces the sealedFiles of runDir with sealed copies.
+func sealRun(runDir string, key []byte) (int, error) {
+	n := 0
+	for _, pattern := range sealedFiles {
+		paths, err := filepath.Glob(filepath.Join(runDir, pattern))
+		if err != nil {
+			return n, err
+		}
+		for _, p := range paths {
+			if info, err := os.Stat(p); err != nil || !info.Mode().IsRegular() || strings.HasSuffix(p, sealed.Suffix) {
+				continue
+			}
+			if err := sealed.SealFile(p, p+sealed.Suffix, key); err != nil {
+				return n, err
+			}
+			if err := os.Remove(p); err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+	return n, nil
+}
+
+// unsealRun replaces the sealed files of runDir with their contents.
+func unsealRun(runDir string, key []byte) (int, error) {
+	n := 0
+	err := filepath.WalkDir(runDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() || !strings.HasSuffix(p, sealed.Suffix) {
+			return err
+		}
+		if err := sealed.OpenFile(p, strings.TrimSuffix(p, sealed.Suffix), key); err != nil {
+			return err
+		}
+		n++
+		return os.Remove(p)
+	})
+	return n, err
+}
+
+// seal seals a finished run, after everything that reads it and before its
+// artifacts are uploaded.
+func seal(runDir string, key []byte) error {
+	n, err := sealRun(runDir, key)
+	if err != nil {
+		return fmt.Errorf("failed to seal run: %w", err)
+	}
+	log.Printf("Sealed %d files of %s", n, runDir)
+	return nil
+}
+
+// sealCommand seals a run's transcripts and secrets at rest, and
+// unsealCommand opens them again for analysis.
+func sealCommand(args []string) error {
+	return sealRunCommand("seal", args)
+}
+
+func unsealCommand(args []string) error {
+	return sealRunCommand("unseal", args)
+}
+
+func sealRunCommand(name string, args []string) error {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: %s <run-id>", name)
+	}
+	c, err := config.Load("")
+	if err != nil {
+		return err
+	}
+	key, err := encryptionKey(c)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		return fmt.Errorf("%s needs LEAKBENCH_ENCRYPTION_KEY or LEAKBENCH_ENCRYPTION_KEY_COMMAND", name)
+	}
+
+	runDir := filepath.Join("runs", fs.Arg(0))
+	if _, err := os.Stat(runDir); err != nil {
+		return err
+	}
+	var n int
+	if name == "seal" {
+		n, err = sealRun(runDir, key)
+	} else {
+		n, err = unsealRun(runDir, key)
+	}
+	fmt.Printf("%sed %d files of %s\n", strings.ToUpper(name[:1])+name[1:], n, runDir)
+	return err
+}
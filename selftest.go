@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/leakbenchmark/deployer/internal/scaffold"
+	"github.com/leakbenchmark/deployer/pkg/analyzer"
+	"github.com/leakbenchmark/deployer/pkg/deployer"
+	"github.com/leakbenchmark/deployer/pkg/proxy"
+)
+
+// selftestCommand checks the pipeline end to end before an expensive run:
+// it plants secrets in a built-in project, has the scripted adversary leak
+// every one of them through an in-process proxy to the mock LLM, and fails
+// unless the analyzer finds each leak and attributes it to its session,
+// project and secret.
+func selftestCommand(args []string) error {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	tmpl := fs.String("template", "express", "built-in project to deploy: "+strings.Join(scaffold.Names(), ", "))
+	patterns := fs.String("patterns", strings.Join(adversaryPatterns, ","), "comma-separated leak patterns to use")
+	keep := fs.Bool("keep", false, "keep the self-test's project and transcripts, and print where")
+	parallel := fs.Int("parallel", 1, "sessions to leak at once, as parallel cells would; build with -race to check the proxy under them")
+	fs.Parse(args)
+
+	dir, err := os.MkdirTemp("", "leakbench-selftest-")
+	if err != nil {
+		return err
+	}
+	if *keep {
+		fmt.Printf("Self-test files are in %s\n", dir)
+	} else {
+		defer os.RemoveAll(dir)
+	}
+
+	projectsDir, manifestsDir := filepath.Join(dir, "projects"), filepath.Join(dir, "manifests")
+	if err := scaffold.Generate(*tmpl, "selftest", projectsDir, manifestsDir); err != nil {
+		return err
+	}
+	d := &deployer.Deployer{ManifestDir: manifestsDir}
+	projects, err := d.DiscoverProjects(projectsDir)
+	if err != nil {
+		return err
+	}
+	if len(projects) != 1 {
+		return fmt.Errorf("expected the self-test project, found %d projects", len(projects))
+	}
+	project := projects[0]
+
+	ctx := context.Background()
+	result := d.Prepare(ctx, project, filepath.Join(dir, "deployed"))
+	if result.Error != nil {
+		return fmt.Errorf("failed to deploy the self-test project: %w", result.Error)
+	}
+	if len(result.Diagnostics) > 0 {
+		return fmt.Errorf("deploying the self-test project: %w", result.Diagnostics[0])
+	}
+
+	// Only the secrets actually planted are leaked, as only those could be
+	// read by an agent.
+	planted := map[string]bool{}
+	var files []string
+	for f, ids := range result.SecretFiles {
+		files = append(files, f)
+		for _, id := range ids {
+			planted[id] = true
+		}
+	}
+	if len(planted) == 0 {
+		return fmt.Errorf("no secrets were planted in the self-test project")
+	}
+	sort.Strings(files)
+	fmt.Printf("Planted %d secrets in %s\n", len(planted), strings.Join(files, ", "))
+	var secrets []analyzer.Secret
+	for _, s := range analyzer.SecretsFromConfigs(map[string]deployer.SecretConfig{project.Name: *result.Secrets}) {
+		if planted[s.ID] {
+			secrets = append(secrets, s)
+		}
+	}
+
+	upstream, stop, err := startMockLLM()
+	if err != nil {
+		return err
+	}
+	defer stop()
+	dbPath := filepath.Join(dir, "messages.db")
+	server, err := proxy.New(dbPath, upstream)
+	if err != nil {
+		return err
+	}
+	defer server.Close()
+	// With the mock's key, the proxy issues every session a key of its own,
+	// which is how it tells parallel sessions apart.
+	server.Keys[strings.TrimPrefix(upstream, "http://")] = "selftest"
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	go http.Serve(l, server)
+
+	a := adversary{proxyURL: "http://" + l.Addr().String(), db: dbPath, upstream: upstream, parallel: *parallel}
+	return a.run(*patterns, secrets)
+}
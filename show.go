@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/leakbenchmark/deployer/pkg/transcripts"
+)
+
+// exchange is a recorded request with the turns it added to the
+// conversation.
+type exchange struct {
+	MessageID int64     `json:"message_id"`
+	Timestamp time.Time `json:"timestamp"`
+	Step      string    `json:"step,omitempty"`
+	// Response is set for the provider's replies, which are shown as
+	// recorded.
+	Response bool `json:"response,omitempty"`
+	// From is the index of the first turn in Turns.
+	From int `json:"from"`
+	// Rewritten is set when the request changed turns sent before, for
+	// example after the agent compacted its history.
+	Rewritten bool `json:"rewritten,omitempty"`
+	// ReplayOf is the recorded message a replayed request copies.
+	ReplayOf int64              `json:"replay_of,omitempty"`
+	Turns    []transcripts.Turn `json:"turns"`
+}
+
+// showCommand renders a session's conversation turn by turn.
+func showCommand(args []string) error {
+	fs := flag.NewFlagSet("show", flag.ExitOnError)
+	run := fs.String("run", "", "show a session of runs/<id>, using its messages.db")
+	dbPath := fs.String("db", "./openai_proxy/messages.db", "proxy transcript database")
+	session := fs.String("session", "", "session ID to show")
+	format := fs.String("format", "text", "output format: text, markdown or json")
+	maxChars := fs.Int("max-chars", 2000, "truncate turns longer than this many bytes, 0 to show them whole")
+	fs.Parse(args)
+
+	if *session == "" {
+		return fmt.Errorf("show needs -session")
+	}
+	if *run != "" {
+		*dbPath = filepath.Join("runs", *run, "messages.db")
+	}
+
+	db, err := transcripts.Open(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	messages, err := db.Messages(*session)
+	if err != nil {
+		return fmt.Errorf("failed to load transcripts: %w", err)
+	}
+	if len(messages) == 0 {
+		return fmt.Errorf("no messages recorded for session %s", *session)
+	}
+
+	exchanges := conversation(messages)
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(exchanges)
+	case "markdown":
+		renderMarkdown(os.Stdout, *session, exchanges, *maxChars)
+	case "text":
+		renderText(os.Stdout, exchanges, *maxChars)
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	return nil
+}
+
+// conversation splits messages into the turns each adds. Every request
+// resends the conversation so far, so only turns past the part it shares
+// with the previous request are kept.
+func conversation(messages []transcripts.Message) []exchange {
+	var exchanges []exchange
+	var prev []transcripts.Turn
+	for _, m := range messages {
+		turns := transcripts.Turns(m.Content)
+		response := m.Direction == transcripts.Outbound
+		if response || len(turns) == 1 && turns[0].Role == transcripts.RoleRaw {
+			// Not a request body, so not part of the history.
+			exchanges = append(exchanges, exchange{MessageID: m.ID, Timestamp: m.Timestamp, Step: m.Step, Response: response, From: len(prev), Turns: turns})
+			continue
+		}
+		from := 0
+		for from < len(prev) && from < len(turns) && reflect.DeepEqual(prev[from], turns[from]) {
+			from++
+		}
+		exchanges = append(exchanges, exchange{
+			MessageID: m.ID,
+			Timestamp: m.Timestamp,
+			Step:      m.Step,
+			From:      from,
+			Rewritten: from < len(prev),
+			ReplayOf:  m.ReplayOf,
+			Turns:     turns[from:],
+		})
+		prev = turns
+	}
+	return exchanges
+}
+
+func renderText(w io.Writer, exchanges []exchange, maxChars int) {
+	for _, e := range exchanges {
+		kind := "message"
+		if e.Response {
+			kind = "response"
+		}
+		header := fmt.Sprintf("=== %s %d  %s", kind, e.MessageID, e.Timestamp.Format(time.DateTime))
+		if e.Step != "" {
+			header += "  step " + e.Step
+		}
+		fmt.Fprintln(w, header+" ===")
+		if e.ReplayOf != 0 {
+			fmt.Fprintf(w, "(replay of message %d)\n", e.ReplayOf)
+		}
+		if e.Rewritten {
+			fmt.Fprintf(w, "(history rewritten from turn %d)\n", e.From+1)
+		}
+
+		for _, t := range e.Turns {
+			role := t.Role
+			if t.CallID != "" {
+				role += " " + t.CallID
+			}
+			fmt.Fprintf(w, "[%s] ~%d tokens\n", role, t.Tokens)
+			if t.Text != "" {
+				fmt.Fprintln(w, indent(truncate(t.Text, maxChars), "  "))
+			}
+			for _, c := range t.ToolCalls {
+				fmt.Fprintf(w, "  -> %s(%s) %s\n", c.Name, c.ID, truncate(c.Arguments, maxChars))
+			}
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+func renderMarkdown(w io.Writer, session string, exchanges []exchange, maxChars int) {
+	fmt.Fprintf(w, "# %s\n\n", session)
+	for _, e := range exchanges {
+		kind := "Message"
+		if e.Response {
+			kind = "Response"
+		}
+		fmt.Fprintf(w, "## %s %d\n\n_%s", kind, e.MessageID, e.Timestamp.Format(time.DateTime))
+		if e.Step != "" {
+			fmt.Fprintf(w, ", step %s", e.Step)
+		}
+		fmt.Fprint(w, "_\n\n")
+		if e.ReplayOf != 0 {
+			fmt.Fprintf(w, "> Replay of message %d.\n\n", e.ReplayOf)
+		}
+		if e.Rewritten {
+			fmt.Fprintf(w, "> History rewritten from turn %d.\n\n", e.From+1)
+		}
+
+		for _, t := range e.Turns {
+			fmt.Fprintf(w, "**%s**", t.Role)
+			if t.CallID != "" {
+				fmt.Fprintf(w, " (`%s`)", t.CallID)
+			}
+			fmt.Fprintf(w, " · ~%d tokens\n\n", t.Tokens)
+			if t.Text != "" {
+				fmt.Fprintf(w, "````\n%s\n````\n\n", truncate(t.Text, maxChars))
+			}
+			for _, c := range t.ToolCalls {
+				fmt.Fprintf(w, "Tool call `%s` (`%s`):\n\n````\n%s\n````\n\n", c.Name, c.ID, truncate(c.Arguments, maxChars))
+			}
+		}
+	}
+}
+
+func truncate(s string, n int) string {
+	if n <= 0 || len(s) <= n {
+		return s
+	}
+	return fmt.Sprintf("%s... (%d more bytes)", strings.ToValidUTF8(s[:n], ""), len(s)-n)
+}
+
+func indent(s, prefix string) string {
+	return prefix + strings.ReplaceAll(s, "\n", "\n"+prefix)
+}
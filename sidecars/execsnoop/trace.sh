@@ -0,0 +1,10 @@
+#!/bin/sh
+# Logs every exec in the cgroup of host process $1, one "pid uid argv..." line
+# each. Needs --privileged --pid host --cgroupns host.
+set -e
+cgroup=$(sed -n 's/^0:://p' "/proc/$1/cgroup")
+exec bpftrace -B line -e "
+tracepoint:syscalls:sys_enter_execve /cgroup == cgroupid(\"/sys/fs/cgroup$cgroup\")/ {
+	printf(\"%d %d \", pid, uid);
+	join(args->argv);
+}"
@@ -0,0 +1,13 @@
+#!/bin/sh
+# Logs every read of the given container paths, one "EVENTS path" line each,
+# through the root of host process $1. Needs --privileged --pid host.
+root="/proc/$1/root"
+shift
+n=$#
+while [ "$n" -gt 0 ]; do
+	set -- "$@" "$root$1"
+	shift
+	n=$((n - 1))
+done
+# A file closed without being written to was read.
+inotifywait -m -q -e close_nowrite --format '%e %w' "$@" | sed -u "s|$root||"
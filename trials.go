@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/leakbenchmark/deployer/internal/sealed"
+	"github.com/leakbenchmark/deployer/pkg/analyzer"
+	"github.com/leakbenchmark/deployer/pkg/config"
+	"github.com/leakbenchmark/deployer/pkg/transcripts"
+)
+
+// runTrials runs the benchmark c.Trials times, each as a run of its own,
+// <run-id>-t<n>, with freshly generated secrets in the same places, and
+// records the trials in runs/<run-id>/trials.json.
+func runTrials(c *config.Config) error {
+	dir := filepath.Join("runs", c.RunID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if c.MessagesDB != "" {
+		fmt.Printf("Warning: recording each trial to its own run's messages.db, not %s\n", c.MessagesDB)
+	}
+	key, err := encryptionKey(*c)
+	if err != nil {
+		return err
+	}
+
+	var trials []analyzer.Trial
+	var earlier [][]analyzer.Secret
+	for n := 1; n <= c.Trials; n++ {
+		trial := *c
+		trial.RunID = fmt.Sprintf("%s-t%d", c.RunID, n)
+		trial.MessagesDB = ""
+		log.Printf("Trial %d/%d as run %s", n, c.Trials, trial.RunID)
+		if err := benchmark(&trial); err != nil {
+			return fmt.Errorf("trial %d failed: %w", n, err)
+		}
+
+		t, secrets, err := recordTrial(n, trial.RunID, earlier, key)
+		if err != nil {
+			return fmt.Errorf("failed to record trial: %w", err)
+		}
+		if len(trials) > 0 && t.Structure != trials[0].Structure {
+			fmt.Printf("Warning: trial %d planted its secrets in different places than trial 1\n", n)
+		}
+		for _, co := range t.Carryover {
+			log.Printf("%s repeated %s/%s from trial %d (%d findings)", co.Session, co.Project, co.SecretID, co.FromTrial, co.Findings)
+		}
+		trials = append(trials, t)
+		earlier = append(earlier, secrets)
+		if err := writeJSON(filepath.Join(dir, "trials.json"), trials); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordTrial builds the manifest of the trial recorded as runID, looking
+// for the secrets of earlier trials in its transcripts, and returns it with
+// the trial's secrets. A run sealed with key is read from its sealed files.
+func recordTrial(n int, runID string, earlier [][]analyzer.Secret, key []byte) (analyzer.Trial, []analyzer.Secret, error) {
+	runDir := filepath.Join("runs", runID)
+	tmp, err := os.MkdirTemp("", "leakbench-trial-")
+	if err != nil {
+		return analyzer.Trial{}, nil, err
+	}
+	defer os.RemoveAll(tmp)
+	open := func(name string) (string, error) {
+		return runFile(runDir, name, tmp, key)
+	}
+
+	secretsPath, err := open("secrets.json")
+	if err != nil {
+		return analyzer.Trial{}, nil, err
+	}
+	secrets, err := analyzer.LoadSecrets(secretsPath)
+	if err != nil {
+		return analyzer.Trial{}, nil, err
+	}
+	locationsPath, err := open("secret_locations.json")
+	if err != nil {
+		return analyzer.Trial{}, nil, err
+	}
+	locations, err := analyzer.LoadSecretLocations(locationsPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return analyzer.Trial{}, nil, err
+	}
+	t := analyzer.NewTrial(n, runID, secrets, locations)
+
+	if len(earlier) > 0 {
+		dbPath, err := open("messages.db")
+		if err != nil {
+			return t, nil, err
+		}
+		// The database's journal is sealed on its own, if it had one.
+		for _, journal := range []string{"messages.db-wal", "messages.db-shm"} {
+			if _, err := open(journal); err != nil {
+				return t, nil, err
+			}
+		}
+		db, err := transcripts.Open(dbPath)
+		if err != nil {
+			return t, nil, err
+		}
+		defer db.Close()
+		messages, err := db.Messages("")
+		if err != nil {
+			return t, nil, err
+		}
+		t.Carryover = analyzer.Carryovers(messages, secrets, earlier)
+	}
+	return t, secrets, nil
+}
+
+// runFile returns the path to read the run file name of runDir from. When
+// only its sealed copy is there and key is set, that is opened into tmp.
+func runFile(runDir, name, tmp string, key []byte) (string, error) {
+	p := filepath.Join(runDir, name)
+	if _, err := os.Stat(p); err == nil || key == nil {
+		return p, nil
+	}
+	if _, err := os.Stat(p + sealed.Suffix); err != nil {
+		return p, nil
+	}
+	out := filepath.Join(tmp, name)
+	if err := sealed.OpenFile(p+sealed.Suffix, out, key); err != nil {
+		return "", fmt.Errorf("failed to open sealed %s: %w", name, err)
+	}
+	return out, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/leakbenchmark/deployer/pkg/analyzer"
+	"github.com/leakbenchmark/deployer/pkg/deployer"
+)
+
+// chdir runs the test in dir, where runs/ is created.
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestRecordTrialSealed(t *testing.T) {
+	chdir(t, t.TempDir())
+	runDir := filepath.Join("runs", "run-t2")
+	if err := os.MkdirAll(runDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	secrets := map[string]deployer.SecretConfig{
+		"shop": {AppKeys: map[string]string{"STRIPE_KEY": "sk_live_trial2value"}},
+	}
+	b, err := json.Marshal(secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(runDir, "secrets.json"), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite3", filepath.Join(runDir, "messages.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`CREATE TABLE messages (id INTEGER PRIMARY KEY AUTOINCREMENT, session_id TEXT NOT NULL, content TEXT NOT NULL,
+		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP, step TEXT NOT NULL DEFAULT '', direction TEXT NOT NULL DEFAULT 'inbound',
+		endpoint TEXT NOT NULL DEFAULT '', replay_of INTEGER NOT NULL DEFAULT 0);
+		INSERT INTO messages (session_id, content) VALUES ('m__Codex__shop', 'STRIPE_KEY=sk_live_trial1value')`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := bytes.Repeat([]byte{7}, 32)
+	if _, err := sealRun(runDir, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(runDir, "secrets.json")); !os.IsNotExist(err) {
+		t.Fatalf("secrets.json left in plaintext: %v", err)
+	}
+
+	earlier := [][]analyzer.Secret{{{ID: "app_keys.STRIPE_KEY", Project: "shop", Value: "sk_live_trial1value"}}}
+	trial, got, err := recordTrial(2, "run-t2", earlier, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Value != "sk_live_trial2value" {
+		t.Errorf("secrets = %+v, want the trial's own", got)
+	}
+	if len(trial.Carryover) != 1 || trial.Carryover[0].FromTrial != 1 {
+		t.Errorf("carryover = %+v, want trial 1's secret", trial.Carryover)
+	}
+
+	if _, _, err := recordTrial(2, "run-t2", earlier, nil); err == nil {
+		t.Error("recordTrial read a sealed run without its key")
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+
+	"github.com/leakbenchmark/deployer/pkg/transcripts"
+)
+
+// usageCommand rebuilds the usage table of a transcript database: the size
+// of every request and the cumulative context it carried.
+func usageCommand(args []string) error {
+	fs := flag.NewFlagSet("usage", flag.ExitOnError)
+	run := fs.String("run", "", "index runs/<id>/messages.db")
+	dbPath := fs.String("db", "./openai_proxy/messages.db", "proxy transcript database")
+	fs.Parse(args)
+
+	if *run != "" {
+		*dbPath = filepath.Join("runs", *run, "messages.db")
+	}
+	n, err := transcripts.IndexUsage(*dbPath)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Indexed %d requests in %s\n", n, *dbPath)
+	return nil
+}